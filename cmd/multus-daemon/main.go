@@ -156,6 +156,20 @@ func startMultusDaemon(daemonConfig *srv.ControllerNetConf, stopCh chan struct{}
 		return fmt.Errorf("failed to create the server: %v", err)
 	}
 
+	if daemonConfig.CacheAPIObjects {
+		server.StartAPICache(os.Getenv("NODE_NAME"), stopCh)
+	}
+
+	if err := server.StartMetricsListener(); err != nil {
+		return fmt.Errorf("failed to start the metrics listener: %v", err)
+	}
+
+	go utilwait.Until(func() {
+		if err := server.SyncReadinessOutputFile(); err != nil {
+			logging.Verbosef("multus is not ready: %v", err)
+		}
+	}, srv.ReadinessCheckPeriod, stopCh)
+
 	if daemonConfig.MetricsPort != nil {
 		go utilwait.Until(func() {
 			http.Handle("/metrics", promhttp.Handler())
@@ -19,11 +19,14 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/containernetworking/cni/pkg/skel"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
 	cniversion "github.com/containernetworking/cni/pkg/version"
 	"gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/multus"
 )
@@ -43,6 +46,21 @@ func main() {
 		return
 	}
 
+	// skel does not dispatch the STATUS command of CNI 1.1.0
+	if os.Getenv("CNI_COMMAND") == "STATUS" {
+		os.Exit(cmdStatus())
+	}
+
+	// nor the GC command of CNI 1.1.0, cleaning up the stale containers
+	if os.Getenv("CNI_COMMAND") == "GC" {
+		os.Exit(cmdGC())
+	}
+
+	// VALIDATE is a multus command, checking the networks of a pod without adding them
+	if os.Getenv("CNI_COMMAND") == "VALIDATE" {
+		os.Exit(cmdValidate())
+	}
+
 	skel.PluginMain(
 		func(args *skel.CmdArgs) error {
 			result, err := multus.CmdAdd(args, nil, nil)
@@ -57,3 +75,68 @@ func main() {
 		func(args *skel.CmdArgs) error { return multus.CmdDel(args, nil, nil) },
 		cniversion.All, "meta-plugin that delegates to other CNI plugins")
 }
+
+// cmdStatus runs multus.CmdStatus with the config of stdin, printing the error, if any,
+// as skel does, and returns the exit code
+func cmdStatus() int {
+	stdinData, err := io.ReadAll(os.Stdin)
+	if err == nil {
+		err = multus.CmdStatus(&skel.CmdArgs{StdinData: stdinData, Path: os.Getenv("CNI_PATH")}, nil, nil)
+	}
+	if err == nil {
+		return 0
+	}
+	return printError(err)
+}
+
+// cmdGC runs multus.CmdGC with the config of stdin, printing the error, if any, as
+// skel does, and returns the exit code
+func cmdGC() int {
+	stdinData, err := io.ReadAll(os.Stdin)
+	if err == nil {
+		_, err = multus.CmdGC(&skel.CmdArgs{StdinData: stdinData, Path: os.Getenv("CNI_PATH")}, nil, nil)
+	}
+	if err == nil {
+		return 0
+	}
+	return printError(err)
+}
+
+// cmdValidate runs multus.CmdValidate with the config of stdin and the CNI environment
+// variables, printing the delegates of the pod as JSON, or the error as skel does, and
+// returns the exit code
+func cmdValidate() int {
+	stdinData, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return printError(err)
+	}
+	report, err := multus.CmdValidate(&skel.CmdArgs{
+		ContainerID: os.Getenv("CNI_CONTAINERID"),
+		Netns:       os.Getenv("CNI_NETNS"),
+		IfName:      os.Getenv("CNI_IFNAME"),
+		Args:        os.Getenv("CNI_ARGS"),
+		Path:        os.Getenv("CNI_PATH"),
+		StdinData:   stdinData,
+	}, nil, nil)
+	if err != nil {
+		return printError(err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "    ")
+	if err := encoder.Encode(report); err != nil {
+		return printError(err)
+	}
+	return 0
+}
+
+// printError prints err as a CNI error JSON and returns the exit code of a failure
+func printError(err error) int {
+	cniErr, ok := err.(*cnitypes.Error)
+	if !ok {
+		cniErr = cnitypes.NewError(cnitypes.ErrInternal, err.Error(), "")
+	}
+	if printErr := cniErr.Print(); printErr != nil {
+		fmt.Fprintf(os.Stderr, "error writing error JSON to stdout: %v\n", printErr)
+	}
+	return 1
+}
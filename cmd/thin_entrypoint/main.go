@@ -391,16 +391,16 @@ func (o *Options) createMultusConfig() (string, error) {
 		logToStderrConfig = "\n        \"logToStderr\": false,"
 	}
 
-	// check MultusLogLevel (debug/error/panic/verbose) and reject others
+	// check MultusLogLevel (debug/info/error/panic/verbose) and reject others
 	logLevelConfig := ""
 	logLevelStr := strings.ToLower(o.MultusLogLevel)
 	switch logLevelStr {
-	case "debug", "error", "panic", "verbose":
+	case "debug", "info", "error", "panic", "verbose":
 		logLevelConfig = fmt.Sprintf("\n        \"logLevel\": %q,", logLevelStr)
 	case "":
 		// no logLevel config, skipped
 	default:
-		return "", fmt.Errorf("Log levels should be one of: debug/verbose/error/panic, did not understand: %q", o.MultusLogLevel)
+		return "", fmt.Errorf("Log levels should be one of: debug/info/verbose/error/panic, did not understand: %q", o.MultusLogLevel)
 	}
 
 	// check MultusLogFile
@@ -0,0 +1,98 @@
+// Copyright (c) 2022 Multus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8sclient
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+
+	nettypes "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	netclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned/typed/k8s.cni.cncf.io/v1"
+	netinformers "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/informers/externalversions"
+)
+
+// netClientset is the clientset of the net-attach-def informer factory, built on the
+// net-attach-def client of ClientInfo. The informers do not use its discovery client.
+type netClientset struct {
+	netclient.K8sCniCncfIoV1Interface
+}
+
+func (c netClientset) Discovery() discovery.DiscoveryInterface {
+	return nil
+}
+
+func (c netClientset) K8sCniCncfIoV1() netclient.K8sCniCncfIoV1Interface {
+	return c.K8sCniCncfIoV1Interface
+}
+
+// StartCache backs the pod and network-attachment-definition lookups of the client with
+// shared informers watching the apiserver until stopCh is closed. Only the pods of
+// nodeName are cached, or the pods of all the nodes if nodeName is empty.
+func (c *ClientInfo) StartCache(nodeName string, stopCh <-chan struct{}) {
+	var options []informers.SharedInformerOption
+	if nodeName != "" {
+		options = append(options, informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", nodeName).String()
+		}))
+	}
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(c.Client, 0, options...)
+	podInformer := informerFactory.Core().V1().Pods()
+	netInformerFactory := netinformers.NewSharedInformerFactory(netClientset{c.NetClient}, 0)
+	netAttachDefInformer := netInformerFactory.K8sCniCncfIo().V1().NetworkAttachmentDefinitions()
+
+	c.podLister = podInformer.Lister()
+	c.netAttachDefLister = netAttachDefInformer.Lister()
+	c.cacheSynced = []cache.InformerSynced{podInformer.Informer().HasSynced, netAttachDefInformer.Informer().HasSynced}
+	informerFactory.Start(stopCh)
+	netInformerFactory.Start(stopCh)
+}
+
+// WaitForCacheSync waits up to timeout for the caches to be listed, and returns
+// whether they are. It returns true if the caches are not started.
+func (c *ClientInfo) WaitForCacheSync(timeout time.Duration) bool {
+	timeoutCh := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() { close(timeoutCh) })
+	defer timer.Stop()
+	return cache.WaitForCacheSync(timeoutCh, c.cacheSynced...)
+}
+
+// GetPodFromCache gets pod from the cache, or from kubernetes if the cache is not
+// started or has not seen the pod yet
+func (c *ClientInfo) GetPodFromCache(namespace, name string) (*v1.Pod, error) {
+	if c.podLister != nil {
+		if pod, err := c.podLister.Pods(namespace).Get(name); err == nil {
+			return pod.DeepCopy(), nil
+		}
+	}
+	return c.GetPod(namespace, name)
+}
+
+// getNetAttachDef gets net-attach-def from the cache, or from kubernetes if the cache
+// is not started or has not seen the net-attach-def yet
+func (c *ClientInfo) getNetAttachDef(namespace, name string) (*nettypes.NetworkAttachmentDefinition, error) {
+	if c.netAttachDefLister != nil {
+		if netAttachDef, err := c.netAttachDefLister.NetworkAttachmentDefinitions(namespace).Get(name); err == nil {
+			return netAttachDef.DeepCopy(), nil
+		}
+	}
+	return c.NetClient.NetworkAttachmentDefinitions(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
@@ -16,31 +16,47 @@
 package k8sclient
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"net"
 	"os"
+	"reflect"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	k8snet "k8s.io/apimachinery/pkg/util/net"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/record"
+	k8sretry "k8s.io/client-go/util/retry"
 	"k8s.io/klog"
 
 	"github.com/containernetworking/cni/libcni"
 	"github.com/containernetworking/cni/pkg/skel"
 	cnitypes "github.com/containernetworking/cni/pkg/types"
+	cniversion "github.com/containernetworking/cni/pkg/version"
 	nettypes "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
 	netclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned/typed/k8s.cni.cncf.io/v1"
+	netlisters "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/listers/k8s.cni.cncf.io/v1"
 	netutils "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/utils"
 	"gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/kubeletclient"
 	"gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/logging"
@@ -51,19 +67,47 @@ const (
 	resourceNameAnnot      = "k8s.v1.cni.cncf.io/resourceName"
 	defaultNetAnnot        = "v1.multus-cni.io/default-network"
 	networkAttachmentAnnot = "k8s.v1.cni.cncf.io/networks"
+	nodeSelectorAnnot      = "k8s.v1.cni.cncf.io/node-selector"
+	noDefaultNetworkAnnot  = "k8s.v1.cni.cncf.io/default-network"
+	networkSelectorAnnot   = "k8s.v1.cni.cncf.io/network-selector"
+	ipClaimAnnot           = "k8s.v1.cni.cncf.io/ip-claim"
 )
 
+// noDefaultNetworkValue of the noDefaultNetworkAnnot annotation opts the pod out of the default network
+const noDefaultNetworkValue = "none"
+
 // NoK8sNetworkError indicates error, no network in kubernetes
 type NoK8sNetworkError struct {
 	message string
 }
 
+// NodeSelectorMismatchError indicates that the node of the pod does not match
+// the node selector of a network
+type NodeSelectorMismatchError struct {
+	message string
+}
+
+func (e *NodeSelectorMismatchError) Error() string { return e.message }
+
+// MalformedAnnotationError indicates that the network selection annotation of
+// a pod cannot be parsed
+type MalformedAnnotationError struct {
+	message string
+}
+
+func (e *MalformedAnnotationError) Error() string { return e.message }
+
 // ClientInfo contains information given from k8s client
 type ClientInfo struct {
 	Client           kubernetes.Interface
 	NetClient        netclient.K8sCniCncfIoV1Interface
 	EventBroadcaster record.EventBroadcaster
 	EventRecorder    record.EventRecorder
+
+	// listers of the pods and net-attach-defs, see StartCache
+	podLister          v1listers.PodLister
+	netAttachDefLister netlisters.NetworkAttachmentDefinitionLister
+	cacheSynced        []cache.InformerSynced
 }
 
 // AddPod adds pod into kubernetes
@@ -97,15 +141,25 @@ func (e *NoK8sNetworkError) Error() string { return e.message }
 
 // SetNetworkStatus sets network status into Pod annotation
 func SetNetworkStatus(client *ClientInfo, k8sArgs *types.K8sArgs, netStatus []nettypes.NetworkStatus, conf *types.NetConf) error {
+	return SetNetworkStatusWithAttachmentIDs(client, k8sArgs, netStatus, nil, conf)
+}
+
+// SetNetworkStatusWithAttachmentIDs sets network status into Pod annotation, each entry tagged
+// with the attachment ID of the same index, if any
+func SetNetworkStatusWithAttachmentIDs(client *ClientInfo, k8sArgs *types.K8sArgs, netStatus []nettypes.NetworkStatus, attachmentIDs []string, conf *types.NetConf) error {
 	podName := string(k8sArgs.K8S_POD_NAME)
 	podNamespace := string(k8sArgs.K8S_POD_NAMESPACE)
 	podUID := string(k8sArgs.K8S_POD_UID)
 
-	return SetPodNetworkStatusAnnotation(client, podName, podNamespace, podUID, netStatus, conf)
+	return setPodNetworkStatusAnnotation(client, podName, podNamespace, podUID, netStatus, attachmentIDs, conf)
 }
 
 // SetPodNetworkStatusAnnotation sets network status into Pod annotation
 func SetPodNetworkStatusAnnotation(client *ClientInfo, podName string, podNamespace string, podUID string, netStatus []nettypes.NetworkStatus, conf *types.NetConf) error {
+	return setPodNetworkStatusAnnotation(client, podName, podNamespace, podUID, netStatus, nil, conf)
+}
+
+func setPodNetworkStatusAnnotation(client *ClientInfo, podName string, podNamespace string, podUID string, netStatus []nettypes.NetworkStatus, attachmentIDs []string, conf *types.NetConf) error {
 	var err error
 	logging.Debugf("SetPodNetworkStatusAnnotation: %v, %v, %v", client, netStatus, conf)
 
@@ -132,7 +186,31 @@ func SetPodNetworkStatusAnnotation(client *ClientInfo, podName string, podNamesp
 	}
 
 	if netStatus != nil {
-		err = netutils.SetNetworkStatus(client.Client, pod, netStatus)
+		entries := make([]networkStatusEntry, len(netStatus))
+		for i := range netStatus {
+			entries[i].NetworkStatus = netStatus[i]
+			if i < len(attachmentIDs) {
+				entries[i].AttachmentID = attachmentIDs[i]
+			}
+		}
+		if conf.NetworkStatusMaxSize > 0 {
+			entries, err = truncateNetworkStatus(entries, conf.NetworkStatusMaxSize)
+			if err != nil {
+				return logging.Errorf("SetPodNetworkStatusAnnotation: %v", err)
+			}
+		}
+		if len(attachmentIDs) == 0 {
+			statuses := make([]nettypes.NetworkStatus, len(entries))
+			for i := range entries {
+				statuses[i] = entries[i].NetworkStatus
+			}
+			err = netutils.SetNetworkStatus(client.Client, pod, statuses)
+		} else {
+			var annotation string
+			if annotation, err = networkStatusAnnotation(entries); err == nil {
+				err = updatePodAnnotation(client, podNamespace, podName, nettypes.NetworkStatusAnnot, &annotation)
+			}
+		}
 		if err != nil {
 			return logging.Errorf("SetPodNetworkStatusAnnotation: failed to update the pod %v in out of cluster comm: %v", podName, err)
 		}
@@ -141,6 +219,117 @@ func SetPodNetworkStatusAnnotation(client *ClientInfo, podName string, podNamesp
 	return nil
 }
 
+// networkStatusEntry is an entry of the network status annotation, with the attachment ID
+// of the network if enabled
+type networkStatusEntry struct {
+	nettypes.NetworkStatus
+	AttachmentID string `json:"attachment-id,omitempty"`
+}
+
+// networkStatusAnnotation returns the network status annotation as written by netutils.SetNetworkStatus
+func networkStatusAnnotation(entries []networkStatusEntry) (string, error) {
+	networkStatus := []string{}
+	for _, entry := range entries {
+		data, err := json.MarshalIndent(entry, "", "    ")
+		if err != nil {
+			return "", err
+		}
+		networkStatus = append(networkStatus, string(data))
+	}
+	return fmt.Sprintf("[%s]", strings.Join(networkStatus, ",")), nil
+}
+
+// networkStatusSize returns the size of the network status annotation
+func networkStatusSize(entries []networkStatusEntry) (int, error) {
+	annotation, err := networkStatusAnnotation(entries)
+	return len(annotation), err
+}
+
+// truncateNetworkStatus fits the network status within maxSize bytes. It drops the dns,
+// device-info and gateway fields first, then the ips and mac, and finally the last entries,
+// the default network coming first.
+func truncateNetworkStatus(netStatus []networkStatusEntry, maxSize int) ([]networkStatusEntry, error) {
+	size, err := networkStatusSize(netStatus)
+	if err != nil {
+		return nil, err
+	}
+	if size <= maxSize {
+		return netStatus, nil
+	}
+	logging.Verbosef("warning: network status of %d bytes exceeds networkStatusMaxSize %d, truncating it", size, maxSize)
+
+	truncated := make([]networkStatusEntry, len(netStatus))
+	copy(truncated, netStatus)
+	for i := range truncated {
+		truncated[i].DNS = nettypes.DNS{}
+		truncated[i].DeviceInfo = nil
+		truncated[i].Gateway = nil
+	}
+	if size, err = networkStatusSize(truncated); err != nil || size <= maxSize {
+		return truncated, err
+	}
+
+	for i := range truncated {
+		truncated[i].IPs = nil
+		truncated[i].Mac = ""
+	}
+	if size, err = networkStatusSize(truncated); err != nil || size <= maxSize {
+		return truncated, err
+	}
+
+	sort.SliceStable(truncated, func(i, j int) bool { return truncated[i].Default && !truncated[j].Default })
+	for size > maxSize && len(truncated) > 0 {
+		truncated = truncated[:len(truncated)-1]
+		if size, err = networkStatusSize(truncated); err != nil {
+			return nil, err
+		}
+	}
+	logging.Verbosef("warning: network status keeps only %d of %d networks within networkStatusMaxSize %d", len(truncated), len(netStatus), maxSize)
+	return truncated, nil
+}
+
+// SetPodAnnotation sets an annotation of the pod
+func SetPodAnnotation(client *ClientInfo, podNamespace, podName, key, value string) error {
+	if err := updatePodAnnotation(client, podNamespace, podName, key, &value); err != nil {
+		return logging.Errorf("SetPodAnnotation: failed to set annotation %s of pod %s/%s: %v", key, podNamespace, podName, err)
+	}
+	return nil
+}
+
+// ClearPodNetworkStatusAnnotation removes the network status annotation of the pod, if the pod still exists
+func ClearPodNetworkStatusAnnotation(client *ClientInfo, podNamespace, podName string) error {
+	// a nil value removes the annotation
+	err := updatePodAnnotation(client, podNamespace, podName, nettypes.NetworkStatusAnnot, nil)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return logging.Errorf("ClearPodNetworkStatusAnnotation: failed to clear the network status of pod %s/%s: %v", podNamespace, podName, err)
+	}
+	return nil
+}
+
+// updatePodAnnotation sets the annotation key of the pod to value, or removes it if value is
+// nil, with the pods/status update netutils.SetNetworkStatus uses
+func updatePodAnnotation(client *ClientInfo, podNamespace, podName, key string, value *string) error {
+	return k8sretry.RetryOnConflict(k8sretry.DefaultRetry, func() error {
+		pod, err := client.Client.CoreV1().Pods(podNamespace).Get(context.TODO(), podName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			if _, ok := pod.Annotations[key]; !ok {
+				return nil
+			}
+			delete(pod.Annotations, key)
+		} else {
+			if pod.Annotations == nil {
+				pod.Annotations = map[string]string{}
+			}
+			pod.Annotations[key] = *value
+		}
+		_, err = client.Client.CoreV1().Pods(podNamespace).UpdateStatus(context.TODO(), pod, metav1.UpdateOptions{})
+		return err
+	})
+}
+
 func parsePodNetworkObjectName(podnetwork string) (string, string, string, error) {
 	var netNsName string
 	var netIfName string
@@ -170,7 +359,8 @@ func parsePodNetworkObjectName(podnetwork string) (string, string, string, error
 	// [a-z0-9]([-a-z0-9]*[a-z0-9])?
 	// And we allow at (@), and forward slash (/) (units separated by commas)
 	// It must start and end alphanumerically.
-	allItems := []string{netNsName, networkName, netIfName}
+	// The interface name follows the Linux interface name rules instead, see validateInterfaceRequest.
+	allItems := []string{netNsName, networkName}
 	for i := range allItems {
 		matched, _ := regexp.MatchString("^[a-z0-9]([-a-z0-9]*[a-z0-9])?$", allItems[i])
 		if !matched && len([]rune(allItems[i])) > 0 {
@@ -182,6 +372,142 @@ func parsePodNetworkObjectName(podnetwork string) (string, string, string, error
 	return netNsName, networkName, netIfName, nil
 }
 
+// maxInterfaceNameLength is the maximum length of a Linux interface name (IFNAMSIZ - 1)
+const maxInterfaceNameLength = 15
+
+// validateInterfaceRequest returns an error if ifName is not a valid Linux interface name
+func validateInterfaceRequest(ifName string) error {
+	if len(ifName) > maxInterfaceNameLength {
+		return fmt.Errorf("interface name %q is longer than %d characters", ifName, maxInterfaceNameLength)
+	}
+	if ifName == "." || ifName == ".." {
+		return fmt.Errorf("interface name %q is not valid", ifName)
+	}
+	if strings.ContainsAny(ifName, "/: \t\n") {
+		return fmt.Errorf("interface name %q must not contain slashes, colons or whitespace", ifName)
+	}
+	return nil
+}
+
+// maxAnnotationErrorLength is the maximum length of an annotation value quoted in an error
+const maxAnnotationErrorLength = 128
+
+// normalizeMacRequest validates a requested MAC address, which must be the unicast address
+// of an ethernet interface, and returns it in the lower case colon separated form
+func normalizeMacRequest(macRequest string) (string, error) {
+	mac, err := net.ParseMAC(macRequest)
+	if err != nil {
+		return "", err
+	}
+	if len(mac) != 6 {
+		return "", fmt.Errorf("must be a 6 bytes ethernet address")
+	}
+	if mac[0]&0x01 != 0 {
+		return "", fmt.Errorf("must not be a multicast or broadcast address")
+	}
+	if bytes.Equal(mac, make(net.HardwareAddr, len(mac))) {
+		return "", fmt.Errorf("must not be the all-zero address")
+	}
+	return mac.String(), nil
+}
+
+// isValidIPRequest returns whether a requested IP address is an IP address, with or
+// without prefix length
+func isValidIPRequest(ip string) bool {
+	if strings.Contains(ip, "/") {
+		_, _, err := net.ParseCIDR(ip)
+		return err == nil
+	}
+	return net.ParseIP(ip) != nil
+}
+
+// GetStaticIPs returns the IP addresses of the pod in the ConfigMap of pool, a comma
+// separated list under the name of the pod, or the key of its ip-claim annotation
+func GetStaticIPs(client *ClientInfo, pod *v1.Pod, pool *types.StaticIPPool, apiRetry *types.APIRetry) ([]string, error) {
+	namespace := pool.Namespace
+	if namespace == "" {
+		namespace = pod.ObjectMeta.Namespace
+	}
+	key := pod.ObjectMeta.Name
+	if claim := pod.Annotations[ipClaimAnnot]; claim != "" {
+		key = claim
+	}
+
+	var configMap *v1.ConfigMap
+	err := RetryAPICall(apiRetry, func() error {
+		var getErr error
+		configMap, getErr = client.Client.CoreV1().ConfigMaps(namespace).Get(context.TODO(), pool.ConfigMap, metav1.GetOptions{})
+		return getErr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("GetStaticIPs: failed to get the configmap %s/%s: %v", namespace, pool.ConfigMap, err)
+	}
+
+	var ips []string
+	for _, ip := range strings.Split(configMap.Data[key], ",") {
+		if ip = strings.TrimSpace(ip); ip == "" {
+			continue
+		}
+		if !isValidIPRequest(ip) {
+			return nil, fmt.Errorf("GetStaticIPs: invalid IP address %q of key %q in the configmap %s/%s, must be an IP address or a CIDR", ip, key, namespace, pool.ConfigMap)
+		}
+		ips = append(ips, ip)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("GetStaticIPs: no IP address for pod %s/%s with key %q in the configmap %s/%s", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, key, namespace, pool.ConfigMap)
+	}
+	return ips, nil
+}
+
+// validateBandwidthRequest checks that a requested bandwidth limits the ingress, the egress
+// or both, each with a rate and a burst
+func validateBandwidthRequest(bandwidth *types.BandwidthEntry) error {
+	directions := []struct {
+		name        string
+		rate, burst int
+	}{
+		{"ingress", bandwidth.IngressRate, bandwidth.IngressBurst},
+		{"egress", bandwidth.EgressRate, bandwidth.EgressBurst},
+	}
+	limited := false
+	for _, direction := range directions {
+		if direction.rate < 0 || direction.burst < 0 {
+			return fmt.Errorf("%sRate and %sBurst must not be negative", direction.name, direction.name)
+		}
+		if (direction.rate == 0) != (direction.burst == 0) {
+			return fmt.Errorf("%sRate and %sBurst must be set together", direction.name, direction.name)
+		}
+		limited = limited || direction.rate > 0
+	}
+	if !limited {
+		return fmt.Errorf("neither the ingress nor the egress is limited")
+	}
+	return nil
+}
+
+// validateCNIArgs checks that the values of the requested cni-args are strings, as the
+// CNI_ARGS they extend
+func validateCNIArgs(cniArgs map[string]interface{}) error {
+	keys := make([]string, 0, len(cniArgs))
+	for key := range cniArgs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, ok := cniArgs[key].(string); !ok {
+			return fmt.Errorf("the value of %q must be a string", key)
+		}
+	}
+	return nil
+}
+
+func truncateAnnotation(value string) string {
+	if len(value) <= maxAnnotationErrorLength {
+		return value
+	}
+	return value[:maxAnnotationErrorLength] + "..."
+}
+
 func parsePodNetworkAnnotation(podNetworks, defaultNamespace string) ([]*types.NetworkSelectionElement, error) {
 	var networks []*types.NetworkSelectionElement
 
@@ -192,19 +518,26 @@ func parsePodNetworkAnnotation(podNetworks, defaultNamespace string) ([]*types.N
 
 	if strings.ContainsAny(podNetworks, "[{\"") {
 		if err := json.Unmarshal([]byte(podNetworks), &networks); err != nil {
-			return nil, logging.Errorf("parsePodNetworkAnnotation: failed to parse pod Network Attachment Selection Annotation JSON format: %v", err)
+			return nil, logging.Errorf("parsePodNetworkAnnotation: failed to parse pod Network Attachment Selection Annotation JSON format %q: %v", truncateAnnotation(podNetworks), err)
 		}
 	} else {
 		// Comma-delimited list of network attachment object names
 		for _, item := range strings.Split(podNetworks, ",") {
 			// Remove leading and trailing whitespace.
 			item = strings.TrimSpace(item)
+			// Skip empty entries, e.g. "net1, , net2,"
+			if item == "" {
+				continue
+			}
 
 			// Parse network name (i.e. <namespace>/<network name>@<ifname>)
 			netNsName, networkName, netIfName, err := parsePodNetworkObjectName(item)
 			if err != nil {
 				return nil, logging.Errorf("parsePodNetworkAnnotation: %v", err)
 			}
+			if strings.Contains(item, "@") && netIfName == "" {
+				return nil, logging.Errorf("parsePodNetworkAnnotation: empty interface name requested for network %q", item)
+			}
 
 			networks = append(networks, &types.NetworkSelectionElement{
 				Name:             networkName,
@@ -212,6 +545,9 @@ func parsePodNetworkAnnotation(podNetworks, defaultNamespace string) ([]*types.N
 				InterfaceRequest: netIfName,
 			})
 		}
+		if len(networks) == 0 {
+			return nil, logging.Errorf("parsePodNetworkAnnotation: pod annotation %q does not contain any network", podNetworks)
+		}
 	}
 
 	for _, n := range networks {
@@ -219,11 +555,30 @@ func parsePodNetworkAnnotation(podNetworks, defaultNamespace string) ([]*types.N
 			n.Namespace = defaultNamespace
 		}
 		if n.MacRequest != "" {
-			// validate MAC address
-			if _, err := net.ParseMAC(n.MacRequest); err != nil {
-				return nil, logging.Errorf("parsePodNetworkAnnotation: failed to mac: %v", err)
+			mac, err := normalizeMacRequest(n.MacRequest)
+			if err != nil {
+				return nil, logging.Errorf("parsePodNetworkAnnotation: invalid mac %q of network %s/%s: %v", n.MacRequest, n.Namespace, n.Name, err)
+			}
+			n.MacRequest = mac
+		}
+		if n.BandwidthRequest != nil {
+			if err := validateBandwidthRequest(n.BandwidthRequest); err != nil {
+				return nil, logging.Errorf("parsePodNetworkAnnotation: invalid bandwidth of network %s/%s: %v", n.Namespace, n.Name, err)
 			}
 		}
+		for key := range n.SysctlsRequest {
+			if !isSafeSysctl(key) {
+				return nil, logging.Errorf("parsePodNetworkAnnotation: sysctl %q of network %s/%s is not allowed, it must start with one of %v", key, n.Namespace, n.Name, safeSysctlPrefixes)
+			}
+		}
+		if n.CNIArgs != nil {
+			if err := validateCNIArgs(*n.CNIArgs); err != nil {
+				return nil, logging.Errorf("parsePodNetworkAnnotation: invalid cni-args of network %s/%s: %v", n.Namespace, n.Name, err)
+			}
+		}
+		if n.MTURequest != nil && *n.MTURequest <= 0 {
+			return nil, logging.Errorf("parsePodNetworkAnnotation: invalid mtu %d of network %s/%s, must be a positive integer", *n.MTURequest, n.Namespace, n.Name)
+		}
 		if n.InfinibandGUIDRequest != "" {
 			// validate GUID address
 			if _, err := net.ParseMAC(n.InfinibandGUIDRequest); err != nil {
@@ -232,35 +587,103 @@ func parsePodNetworkAnnotation(podNetworks, defaultNamespace string) ([]*types.N
 		}
 		if n.IPRequest != nil {
 			for _, ip := range n.IPRequest {
-				// validate IP address
-				if strings.Contains(ip, "/") {
-					if _, _, err := net.ParseCIDR(ip); err != nil {
-						return nil, logging.Errorf("failed to parse CIDR %q: %v", ip, err)
-					}
-				} else if net.ParseIP(ip) == nil {
-					return nil, logging.Errorf("failed to parse IP address %q", ip)
+				if !isValidIPRequest(ip) {
+					return nil, logging.Errorf("parsePodNetworkAnnotation: invalid IP address %q of network %s/%s, must be an IP address or a CIDR", ip, n.Namespace, n.Name)
 				}
 			}
 		}
+		for _, gateway := range n.GatewayCapabilityRequest {
+			if net.ParseIP(gateway) == nil {
+				return nil, logging.Errorf("parsePodNetworkAnnotation: invalid gateway %q of network %s/%s, must be an IP address", gateway, n.Namespace, n.Name)
+			}
+		}
 		// compatibility pre v3.2, will be removed in v4.0
 		if n.DeprecatedInterfaceRequest != "" && n.InterfaceRequest == "" {
 			n.InterfaceRequest = n.DeprecatedInterfaceRequest
 		}
 	}
 
+	// the requested interface names must be valid and unique
+	requestedBy := map[string]string{}
+	for _, n := range networks {
+		if n.InterfaceRequest == "" {
+			continue
+		}
+		if err := validateInterfaceRequest(n.InterfaceRequest); err != nil {
+			return nil, logging.Errorf("parsePodNetworkAnnotation: network %s/%s: %v", n.Namespace, n.Name, err)
+		}
+		if other, ok := requestedBy[n.InterfaceRequest]; ok {
+			return nil, logging.Errorf("parsePodNetworkAnnotation: interface name %q is requested by networks %s and %s/%s", n.InterfaceRequest, other, n.Namespace, n.Name)
+		}
+		requestedBy[n.InterfaceRequest] = n.Namespace + "/" + n.Name
+	}
+
 	return networks, nil
 }
 
-func getKubernetesDelegate(client *ClientInfo, net *types.NetworkSelectionElement, confdir string, pod *v1.Pod, resourceMap map[string]*types.ResourceInfo) (*types.DelegateNetConf, map[string]*types.ResourceInfo, error) {
+// IsTransientAPIError returns true if a Kubernetes API call failed with an error worth retrying
+func IsTransientAPIError(err error) bool {
+	errorTypesAllowingRetry := []func(error) bool{
+		k8serrors.IsServiceUnavailable, k8serrors.IsInternalError, k8snet.IsConnectionReset, k8snet.IsConnectionRefused}
+	for _, f := range errorTypesAllowingRetry {
+		if f(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryAPICall runs call, and runs it again up to retry.MaxRetries times while it fails with
+// a transient error and its Budget, if any, has time left for the wait. It returns the error
+// of the last attempt; a nil retry runs call once.
+func RetryAPICall(retry *types.APIRetry, call func() error) error {
+	err := call()
+	if retry == nil {
+		return err
+	}
+	for attempt := 1; attempt <= retry.MaxRetries && err != nil && IsTransientAPIError(err); attempt++ {
+		backoff := retry.Backoff(attempt)
+		if retry.Budget.Timeout(backoff) < backoff {
+			logging.Debugf("RetryAPICall: retry budget exhausted after: %v", err)
+			break
+		}
+		logging.Debugf("RetryAPICall: retry %d/%d in %v after: %v", attempt, retry.MaxRetries, backoff, err)
+		start := time.Now()
+		time.Sleep(backoff)
+		err = call()
+		retry.Budget.Consume(time.Since(start))
+	}
+	return err
+}
+
+func getKubernetesDelegate(client *ClientInfo, net *types.NetworkSelectionElement, confdir string, pod *v1.Pod, resourceMap map[string]*types.ResourceInfo, apiRetry *types.APIRetry, ageCheck *nadAgeCheck) (*types.DelegateNetConf, map[string]*types.ResourceInfo, error) {
 
 	logging.Debugf("getKubernetesDelegate: %v, %v, %s, %v, %v", client, net, confdir, pod, resourceMap)
-	customResource, err := client.NetClient.NetworkAttachmentDefinitions(net.Namespace).Get(context.TODO(), net.Name, metav1.GetOptions{})
+	var customResource *nettypes.NetworkAttachmentDefinition
+	err := RetryAPICall(apiRetry, func() error {
+		var getErr error
+		customResource, getErr = client.getNetAttachDef(net.Namespace, net.Name)
+		return getErr
+	})
 	if err != nil {
 		errMsg := fmt.Sprintf("cannot find a network-attachment-definition (%s) in namespace (%s): %v", net.Name, net.Namespace, err)
 		if client != nil {
 			client.Eventf(pod, v1.EventTypeWarning, "NoNetworkFound", errMsg)
 		}
-		return nil, resourceMap, logging.Errorf("getKubernetesDelegate: " + errMsg)
+		return nil, resourceMap, logging.Errorf("getKubernetesDelegate: cannot find a network-attachment-definition (%s) in namespace (%s): %w", net.Name, net.Namespace, err)
+	}
+
+	if ageCheck != nil {
+		if customResource, err = ageCheck.check(client, net, customResource); err != nil {
+			return nil, resourceMap, logging.Errorf("getKubernetesDelegate: %v", err)
+		}
+	}
+
+	// Check the node-selector annotation of the NetworkAttachmentDefinition
+	if selector, ok := customResource.GetAnnotations()[nodeSelectorAnnot]; ok && pod.Name != "" {
+		if err := checkNodeSelector(client, pod, selector); err != nil {
+			return nil, resourceMap, err
+		}
 	}
 
 	// Get resourceName annotation from NetworkAttachmentDefinition
@@ -301,6 +724,9 @@ func getKubernetesDelegate(client *ClientInfo, net *types.NetworkSelectionElemen
 	if err != nil {
 		return nil, resourceMap, err
 	}
+	if err := validateNetAttachDefConfig(configBytes); err != nil {
+		return nil, resourceMap, logging.Errorf("getKubernetesDelegate: invalid config of network-attachment-definition %s/%s: %v", customResource.Namespace, customResource.Name, err)
+	}
 
 	delegate, err := types.LoadDelegateNetConf(configBytes, net, deviceID, resourceName)
 	if err != nil {
@@ -310,6 +736,204 @@ func getKubernetesDelegate(client *ClientInfo, net *types.NetworkSelectionElemen
 	return delegate, resourceMap, nil
 }
 
+// validateNetAttachDefConfig checks the fields required by the CNI spec in the config of
+// a network-attachment-definition, so that mistakes are reported before any delegate runs
+func validateNetAttachDefConfig(configBytes []byte) error {
+	var conf struct {
+		CNIVersion string `json:"cniVersion"`
+		Type       string `json:"type"`
+		Plugins    *[]struct {
+			Type string `json:"type"`
+		} `json:"plugins"`
+	}
+	if err := json.Unmarshal(configBytes, &conf); err != nil {
+		return fmt.Errorf("malformed JSON: %v", err)
+	}
+	if conf.CNIVersion != "" {
+		if _, _, _, err := cniversion.ParseVersion(conf.CNIVersion); err != nil {
+			return fmt.Errorf("invalid cniVersion %q", conf.CNIVersion)
+		}
+	}
+	if conf.Plugins == nil {
+		if conf.Type == "" {
+			return fmt.Errorf("missing type")
+		}
+		return nil
+	}
+	if len(*conf.Plugins) == 0 {
+		return fmt.Errorf("empty plugins list")
+	}
+	for i, plugin := range *conf.Plugins {
+		if plugin.Type == "" {
+			return fmt.Errorf("missing type in plugin %d", i)
+		}
+	}
+	return nil
+}
+
+// nadLastModified returns the time the network-attachment-definition was created or last modified
+func nadLastModified(nad *nettypes.NetworkAttachmentDefinition) time.Time {
+	lastModified := nad.CreationTimestamp.Time
+	for _, entry := range nad.ManagedFields {
+		if entry.Time != nil && entry.Time.After(lastModified) {
+			lastModified = entry.Time.Time
+		}
+	}
+	return lastModified
+}
+
+// checkNetworkNamespace returns a clear error if the namespace of a network does not exist,
+// instead of the NotFound error of the network-attachment-definition
+func checkNetworkNamespace(client *ClientInfo, net *types.NetworkSelectionElement, apiRetry *types.APIRetry) error {
+	err := RetryAPICall(apiRetry, func() error {
+		_, getErr := client.Client.CoreV1().Namespaces().Get(context.TODO(), net.Namespace, metav1.GetOptions{})
+		return getErr
+	})
+	if k8serrors.IsNotFound(err) {
+		return fmt.Errorf("namespace %s referenced by network %s does not exist", net.Namespace, net.Name)
+	}
+	if k8serrors.IsForbidden(err) {
+		logging.Verbosef("warning: cannot check that namespace %s referenced by network %s exists, multus is not allowed to get namespaces (see the ClusterRole of the deployments): %v", net.Namespace, net.Name, err)
+	}
+	// other errors are reported when getting the delegate
+	return nil
+}
+
+// ownerNetworkAnnotation returns the networks annotation of the controller owner of the pod,
+// e.g. its ReplicaSet, or an empty string if the pod has no such owner
+func ownerNetworkAnnotation(client *ClientInfo, pod *v1.Pod, apiRetry *types.APIRetry) (string, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return "", nil
+	}
+
+	var getOwner func() (metav1.Object, error)
+	switch owner.Kind {
+	case "ReplicaSet":
+		getOwner = func() (metav1.Object, error) {
+			return client.Client.AppsV1().ReplicaSets(pod.Namespace).Get(context.TODO(), owner.Name, metav1.GetOptions{})
+		}
+	case "StatefulSet":
+		getOwner = func() (metav1.Object, error) {
+			return client.Client.AppsV1().StatefulSets(pod.Namespace).Get(context.TODO(), owner.Name, metav1.GetOptions{})
+		}
+	case "DaemonSet":
+		getOwner = func() (metav1.Object, error) {
+			return client.Client.AppsV1().DaemonSets(pod.Namespace).Get(context.TODO(), owner.Name, metav1.GetOptions{})
+		}
+	case "Job":
+		getOwner = func() (metav1.Object, error) {
+			return client.Client.BatchV1().Jobs(pod.Namespace).Get(context.TODO(), owner.Name, metav1.GetOptions{})
+		}
+	case "ReplicationController":
+		getOwner = func() (metav1.Object, error) {
+			return client.Client.CoreV1().ReplicationControllers(pod.Namespace).Get(context.TODO(), owner.Name, metav1.GetOptions{})
+		}
+	default:
+		logging.Debugf("ownerNetworkAnnotation: unsupported owner kind %s of pod %s/%s", owner.Kind, pod.Namespace, pod.Name)
+		return "", nil
+	}
+
+	var obj metav1.Object
+	err := RetryAPICall(apiRetry, func() error {
+		var getErr error
+		obj, getErr = getOwner()
+		return getErr
+	})
+	if k8serrors.IsNotFound(err) {
+		logging.Verbosef("warning: owner %s %s of pod %s/%s not found", owner.Kind, owner.Name, pod.Namespace, pod.Name)
+		return "", nil
+	}
+	if k8serrors.IsForbidden(err) {
+		// e.g. a ClusterRole predating inheritNetworkAnnotationFromOwner, the pod gets no networks
+		// from its owner
+		logging.Verbosef("warning: cannot inherit the networks of owner %s %s of pod %s/%s, multus is not allowed to get it (see the ClusterRole of the deployments): %v", owner.Kind, owner.Name, pod.Namespace, pod.Name, err)
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get owner %s %s of pod %s/%s: %w", owner.Kind, owner.Name, pod.Namespace, pod.Name, err)
+	}
+	if obj.GetUID() != owner.UID {
+		// the owner was replaced by an object of the same name
+		return "", nil
+	}
+	return obj.GetAnnotations()[networkAttachmentAnnot], nil
+}
+
+// nadAgeCheck rejects, or waits for, the network-attachment-definitions modified less than
+// minNADAgeSeconds ago, to avoid using them while controllers are still updating them. The
+// waits of a request end by the same deadline, minNADAgeSeconds after the first check, so
+// that they do not add up over the networks of the pod.
+type nadAgeCheck struct {
+	minAge   time.Duration
+	wait     bool
+	deadline time.Time
+}
+
+// newNADAgeCheck returns the nadAgeCheck of a request, or nil if minNADAgeSeconds is not set
+func newNADAgeCheck(conf *types.NetConf) *nadAgeCheck {
+	if conf.MinNADAgeSeconds <= 0 {
+		return nil
+	}
+	minAge := time.Duration(conf.MinNADAgeSeconds) * time.Second
+	return &nadAgeCheck{
+		minAge:   minAge,
+		wait:     conf.MinNADAgeAction == types.MinNADAgeActionWait,
+		deadline: time.Now().Add(minAge),
+	}
+}
+
+// check returns the network-attachment-definition, got again after waiting for it if needed
+func (c *nadAgeCheck) check(client *ClientInfo, net *types.NetworkSelectionElement, nad *nettypes.NetworkAttachmentDefinition) (*nettypes.NetworkAttachmentDefinition, error) {
+	lastModified := nadLastModified(nad)
+	if lastModified.IsZero() {
+		return nad, nil
+	}
+	age := time.Since(lastModified)
+	if age >= c.minAge {
+		return nad, nil
+	}
+	if !c.wait {
+		return nil, fmt.Errorf("network-attachment-definition %s/%s was modified %v ago, less than minNADAgeSeconds %v", net.Namespace, net.Name, age.Round(time.Second), c.minAge.Seconds())
+	}
+	wait := c.minAge - age
+	if remaining := time.Until(c.deadline); wait > remaining {
+		return nil, fmt.Errorf("network-attachment-definition %s/%s was modified %v ago, waiting %v for it would exceed the minNADAgeSeconds %v of the request", net.Namespace, net.Name, age.Round(time.Second), wait.Round(time.Millisecond), c.minAge.Seconds())
+	}
+	logging.Verbosef("nadAgeCheck: network-attachment-definition %s/%s was modified %v ago, waiting %v", net.Namespace, net.Name, age.Round(time.Second), wait)
+	time.Sleep(wait)
+
+	// the network-attachment-definition may have been modified again meanwhile
+	nad, err := client.getNetAttachDef(net.Namespace, net.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get network-attachment-definition %s/%s after waiting for it: %v", net.Namespace, net.Name, err)
+	}
+	if age := time.Since(nadLastModified(nad)); age < c.minAge {
+		return nil, fmt.Errorf("network-attachment-definition %s/%s was modified %v ago, less than minNADAgeSeconds %v", net.Namespace, net.Name, age.Round(time.Second), c.minAge.Seconds())
+	}
+	return nad, nil
+}
+
+// checkNodeSelector checks that the node of the pod matches the label selector
+func checkNodeSelector(client *ClientInfo, pod *v1.Pod, selector string) error {
+	nodeSelector, err := labels.Parse(selector)
+	if err != nil {
+		return logging.Errorf("checkNodeSelector: invalid node selector %q: %v", selector, err)
+	}
+	if pod.Spec.NodeName == "" {
+		return logging.Errorf("checkNodeSelector: pod %s/%s is not scheduled to a node", pod.Namespace, pod.Name)
+	}
+
+	node, err := client.Client.CoreV1().Nodes().Get(context.TODO(), pod.Spec.NodeName, metav1.GetOptions{})
+	if err != nil {
+		return logging.Errorf("checkNodeSelector: failed to get node %s: %v", pod.Spec.NodeName, err)
+	}
+	if !nodeSelector.Matches(labels.Set(node.Labels)) {
+		return &NodeSelectorMismatchError{fmt.Sprintf("node %s does not match the node selector %q", node.Name, selector)}
+	}
+	return nil
+}
+
 // GetK8sArgs gets k8s related args from CNI args
 func GetK8sArgs(args *skel.CmdArgs) (*types.K8sArgs, error) {
 	k8sArgs := &types.K8sArgs{}
@@ -342,19 +966,51 @@ func TryLoadPodDelegates(pod *v1.Pod, conf *types.NetConf, clientInfo *ClientInf
 		return 0, nil, nil
 	}
 
-	delegate, err := tryLoadK8sPodDefaultNetwork(clientInfo, pod, conf)
-	if err != nil {
-		return 0, nil, logging.Errorf("TryLoadPodDelegates: error in loading K8s cluster default network from pod annotation: %v", err)
+	ageCheck := newNADAgeCheck(conf)
+	if !conf.NoDefaultNetwork {
+		delegate, err := tryLoadK8sPodDefaultNetwork(clientInfo, pod, conf, ageCheck)
+		if err != nil {
+			return 0, nil, logging.Errorf("TryLoadPodDelegates: error in loading K8s cluster default network from pod annotation: %v", err)
+		}
+		if delegate != nil {
+			logging.Debugf("TryLoadPodDelegates: Overwrite the cluster default network with %v from pod annotations", delegate)
+
+			conf.Delegates[0] = delegate
+		}
 	}
-	if delegate != nil {
-		logging.Debugf("TryLoadPodDelegates: Overwrite the cluster default network with %v from pod annotations", delegate)
 
-		conf.Delegates[0] = delegate
+	if conf.InheritNetworkAnnotationFromOwner && pod != nil && pod.Annotations[networkAttachmentAnnot] == "" {
+		netAnnot, err := ownerNetworkAnnotation(clientInfo, pod, conf.APIRetry)
+		if err != nil {
+			return 0, nil, logging.Errorf("TryLoadPodDelegates: %v", err)
+		}
+		if netAnnot != "" {
+			logging.Debugf("TryLoadPodDelegates: pod %s/%s inherits the networks %q of its owner", pod.Namespace, pod.Name, netAnnot)
+			pod = pod.DeepCopy()
+			if pod.Annotations == nil {
+				pod.Annotations = map[string]string{}
+			}
+			pod.Annotations[networkAttachmentAnnot] = netAnnot
+		}
 	}
 
-	networks, err := GetPodNetwork(pod)
+	networks, err := getPodNetworks(pod, conf)
+	if _, ok := err.(*MalformedAnnotationError); ok && conf.TolerateMalformedAnnotation {
+		logging.Verbosef("warning: TryLoadPodDelegates: ignoring additional networks of pod: %v", err)
+		clientInfo.Eventf(pod, v1.EventTypeWarning, "MalformedNetworkAnnotation", "ignoring additional networks: %v", err)
+		return 0, clientInfo, nil
+	}
+	if _, ok := err.(*NoK8sNetworkError); err == nil || ok {
+		selected, selectErr := getSelectedNetwork(clientInfo, pod, conf.APIRetry)
+		if selectErr != nil {
+			return 0, nil, logging.Errorf("TryLoadPodDelegates: %v", selectErr)
+		}
+		if selected != nil && !containsNetworkSelection(networks, selected) {
+			networks, err = append(networks, selected), nil
+		}
+	}
 	if networks != nil {
-		delegates, err := GetNetworkDelegates(clientInfo, pod, networks, conf, resourceMap)
+		delegates, err := getNetworkDelegates(clientInfo, pod, networks, conf, resourceMap, ageCheck)
 
 		if err != nil {
 			if _, ok := err.(*NoK8sNetworkError); ok {
@@ -416,15 +1072,30 @@ func GetK8sClient(kubeconfig string, kubeClient *ClientInfo) (*ClientInfo, error
 
 	var err error
 	var config *rest.Config
+	var key, hash string
 
 	// Otherwise try to create a kubeClient from a given kubeConfig
 	if kubeconfig != "" {
+		data, err := checkKubeconfig(kubeconfig)
+		if err != nil {
+			return nil, logging.Errorf("GetK8sClient: %v", err)
+		}
+		// a rewritten kubeconfig, e.g. with a rotated certificate, gets a new client
+		sum := sha256.Sum256(data)
+		key, hash = kubeconfig, hex.EncodeToString(sum[:])
+		if client := sharedClients.get(key, hash); client != nil {
+			return client, nil
+		}
 		// uses the current context in kubeconfig
 		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
 		if err != nil {
 			return nil, logging.Errorf("GetK8sClient: failed to get context for the kubeconfig %v: %v", kubeconfig, err)
 		}
 	} else if os.Getenv("KUBERNETES_SERVICE_HOST") != "" && os.Getenv("KUBERNETES_SERVICE_PORT") != "" {
+		key = inClusterClientKey
+		if client := sharedClients.get(key, hash); client != nil {
+			return client, nil
+		}
 		// Try in-cluster config where multus might be running in a kubernetes pod
 		config, err = rest.InClusterConfig()
 		if err != nil {
@@ -441,7 +1112,73 @@ func GetK8sClient(kubeconfig string, kubeClient *ClientInfo) (*ClientInfo, error
 	// Set the config timeout to one minute.
 	config.Timeout = time.Minute
 
-	return NewClientInfo(config)
+	client, err := NewClientInfo(config)
+	if err != nil {
+		return nil, err
+	}
+	return sharedClients.add(key, hash, client), nil
+}
+
+// inClusterClientKey is the key of the client of the in-cluster config in sharedClients
+const inClusterClientKey = "in-cluster"
+
+// clientPool keeps the clients created by GetK8sClient, so that the later calls of the
+// process share their connections instead of dialing the apiserver again. It keeps a
+// single client per kubeconfig path, along with the hash of the file it was built from
+type clientPool struct {
+	sync.Mutex
+	clients map[string]*pooledClient
+}
+
+type pooledClient struct {
+	hash   string
+	client *ClientInfo
+}
+
+var sharedClients = &clientPool{clients: map[string]*pooledClient{}}
+
+// get returns the client of key if it was built from the file with hash
+func (p *clientPool) get(key, hash string) *ClientInfo {
+	p.Lock()
+	defer p.Unlock()
+	if pooled, ok := p.clients[key]; ok && pooled.hash == hash {
+		return pooled.client
+	}
+	return nil
+}
+
+// add keeps client for key, unless another one was added meanwhile for the same hash,
+// and returns the kept one. The client of a previous hash of key is evicted
+func (p *clientPool) add(key, hash string, client *ClientInfo) *ClientInfo {
+	p.Lock()
+	defer p.Unlock()
+	if existing, ok := p.clients[key]; ok {
+		if existing.hash == hash {
+			client.EventBroadcaster.Shutdown()
+			return existing.client
+		}
+		existing.client.EventBroadcaster.Shutdown()
+	}
+	p.clients[key] = &pooledClient{hash: hash, client: client}
+	return client
+}
+
+// checkKubeconfig reports a missing, unreadable or malformed kubeconfig file up
+// front, rather than with the first API call
+func checkKubeconfig(kubeconfig string) ([]byte, error) {
+	data, err := os.ReadFile(kubeconfig)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("kubeconfig at %s is unreadable: file does not exist", kubeconfig)
+	case errors.Is(err, fs.ErrPermission):
+		return nil, fmt.Errorf("kubeconfig at %s is unreadable: permission denied", kubeconfig)
+	case err != nil:
+		return nil, fmt.Errorf("kubeconfig at %s is unreadable: %v", kubeconfig, err)
+	}
+	if _, err := clientcmd.Load(data); err != nil {
+		return nil, fmt.Errorf("kubeconfig at %s is unreadable: failed to parse: %v", kubeconfig, err)
+	}
+	return data, nil
 }
 
 // NewClientInfo returns a `ClientInfo` from a configuration created from an
@@ -457,6 +1194,12 @@ func NewClientInfo(config *rest.Config) (*ClientInfo, error) {
 		return nil, err
 	}
 
+	return NewClientInfoFromClients(client, netclient), nil
+}
+
+// NewClientInfoFromClients returns a `ClientInfo` using pre-built clients, e.g. sharing
+// the transport of other clients, recording events with client
+func NewClientInfoFromClients(client kubernetes.Interface, netclient netclient.K8sCniCncfIoV1Interface) *ClientInfo {
 	broadcaster := record.NewBroadcaster()
 	broadcaster.StartLogging(klog.Infof)
 	broadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: client.CoreV1().Events("")})
@@ -466,7 +1209,7 @@ func NewClientInfo(config *rest.Config) (*ClientInfo, error) {
 		NetClient:        netclient,
 		EventBroadcaster: broadcaster,
 		EventRecorder:    recorder,
-	}, nil
+	}
 }
 
 // GetPodNetwork gets net-attach-def annotation from pod
@@ -482,13 +1225,119 @@ func GetPodNetwork(pod *v1.Pod) ([]*types.NetworkSelectionElement, error) {
 
 	networks, err := parsePodNetworkAnnotation(netAnnot, defaultNamespace)
 	if err != nil {
+		return nil, &MalformedAnnotationError{fmt.Sprintf("GetPodNetwork: invalid annotation %q of pod %s/%s: %v", networkAttachmentAnnot, pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, err)}
+	}
+	return networks, nil
+}
+
+// getPodNetworks gets the networks selected by the standard annotation and by
+// the additional annotation keys of conf, dropping identical selections
+func getPodNetworks(pod *v1.Pod, conf *types.NetConf) ([]*types.NetworkSelectionElement, error) {
+	networks, err := GetPodNetwork(pod)
+	if len(conf.AdditionalNetworkAnnotationKeys) == 0 {
+		return networks, err
+	}
+	if _, ok := err.(*NoK8sNetworkError); err != nil && !ok {
 		return nil, err
 	}
+
+	for _, key := range conf.AdditionalNetworkAnnotationKeys {
+		netAnnot := pod.Annotations[key]
+		if len(netAnnot) == 0 {
+			continue
+		}
+		extraNetworks, err := parsePodNetworkAnnotation(netAnnot, pod.ObjectMeta.Namespace)
+		if err != nil {
+			return nil, &MalformedAnnotationError{fmt.Sprintf("getPodNetworks: invalid annotation %q of pod %s/%s: %v", key, pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, err)}
+		}
+		for _, net := range extraNetworks {
+			if containsNetworkSelection(networks, net) {
+				logging.Debugf("getPodNetworks: skipping duplicate selection of network %s/%s from annotation %q", net.Namespace, net.Name, key)
+				continue
+			}
+			networks = append(networks, net)
+		}
+	}
+
+	if len(networks) == 0 {
+		return nil, &NoK8sNetworkError{"no kubernetes network found"}
+	}
 	return networks, nil
 }
 
+// getSelectedNetwork returns the network-attachment-definition of the namespace of the pod
+// matching the label selector of its network-selector annotation, or nil if the pod has no
+// such annotation. It fails unless exactly one network-attachment-definition matches.
+func getSelectedNetwork(client *ClientInfo, pod *v1.Pod, apiRetry *types.APIRetry) (*types.NetworkSelectionElement, error) {
+	selectorAnnot := strings.TrimSpace(pod.Annotations[networkSelectorAnnot])
+	if selectorAnnot == "" {
+		return nil, nil
+	}
+	selector, err := labels.Parse(selectorAnnot)
+	if err != nil {
+		return nil, fmt.Errorf("getSelectedNetwork: invalid annotation %q of pod %s/%s: %v", networkSelectorAnnot, pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, err)
+	}
+
+	var nads *nettypes.NetworkAttachmentDefinitionList
+	err = RetryAPICall(apiRetry, func() error {
+		var listErr error
+		nads, listErr = client.NetClient.NetworkAttachmentDefinitions(pod.ObjectMeta.Namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+		return listErr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getSelectedNetwork: failed to list the network-attachment-definitions of namespace %s: %v", pod.ObjectMeta.Namespace, err)
+	}
+
+	var names []string
+	for _, nad := range nads.Items {
+		names = append(names, nad.Name)
+	}
+	sort.Strings(names)
+	switch len(names) {
+	case 0:
+		return nil, fmt.Errorf("getSelectedNetwork: no network-attachment-definition of namespace %s matches the selector %q of pod %s/%s", pod.ObjectMeta.Namespace, selectorAnnot, pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+	case 1:
+		logging.Debugf("getSelectedNetwork: pod %s/%s selects the network %s with %q", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, names[0], selectorAnnot)
+		return &types.NetworkSelectionElement{Name: names[0], Namespace: pod.ObjectMeta.Namespace}, nil
+	default:
+		return nil, fmt.Errorf("getSelectedNetwork: the network-attachment-definitions %v of namespace %s match the selector %q of pod %s/%s, expected one", names, pod.ObjectMeta.Namespace, selectorAnnot, pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+	}
+}
+
+func containsNetworkSelection(networks []*types.NetworkSelectionElement, net *types.NetworkSelectionElement) bool {
+	for _, n := range networks {
+		if reflect.DeepEqual(n, net) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetNetworkStatusDelegate gets the delegate of a network listed in the pod network status
+func GetNetworkStatusDelegate(client *ClientInfo, pod *v1.Pod, status nettypes.NetworkStatus, conf *types.NetConf) (*types.DelegateNetConf, error) {
+	namespace, name, found := strings.Cut(status.Name, "/")
+	if !found {
+		return nil, logging.Errorf("GetNetworkStatusDelegate: network %q is not a network-attachment-definition", status.Name)
+	}
+
+	net := &types.NetworkSelectionElement{
+		Name:             name,
+		Namespace:        namespace,
+		InterfaceRequest: status.Interface,
+	}
+	delegate, _, err := getKubernetesDelegate(client, net, conf.ConfDir, pod, nil, conf.APIRetry, nil)
+	if err != nil {
+		return nil, err
+	}
+	return delegate, nil
+}
+
 // GetNetworkDelegates returns delegatenetconf from net-attach-def annotation in pod
 func GetNetworkDelegates(k8sclient *ClientInfo, pod *v1.Pod, networks []*types.NetworkSelectionElement, conf *types.NetConf, resourceMap map[string]*types.ResourceInfo) ([]*types.DelegateNetConf, error) {
+	return getNetworkDelegates(k8sclient, pod, networks, conf, resourceMap, newNADAgeCheck(conf))
+}
+
+func getNetworkDelegates(k8sclient *ClientInfo, pod *v1.Pod, networks []*types.NetworkSelectionElement, conf *types.NetConf, resourceMap map[string]*types.ResourceInfo, ageCheck *nadAgeCheck) ([]*types.DelegateNetConf, error) {
 	logging.Debugf("GetNetworkDelegates: %v, %v, %v, %v, %v", k8sclient, pod, networks, conf, resourceMap)
 
 	// Read all network objects referenced by 'networks'
@@ -508,9 +1357,25 @@ func GetNetworkDelegates(k8sclient *ClientInfo, pod *v1.Pod, networks []*types.N
 			}
 		}
 
-		delegate, updatedResourceMap, err := getKubernetesDelegate(k8sclient, net, conf.ConfDir, pod, resourceMap)
+		if sources, ok := conf.AllowedNamespaceSources[net.Namespace]; ok && defaultNamespace != net.Namespace {
+			if !isValidNamespaceReference(defaultNamespace, sources) {
+				return nil, logging.Errorf("GetNetworkDelegates: pod %s/%s is not allowed to use the networks of namespace %s, allowed from namespaces %v", defaultNamespace, pod.ObjectMeta.Name, net.Namespace, sources)
+			}
+		}
+
+		if conf.CheckNetworkNamespace && defaultNamespace != net.Namespace {
+			if err := checkNetworkNamespace(k8sclient, net, conf.APIRetry); err != nil {
+				return nil, logging.Errorf("GetNetworkDelegates: %v", err)
+			}
+		}
+
+		delegate, updatedResourceMap, err := getKubernetesDelegate(k8sclient, net, conf.ConfDir, pod, resourceMap, conf.APIRetry, ageCheck)
 		if err != nil {
-			return nil, logging.Errorf("GetNetworkDelegates: failed getting the delegate: %v", err)
+			if _, ok := err.(*NodeSelectorMismatchError); ok && net.Optional {
+				logging.Verbosef("warning: skipping optional network %s/%s: %v", net.Namespace, net.Name, err)
+				continue
+			}
+			return nil, logging.Errorf("GetNetworkDelegates: failed getting the delegate: %w", err)
 		}
 		delegates = append(delegates, delegate)
 		resourceMap = updatedResourceMap
@@ -519,6 +1384,22 @@ func GetNetworkDelegates(k8sclient *ClientInfo, pod *v1.Pod, networks []*types.N
 	return delegates, nil
 }
 
+// safeSysctlPrefixes are the prefixes of the sysctls a pod may request for a network,
+// which only affect the pod network namespace
+var safeSysctlPrefixes = []string{"net.ipv4.conf.", "net.ipv6.conf.", "net.ipv4.neigh.", "net.ipv6.neigh."}
+
+func isSafeSysctl(key string) bool {
+	if strings.Contains(key, "/") || strings.Contains(key, "..") {
+		return false
+	}
+	for _, prefix := range safeSysctlPrefixes {
+		if strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func isValidNamespaceReference(targetns string, allowednamespaces []string) bool {
 	for _, eachns := range allowednamespaces {
 		if eachns == targetns {
@@ -529,7 +1410,7 @@ func isValidNamespaceReference(targetns string, allowednamespaces []string) bool
 }
 
 // getNetDelegate loads delegate network for clusterNetwork/defaultNetworks
-func getNetDelegate(client *ClientInfo, pod *v1.Pod, netname, confdir, namespace string, resourceMap map[string]*types.ResourceInfo) (*types.DelegateNetConf, map[string]*types.ResourceInfo, error) {
+func getNetDelegate(client *ClientInfo, pod *v1.Pod, netname, confdir, namespace string, resourceMap map[string]*types.ResourceInfo, apiRetry *types.APIRetry) (*types.DelegateNetConf, map[string]*types.ResourceInfo, error) {
 	logging.Debugf("getNetDelegate: %v, %v, %v, %s", client, netname, confdir, namespace)
 	var configBytes []byte
 	isNetnamePath := strings.Contains(netname, "/")
@@ -541,7 +1422,7 @@ func getNetDelegate(client *ClientInfo, pod *v1.Pod, netname, confdir, namespace
 			Name:      netname,
 			Namespace: namespace,
 		}
-		delegate, resourceMap, err := getKubernetesDelegate(client, net, confdir, pod, resourceMap)
+		delegate, resourceMap, err := getKubernetesDelegate(client, net, confdir, pod, resourceMap, apiRetry, nil)
 		if err == nil {
 			return delegate, resourceMap, nil
 		}
@@ -620,6 +1501,75 @@ func getNetDelegate(client *ClientInfo, pod *v1.Pod, netname, confdir, namespace
 	return nil, resourceMap, logging.Errorf("getNetDelegate: cannot find network: %v", netname)
 }
 
+// defaultNetworkCacheEntry is a resolved clusterNetwork delegate config
+type defaultNetworkCacheEntry struct {
+	name    string
+	bytes   []byte
+	expires time.Time
+}
+
+var (
+	defaultNetworkCacheMutex sync.Mutex
+	defaultNetworkCache      = map[string]*defaultNetworkCacheEntry{}
+)
+
+// getClusterNetworkDelegate resolves the clusterNetwork delegate, reusing the
+// config resolved by a previous request for defaultNetworkCacheTTLSeconds
+func getClusterNetworkDelegate(kubeClient *ClientInfo, pod *v1.Pod, conf *types.NetConf, resourceMap map[string]*types.ResourceInfo) (*types.DelegateNetConf, map[string]*types.ResourceInfo, error) {
+	ttl := time.Duration(conf.DefaultNetworkCacheTTLSeconds) * time.Second
+	if ttl <= 0 {
+		return getNetDelegate(kubeClient, pod, conf.ClusterNetwork, conf.ConfDir, conf.MultusNamespace, resourceMap, conf.APIRetry)
+	}
+
+	key := fmt.Sprintf("%s:%s/%s", conf.ConfDir, conf.MultusNamespace, conf.ClusterNetwork)
+	defaultNetworkCacheMutex.Lock()
+	entry, ok := defaultNetworkCache[key]
+	defaultNetworkCacheMutex.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		delegate, err := types.LoadDelegateNetConf(entry.bytes, nil, "", "")
+		if err == nil {
+			logging.Debugf("getClusterNetworkDelegate: using cached config of clusterNetwork %s", conf.ClusterNetwork)
+			delegate.Name = entry.name
+			return delegate, resourceMap, nil
+		}
+	}
+
+	delegate, resourceMap, err := getNetDelegate(kubeClient, pod, conf.ClusterNetwork, conf.ConfDir, conf.MultusNamespace, resourceMap, conf.APIRetry)
+	if err != nil {
+		return nil, resourceMap, err
+	}
+	// configs with an allocated device are specific to the pod
+	if delegate.ResourceName == "" {
+		defaultNetworkCacheMutex.Lock()
+		defaultNetworkCache[key] = &defaultNetworkCacheEntry{
+			name:    delegate.Name,
+			bytes:   delegate.Bytes,
+			expires: time.Now().Add(ttl),
+		}
+		defaultNetworkCacheMutex.Unlock()
+	}
+	return delegate, resourceMap, nil
+}
+
+// CheckClusterNetwork verifies that the clusterNetwork of conf can be resolved
+func CheckClusterNetwork(client *ClientInfo, conf *types.NetConf) error {
+	if _, err := GetClusterNetworkDelegate(client, conf); err != nil {
+		return logging.Errorf("CheckClusterNetwork: %v", err)
+	}
+	return nil
+}
+
+// GetClusterNetworkDelegate resolves the clusterNetwork of conf outside of any pod
+func GetClusterNetworkDelegate(client *ClientInfo, conf *types.NetConf) (*types.DelegateNetConf, error) {
+	// no pod is involved, hence do not record events
+	quietClient := &ClientInfo{Client: client.Client, NetClient: client.NetClient}
+	delegate, _, err := getNetDelegate(quietClient, &v1.Pod{}, conf.ClusterNetwork, conf.ConfDir, conf.MultusNamespace, nil, conf.APIRetry)
+	if err != nil {
+		return nil, logging.Errorf("GetClusterNetworkDelegate: failed to get clusterNetwork %s in namespace %s: %v", conf.ClusterNetwork, conf.MultusNamespace, err)
+	}
+	return delegate, nil
+}
+
 // GetDefaultNetworks parses 'defaultNetwork' config, gets network json and put it into netconf.Delegates.
 func GetDefaultNetworks(pod *v1.Pod, conf *types.NetConf, kubeClient *ClientInfo, resourceMap map[string]*types.ResourceInfo) (map[string]*types.ResourceInfo, error) {
 	logging.Debugf("GetDefaultNetworks: %v, %v, %v, %v", pod, conf, kubeClient, resourceMap)
@@ -637,18 +1587,21 @@ func GetDefaultNetworks(pod *v1.Pod, conf *types.NetConf, kubeClient *ClientInfo
 		return resourceMap, nil
 	}
 
-	delegate, resourceMap, err := getNetDelegate(kubeClient, pod, conf.ClusterNetwork, conf.ConfDir, conf.MultusNamespace, resourceMap)
-
-	if err != nil {
-		return resourceMap, logging.Errorf("GetDefaultNetworks: failed to get clusterNetwork %s in namespace %s", conf.ClusterNetwork, conf.MultusNamespace)
+	if conf.ClusterNetwork != "" {
+		var delegate *types.DelegateNetConf
+		delegate, resourceMap, err = getClusterNetworkDelegate(kubeClient, pod, conf, resourceMap)
+		if err != nil {
+			return resourceMap, logging.Errorf("GetDefaultNetworks: failed to get clusterNetwork %s in namespace %s", conf.ClusterNetwork, conf.MultusNamespace)
+		}
+		delegate.MasterPlugin = true
+		delegates = append(delegates, delegate)
 	}
-	delegate.MasterPlugin = true
-	delegates = append(delegates, delegate)
 
-	// Pod in kube-system namespace does not have default network for now.
-	if !types.CheckSystemNamespaces(pod.ObjectMeta.Namespace, conf.SystemNamespaces) {
+	// Pod in kube-system namespace does not have default network for now, unless
+	// defaultNetworks provide the default network in place of clusterNetwork.
+	if conf.ClusterNetwork == "" || !types.CheckSystemNamespaces(pod.ObjectMeta.Namespace, conf.SystemNamespaces) {
 		for _, netname := range conf.DefaultNetworks {
-			delegate, resourceMap, err := getNetDelegate(kubeClient, pod, netname, conf.ConfDir, conf.MultusNamespace, resourceMap)
+			delegate, resourceMap, err := getNetDelegate(kubeClient, pod, netname, conf.ConfDir, conf.MultusNamespace, resourceMap, conf.APIRetry)
 			if err != nil {
 				return resourceMap, err
 			}
@@ -656,6 +1609,12 @@ func GetDefaultNetworks(pod *v1.Pod, conf *types.NetConf, kubeClient *ClientInfo
 		}
 	}
 
+	if conf.ClusterNetwork == "" {
+		// without clusterNetwork, defaultNetworks take precedence over delegates
+		conf.Delegates = append(delegates, conf.Delegates...)
+		return resourceMap, nil
+	}
+
 	if err = conf.AddDelegates(delegates); err != nil {
 		return resourceMap, err
 	}
@@ -663,8 +1622,36 @@ func GetDefaultNetworks(pod *v1.Pod, conf *types.NetConf, kubeClient *ClientInfo
 	return resourceMap, nil
 }
 
+// GetPriorityClassNetworks appends the networks that priorityClassNetworks maps to the
+// priority class of the pod to netconf.Delegates
+func GetPriorityClassNetworks(pod *v1.Pod, conf *types.NetConf, kubeClient *ClientInfo, resourceMap map[string]*types.ResourceInfo) (map[string]*types.ResourceInfo, error) {
+	netnames := conf.PriorityClassNetworks[pod.Spec.PriorityClassName]
+	if pod.Spec.PriorityClassName == "" || len(netnames) == 0 {
+		return resourceMap, nil
+	}
+	logging.Debugf("GetPriorityClassNetworks: priority class %s of pod %s/%s selects %v", pod.Spec.PriorityClassName, pod.Namespace, pod.Name, netnames)
+	if kubeClient == nil {
+		return resourceMap, logging.Errorf("GetPriorityClassNetworks: no k8s client to get networks %v", netnames)
+	}
+
+	var delegates []*types.DelegateNetConf
+	for _, netname := range netnames {
+		delegate, updatedResourceMap, err := getNetDelegate(kubeClient, pod, netname, conf.ConfDir, conf.MultusNamespace, resourceMap, conf.APIRetry)
+		if err != nil {
+			return resourceMap, logging.Errorf("GetPriorityClassNetworks: failed to get network %s of priority class %s: %v", netname, pod.Spec.PriorityClassName, err)
+		}
+		delegates = append(delegates, delegate)
+		resourceMap = updatedResourceMap
+	}
+
+	if err := conf.AddDelegates(delegates); err != nil {
+		return resourceMap, err
+	}
+	return resourceMap, nil
+}
+
 // tryLoadK8sPodDefaultNetwork get pod default network from annotations
-func tryLoadK8sPodDefaultNetwork(kubeClient *ClientInfo, pod *v1.Pod, conf *types.NetConf) (*types.DelegateNetConf, error) {
+func tryLoadK8sPodDefaultNetwork(kubeClient *ClientInfo, pod *v1.Pod, conf *types.NetConf, ageCheck *nadAgeCheck) (*types.DelegateNetConf, error) {
 	var netAnnot string
 	logging.Debugf("tryLoadK8sPodDefaultNetwork: %v, %v, %v", kubeClient, pod, conf)
 
@@ -683,7 +1670,7 @@ func tryLoadK8sPodDefaultNetwork(kubeClient *ClientInfo, pod *v1.Pod, conf *type
 		return nil, logging.Errorf("tryLoadK8sPodDefaultNetwork: more than one default network is specified: %s", netAnnot)
 	}
 
-	delegate, _, err := getKubernetesDelegate(kubeClient, networks[0], conf.ConfDir, pod, nil)
+	delegate, _, err := getKubernetesDelegate(kubeClient, networks[0], conf.ConfDir, pod, nil, conf.APIRetry, ageCheck)
 	if err != nil {
 		return nil, logging.Errorf("tryLoadK8sPodDefaultNetwork: failed getting the delegate: %v", err)
 	}
@@ -695,6 +1682,12 @@ func tryLoadK8sPodDefaultNetwork(kubeClient *ClientInfo, pod *v1.Pod, conf *type
 // ConfigSourceAnnotationKey specifies kubernetes annotation, defined in k8s.io/kubernetes/pkg/kubelet/types
 const ConfigSourceAnnotationKey = "kubernetes.io/config.source"
 
+// SkipsDefaultNetwork returns true if the pod opts out of the default network with the
+// k8s.v1.cni.cncf.io/default-network: none annotation.
+func SkipsDefaultNetwork(pod *v1.Pod) bool {
+	return pod != nil && pod.Annotations[noDefaultNetworkAnnot] == noDefaultNetworkValue
+}
+
 // IsStaticPod returns true if the pod is static pod.
 func IsStaticPod(pod *v1.Pod) bool {
 	if pod.Annotations != nil {
@@ -16,10 +16,18 @@
 package k8sclient
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	types020 "github.com/containernetworking/cni/pkg/types/020"
 	testutils "gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/testing"
@@ -31,7 +39,14 @@ import (
 	netfake "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned/fake"
 	netutils "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/utils"
 
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -83,6 +98,28 @@ var _ = Describe("k8sclient operations", func() {
 		Expect(err).NotTo(HaveOccurred())
 	})
 
+	It("parses the pod UID of the CNI_ARGS, if any", func() {
+		k8sArgs, err := GetK8sArgs(args)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(k8sArgs.K8S_POD_NAME)).To(Equal(fakePodName))
+		Expect(string(k8sArgs.K8S_POD_NAMESPACE)).To(Equal("test"))
+		Expect(string(k8sArgs.K8S_POD_UID)).To(Equal("testUID"))
+
+		// the UID is passed on to the delegates
+		rt, _ := types.CreateCNIRuntimeConf(args, k8sArgs, "net1", nil, nil)
+		Expect(rt.Args).To(ContainElement([2]string{"K8S_POD_UID", "testUID"}))
+
+		// older runtimes do not pass the UID
+		k8sArgs, err = GetK8sArgs(&skel.CmdArgs{
+			Args: fmt.Sprintf("K8S_POD_NAME=%s;K8S_POD_NAMESPACE=%s", fakePodName, "test"),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(k8sArgs.K8S_POD_NAME)).To(Equal(fakePodName))
+		Expect(string(k8sArgs.K8S_POD_UID)).To(BeEmpty())
+		rt, _ = types.CreateCNIRuntimeConf(args, k8sArgs, "net1", nil, nil)
+		Expect(rt.Args).To(ContainElement([2]string{"K8S_POD_UID", ""}))
+	})
+
 	It("retrieves delegates from kubernetes using simple format annotation", func() {
 		fakePod := testutils.NewFakePod(fakePodName, "net1,net2", "")
 		net1 := `{
@@ -132,6 +169,118 @@ var _ = Describe("k8sclient operations", func() {
 		Expect(delegates[1].MasterPlugin).To(BeFalse())
 	})
 
+	It("retries the network-attachment-definition lookup with apiRetry", func() {
+		fakePod := testutils.NewFakePod(fakePodName, "net1", "")
+		net1 := `{
+	"name": "net1",
+	"type": "mynet",
+	"cniVersion": "0.2.0"
+}`
+		netClientset := netfake.NewSimpleClientset()
+		clientInfo := &ClientInfo{
+			Client:    fake.NewSimpleClientset(),
+			NetClient: netClientset.K8sCniCncfIoV1(),
+		}
+		_, err := clientInfo.AddNetAttachDef(testutils.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "net1", net1))
+		Expect(err).NotTo(HaveOccurred())
+
+		getCount := 0
+		netClientset.PrependReactor("get", "network-attachment-definitions", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+			getCount++
+			if getCount <= 2 {
+				return true, nil, k8serrors.NewServiceUnavailable("apiserver is unavailable")
+			}
+			return false, nil, nil
+		})
+
+		networks, err := GetPodNetwork(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		netConf, err := types.LoadNetConf([]byte(`{
+			"name":"node-cni-network",
+			"type":"multus",
+			"delegates": [{"name": "weave1", "cniVersion": "0.2.0", "type": "weave-net"}],
+			"apiRetry": {"maxRetries": 3, "backoffMillis": 1, "backoffStrategy": "exponential"}
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+		netConf.ConfDir = tmpDir
+		delegates, err := GetNetworkDelegates(clientInfo, fakePod, networks, netConf, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getCount).To(Equal(3))
+		Expect(len(delegates)).To(Equal(1))
+		Expect(delegates[0].Conf.Type).To(Equal("mynet"))
+
+		// once the retries are exhausted the API error is surfaced
+		getCount = -10
+		_, err = GetNetworkDelegates(clientInfo, fakePod, networks, netConf, nil)
+		Expect(err).To(HaveOccurred())
+		Expect(k8serrors.IsServiceUnavailable(err)).To(BeTrue())
+		Expect(getCount).To(Equal(-6))
+	})
+
+	It("fails clearly when a network refers to a missing namespace with checkNetworkNamespace", func() {
+		fakePod := testutils.NewFakePod(fakePodName, "nosuchns/net1", "")
+		clientInfo := NewFakeClientInfo()
+		_, err := clientInfo.AddPod(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+
+		networks, err := GetPodNetwork(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		netConf, err := types.LoadNetConf([]byte(genericConf))
+		Expect(err).NotTo(HaveOccurred())
+		netConf.ConfDir = tmpDir
+
+		// without the check the missing network-attachment-definition is reported
+		_, err = GetNetworkDelegates(clientInfo, fakePod, networks, netConf, nil)
+		Expect(err).To(MatchError(ContainSubstring("cannot find a network-attachment-definition (net1) in namespace (nosuchns)")))
+
+		netConf.CheckNetworkNamespace = true
+		_, err = GetNetworkDelegates(clientInfo, fakePod, networks, netConf, nil)
+		Expect(err).To(MatchError("GetNetworkDelegates: namespace nosuchns referenced by network net1 does not exist"))
+	})
+
+	It("gets the network without the namespace check when namespaces are forbidden with checkNetworkNamespace", func() {
+		fakePod := testutils.NewFakePod(fakePodName, "nosuchns/net1", "")
+		clientInfo := NewFakeClientInfo()
+		_, err := clientInfo.AddPod(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		namespaceGets := 0
+		clientInfo.Client.(*fake.Clientset).PrependReactor("get", "namespaces", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+			namespaceGets++
+			return true, nil, k8serrors.NewForbidden(v1.Resource("namespaces"), "nosuchns", fmt.Errorf("get is not allowed"))
+		})
+
+		networks, err := GetPodNetwork(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		netConf, err := types.LoadNetConf([]byte(genericConf))
+		Expect(err).NotTo(HaveOccurred())
+		netConf.ConfDir = tmpDir
+		netConf.CheckNetworkNamespace = true
+
+		_, err = GetNetworkDelegates(clientInfo, fakePod, networks, netConf, nil)
+		Expect(err).To(MatchError(ContainSubstring("cannot find a network-attachment-definition (net1) in namespace (nosuchns)")))
+		Expect(namespaceGets).To(Equal(1))
+	})
+
+	It("clears the network status annotation and tolerates a deleted pod", func() {
+		fakePod := testutils.NewFakePod(fakePodName, "", "")
+		fakePod.Annotations[nettypes.NetworkStatusAnnot] = `[{"name": "weave1", "interface": "eth0"}]`
+		clientInfo := NewFakeClientInfo()
+		_, err := clientInfo.AddPod(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		// the ClusterRole grants get and update on pods/status, not patch
+		clientInfo.Client.(*fake.Clientset).PrependReactor("patch", "pods", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, k8serrors.NewForbidden(v1.Resource("pods"), fakePod.Name, fmt.Errorf("patch is not allowed"))
+		})
+
+		Expect(ClearPodNetworkStatusAnnotation(clientInfo, fakePod.Namespace, fakePod.Name)).To(Succeed())
+		pod, err := clientInfo.GetPod(fakePod.Namespace, fakePod.Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.Annotations).NotTo(HaveKey(nettypes.NetworkStatusAnnot))
+
+		Expect(clientInfo.DeletePod(fakePod.Namespace, fakePod.Name)).To(Succeed())
+		Expect(ClearPodNetworkStatusAnnotation(clientInfo, fakePod.Namespace, fakePod.Name)).To(Succeed())
+	})
+
 	It("fails when the network does not exist", func() {
 		fakePod := testutils.NewFakePod(fakePodName, "net1,net2", "")
 		net3 := `{
@@ -159,6 +308,88 @@ var _ = Describe("k8sclient operations", func() {
 		Expect(err).To(MatchError("GetNetworkDelegates: failed getting the delegate: getKubernetesDelegate: cannot find a network-attachment-definition (net1) in namespace (test): network-attachment-definitions.k8s.cni.cncf.io \"net1\" not found"))
 	})
 
+	It("injects the device IDs allocated for the resourceName of the network-attachment-definitions", func() {
+		fakePod := testutils.NewFakePod(fakePodName, "net1,net2", "")
+		clientInfo := NewFakeClientInfo()
+		_, err := clientInfo.AddPod(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = clientInfo.AddNetAttachDef(testutils.NewFakeNetAttachDefAnnotation(fakePod.ObjectMeta.Namespace, "net1",
+			`{"name": "net1", "type": "sriov", "cniVersion": "0.3.1"}`))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = clientInfo.AddNetAttachDef(testutils.NewFakeNetAttachDefAnnotation(fakePod.ObjectMeta.Namespace, "net2",
+			`{"name": "net2", "cniVersion": "0.3.1", "plugins": [{"type": "sriov"}, {"type": "tuning"}]}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		k8sArgs, err := GetK8sArgs(args)
+		Expect(err).NotTo(HaveOccurred())
+		pod, err := clientInfo.GetPod(string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_NAME))
+		Expect(err).NotTo(HaveOccurred())
+		networks, err := GetPodNetwork(pod)
+		Expect(err).NotTo(HaveOccurred())
+		netConf, err := types.LoadNetConf([]byte(genericConf))
+		Expect(err).NotTo(HaveOccurred())
+		netConf.ConfDir = tmpDir
+
+		// as allocated by the device plugin, and read from the kubelet
+		resourceMap := map[string]*types.ResourceInfo{
+			"intel.com/sriov": {DeviceIDs: []string{"0000:03:02.3", "0000:03:02.4"}},
+		}
+		delegates, err := GetNetworkDelegates(clientInfo, pod, networks, netConf, resourceMap)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(delegates).To(HaveLen(2))
+
+		Expect(delegates[0].ResourceName).To(Equal("intel.com/sriov"))
+		Expect(delegates[0].DeviceID).To(Equal("0000:03:02.3"))
+		conf := map[string]interface{}{}
+		Expect(json.Unmarshal(delegates[0].Bytes, &conf)).To(Succeed())
+		Expect(conf).To(HaveKeyWithValue("deviceID", "0000:03:02.3"))
+		Expect(conf).To(HaveKeyWithValue("pciBusID", "0000:03:02.3"))
+
+		Expect(delegates[1].ResourceName).To(Equal("intel.com/sriov"))
+		Expect(delegates[1].DeviceID).To(Equal("0000:03:02.4"))
+		confList := struct {
+			Plugins []map[string]interface{} `json:"plugins"`
+		}{}
+		Expect(json.Unmarshal(delegates[1].Bytes, &confList)).To(Succeed())
+		Expect(confList.Plugins[0]).To(HaveKeyWithValue("deviceID", "0000:03:02.4"))
+		Expect(confList.Plugins[0]).To(HaveKeyWithValue("pciBusID", "0000:03:02.4"))
+
+		// the device ID is also passed to the delegate in the runtime config
+		rt, _ := types.CreateCNIRuntimeConf(args, k8sArgs, "net1", nil, delegates[0])
+		Expect(rt.CapabilityArgs).To(HaveKeyWithValue("deviceID", "0000:03:02.3"))
+	})
+
+	It("fails with a descriptive error when the network-attachment-definition config is invalid", func() {
+		fakePod := testutils.NewFakePod(fakePodName, "net1", "")
+		clientInfo := NewFakeClientInfo()
+		_, err := clientInfo.AddPod(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+
+		k8sArgs, err := GetK8sArgs(args)
+		Expect(err).NotTo(HaveOccurred())
+		pod, err := clientInfo.GetPod(string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_NAME))
+		Expect(err).NotTo(HaveOccurred())
+		networks, err := GetPodNetwork(pod)
+		Expect(err).NotTo(HaveOccurred())
+		netConf, err := types.LoadNetConf([]byte(genericConf))
+		Expect(err).NotTo(HaveOccurred())
+		netConf.ConfDir = tmpDir
+
+		for config, reason := range map[string]string{
+			`{"name": "net1", "cniVersion": "0.3.1"}`:                                         "missing type",
+			`{"name": "net1", "type": "mynet", "cniVersion": "one"}`:                          `invalid cniVersion "one"`,
+			`{"name": "net1", "cniVersion": "0.3.1", "plugins": []}`:                          "empty plugins list",
+			`{"name": "net1", "cniVersion": "0.3.1", "plugins": [{"type": "a"}, {"mtu": 1}]}`: "missing type in plugin 1",
+		} {
+			Expect(clientInfo.NetClient.NetworkAttachmentDefinitions(fakePod.ObjectMeta.Namespace).Delete(context.TODO(), "net1", metav1.DeleteOptions{})).To(Or(Succeed(), MatchError(ContainSubstring("not found"))))
+			_, err = clientInfo.AddNetAttachDef(testutils.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "net1", config))
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = GetNetworkDelegates(clientInfo, pod, networks, netConf, nil)
+			Expect(err).To(MatchError(ContainSubstring("invalid config of network-attachment-definition test/net1: " + reason)))
+		}
+	})
+
 	It("retrieves delegates from kubernetes using JSON format annotation", func() {
 		fakePod := testutils.NewFakePod(fakePodName, `[
 {"name":"net1"},
@@ -226,7 +457,328 @@ var _ = Describe("k8sclient operations", func() {
 		pod, err := clientInfo.GetPod(string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_NAME))
 		networks, err := GetPodNetwork(pod)
 		Expect(len(networks)).To(Equal(0))
-		Expect(err).To(MatchError("parsePodNetworkAnnotation: failed to parse pod Network Attachment Selection Annotation JSON format: invalid character 'a' looking for beginning of value"))
+		Expect(err).To(MatchError(fmt.Sprintf(`GetPodNetwork: invalid annotation "k8s.v1.cni.cncf.io/networks" of pod %s/%s: parsePodNetworkAnnotation: failed to parse pod Network Attachment Selection Annotation JSON format "[adsfasdfasdfasf]": invalid character 'a' looking for beginning of value`, fakePod.ObjectMeta.Namespace, fakePodName)))
+	})
+
+	It("fails to load delegates when the annotation is malformed", func() {
+		fakePod := testutils.NewFakePod(fakePodName, `[{"name": "net1"`, "")
+		conf := `{
+			"name":"node-cni-network",
+			"type":"multus",
+			"kubeconfig":"/etc/kubernetes/node-kubeconfig.yaml",
+			"delegates": [{
+				"name": "weave1",
+				"cniVersion": "0.2.0",
+				"type": "weave-net"
+			}]
+		}`
+		netConf, err := types.LoadNetConf([]byte(conf))
+		Expect(err).NotTo(HaveOccurred())
+
+		clientInfo := NewFakeClientInfo()
+		_, err = clientInfo.AddPod(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, _, err = TryLoadPodDelegates(fakePod, netConf, clientInfo, nil)
+		Expect(err).To(BeAssignableToTypeOf(&MalformedAnnotationError{}))
+		Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("of pod %s/%s", fakePod.ObjectMeta.Namespace, fakePodName))))
+		Expect(err).To(MatchError(ContainSubstring(`JSON format "[{\"name\": \"net1\"": unexpected end of JSON input`)))
+	})
+
+	It("validates and normalizes the requested mac of the JSON format annotation", func() {
+		fakePod := testutils.NewFakePod(fakePodName, `[{"name": "net1", "mac": "C2-11-22-33-44-6A"}, {"name": "net2"}]`, "")
+		networks, err := GetPodNetwork(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(networks[0].MacRequest).To(Equal("c2:11:22:33:44:6a"))
+		Expect(networks[1].MacRequest).To(BeEmpty())
+
+		for mac, reason := range map[string]string{
+			"c2:11:22:33:44":          "address c2:11:22:33:44: invalid MAC address",
+			"02:00:5e:10:00:00:00:01": "must be a 6 bytes ethernet address",
+			"01:00:5e:00:00:01":       "must not be a multicast or broadcast address",
+			"ff:ff:ff:ff:ff:ff":       "must not be a multicast or broadcast address",
+			"00:00:00:00:00:00":       "must not be the all-zero address",
+		} {
+			fakePod = testutils.NewFakePod(fakePodName, fmt.Sprintf(`[{"name": "net1", "mac": %q}]`, mac), "")
+			_, err = GetPodNetwork(fakePod)
+			Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("parsePodNetworkAnnotation: invalid mac %q of network test/net1: %s", mac, reason))))
+		}
+	})
+
+	It("validates the requested gateway of the JSON format annotation", func() {
+		fakePod := testutils.NewFakePod(fakePodName, `[{"name": "net1", "gateway": ["10.1.1.1", "fd00::1"]}, {"name": "net2"}]`, "")
+		networks, err := GetPodNetwork(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(networks[0].GatewayCapabilityRequest).To(Equal([]string{"10.1.1.1", "fd00::1"}))
+		Expect(networks[1].GatewayCapabilityRequest).To(BeNil())
+
+		for _, gateway := range []string{"10.1.1", "10.1.1.1/24", "gateway"} {
+			fakePod = testutils.NewFakePod(fakePodName, fmt.Sprintf(`[{"name": "net1", "gateway": [%q]}]`, gateway), "")
+			_, err = GetPodNetwork(fakePod)
+			Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("parsePodNetworkAnnotation: invalid gateway %q of network test/net1, must be an IP address", gateway))))
+		}
+	})
+
+	It("validates the requested cni-args of the JSON format annotation", func() {
+		fakePod := testutils.NewFakePod(fakePodName, `[{"name": "net1", "cni-args": {"foo": "bar"}}, {"name": "net2"}]`, "")
+		networks, err := GetPodNetwork(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*networks[0].CNIArgs).To(Equal(map[string]interface{}{"foo": "bar"}))
+		Expect(networks[1].CNIArgs).To(BeNil())
+
+		for _, cniArgs := range []string{`{"foo": 1}`, `{"foo": true}`, `{"foo": {"bar": "baz"}}`, `{"foo": null}`} {
+			fakePod = testutils.NewFakePod(fakePodName, fmt.Sprintf(`[{"name": "net1", "cni-args": %s}]`, cniArgs), "")
+			_, err = GetPodNetwork(fakePod)
+			Expect(err).To(MatchError(ContainSubstring(`parsePodNetworkAnnotation: invalid cni-args of network test/net1: the value of "foo" must be a string`)))
+		}
+	})
+
+	It("validates the requested bandwidth of the JSON format annotation", func() {
+		fakePod := testutils.NewFakePod(fakePodName, `[
+			{"name": "net1", "bandwidth": {"ingressRate": 2048, "ingressBurst": 1600}},
+			{"name": "net2", "bandwidth": {"egressRate": 4096, "egressBurst": 1600}}
+		]`, "")
+		networks, err := GetPodNetwork(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(networks[0].BandwidthRequest).To(Equal(&types.BandwidthEntry{IngressRate: 2048, IngressBurst: 1600}))
+		Expect(networks[1].BandwidthRequest).To(Equal(&types.BandwidthEntry{EgressRate: 4096, EgressBurst: 1600}))
+
+		for bandwidth, reason := range map[string]string{
+			`{"ingressRate": 2048}`: "ingressRate and ingressBurst must be set together",
+			`{"ingressRate": 2048, "ingressBurst": 1600, "egressBurst": 1600}`: "egressRate and egressBurst must be set together",
+			`{"egressRate": -1, "egressBurst": 1600}`:                          "egressRate and egressBurst must not be negative",
+			`{}`: "neither the ingress nor the egress is limited",
+		} {
+			fakePod = testutils.NewFakePod(fakePodName, fmt.Sprintf(`[{"name": "net1", "bandwidth": %s}]`, bandwidth), "")
+			_, err = GetPodNetwork(fakePod)
+			Expect(err).To(MatchError(ContainSubstring("parsePodNetworkAnnotation: invalid bandwidth of network test/net1: " + reason)))
+		}
+	})
+
+	It("validates the requested mtu of the JSON format annotation", func() {
+		fakePod := testutils.NewFakePod(fakePodName, `[{"name": "net1", "mtu": 1400}, {"name": "net2"}]`, "")
+		networks, err := GetPodNetwork(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*networks[0].MTURequest).To(Equal(1400))
+		Expect(networks[1].MTURequest).To(BeNil())
+
+		fakePod = testutils.NewFakePod(fakePodName, `[{"name": "net1", "mtu": 0}]`, "")
+		_, err = GetPodNetwork(fakePod)
+		Expect(err).To(MatchError(ContainSubstring("parsePodNetworkAnnotation: invalid mtu 0 of network test/net1, must be a positive integer")))
+
+		fakePod = testutils.NewFakePod(fakePodName, `[{"name": "net1", "mtu": "1400"}]`, "")
+		_, err = GetPodNetwork(fakePod)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("validates the requested IP addresses of the JSON format annotation", func() {
+		fakePod := testutils.NewFakePod(fakePodName, `[{"name": "net1", "ips": ["10.1.1.5/24", "fd00::5/64"]}]`, "")
+		networks, err := GetPodNetwork(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(networks[0].IPRequest).To(Equal([]string{"10.1.1.5/24", "fd00::5/64"}))
+
+		fakePod = testutils.NewFakePod(fakePodName, `[{"name": "net1", "ips": ["10.1.1.5"]}]`, "")
+		networks, err = GetPodNetwork(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(networks[0].IPRequest).To(Equal([]string{"10.1.1.5"}))
+
+		for _, ip := range []string{"10.1.1.500", "10.1.1.5/33", "not-an-ip"} {
+			fakePod = testutils.NewFakePod(fakePodName, fmt.Sprintf(`[{"name": "net1", "ips": [%q]}]`, ip), "")
+			_, err = GetPodNetwork(fakePod)
+			Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("parsePodNetworkAnnotation: invalid IP address %q of network test/net1, must be an IP address or a CIDR", ip))))
+		}
+	})
+
+	It("validates the requested sysctls of the JSON format annotation", func() {
+		fakePod := testutils.NewFakePod(fakePodName, `[{"name": "net1", "sysctls": {"net.ipv4.conf.IFNAME.arp_ignore": "1"}}]`, "")
+		networks, err := GetPodNetwork(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(networks[0].SysctlsRequest).To(Equal(map[string]string{"net.ipv4.conf.IFNAME.arp_ignore": "1"}))
+
+		for _, key := range []string{"kernel.shm_rmid_forced", "net.ipv4.ip_forward", "net.ipv4.conf.", "net.ipv4.conf.../../kernel/panic"} {
+			fakePod = testutils.NewFakePod(fakePodName, fmt.Sprintf(`[{"name": "net1", "sysctls": {%q: "1"}}]`, key), "")
+			_, err = GetPodNetwork(fakePod)
+			Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("parsePodNetworkAnnotation: sysctl %q of network test/net1 is not allowed", key))))
+		}
+	})
+
+	It("truncates long malformed annotations in errors", func() {
+		annot := "[" + strings.Repeat("net1,", 40)
+		fakePod := testutils.NewFakePod(fakePodName, annot, "")
+
+		_, err := GetPodNetwork(fakePod)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).NotTo(ContainSubstring(annot))
+		Expect(err.Error()).To(ContainSubstring(annot[:maxAnnotationErrorLength] + "..."))
+	})
+
+	It("ignores additional networks when the annotation is malformed with tolerateMalformedAnnotation", func() {
+		fakePod := testutils.NewFakePod(fakePodName, `[{"name": "net1"`, "")
+		conf := `{
+			"name":"node-cni-network",
+			"type":"multus",
+			"kubeconfig":"/etc/kubernetes/node-kubeconfig.yaml",
+			"tolerateMalformedAnnotation": true,
+			"delegates": [{
+				"name": "weave1",
+				"cniVersion": "0.2.0",
+				"type": "weave-net"
+			}]
+		}`
+		netConf, err := types.LoadNetConf([]byte(conf))
+		Expect(err).NotTo(HaveOccurred())
+
+		recorder := record.NewFakeRecorder(10)
+		clientInfo := NewFakeClientInfo()
+		clientInfo.EventRecorder = recorder
+		_, err = clientInfo.AddPod(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+
+		numK8sDelegates, _, err := TryLoadPodDelegates(fakePod, netConf, clientInfo, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(numK8sDelegates).To(Equal(0))
+		Expect(len(netConf.Delegates)).To(Equal(1))
+		Expect(recorder.Events).To(Receive(ContainSubstring("MalformedNetworkAnnotation")))
+	})
+
+	It("selects a network by the label selector of the network-selector annotation", func() {
+		conf := `{
+			"name":"node-cni-network",
+			"type":"multus",
+			"kubeconfig":"/etc/kubernetes/node-kubeconfig.yaml",
+			"delegates": [{
+				"name": "weave1",
+				"cniVersion": "0.2.0",
+				"type": "weave-net"
+			}]
+		}`
+		labeledNetAttachDef := func(name string, labels map[string]string) *nettypes.NetworkAttachmentDefinition {
+			nad := testutils.NewFakeNetAttachDef("test", name, fmt.Sprintf(`{"name": %q, "type": "mynet", "cniVersion": "0.2.0"}`, name))
+			nad.Labels = labels
+			return nad
+		}
+
+		clientInfo := NewFakeClientInfo()
+		_, err := clientInfo.AddNetAttachDef(labeledNetAttachDef("net1", nil))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = clientInfo.AddNetAttachDef(labeledNetAttachDef("storage-a", map[string]string{"tier": "storage", "zone": "a"}))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = clientInfo.AddNetAttachDef(labeledNetAttachDef("frontend", map[string]string{"tier": "frontend"}))
+		Expect(err).NotTo(HaveOccurred())
+
+		// the selected network follows the networks of the annotation
+		fakePod := testutils.NewFakePod(fakePodName, "net1", "")
+		fakePod.Annotations["k8s.v1.cni.cncf.io/network-selector"] = "tier=storage"
+		netConf, err := types.LoadNetConf([]byte(conf))
+		Expect(err).NotTo(HaveOccurred())
+		netConf.ConfDir = tmpDir
+		numK8sDelegates, _, err := TryLoadPodDelegates(fakePod, netConf, clientInfo, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(numK8sDelegates).To(Equal(2))
+		Expect(netConf.Delegates[1].Conf.Name).To(Equal("net1"))
+		Expect(netConf.Delegates[2].Conf.Name).To(Equal("storage-a"))
+		Expect(netConf.Delegates[2].Name).To(Equal("test/storage-a"))
+
+		// or is the only network of the pod
+		fakePod = testutils.NewFakePod(fakePodName, "", "")
+		fakePod.Annotations["k8s.v1.cni.cncf.io/network-selector"] = "tier in (storage), zone=a"
+		netConf, err = types.LoadNetConf([]byte(conf))
+		Expect(err).NotTo(HaveOccurred())
+		numK8sDelegates, _, err = TryLoadPodDelegates(fakePod, netConf, clientInfo, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(numK8sDelegates).To(Equal(1))
+		Expect(netConf.Delegates[1].Conf.Name).To(Equal("storage-a"))
+
+		// no network matches
+		fakePod.Annotations["k8s.v1.cni.cncf.io/network-selector"] = "tier=database"
+		netConf, err = types.LoadNetConf([]byte(conf))
+		Expect(err).NotTo(HaveOccurred())
+		_, _, err = TryLoadPodDelegates(fakePod, netConf, clientInfo, nil)
+		Expect(err).To(MatchError(`TryLoadPodDelegates: getSelectedNetwork: no network-attachment-definition of namespace test matches the selector "tier=database" of pod test/testPod`))
+
+		// several networks match
+		_, err = clientInfo.AddNetAttachDef(labeledNetAttachDef("storage-b", map[string]string{"tier": "storage", "zone": "b"}))
+		Expect(err).NotTo(HaveOccurred())
+		fakePod.Annotations["k8s.v1.cni.cncf.io/network-selector"] = "tier=storage"
+		_, _, err = TryLoadPodDelegates(fakePod, netConf, clientInfo, nil)
+		Expect(err).To(MatchError(`TryLoadPodDelegates: getSelectedNetwork: the network-attachment-definitions [storage-a storage-b] of namespace test match the selector "tier=storage" of pod test/testPod, expected one`))
+
+		// the selector is invalid
+		fakePod.Annotations["k8s.v1.cni.cncf.io/network-selector"] = "tier in storage"
+		_, _, err = TryLoadPodDelegates(fakePod, netConf, clientInfo, nil)
+		Expect(err).To(MatchError(ContainSubstring(`getSelectedNetwork: invalid annotation "k8s.v1.cni.cncf.io/network-selector" of pod test/testPod`)))
+	})
+
+	It("inherits the networks annotation of the owner of the pod with inheritNetworkAnnotationFromOwner", func() {
+		fakePod := testutils.NewFakePod(fakePodName, "", "")
+		replicaSet := &appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "testrs",
+				Namespace:   fakePod.Namespace,
+				UID:         "rs-uid",
+				Annotations: map[string]string{networkAttachmentAnnot: "net1"},
+			},
+		}
+		isController := true
+		fakePod.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: "apps/v1",
+			Kind:       "ReplicaSet",
+			Name:       replicaSet.Name,
+			UID:        replicaSet.UID,
+			Controller: &isController,
+		}}
+		net1 := `{
+	"name": "net1",
+	"type": "mynet",
+	"cniVersion": "0.2.0"
+}`
+		conf := `{
+			"name":"node-cni-network",
+			"type":"multus",
+			"kubeconfig":"/etc/kubernetes/node-kubeconfig.yaml",
+			"inheritNetworkAnnotationFromOwner": %t,
+			"delegates": [{
+				"name": "weave1",
+				"cniVersion": "0.2.0",
+				"type": "weave-net"
+			}]
+		}`
+
+		clientInfo := NewFakeClientInfo()
+		_, err := clientInfo.AddPod(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = clientInfo.Client.AppsV1().ReplicaSets(fakePod.Namespace).Create(context.TODO(), replicaSet, metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = clientInfo.AddNetAttachDef(testutils.NewFakeNetAttachDef(fakePod.Namespace, "net1", net1))
+		Expect(err).NotTo(HaveOccurred())
+
+		netConf, err := types.LoadNetConf([]byte(fmt.Sprintf(conf, false)))
+		Expect(err).NotTo(HaveOccurred())
+		netConf.ConfDir = tmpDir
+		numK8sDelegates, _, err := TryLoadPodDelegates(fakePod, netConf, clientInfo, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(numK8sDelegates).To(Equal(0))
+
+		netConf, err = types.LoadNetConf([]byte(fmt.Sprintf(conf, true)))
+		Expect(err).NotTo(HaveOccurred())
+		netConf.ConfDir = tmpDir
+		numK8sDelegates, _, err = TryLoadPodDelegates(fakePod, netConf, clientInfo, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(numK8sDelegates).To(Equal(1))
+		Expect(netConf.Delegates[1].Name).To(Equal("test/net1"))
+		// the pod itself is not modified
+		Expect(fakePod.Annotations).NotTo(HaveKey(networkAttachmentAnnot))
+
+		// without the permission to get the owner, the pod inherits no networks
+		clientInfo.Client.(*fake.Clientset).PrependReactor("get", "replicasets", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, k8serrors.NewForbidden(appsv1.Resource("replicasets"), replicaSet.Name, fmt.Errorf("get is not allowed"))
+		})
+		netConf, err = types.LoadNetConf([]byte(fmt.Sprintf(conf, true)))
+		Expect(err).NotTo(HaveOccurred())
+		netConf.ConfDir = tmpDir
+		numK8sDelegates, _, err = TryLoadPodDelegates(fakePod, netConf, clientInfo, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(numK8sDelegates).To(Equal(0))
 	})
 
 	It("can set the default-gateway on an additional interface", func() {
@@ -906,6 +1458,52 @@ users:
 
 	})
 
+	It("restricts the cross-namespace references with allowedNamespaceSources", func() {
+		net1 := `{
+	"name": "net1",
+	"type": "mynet",
+	"cniVersion": "0.2.0"
+}`
+		clientInfo := NewFakeClientInfo()
+		_, err := clientInfo.AddNetAttachDef(testutils.NewFakeNetAttachDef("kube-system", "net1", net1))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = clientInfo.AddNetAttachDef(testutils.NewFakeNetAttachDef("shared", "net1", net1))
+		Expect(err).NotTo(HaveOccurred())
+
+		netConf, err := types.LoadNetConf([]byte(`{
+			"name":"node-cni-network",
+			"type":"multus",
+			"delegates": [{"name": "weave1", "cniVersion": "0.2.0", "type": "weave-net"}],
+			"allowedNamespaceSources": {"kube-system": ["infra"]}
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+		netConf.ConfDir = tmpDir
+
+		// the pods of test may not use the networks of kube-system
+		fakePod := testutils.NewFakePod(fakePodName, "kube-system/net1", "")
+		networks, err := GetPodNetwork(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = GetNetworkDelegates(clientInfo, fakePod, networks, netConf, nil)
+		Expect(err).To(MatchError("GetNetworkDelegates: pod test/testPod is not allowed to use the networks of namespace kube-system, allowed from namespaces [infra]"))
+
+		// but the pods of infra may
+		fakePod.ObjectMeta.Namespace = "infra"
+		networks, err = GetPodNetwork(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		delegates, err := GetNetworkDelegates(clientInfo, fakePod, networks, netConf, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(len(delegates)).To(Equal(1))
+		Expect(delegates[0].Conf.Type).To(Equal("mynet"))
+
+		// the networks of the namespaces not listed can be used from any namespace
+		fakePod = testutils.NewFakePod(fakePodName, "shared/net1", "")
+		networks, err = GetPodNetwork(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		delegates, err = GetNetworkDelegates(clientInfo, fakePod, networks, netConf, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(len(delegates)).To(Equal(1))
+	})
+
 	Context("Error function", func() {
 		It("Returns proper error message", func() {
 			err := &NoK8sNetworkError{"no kubernetes network found"}
@@ -1030,6 +1628,37 @@ users:
 			_, err = GetPodNetwork(pod)
 			Expect(err).To(HaveOccurred())
 		})
+
+		It("honors the interface names requested with netname@ifname", func() {
+			fakePod := testutils.NewFakePod(fakePodName, "net1@myeth, kube-system/net2@Eth_1.100,net3", "")
+
+			networks, err := GetPodNetwork(fakePod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(networks).To(HaveLen(3))
+			Expect(networks[0].Name).To(Equal("net1"))
+			Expect(networks[0].InterfaceRequest).To(Equal("myeth"))
+			Expect(networks[1].Namespace).To(Equal("kube-system"))
+			Expect(networks[1].InterfaceRequest).To(Equal("Eth_1.100"))
+			Expect(networks[2].InterfaceRequest).To(BeEmpty())
+		})
+
+		It("fails when the requested interface names are invalid or collide", func() {
+			fakePod := testutils.NewFakePod(fakePodName, "net1@averyveryverylongif", "")
+			_, err := GetPodNetwork(fakePod)
+			Expect(err).To(MatchError(ContainSubstring(`interface name "averyveryverylongif" is longer than 15 characters`)))
+
+			fakePod = testutils.NewFakePod(fakePodName, "net1@", "")
+			_, err = GetPodNetwork(fakePod)
+			Expect(err).To(MatchError(ContainSubstring(`empty interface name requested for network "net1@"`)))
+
+			fakePod = testutils.NewFakePod(fakePodName, `[{"name": "net1", "interface": "my/eth"}]`, "")
+			_, err = GetPodNetwork(fakePod)
+			Expect(err).To(MatchError(ContainSubstring(`interface name "my/eth" must not contain slashes, colons or whitespace`)))
+
+			fakePod = testutils.NewFakePod(fakePodName, "net1@myeth,net2@myeth", "")
+			_, err = GetPodNetwork(fakePod)
+			Expect(err).To(MatchError(ContainSubstring(`interface name "myeth" is requested by networks test/net1 and test/net2`)))
+		})
 	})
 
 	Context("setPodNetworkAnnotation", func() {
@@ -1171,13 +1800,73 @@ users:
 		// 	pod, err := clientInfo.GetPod(string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_NAME))
 		// 	Expect(err).NotTo(HaveOccurred())
 
-		// 	networkstatus := "test status"
-		// 	_, err = setPodNetworkAnnotation(clientInfo, "test", pod, networkstatus)
-		// 	Expect(err).NotTo(HaveOccurred())
-		// })
-	})
+		// 	networkstatus := "test status"
+		// 	_, err = setPodNetworkAnnotation(clientInfo, "test", pod, networkstatus)
+		// 	Expect(err).NotTo(HaveOccurred())
+		// })
+	})
+
+	Context("SetNetworkStatus", func() {
+		It("truncates the network status of many interfaces to networkStatusMaxSize", func() {
+			netstatus := []nettypes.NetworkStatus{}
+			for i := 0; i < 200; i++ {
+				netstatus = append(netstatus, nettypes.NetworkStatus{
+					Name:      fmt.Sprintf("kube-system/net%d", i),
+					Interface: fmt.Sprintf("net%d", i),
+					IPs:       []string{fmt.Sprintf("10.1.%d.%d", i/256, i%256), fmt.Sprintf("fd00::%x", i)},
+					Mac:       "0a:58:0a:01:00:02",
+					Default:   i == 199,
+					DNS:       nettypes.DNS{Nameservers: []string{"10.0.0.10"}, Search: []string{"svc.cluster.local", "cluster.local"}},
+					Gateway:   []string{"10.1.0.1"},
+				})
+			}
+
+			fakePod := testutils.NewFakePod(fakePodName, "", "")
+			clientInfo := NewFakeClientInfo()
+			_, err := clientInfo.AddPod(fakePod)
+			Expect(err).NotTo(HaveOccurred())
+			k8sArgs, err := GetK8sArgs(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			getStatus := func() (string, []nettypes.NetworkStatus) {
+				pod, err := clientInfo.GetPod(fakePod.ObjectMeta.Namespace, fakePod.ObjectMeta.Name)
+				Expect(err).NotTo(HaveOccurred())
+				annotation := pod.Annotations[nettypes.NetworkStatusAnnot]
+				status := []nettypes.NetworkStatus{}
+				Expect(json.Unmarshal([]byte(annotation), &status)).To(Succeed())
+				return annotation, status
+			}
+
+			// without the dns and gateway fields
+			netConf, err := types.LoadNetConf([]byte(`{
+			"name": "node-cni-network",
+			"type": "multus",
+			"networkStatusMaxSize": 40000,
+			"delegates": [{"type": "weave-net"}]
+		}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(SetNetworkStatus(clientInfo, k8sArgs, netstatus, netConf)).To(Succeed())
+			annotation, status := getStatus()
+			Expect(len(annotation)).To(BeNumerically("<=", 40000))
+			Expect(status).To(HaveLen(200))
+			Expect(status[0].IPs).To(Equal(netstatus[0].IPs))
+			Expect(status[0].DNS.Nameservers).To(BeEmpty())
+			Expect(status[0].Gateway).To(BeEmpty())
+
+			// only the first networks, the default one first
+			netConf.NetworkStatusMaxSize = 4096
+			Expect(SetNetworkStatus(clientInfo, k8sArgs, netstatus, netConf)).To(Succeed())
+			annotation, status = getStatus()
+			Expect(len(annotation)).To(BeNumerically("<=", 4096))
+			Expect(len(status)).To(BeNumerically(">", 1))
+			Expect(len(status)).To(BeNumerically("<", 200))
+			Expect(status[0].Name).To(Equal("kube-system/net199"))
+			Expect(status[0].Default).To(BeTrue())
+			Expect(status[0].IPs).To(BeEmpty())
+			Expect(status[1].Name).To(Equal("kube-system/net0"))
+			Expect(status[1].Interface).To(Equal("net0"))
+		})
 
-	Context("SetNetworkStatus", func() {
 		It("Sets network status without error when pod UIDs match", func() {
 			result := &types020.Result{
 				CNIVersion: "0.2.0",
@@ -1539,4 +2228,514 @@ users:
 			Expect(err).NotTo(HaveOccurred())
 		})
 	})
+
+	It("drops empty entries of simple format annotation", func() {
+		fakePod := testutils.NewFakePod(fakePodName, "net1, , net2,", "")
+		net1 := `{
+	"name": "net1",
+	"type": "mynet",
+	"cniVersion": "0.2.0"
+}`
+		net2 := `{
+	"name": "net2",
+	"type": "mynet2",
+	"cniVersion": "0.2.0"
+}`
+
+		clientInfo := NewFakeClientInfo()
+		_, err := clientInfo.AddPod(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = clientInfo.AddNetAttachDef(testutils.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "net1", net1))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = clientInfo.AddNetAttachDef(testutils.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "net2", net2))
+		Expect(err).NotTo(HaveOccurred())
+
+		networks, err := GetPodNetwork(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		netConf, err := types.LoadNetConf([]byte(genericConf))
+		Expect(err).NotTo(HaveOccurred())
+		netConf.ConfDir = tmpDir
+		delegates, err := GetNetworkDelegates(clientInfo, fakePod, networks, netConf, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(len(delegates)).To(Equal(2))
+		Expect(delegates[0].Conf.Name).To(Equal("net1"))
+		Expect(delegates[1].Conf.Name).To(Equal("net2"))
+
+		// an annotation without any network is still an error
+		fakePod.Annotations[networkAttachmentAnnot] = " , ,"
+		_, err = GetPodNetwork(fakePod)
+		Expect(err).To(MatchError(ContainSubstring("does not contain any network")))
+	})
+
+	It("merges networks from additional annotation keys", func() {
+		fakePod := testutils.NewFakePod(fakePodName, "net1", "")
+		fakePod.Annotations["example.com/networks"] = "net2, net1"
+		conf := `{
+			"name":"node-cni-network",
+			"type":"multus",
+			"kubeconfig":"/etc/kubernetes/node-kubeconfig.yaml",
+			"delegates": [{
+				"name": "weave1",
+				"cniVersion": "0.2.0",
+				"type": "weave-net"
+			}],
+			"additionalNetworkAnnotationKeys": ["example.com/networks", "example.com/unused"]
+		}`
+		netConf, err := types.LoadNetConf([]byte(conf))
+		Expect(err).NotTo(HaveOccurred())
+		netConf.ConfDir = tmpDir
+
+		clientInfo := NewFakeClientInfo()
+		_, err = clientInfo.AddPod(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = clientInfo.AddNetAttachDef(
+			testutils.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "net1", "{\"type\": \"mynet1\"}"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = clientInfo.AddNetAttachDef(
+			testutils.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "net2", "{\"type\": \"mynet2\"}"))
+		Expect(err).NotTo(HaveOccurred())
+
+		numK8sDelegates, _, err := TryLoadPodDelegates(fakePod, netConf, clientInfo, nil)
+		Expect(err).NotTo(HaveOccurred())
+		// net1 is selected by both annotations but attached only once
+		Expect(numK8sDelegates).To(Equal(2))
+		Expect(len(netConf.Delegates)).To(Equal(3))
+		Expect(netConf.Delegates[1].Conf.Type).To(Equal("mynet1"))
+		Expect(netConf.Delegates[2].Conf.Type).To(Equal("mynet2"))
+
+		// networks selected only by an additional key are attached as well
+		delete(fakePod.Annotations, networkAttachmentAnnot)
+		networks, err := getPodNetworks(fakePod, netConf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(len(networks)).To(Equal(2))
+		Expect(networks[0].Name).To(Equal("net2"))
+		Expect(networks[1].Name).To(Equal("net1"))
+	})
+
+	It("rejects or waits for recently modified network-attachment-definitions with minNADAgeSeconds", func() {
+		fakePod := testutils.NewFakePod(fakePodName, "net1", "")
+		conf := `{
+			"name":"node-cni-network",
+			"type":"multus",
+			"kubeconfig":"/etc/kubernetes/node-kubeconfig.yaml",
+			"minNADAgeSeconds": 1,
+			"delegates": [{
+				"name": "weave1",
+				"cniVersion": "0.2.0",
+				"type": "weave-net"
+			}]
+		}`
+		netConf, err := types.LoadNetConf([]byte(conf))
+		Expect(err).NotTo(HaveOccurred())
+		netConf.ConfDir = tmpDir
+
+		clientInfo := NewFakeClientInfo()
+		_, err = clientInfo.AddPod(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		nad := testutils.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "net1", "{\"type\": \"mynet1\"}")
+		nad.CreationTimestamp = metav1.Now()
+		_, err = clientInfo.AddNetAttachDef(nad)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, _, err = TryLoadPodDelegates(fakePod, netConf, clientInfo, nil)
+		Expect(err).To(MatchError(ContainSubstring("network-attachment-definition test/net1 was modified")))
+
+		netConf.MinNADAgeAction = types.MinNADAgeActionWait
+		start := time.Now()
+		numK8sDelegates, _, err := TryLoadPodDelegates(fakePod, netConf, clientInfo, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(numK8sDelegates).To(Equal(1))
+		Expect(time.Since(start)).To(BeNumerically(">=", 500*time.Millisecond))
+	})
+
+	It("bounds the waits of minNADAgeSeconds by a deadline shared by the networks of the pod", func() {
+		conf := &types.NetConf{MinNADAgeSeconds: 1, MinNADAgeAction: types.MinNADAgeActionWait}
+		net := &types.NetworkSelectionElement{Name: "net1", Namespace: "test"}
+		nad := testutils.NewFakeNetAttachDef("test", "net1", "{\"type\": \"mynet1\"}")
+		nad.CreationTimestamp = metav1.Now()
+
+		Expect(newNADAgeCheck(&types.NetConf{})).To(BeNil())
+		ageCheck := newNADAgeCheck(conf)
+		// the previous networks already spent the wait of the request
+		ageCheck.deadline = time.Now().Add(100 * time.Millisecond)
+		start := time.Now()
+		_, err := ageCheck.check(NewFakeClientInfo(), net, nad)
+		Expect(err).To(MatchError(ContainSubstring("would exceed the minNADAgeSeconds 1 of the request")))
+		Expect(time.Since(start)).To(BeNumerically("<", 100*time.Millisecond))
+
+		// an old enough network-attachment-definition is used as is, without getting it again
+		nad.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Minute))
+		checked, err := ageCheck.check(nil, net, nad)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(checked).To(BeIdenticalTo(nad))
+	})
+
+	It("caches the clusterNetwork config for defaultNetworkCacheTTLSeconds", func() {
+		fakePod := testutils.NewFakePod(fakePodName, "", "")
+		conf := fmt.Sprintf(`{
+			"name":"node-cni-network",
+			"type":"multus",
+			"clusterNetwork": "net1",
+			"multusNamespace": "kube-system",
+			"kubeconfig":"/etc/kubernetes/node-kubeconfig.yaml",
+			"defaultNetworkCacheTTLSeconds": 60,
+			"confDir": %q
+		}`, tmpDir)
+
+		netClientset := netfake.NewSimpleClientset()
+		clientInfo := &ClientInfo{
+			Client:    fake.NewSimpleClientset(),
+			NetClient: netClientset.K8sCniCncfIoV1(),
+		}
+		_, err := clientInfo.AddPod(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = clientInfo.AddNetAttachDef(
+			testutils.NewFakeNetAttachDef("kube-system", "net1", "{\"type\": \"mynet1\"}"))
+		Expect(err).NotTo(HaveOccurred())
+
+		nadGets := func() int {
+			count := 0
+			for _, action := range netClientset.Actions() {
+				if action.GetVerb() == "get" && action.GetResource().Resource == "network-attachment-definitions" {
+					count++
+				}
+			}
+			return count
+		}
+
+		for i := 0; i < 2; i++ {
+			netConf, err := types.LoadNetConf([]byte(conf))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = GetDefaultNetworks(fakePod, netConf, clientInfo, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.Delegates[0].Name).To(Equal("kube-system/net1"))
+			Expect(netConf.Delegates[0].Conf.Type).To(Equal("mynet1"))
+			Expect(netConf.Delegates[0].MasterPlugin).To(BeTrue())
+		}
+		Expect(nadGets()).To(Equal(1))
+
+		// expire the cached config
+		defaultNetworkCacheMutex.Lock()
+		for _, entry := range defaultNetworkCache {
+			entry.expires = time.Now().Add(-time.Second)
+		}
+		defaultNetworkCacheMutex.Unlock()
+
+		netConf, err := types.LoadNetConf([]byte(conf))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = GetDefaultNetworks(fakePod, netConf, clientInfo, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(nadGets()).To(Equal(2))
+	})
+
+	Context("with a node-selector network-attachment-definition", func() {
+		var fakePod *v1.Pod
+		var clientInfo *ClientInfo
+		var netConf *types.NetConf
+
+		BeforeEach(func() {
+			fakePod = testutils.NewFakePod(fakePodName, "", "")
+			fakePod.Spec.NodeName = "node-a"
+
+			clientInfo = NewFakeClientInfo()
+			_, err := clientInfo.Client.CoreV1().Nodes().Create(context.TODO(), &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "node-a",
+					Labels: map[string]string{"fabric": "ethernet"},
+				},
+			}, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = clientInfo.AddNetAttachDef(
+				testutils.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "net1", "{\"type\": \"mynet1\"}"))
+			Expect(err).NotTo(HaveOccurred())
+			gpuNet := testutils.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "gpunet", "{\"type\": \"mynet2\"}")
+			gpuNet.Annotations = map[string]string{nodeSelectorAnnot: "fabric=gpu"}
+			_, err = clientInfo.AddNetAttachDef(gpuNet)
+			Expect(err).NotTo(HaveOccurred())
+
+			netConf, err = types.LoadNetConf([]byte(genericConf))
+			Expect(err).NotTo(HaveOccurred())
+			netConf.ConfDir = tmpDir
+		})
+
+		It("fails for a required network on a non-matching node", func() {
+			fakePod.Annotations[networkAttachmentAnnot] = "net1,gpunet"
+			networks, err := GetPodNetwork(fakePod)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = GetNetworkDelegates(clientInfo, fakePod, networks, netConf, nil)
+			Expect(err).To(MatchError(ContainSubstring(`node node-a does not match the node selector "fabric=gpu"`)))
+		})
+
+		It("skips an optional network on a non-matching node", func() {
+			fakePod.Annotations[networkAttachmentAnnot] = `[{"name": "net1"}, {"name": "gpunet", "optional": true}]`
+			networks, err := GetPodNetwork(fakePod)
+			Expect(err).NotTo(HaveOccurred())
+
+			delegates, err := GetNetworkDelegates(clientInfo, fakePod, networks, netConf, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(delegates)).To(Equal(1))
+			Expect(delegates[0].Conf.Type).To(Equal("mynet1"))
+		})
+
+		It("attaches the network on a matching node", func() {
+			node, err := clientInfo.Client.CoreV1().Nodes().Get(context.TODO(), "node-a", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			node.Labels["fabric"] = "gpu"
+			_, err = clientInfo.Client.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			fakePod.Annotations[networkAttachmentAnnot] = "gpunet"
+			networks, err := GetPodNetwork(fakePod)
+			Expect(err).NotTo(HaveOccurred())
+
+			delegates, err := GetNetworkDelegates(clientInfo, fakePod, networks, netConf, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(delegates)).To(Equal(1))
+			Expect(delegates[0].Conf.Type).To(Equal("mynet2"))
+		})
+	})
+
+	Context("with an unreadable kubeconfig", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = os.MkdirTemp("", "multus-test")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("fails early when the file does not exist", func() {
+			kubeconfig := filepath.Join(dir, "missing.conf")
+			_, err := GetK8sClient(kubeconfig, nil)
+			Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("kubeconfig at %s is unreadable: file does not exist", kubeconfig))))
+		})
+
+		It("fails early when the file cannot be read", func() {
+			if os.Geteuid() == 0 {
+				Skip("file permissions do not apply to root")
+			}
+			kubeconfig := filepath.Join(dir, "kubelet.conf")
+			Expect(os.WriteFile(kubeconfig, []byte("apiVersion: v1\nkind: Config\n"), 0000)).To(Succeed())
+			_, err := GetK8sClient(kubeconfig, nil)
+			Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("kubeconfig at %s is unreadable: permission denied", kubeconfig))))
+		})
+
+		It("fails early when the file cannot be parsed", func() {
+			kubeconfig := filepath.Join(dir, "kubelet.conf")
+			Expect(os.WriteFile(kubeconfig, []byte("clusters: [\n"), 0600)).To(Succeed())
+			_, err := GetK8sClient(kubeconfig, nil)
+			Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("kubeconfig at %s is unreadable: failed to parse", kubeconfig))))
+		})
+	})
+
+	It("spends the retries of the API calls out of the retry budget", func() {
+		calls := 0
+		failingCall := func() error {
+			calls++
+			return k8serrors.NewServiceUnavailable("apiserver is unavailable")
+		}
+		retry := &types.APIRetry{MaxRetries: 10, BackoffMillis: 20, BackoffStrategy: types.APIRetryBackoffLinear, Budget: types.NewRetryBudget(50)}
+
+		// the second retry waits 40ms while at most 30ms are left
+		err := RetryAPICall(retry, failingCall)
+		Expect(k8serrors.IsServiceUnavailable(err)).To(BeTrue())
+		Expect(calls).To(Equal(2))
+
+		// the budget is shared with the next calls of the request
+		calls = 0
+		retry.BackoffMillis = 1
+		err = RetryAPICall(retry, failingCall)
+		Expect(err).To(HaveOccurred())
+		Expect(calls).To(BeNumerically("<", 11))
+	})
+
+	It("serves the pod and net-attach-def lookups from the cache", func() {
+		fakePod := testutils.NewFakePod(fakePodName, "net1", "")
+		net1 := `{
+	"name": "net1",
+	"type": "mynet",
+	"cniVersion": "0.2.0"
+}`
+		clientset := fake.NewSimpleClientset(fakePod)
+		netClientset := netfake.NewSimpleClientset()
+		clientInfo := &ClientInfo{
+			Client:    clientset,
+			NetClient: netClientset.K8sCniCncfIoV1(),
+		}
+		_, err := clientInfo.AddNetAttachDef(testutils.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "net1", net1))
+		Expect(err).NotTo(HaveOccurred())
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		clientInfo.StartCache("", stopCh)
+		Expect(clientInfo.WaitForCacheSync(5 * time.Second)).To(BeTrue())
+
+		podGets, netAttachDefGets := 0, 0
+		clientset.PrependReactor("get", "pods", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+			podGets++
+			return false, nil, nil
+		})
+		netClientset.PrependReactor("get", "network-attachment-definitions", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+			netAttachDefGets++
+			return false, nil, nil
+		})
+
+		pod, err := clientInfo.GetPodFromCache(fakePod.ObjectMeta.Namespace, fakePod.ObjectMeta.Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.ObjectMeta.UID).To(Equal(fakePod.ObjectMeta.UID))
+		Expect(podGets).To(Equal(0))
+
+		networks, err := GetPodNetwork(pod)
+		Expect(err).NotTo(HaveOccurred())
+		netConf, err := types.LoadNetConf([]byte(genericConf))
+		Expect(err).NotTo(HaveOccurred())
+		netConf.ConfDir = tmpDir
+		delegates, err := GetNetworkDelegates(clientInfo, pod, networks, netConf, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(len(delegates)).To(Equal(1))
+		Expect(delegates[0].Conf.Type).To(Equal("mynet"))
+		Expect(netAttachDefGets).To(Equal(0))
+
+		// the cache follows the changes of the pods
+		pod.ObjectMeta.Annotations["foo"] = "bar"
+		_, err = clientset.CoreV1().Pods(pod.ObjectMeta.Namespace).Update(context.TODO(), pod, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() string {
+			cached, err := clientInfo.GetPodFromCache(fakePod.ObjectMeta.Namespace, fakePod.ObjectMeta.Name)
+			Expect(err).NotTo(HaveOccurred())
+			return cached.ObjectMeta.Annotations["foo"]
+		}, 5*time.Second).Should(Equal("bar"))
+		Expect(podGets).To(Equal(0))
+	})
+
+	It("gets the pods missing from the cache from the apiserver", func() {
+		clientset := fake.NewSimpleClientset()
+		clientInfo := &ClientInfo{
+			Client:    clientset,
+			NetClient: netfake.NewSimpleClientset().K8sCniCncfIoV1(),
+		}
+
+		// without a cache, the pods are always read from the apiserver
+		freshPod := testutils.NewFakePod("freshpod", "", "")
+		podGets := 0
+		clientset.PrependReactor("get", "pods", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+			podGets++
+			return true, freshPod, nil
+		})
+		pod, err := clientInfo.GetPodFromCache(freshPod.ObjectMeta.Namespace, freshPod.ObjectMeta.Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.ObjectMeta.Name).To(Equal("freshpod"))
+		Expect(podGets).To(Equal(1))
+
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		clientInfo.StartCache("", stopCh)
+		Expect(clientInfo.WaitForCacheSync(5 * time.Second)).To(BeTrue())
+
+		// the pod is not known to the cache yet
+		pod, err = clientInfo.GetPodFromCache(freshPod.ObjectMeta.Namespace, freshPod.ObjectMeta.Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.ObjectMeta.Name).To(Equal("freshpod"))
+		Expect(podGets).To(Equal(2))
+	})
+
+	It("reuses the client and its connection across the calls of GetK8sClient", func() {
+		var newConns, requests int32
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			Expect(r.URL.Path).To(Equal("/apis/k8s.cni.cncf.io/v1/namespaces/test/network-attachment-definitions/net1"))
+			w.Header().Set("Content-Type", "application/json")
+			Expect(json.NewEncoder(w).Encode(testutils.NewFakeNetAttachDef("test", "net1", `{"cniVersion": "0.3.1", "type": "mynet"}`))).To(Succeed())
+		}))
+		server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&newConns, 1)
+			}
+		}
+		server.Start()
+		defer server.Close()
+
+		dir, err := os.MkdirTemp("", "multus-test")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		kubeconfig := filepath.Join(dir, "kubeconfig")
+		Expect(os.WriteFile(kubeconfig, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: %s
+  name: test
+contexts:
+- context:
+    cluster: test
+    user: test
+  name: test
+current-context: test
+users:
+- name: test
+  user: {}
+`, server.URL)), 0600)).To(Succeed())
+
+		const lookups = 10
+		first, err := GetK8sClient(kubeconfig, nil)
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i < lookups; i++ {
+			clientInfo, err := GetK8sClient(kubeconfig, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clientInfo).To(BeIdenticalTo(first))
+			nad, err := clientInfo.NetClient.NetworkAttachmentDefinitions("test").Get(context.TODO(), "net1", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nad.Name).To(Equal("net1"))
+		}
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(lookups)))
+		Expect(atomic.LoadInt32(&newConns)).To(Equal(int32(1)))
+	})
+
+	It("evicts the client of a kubeconfig which was rewritten", func() {
+		dir, err := os.MkdirTemp("", "multus-test")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		kubeconfig := filepath.Join(dir, "kubeconfig")
+		writeKubeconfig := func(server string) {
+			Expect(os.WriteFile(kubeconfig, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: %s
+  name: test
+contexts:
+- context:
+    cluster: test
+    user: test
+  name: test
+current-context: test
+users:
+- name: test
+  user: {}
+`, server)), 0600)).To(Succeed())
+		}
+
+		writeKubeconfig("https://127.0.0.1:6443")
+		first, err := GetK8sClient(kubeconfig, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		writeKubeconfig("https://127.0.0.1:6444")
+		second, err := GetK8sClient(kubeconfig, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second).NotTo(BeIdenticalTo(first))
+
+		sharedClients.Lock()
+		pooled := sharedClients.clients[kubeconfig]
+		sharedClients.Unlock()
+		Expect(pooled.client).To(BeIdenticalTo(second))
+
+		again, err := GetK8sClient(kubeconfig, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again).To(BeIdenticalTo(second))
+	})
 })
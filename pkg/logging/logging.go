@@ -16,11 +16,13 @@
 package logging
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	lumberjack "gopkg.in/natefinch/lumberjack.v2"
@@ -34,18 +36,29 @@ const (
 	PanicLevel Level = iota
 	ErrorLevel
 	VerboseLevel
+	InfoLevel
 	DebugLevel
 	MaxLevel
 	UnknownLevel
 )
 
+// PlainFormat and JSONFormat are the log formats
+const (
+	PlainFormat = "plain"
+	JSONFormat  = "json"
+)
+
 var loggingStderr bool
 var loggingW io.Writer
-var loggingLevel Level
+var loggingLevel Level // atomic, as the daemon logs from concurrent requests
+var loggingFormat string
 var logger *lumberjack.Logger
 
 const defaultTimestampFormat = time.RFC3339
 
+// Fields are key-value pairs, such as the pod identity, added to the log lines in the json format
+type Fields map[string]string
+
 // LogOptions specifies the configuration of the log
 type LogOptions struct {
 	MaxAge     *int  `json:"maxAge,omitempty"`
@@ -57,25 +70,49 @@ type LogOptions struct {
 // SetLogOptions set the LoggingOptions of NetConf
 func SetLogOptions(options *LogOptions) {
 	// give some default value
-	logger.MaxSize = 100
-	logger.MaxAge = 5
-	logger.MaxBackups = 5
-	logger.Compress = true
+	maxSize, maxAge, maxBackups, compress := 100, 5, 5, true
 	if options != nil {
 		if options.MaxAge != nil {
-			logger.MaxAge = *options.MaxAge
+			maxAge = *options.MaxAge
 		}
 		if options.MaxSize != nil {
-			logger.MaxSize = *options.MaxSize
+			maxSize = *options.MaxSize
 		}
 		if options.MaxBackups != nil {
-			logger.MaxBackups = *options.MaxBackups
+			maxBackups = *options.MaxBackups
 		}
 		if options.Compress != nil {
-			logger.Compress = *options.Compress
+			compress = *options.Compress
 		}
 	}
-	loggingW = logger
+	// the rotation goroutine of the logger reads these options, so they are only written
+	// when they change, not on every request of the daemon
+	if logger.MaxSize != maxSize {
+		logger.MaxSize = maxSize
+	}
+	if logger.MaxAge != maxAge {
+		logger.MaxAge = maxAge
+	}
+	if logger.MaxBackups != maxBackups {
+		logger.MaxBackups = maxBackups
+	}
+	if logger.Compress != compress {
+		logger.Compress = compress
+	}
+	if loggingW != io.Writer(logger) {
+		loggingW = logger
+	}
+}
+
+// SetLogRotation sets the size in megabytes at which the log file is rotated and
+// the number of rotated files kept, overriding the LogOptions
+func SetLogRotation(maxSizeMB, maxBackups *int) {
+	if maxSizeMB != nil {
+		logger.MaxSize = *maxSizeMB
+	}
+	if maxBackups != nil {
+		logger.MaxBackups = *maxBackups
+	}
 }
 
 func (l Level) String() string {
@@ -88,30 +125,59 @@ func (l Level) String() string {
 		return "error"
 	case DebugLevel:
 		return "debug"
+	case InfoLevel:
+		return "info"
 	}
 	return "unknown"
 }
 
 func printf(level Level, format string, a ...interface{}) {
-	header := "%s [%s] "
+	printFields(level, nil, format, a...)
+}
+
+func printFields(level Level, fields Fields, format string, a ...interface{}) {
+	printAt(GetLoggingLevel(), level, fields, format, a...)
+}
+
+// printAt prints the line if level <= maxLevel, the global logging level or the level
+// of a Logger
+func printAt(maxLevel Level, level Level, fields Fields, format string, a ...interface{}) {
 	t := time.Now()
-	if level > loggingLevel {
+	if level > maxLevel {
 		return
 	}
+	var line string
+	if loggingFormat == JSONFormat {
+		line = jsonLine(t, level, fields, fmt.Sprintf(format, a...))
+	} else {
+		line = fmt.Sprintf("%s [%s] ", t.Format(defaultTimestampFormat), level) + fmt.Sprintf(format, a...)
+	}
 
 	if loggingStderr {
-		fmt.Fprintf(os.Stderr, header, t.Format(defaultTimestampFormat), level)
-		fmt.Fprintf(os.Stderr, format, a...)
-		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintln(os.Stderr, line)
 	}
 
 	if loggingW != nil {
-		fmt.Fprintf(loggingW, header, t.Format(defaultTimestampFormat), level)
-		fmt.Fprintf(loggingW, format, a...)
-		fmt.Fprintf(loggingW, "\n")
+		fmt.Fprintln(loggingW, line)
 	}
 }
 
+// jsonLine returns a log line as a JSON object of the level, timestamp, message and fields
+func jsonLine(t time.Time, level Level, fields Fields, msg string) string {
+	entry := map[string]string{}
+	for key, value := range fields {
+		entry[key] = value
+	}
+	entry["level"] = level.String()
+	entry["ts"] = t.Format(defaultTimestampFormat)
+	entry["msg"] = msg
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Sprintf(`{"level":"error","msg":"failed to marshal the log line: %v"}`, err)
+	}
+	return string(data)
+}
+
 // Debugf prints logging if logging level >= debug
 func Debugf(format string, a ...interface{}) {
 	printf(DebugLevel, format, a...)
@@ -124,8 +190,91 @@ func Verbosef(format string, a ...interface{}) {
 
 // Errorf prints logging if logging level >= error
 func Errorf(format string, a ...interface{}) error {
-	printf(ErrorLevel, format, a...)
-	return fmt.Errorf(format, a...)
+	err := fmt.Errorf(format, a...)
+	printf(ErrorLevel, "%s", err)
+	return err
+}
+
+// Infof prints logging if logging level >= info
+func Infof(format string, a ...interface{}) {
+	printf(InfoLevel, format, a...)
+}
+
+// Debugf prints logging with the fields if logging level >= debug
+func (f Fields) Debugf(format string, a ...interface{}) {
+	printFields(DebugLevel, f, format, a...)
+}
+
+// Verbosef prints logging with the fields if logging level >= verbose
+func (f Fields) Verbosef(format string, a ...interface{}) {
+	printFields(VerboseLevel, f, format, a...)
+}
+
+// Infof prints logging with the fields if logging level >= info
+func (f Fields) Infof(format string, a ...interface{}) {
+	printFields(InfoLevel, f, format, a...)
+}
+
+// Errorf prints logging with the fields if logging level >= error
+func (f Fields) Errorf(format string, a ...interface{}) error {
+	err := fmt.Errorf(format, a...)
+	printFields(ErrorLevel, f, "%s", err)
+	return err
+}
+
+// Logger logs at its own logging level instead of the global one, e.g. the lines of a
+// delegate with a delegateLogLevels level. Unlike the global logging level, it is not
+// shared by the concurrent delegates and requests.
+type Logger struct {
+	level  *Level
+	fields Fields
+}
+
+// NewLogger returns a Logger at levelStr, or at the global logging level if levelStr is
+// empty or not a valid logging level
+func NewLogger(levelStr string) *Logger {
+	l := &Logger{}
+	if levelStr != "" {
+		if level := getLoggingLevel(levelStr); level < MaxLevel {
+			l.level = &level
+		}
+	}
+	return l
+}
+
+// WithFields returns a Logger at the same level adding the fields to its lines
+func (l *Logger) WithFields(fields Fields) *Logger {
+	return &Logger{level: l.level, fields: fields}
+}
+
+// Level returns the logging level of the Logger
+func (l *Logger) Level() Level {
+	if l.level != nil {
+		return *l.level
+	}
+	return GetLoggingLevel()
+}
+
+// Debugf prints logging if the level of the Logger >= debug
+func (l *Logger) Debugf(format string, a ...interface{}) {
+	printAt(l.Level(), DebugLevel, l.fields, format, a...)
+}
+
+// Verbosef prints logging if the level of the Logger >= verbose
+func (l *Logger) Verbosef(format string, a ...interface{}) {
+	printAt(l.Level(), VerboseLevel, l.fields, format, a...)
+}
+
+// Infof prints logging if the level of the Logger >= info
+func (l *Logger) Infof(format string, a ...interface{}) {
+	printAt(l.Level(), InfoLevel, l.fields, format, a...)
+}
+
+// Errorf prints logging if the level of the Logger >= error
+func (l *Logger) Errorf(format string, a ...interface{}) error {
+	err := fmt.Errorf(format, a...)
+	printAt(l.Level(), ErrorLevel, l.fields, "%s", err)
+	return err
 }
 
 // Panicf prints logging plus stack trace. This should be used only for unrecoverable error
@@ -138,13 +287,15 @@ func Panicf(format string, a ...interface{}) {
 
 // GetLoggingLevel gets current logging level
 func GetLoggingLevel() Level {
-	return loggingLevel
+	return Level(atomic.LoadUint32((*uint32)(&loggingLevel)))
 }
 
 func getLoggingLevel(levelStr string) Level {
 	switch strings.ToLower(levelStr) {
 	case "debug":
 		return DebugLevel
+	case "info":
+		return InfoLevel
 	case "verbose":
 		return VerboseLevel
 	case "error":
@@ -160,8 +311,35 @@ func getLoggingLevel(levelStr string) Level {
 func SetLogLevel(levelStr string) {
 	level := getLoggingLevel(levelStr)
 	if level < MaxLevel {
-		loggingLevel = level
+		atomic.StoreUint32((*uint32)(&loggingLevel), uint32(level))
+	}
+}
+
+// IsValidLevel returns true if levelStr is a valid logging level
+func IsValidLevel(levelStr string) bool {
+	switch strings.ToLower(levelStr) {
+	case "debug", "info", "verbose", "error", "panic":
+		return true
+	}
+	return false
+}
+
+// SetLogFormat sets the log format, plain (the default) or json
+func SetLogFormat(format string) {
+	if !IsValidFormat(format) {
+		fmt.Fprintf(os.Stderr, "multus logging: cannot set logging format to %s\n", format)
+		return
+	}
+	loggingFormat = strings.ToLower(format)
+}
+
+// IsValidFormat returns true if format is a valid log format
+func IsValidFormat(format string) bool {
+	switch strings.ToLower(format) {
+	case PlainFormat, JSONFormat:
+		return true
 	}
+	return false
 }
 
 // SetLogStderr sets flag for logging stderr output
@@ -175,14 +353,25 @@ func SetLogFile(filename string) {
 		return
 	}
 
+	// lumberjack keeps writing to the file it opened, whatever its Filename
+	if logger.Filename != filename {
+		logger.Close()
+	}
 	logger.Filename = filename
 	loggingW = logger
 
 }
 
+// CloseLogFile closes the logging file set by SetLogFile and stops logging to it
+func CloseLogFile() error {
+	loggingW = nil
+	return logger.Close()
+}
+
 func init() {
 	loggingStderr = true
 	loggingW = nil
 	loggingLevel = PanicLevel
+	loggingFormat = PlainFormat
 	logger = &lumberjack.Logger{}
 }
@@ -16,8 +16,13 @@
 package logging
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	testutils "gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/testing"
@@ -40,6 +45,14 @@ var _ = Describe("logging operations", func() {
 		loggingLevel = PanicLevel
 	})
 
+	AfterEach(func() {
+		// Revert the log variables to init, for the writers set by the tests
+		loggingStderr = true
+		loggingW = nil
+		loggingLevel = PanicLevel
+		loggingFormat = PlainFormat
+	})
+
 	It("Check file setter with empty", func() {
 		SetLogFile("")
 		Expect(loggingW).To(BeNil())
@@ -67,11 +80,34 @@ var _ = Describe("logging operations", func() {
 		SetLogLevel("VERbose")
 		Expect(loggingLevel).To(Equal(VerboseLevel))
 		Expect(loggingLevel.String()).To(Equal("verbose"))
+		SetLogLevel("Info")
+		Expect(loggingLevel).To(Equal(InfoLevel))
+		Expect(loggingLevel.String()).To(Equal("info"))
 		SetLogLevel("PANIC")
 		Expect(loggingLevel).To(Equal(PanicLevel))
 		Expect(loggingLevel.String()).To(Equal("panic"))
 	})
 
+	It("Check logger at its own level", func() {
+		var buf bytes.Buffer
+		loggingW = &buf
+		SetLogLevel("error")
+		logger := NewLogger("debug")
+		Expect(logger.Level()).To(Equal(DebugLevel))
+		logger.WithFields(Fields{"netName": "net1"}).Debugf("logged at the debug level")
+		Verbosef("not logged at the error level")
+		Expect(loggingLevel).To(Equal(ErrorLevel))
+		Expect(buf.String()).To(MatchRegexp(`^\S+ \[debug\] logged at the debug level\n$`))
+
+		// the global level applies to the loggers without their own level
+		Expect(NewLogger("").Level()).To(Equal(ErrorLevel))
+		Expect(NewLogger("XXXX").Level()).To(Equal(ErrorLevel))
+		SetLogLevel("verbose")
+		Expect(NewLogger("").Level()).To(Equal(VerboseLevel))
+		Expect(IsValidLevel("Verbose")).To(BeTrue())
+		Expect(IsValidLevel("XXXX")).To(BeFalse())
+	})
+
 	It("Check loglevel setter with invalid level", func() {
 		currentLevel := loggingLevel
 		SetLogLevel("XXXX")
@@ -170,4 +206,114 @@ var _ = Describe("logging operations", func() {
 		Expect(logger1).To(Equal(logger))
 	})
 
+	It("Check file closer", func() {
+		tmpDir, err := os.MkdirTemp("", "multus_tmp")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(tmpDir)
+		logFile := filepath.Join(tmpDir, "multus.log")
+
+		SetLogLevel("verbose")
+		SetLogFile(logFile)
+		Verbosef("logged to the file")
+		Expect(CloseLogFile()).To(Succeed())
+		Expect(loggingW).To(BeNil())
+		Verbosef("not logged to the file")
+
+		logs, err := os.ReadFile(logFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(logs)).To(MatchRegexp(`^\S+ \[verbose\] logged to the file\n$`))
+	})
+
+	It("Check log format setter", func() {
+		Expect(loggingFormat).To(Equal(PlainFormat))
+		SetLogFormat("JSON")
+		Expect(loggingFormat).To(Equal(JSONFormat))
+		SetLogFormat("xml")
+		Expect(loggingFormat).To(Equal(JSONFormat))
+		Expect(IsValidFormat("plain")).To(BeTrue())
+		Expect(IsValidFormat("xml")).To(BeFalse())
+		SetLogFormat(PlainFormat)
+	})
+
+	It("Check log lines in the plain format", func() {
+		var buf bytes.Buffer
+		loggingW = &buf
+		SetLogLevel("verbose")
+		Fields{"podName": "testpod"}.Verbosef("Add: %s", "eth0")
+		Expect(buf.String()).To(MatchRegexp(`^\S+ \[verbose\] Add: eth0\n$`))
+	})
+
+	It("Check log lines in the json format", func() {
+		var buf bytes.Buffer
+		loggingW = &buf
+		SetLogLevel("verbose")
+		SetLogFormat(JSONFormat)
+		defer SetLogFormat(PlainFormat)
+
+		fields := Fields{"containerID": "123456789", "podNamespace": "test", "podName": "testpod", "netName": "net1"}
+		fields.Verbosef("Add: %s", "net1")
+		Expect(fields.Errorf("failed: %w", errors.New("boom"))).To(MatchError("failed: boom"))
+		Debugf("not logged at the verbose level")
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		Expect(lines).To(HaveLen(2))
+		var entry map[string]string
+		Expect(json.Unmarshal([]byte(lines[0]), &entry)).To(Succeed())
+		Expect(entry).To(HaveKeyWithValue("level", "verbose"))
+		Expect(entry).To(HaveKey("ts"))
+		Expect(entry).To(HaveKeyWithValue("msg", "Add: net1"))
+		Expect(entry).To(HaveKeyWithValue("containerID", "123456789"))
+		Expect(entry).To(HaveKeyWithValue("podNamespace", "test"))
+		Expect(entry).To(HaveKeyWithValue("podName", "testpod"))
+		Expect(entry).To(HaveKeyWithValue("netName", "net1"))
+		Expect(json.Unmarshal([]byte(lines[1]), &entry)).To(Succeed())
+		Expect(entry).To(HaveKeyWithValue("level", "error"))
+		Expect(entry).To(HaveKeyWithValue("msg", "failed: boom"))
+	})
+
+	It("Check info lines are logged between the verbose and debug levels", func() {
+		var buf bytes.Buffer
+		loggingW = &buf
+		SetLogLevel("verbose")
+		Infof("not logged at the verbose level")
+		SetLogLevel("info")
+		Infof("resolved networks: %s", "net1")
+		Verbosef("logged at the info level")
+		Debugf("not logged at the info level")
+		Expect(buf.String()).To(MatchRegexp(`^\S+ \[info\] resolved networks: net1\n\S+ \[verbose\] logged at the info level\n$`))
+	})
+
+	It("Check the log file is rotated at logFileMaxSizeMB", func() {
+		tmpDir, err := os.MkdirTemp("", "multus_tmp")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(tmpDir)
+		logFile := filepath.Join(tmpDir, "multus.log")
+
+		SetLogLevel("verbose")
+		SetLogStderr(false)
+		SetLogFile(logFile)
+		SetLogOptions(&LogOptions{Compress: testutils.Bool(false)})
+		SetLogRotation(testutils.Int(1), testutils.Int(2))
+		defer func() {
+			Expect(logger.Close()).To(Succeed())
+			SetLogStderr(true)
+			// Revert the log variable to init
+			loggingW = nil
+			logger = &lumberjack.Logger{}
+		}()
+
+		// 4MB of lines rotates the 1MB log file at least 3 times
+		line := strings.Repeat("x", 1023)
+		for i := 0; i < 4*1024; i++ {
+			Verbosef("%s", line)
+		}
+
+		// the rotated files beyond logFileMaxBackups are removed in the background
+		Eventually(func() ([]string, error) {
+			return filepath.Glob(filepath.Join(tmpDir, "multus-*.log"))
+		}).Should(HaveLen(2))
+		info, err := os.Stat(logFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Size()).To(BeNumerically("<", 1024*1024))
+	})
 })
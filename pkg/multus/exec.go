@@ -0,0 +1,126 @@
+// Copyright (c) 2022 Multus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	cniversion "github.com/containernetworking/cni/pkg/version"
+)
+
+// maxStderrTail is the size of the end of the stderr of a failed plugin kept in its error
+const maxStderrTail = 1024
+
+// StderrError is the error of a plugin which failed, with the end of its stderr
+type StderrError struct {
+	Err    error
+	Stderr string
+}
+
+func (e *StderrError) Error() string {
+	return fmt.Sprintf("%v; plugin stderr: %q", e.Err, e.Stderr)
+}
+
+// Unwrap returns the error of the plugin, e.g. its CNI error
+func (e *StderrError) Unwrap() error {
+	return e.Err
+}
+
+// NewStderrError returns err of a failed plugin with the end of its stderr, or err if
+// the plugin printed nothing on stderr
+func NewStderrError(err error, stderr []byte) error {
+	tail := strings.TrimSpace(string(stderr))
+	if tail == "" {
+		return err
+	}
+	if len(tail) > maxStderrTail {
+		tail = "..." + tail[len(tail)-maxStderrTail:]
+	}
+	return &StderrError{Err: err, Stderr: tail}
+}
+
+// stderrExec executes the plugins as invoke.DefaultExec does, but keeps the stderr of the
+// plugins which fail with an error on stdout in their error
+type stderrExec struct {
+	*invoke.RawExec
+	cniversion.PluginDecoder
+}
+
+// newStderrExec returns the default exec of the delegates
+func newStderrExec() invoke.Exec {
+	return &stderrExec{
+		RawExec:       &invoke.RawExec{Stderr: os.Stderr},
+		PluginDecoder: cniversion.PluginDecoder{},
+	}
+}
+
+// ExecPlugin executes the plugin as invoke.RawExec does
+func (e *stderrExec) ExecPlugin(ctx context.Context, pluginPath string, stdinData []byte, environ []string) ([]byte, error) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	c := exec.CommandContext(ctx, pluginPath)
+	c.Env = environ
+	c.Stdin = bytes.NewBuffer(stdinData)
+	c.Stdout = stdout
+	c.Stderr = stderr
+
+	// Retry the command on "text file busy" errors
+	for i := 0; i <= 5; i++ {
+		err := c.Run()
+		if err == nil {
+			break
+		}
+		if strings.Contains(err.Error(), "text file busy") {
+			time.Sleep(time.Second)
+			continue
+		}
+		return nil, pluginErr(err, stdout.Bytes(), stderr.Bytes())
+	}
+
+	// Copy stderr to caller's buffer in case plugin printed to both
+	// stdout and stderr for some reason. Ignore failures as stderr is
+	// only informational.
+	if e.Stderr != nil && stderr.Len() > 0 {
+		_, _ = stderr.WriteTo(e.Stderr)
+	}
+	return stdout.Bytes(), nil
+}
+
+// pluginErr returns the error of a failed plugin, its CNI error on stdout followed by
+// the end of its stderr
+func pluginErr(err error, stdout, stderr []byte) error {
+	emsg := cnitypes.Error{}
+	if len(stdout) == 0 {
+		if len(stderr) == 0 {
+			emsg.Msg = fmt.Sprintf("netplugin failed with no error message: %v", err)
+		} else {
+			emsg.Msg = fmt.Sprintf("netplugin failed: %q", string(stderr))
+		}
+		return &emsg
+	}
+	if perr := json.Unmarshal(stdout, &emsg); perr != nil {
+		emsg.Msg = fmt.Sprintf("netplugin failed but error parsing its diagnostic message %q: %v", string(stdout), perr)
+	}
+	return NewStderrError(&emsg, stderr)
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2022 Multus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multus
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/skel"
+
+	k8s "gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/k8sclient"
+	"gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/logging"
+	"gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/types"
+)
+
+// gcIfName is the interface name given to the default network of the stale containers,
+// which the delegates cache does not record
+const gcIfName = "eth0"
+
+// gcConf is the part of the GC request listing the attachments still in use
+type gcConf struct {
+	ValidAttachments *[]gcAttachment `json:"cni.dev/valid-attachments"`
+}
+
+// gcAttachment is an attachment still in use of the GC request
+type gcAttachment struct {
+	ContainerID string `json:"containerID"`
+	IfName      string `json:"ifname"`
+}
+
+// CmdGC removes the delegates cache of the containers which are not among the valid
+// attachments of the GC request of CNI 1.1, deleting their networks first. It returns
+// the IDs of the containers whose cache was removed.
+func CmdGC(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) ([]string, error) {
+	n, err := types.LoadNetConf(args.StdinData)
+	logging.Debugf("CmdGC: %v, %v, %v", args, exec, kubeClient)
+	if err != nil {
+		return nil, err
+	}
+
+	gc := &gcConf{}
+	if err := json.Unmarshal(args.StdinData, gc); err != nil {
+		return nil, logging.Errorf("CmdGC: failed to load the valid attachments: %v", err)
+	}
+	if gc.ValidAttachments == nil {
+		return nil, logging.Errorf("CmdGC: the request has no cni.dev/valid-attachments")
+	}
+	isValid := func(containerID string) bool {
+		for _, attachment := range *gc.ValidAttachments {
+			if containerIDsMatch(containerID, attachment.ContainerID) {
+				return true
+			}
+		}
+		return false
+	}
+
+	entries, err := os.ReadDir(n.CNIDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, logging.Errorf("CmdGC: failed to read the multus data directory(%q): %v", n.CNIDir, err)
+	}
+
+	var removed, failed []string
+	for _, entry := range entries {
+		containerID := entry.Name()
+		// skip the results of libcni and the temporary files of saveScratchNetConf
+		if entry.IsDir() || strings.HasPrefix(containerID, ".") || isValid(containerID) {
+			continue
+		}
+		gcDelegates(exec, args, n, containerID)
+		if err := deleteDelegates(containerID, n.CNIDir); err != nil {
+			failed = append(failed, containerID)
+			continue
+		}
+		logging.Verbosef("CmdGC: removed the delegates cache of container %s", containerID)
+		removed = append(removed, containerID)
+	}
+
+	sort.Strings(removed)
+	if len(failed) > 0 {
+		return removed, logging.Errorf("CmdGC: failed to remove the delegates cache of containers %v", failed)
+	}
+	return removed, nil
+}
+
+// gcDelegates deletes the networks of the delegates cache of the stale container, if
+// the cache can be loaded. The errors are logged only, as the container is gone.
+func gcDelegates(exec invoke.Exec, args *skel.CmdArgs, n *types.NetConf, containerID string) {
+	netconfBytes, path, err := consumeScratchNetConf(containerID, n.CNIDir)
+	if err != nil {
+		logging.Verbosef("warning: CmdGC: failed to read the cached delegates file %s: %v", path, err)
+		return
+	}
+	delegates, err := loadDelegates(netconfBytes)
+	if err != nil {
+		logging.Verbosef("warning: CmdGC: failed to load the cached delegates file %s: %v, cannot delete the networks of container %s", path, err, containerID)
+		return
+	}
+
+	for _, v := range delegates {
+		// error happen but continue to delete
+		_ = types.SetDelegateCNIVersion(v, n.CNIVersion)
+		_ = types.SetDelegateDefaultCapabilities(v, n.DefaultCapabilities)
+	}
+	gcNetconf := *n
+	gcNetconf.Delegates = delegates
+	gcArgs := &skel.CmdArgs{
+		ContainerID: containerID,
+		IfName:      gcIfName,
+		Path:        args.Path,
+		StdinData:   args.StdinData,
+	}
+	if err := delPluginsInOrder(exec, nil, nil, gcArgs, &types.K8sArgs{}, delegates, delegateOrder(&gcNetconf), n.RuntimeConfig, &gcNetconf); err != nil {
+		logging.Verbosef("warning: CmdGC: failed to delete the networks of container %s: %v", containerID, err)
+	}
+}
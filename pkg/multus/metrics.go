@@ -0,0 +1,141 @@
+// Copyright (c) 2022 Multus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multus
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/logging"
+)
+
+const (
+	metricsResultSuccess = "success"
+	metricsResultFailure = "failure"
+)
+
+var (
+	commandsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "multus_cni_commands_total",
+			Help: "Counter of the CNI commands handled by multus",
+		},
+		[]string{"command", "result"},
+	)
+	delegateOperationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "multus_cni_delegate_operations_total",
+			Help: "Counter of the CNI commands executed on the delegate plugins, by network",
+		},
+		[]string{"command", "network", "result"},
+	)
+	delegateExecDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "multus_cni_delegate_exec_duration_seconds",
+			Help:    "Duration of the CNI commands executed on the delegate plugins, by network",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"command", "network"},
+	)
+)
+
+// metricsListeners are the addresses multus serves metrics on
+var metricsListeners = struct {
+	sync.Mutex
+	started map[string]bool
+}{started: map[string]bool{}}
+
+func init() {
+	prometheus.MustRegister(commandsTotal, delegateOperationsTotal, delegateExecDuration)
+}
+
+func metricsResult(err error) string {
+	if err != nil {
+		return metricsResultFailure
+	}
+	return metricsResultSuccess
+}
+
+// recordCommand counts a CNI command handled by multus
+func recordCommand(command string, err error) {
+	commandsTotal.WithLabelValues(command, metricsResult(err)).Inc()
+}
+
+// StartMetricsListener serves the metrics on address, once per process. An empty
+// address does not start any listener. Only the thick plugin daemon starts it, as
+// the thin plugin process exits with the command it handles.
+func StartMetricsListener(address string) {
+	if address == "" {
+		return
+	}
+	metricsListeners.Lock()
+	defer metricsListeners.Unlock()
+	if metricsListeners.started[address] {
+		return
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		logging.Verbosef("warning: failed to listen for metrics on %s: %v", address, err)
+		return
+	}
+	metricsListeners.started[address] = true
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		logging.Verbosef("warning: metrics listener on %s stopped: %v", address, http.Serve(listener, mux))
+	}()
+}
+
+// metricsExec counts and times the CNI commands executed on the plugins of a network
+type metricsExec struct {
+	invoke.Exec
+	network string
+}
+
+// newMetricsExec returns exec, or the default exec if nil, instrumented for network
+func newMetricsExec(exec invoke.Exec, network string) invoke.Exec {
+	if exec == nil {
+		exec = newStderrExec()
+	}
+	return &metricsExec{Exec: exec, network: network}
+}
+
+// ExecPlugin executes the plugin and records the result and duration of ADD, DEL and CHECK
+func (e *metricsExec) ExecPlugin(ctx context.Context, pluginPath string, stdinData []byte, environ []string) ([]byte, error) {
+	var command string
+	for _, env := range environ {
+		if strings.HasPrefix(env, "CNI_COMMAND=") {
+			command = strings.TrimPrefix(env, "CNI_COMMAND=")
+		}
+	}
+	start := time.Now()
+	stdout, err := e.Exec.ExecPlugin(ctx, pluginPath, stdinData, environ)
+	switch command {
+	case "ADD", "DEL", "CHECK":
+		delegateExecDuration.WithLabelValues(command, e.network).Observe(time.Since(start).Seconds())
+		delegateOperationsTotal.WithLabelValues(command, e.network, metricsResult(err)).Inc()
+	}
+	return stdout, err
+}
@@ -17,12 +17,17 @@ package multus
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/containernetworking/cni/libcni"
@@ -30,13 +35,13 @@ import (
 	"github.com/containernetworking/cni/pkg/skel"
 	cnitypes "github.com/containernetworking/cni/pkg/types"
 	cni100 "github.com/containernetworking/cni/pkg/types/100"
+	cniversion "github.com/containernetworking/cni/pkg/version"
 	"github.com/containernetworking/plugins/pkg/ns"
 	nettypes "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
 	nadutils "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/utils"
 	"github.com/vishvananda/netlink"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
-	k8snet "k8s.io/apimachinery/pkg/util/net"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/util/wait"
 
 	k8s "gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/k8sclient"
@@ -48,8 +53,15 @@ import (
 const (
 	shortPollDuration = 250 * time.Millisecond
 	shortPollTimeout  = 2500 * time.Millisecond
+
+	// debugDelegatesAnnot makes ADD report the resolved delegates of the pod in an event
+	debugDelegatesAnnot = "k8s.v1.cni.cncf.io/debug-delegates"
+	multusVersionAnnot  = "k8s.v1.cni.cncf.io/multus-version"
 )
 
+// errPluginNotAvailable is the CNI error code of a plugin which cannot service ADD requests
+const errPluginNotAvailable uint = 50
+
 var (
 	version       = "master@git"
 	commit        = "unknown commit"
@@ -76,24 +88,112 @@ func saveScratchNetConf(containerID, dataDir string, netconf []byte) error {
 
 	path := filepath.Join(dataDir, containerID)
 
-	err := os.WriteFile(path, netconf, 0600)
+	// write a temporary file renamed into place, so that the readers never see a partial file
+	tmpFile, err := os.CreateTemp(dataDir, "."+containerID+".tmp-")
+	if err != nil {
+		return logging.Errorf("saveScratchNetConf: failed to create a temporary file in the multus data directory(%q): %v", dataDir, err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	err = writeScratchFile(tmpFile, netconf)
+	if err == nil {
+		err = tmpFile.Chmod(0600)
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), path)
+	}
 	if err != nil {
 		return logging.Errorf("saveScratchNetConf: failed to write container data in the path(%q): %v", path, err)
 	}
 
+	// persist the rename
+	dir, err := os.Open(dataDir)
+	if err != nil {
+		return logging.Errorf("saveScratchNetConf: failed to open the multus data directory(%q): %v", dataDir, err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return logging.Errorf("saveScratchNetConf: failed to sync the multus data directory(%q): %v", dataDir, err)
+	}
+
+	return nil
+}
+
+// writeScratchFile writes the data of saveScratchNetConf, replaced by the tests
+var writeScratchFile = func(f *os.File, data []byte) error {
+	_, err := f.Write(data)
 	return err
 }
 
 func consumeScratchNetConf(containerID, dataDir string) ([]byte, string, error) {
 	logging.Debugf("consumeScratchNetConf: %s, %s", containerID, dataDir)
-	path := filepath.Join(dataDir, containerID)
+	path := scratchNetConfPath(containerID, dataDir)
 
 	b, err := os.ReadFile(path)
 	return b, path, err
 }
 
-func getIfname(delegate *types.DelegateNetConf, argif string, idx int) string {
-	logging.Debugf("getIfname: %v, %s, %d", delegate, argif, idx)
+// minShortContainerIDLength is the length of the shortest container ID form, e.g. the
+// 12 characters of the short form of a 64 characters container ID
+const minShortContainerIDLength = 12
+
+// scratchNetConfPath returns the path of the cache of containerID in dataDir. Since the
+// runtimes may pass the short form of the ID of ADD on DEL, or the other way around, it
+// is the cache of another form of containerID if there is no cache of containerID: the
+// only one whose ID starts with containerID, or which containerID starts with, ignoring
+// the case.
+func scratchNetConfPath(containerID, dataDir string) string {
+	path := filepath.Join(dataDir, containerID)
+	if _, err := os.Stat(path); err == nil || len(containerID) < minShortContainerIDLength {
+		return path
+	}
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return path
+	}
+
+	var found []string
+	for _, entry := range entries {
+		name := entry.Name()
+		// skip the results of libcni and the temporary files of saveScratchNetConf
+		if entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if containerIDsMatch(name, containerID) {
+			found = append(found, name)
+		}
+	}
+	if len(found) != 1 {
+		if len(found) > 1 {
+			logging.Verbosef("warning: the caches %v in %s all match container %s, using none", found, dataDir, containerID)
+		}
+		return path
+	}
+	logging.Debugf("scratchNetConfPath: using the cache %s of container %s", found[0], containerID)
+	return filepath.Join(dataDir, found[0])
+}
+
+// containerIDsMatch returns whether the container IDs are the same, or one is the short
+// form of the other, e.g. a runtime passing the short ID and another the full one
+func containerIDsMatch(id, other string) bool {
+	if id == other {
+		return true
+	}
+	if len(id) < minShortContainerIDLength || len(other) < minShortContainerIDLength {
+		return false
+	}
+	id, other = strings.ToLower(id), strings.ToLower(other)
+	return strings.HasPrefix(other, id) || strings.HasPrefix(id, other)
+}
+
+func getIfname(delegate *types.DelegateNetConf, argif, prefix string, idx int) string {
+	logging.Debugf("getIfname: %v, %s, %s, %d", delegate, argif, prefix, idx)
 	if delegate.IfnameRequest != "" {
 		return delegate.IfnameRequest
 	}
@@ -104,7 +204,726 @@ func getIfname(delegate *types.DelegateNetConf, argif string, idx int) string {
 
 	// Otherwise construct a unique interface name from the delegate's
 	// position in the delegate list
-	return fmt.Sprintf("net%d", idx)
+	if prefix == "" {
+		prefix = types.DefaultSecondaryInterfacePrefix
+	}
+	return fmt.Sprintf("%s%d", prefix, idx)
+}
+
+// attachmentID returns a stable ID of the attachment of a network to a container interface,
+// the same for ADD, CHECK and DEL
+func attachmentID(containerID, ifName, netName string) string {
+	sum := sha256.Sum256([]byte(containerID + "\n" + ifName + "\n" + netName))
+	return hex.EncodeToString(sum[:8])
+}
+
+// setAttachmentIDs sets the attachment ID of each delegate
+func setAttachmentIDs(containerID, argIfName, prefix string, delegates []*types.DelegateNetConf) {
+	for idx, delegate := range delegates {
+		delegate.AttachmentID = attachmentID(containerID, getIfname(delegate, argIfName, prefix, idx), delegate.Name)
+	}
+}
+
+// attachmentLogSuffix returns the attachment ID to append to the log lines of a delegate, if any
+func attachmentLogSuffix(delegate *types.DelegateNetConf) string {
+	if delegate.AttachmentID == "" {
+		return ""
+	}
+	return " attachmentID=" + delegate.AttachmentID
+}
+
+// setMasterPlugin makes the default network owning the result the first delegate.
+// clusterNetwork owns the result when set, otherwise the first defaultNetworks entry,
+// otherwise the first delegate, unless masterPlugin names another default network.
+func setMasterPlugin(n *types.NetConf) error {
+	if len(n.Delegates) == 0 {
+		return nil
+	}
+	masterIdx := 0
+	if n.MasterPlugin != "" {
+		masterIdx = -1
+		for idx, delegate := range n.Delegates {
+			if delegate.Name == n.MasterPlugin || delegate.Name == n.MultusNamespace+"/"+n.MasterPlugin {
+				masterIdx = idx
+				break
+			}
+		}
+		if masterIdx < 0 {
+			return logging.Errorf("setMasterPlugin: masterPlugin %q is not one of the default networks", n.MasterPlugin)
+		}
+	}
+
+	master := n.Delegates[masterIdx]
+	for _, delegate := range n.Delegates {
+		delegate.MasterPlugin = false
+	}
+	master.MasterPlugin = true
+	copy(n.Delegates[1:masterIdx+1], n.Delegates[:masterIdx])
+	n.Delegates[0] = master
+	return nil
+}
+
+// checkReservedInterfaceNames rejects additional network interfaces named in reservedInterfaceNames.
+// The master plugin interface is exempt because its name is given by the runtime.
+func checkReservedInterfaceNames(n *types.NetConf, argif string) error {
+	for idx, delegate := range n.Delegates {
+		if delegate.MasterPlugin {
+			continue
+		}
+		ifName := getIfname(delegate, argif, n.SecondaryInterfacePrefix, idx)
+		for _, reserved := range n.ReservedInterfaceNames {
+			if ifName == reserved {
+				return logging.Errorf("checkReservedInterfaceNames: interface name %q for network %q is reserved", ifName, delegate.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// checkInterfaceNameCollisions rejects requested interface names which are also the
+// auto-assigned interface name of another network
+func checkInterfaceNameCollisions(n *types.NetConf, argif string) error {
+	assignedTo := map[string]string{}
+	for idx, delegate := range n.Delegates {
+		if delegate.IfnameRequest == "" && !delegate.MasterPlugin {
+			assignedTo[getIfname(delegate, argif, n.SecondaryInterfacePrefix, idx)] = delegate.Name
+		}
+	}
+	for _, delegate := range n.Delegates {
+		if other, ok := assignedTo[delegate.IfnameRequest]; ok {
+			return logging.Errorf("checkInterfaceNameCollisions: requested interface name %q of network %q collides with the one assigned to network %q", delegate.IfnameRequest, delegate.Name, other)
+		}
+	}
+	return nil
+}
+
+// normalizeInterfaceNames lowercases the interface names requested for the delegates, so that
+// ADD, the delegates cache and DEL use the same names, and rejects names which then collide
+func normalizeInterfaceNames(delegates []*types.DelegateNetConf) error {
+	requestedBy := map[string]string{}
+	for _, delegate := range delegates {
+		if delegate.IfnameRequest == "" {
+			continue
+		}
+		ifName := strings.ToLower(delegate.IfnameRequest)
+		if other, ok := requestedBy[ifName]; ok {
+			return logging.Errorf("normalizeInterfaceNames: interface name %q of network %q collides with the one of network %q", delegate.IfnameRequest, delegate.Name, other)
+		}
+		requestedBy[ifName] = delegate.Name
+		delegate.IfnameRequest = ifName
+	}
+	return nil
+}
+
+// setStaticIPs requests the IP addresses of the pod in the staticIPPool configmap for the
+// network of the pool, unless the pod does not select it or requests IP addresses already
+func setStaticIPs(n *types.NetConf, pod *v1.Pod, kubeClient *k8s.ClientInfo) error {
+	if kubeClient == nil || pod == nil {
+		return fmt.Errorf("setStaticIPs: staticIPPool requires a Kubernetes client")
+	}
+	network := n.StaticIPPool.Network
+	if network != "" && !strings.Contains(network, "/") {
+		network = pod.ObjectMeta.Namespace + "/" + network
+	}
+
+	var delegate *types.DelegateNetConf
+	for _, d := range n.Delegates {
+		if !d.MasterPlugin && (network == "" || d.Name == network) {
+			delegate = d
+			break
+		}
+	}
+	if delegate == nil {
+		logging.Debugf("setStaticIPs: pod %s/%s does not select the network %q of staticIPPool", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, network)
+		return nil
+	}
+	if len(delegate.IPRequest) > 0 {
+		logging.Debugf("setStaticIPs: network %s of pod %s/%s requests IP addresses already", delegate.Name, pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+		return nil
+	}
+
+	ips, err := k8s.GetStaticIPs(kubeClient, pod, n.StaticIPPool, n.APIRetry)
+	if err != nil {
+		return err
+	}
+	logging.Debugf("setStaticIPs: network %s of pod %s/%s gets the IP addresses %v", delegate.Name, pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, ips)
+	delegate.IPRequest = ips
+	return nil
+}
+
+// resultDelegate returns the delegate of the resultNetwork of n, whose result is returned
+// instead of the result of the default network, or nil if not set or not selected
+func resultDelegate(n *types.NetConf, podNamespace string) *types.DelegateNetConf {
+	if n.ResultNetwork == "" {
+		return nil
+	}
+	network := n.ResultNetwork
+	if !strings.Contains(network, "/") {
+		network = podNamespace + "/" + network
+	}
+	for _, d := range n.Delegates {
+		if !d.MasterPlugin && d.Name == network {
+			return d
+		}
+	}
+	logging.Debugf("resultDelegate: the pod does not select the resultNetwork %q, returning the result of the default network", network)
+	return nil
+}
+
+// checkBandwidthLimits rejects bandwidth requests whose ingress or egress rate exceeds maxBandwidthBps
+func checkBandwidthLimits(n *types.NetConf) error {
+	for _, delegate := range n.Delegates {
+		var bandwidth *types.BandwidthEntry
+		if n.RuntimeConfig != nil {
+			bandwidth = n.RuntimeConfig.Bandwidth
+		}
+		if !delegate.MasterPlugin && delegate.BandwidthRequest != nil {
+			bandwidth = delegate.BandwidthRequest
+		}
+		if bandwidth == nil {
+			continue
+		}
+		if bandwidth.IngressRate > n.MaxBandwidthBps {
+			return logging.Errorf("checkBandwidthLimits: ingress rate %d of network %q exceeds the limit of %d bps", bandwidth.IngressRate, delegate.Name, n.MaxBandwidthBps)
+		}
+		if bandwidth.EgressRate > n.MaxBandwidthBps {
+			return logging.Errorf("checkBandwidthLimits: egress rate %d of network %q exceeds the limit of %d bps", bandwidth.EgressRate, delegate.Name, n.MaxBandwidthBps)
+		}
+	}
+	return nil
+}
+
+// checkHostPortCollisions rejects a hostPort and protocol requested by the portMappings of
+// two networks, unless they are bound to different host IPs
+func checkHostPortCollisions(n *types.NetConf) error {
+	type hostPortOwner struct {
+		entry   *types.PortMapEntry
+		network string
+	}
+	owners := map[string][]hostPortOwner{}
+	for _, delegate := range n.Delegates {
+		var portMaps []*types.PortMapEntry
+		if delegate.MasterPlugin {
+			if n.RuntimeConfig != nil {
+				portMaps = n.RuntimeConfig.PortMaps
+			}
+		} else {
+			portMaps = delegate.PortMappingsRequest
+		}
+		for _, entry := range portMaps {
+			protocol := strings.ToLower(entry.Protocol)
+			if protocol == "" {
+				protocol = "tcp"
+			}
+			key := fmt.Sprintf("%d/%s", entry.HostPort, protocol)
+			for _, owner := range owners[key] {
+				if owner.network != delegate.Name && hostIPsOverlap(owner.entry.HostIP, entry.HostIP) {
+					return logging.Errorf("checkHostPortCollisions: hostPort %s of network %q is already requested by network %q", key, delegate.Name, owner.network)
+				}
+			}
+			owners[key] = append(owners[key], hostPortOwner{entry: entry, network: delegate.Name})
+		}
+	}
+	return nil
+}
+
+// hostIPsOverlap returns true if two portMappings host IPs share an address,
+// an empty or unspecified host IP meaning all of them
+func hostIPsOverlap(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil || ipA.IsUnspecified() || ipB.IsUnspecified() {
+		return true
+	}
+	return ipA.Equal(ipB)
+}
+
+// reconcileDelegatesWithStatus combines the cached delegates with the networks listed in
+// the pod network status annotation according to delReconcileStrategy, so that networks
+// of a partial ADD missing from either of them are torn down as well
+func reconcileDelegatesWithStatus(kubeClient *k8s.ClientInfo, pod *v1.Pod, in *types.NetConf) []*types.DelegateNetConf {
+	if in.DelReconcileStrategy == types.DelReconcileCachePreferred {
+		return in.Delegates
+	}
+	statuses, err := nadutils.GetNetworkStatus(pod)
+	if err != nil || len(statuses) == 0 {
+		// no network status, only the cache is available
+		return in.Delegates
+	}
+
+	cached := map[string]*types.DelegateNetConf{}
+	for _, delegate := range in.Delegates {
+		cached[delegate.Name] = delegate
+	}
+
+	var statusDelegates, statusOnly []*types.DelegateNetConf
+	for _, status := range statuses {
+		delegate, ok := cached[status.Name]
+		if !ok {
+			delegate, err = k8s.GetNetworkStatusDelegate(kubeClient, pod, status, in)
+			if err != nil {
+				logging.Verbosef("warning: cannot tear down network %q of the network status: %v", status.Name, err)
+				continue
+			}
+			statusOnly = append(statusOnly, delegate)
+		} else if !delegate.MasterPlugin && delegate.IfnameRequest == "" && status.Interface != "" {
+			// keep the interface name, which depends on the position in the delegate list
+			delegate.IfnameRequest = status.Interface
+		}
+		statusDelegates = append(statusDelegates, delegate)
+	}
+
+	if in.DelReconcileStrategy == types.DelReconcileStatusPreferred {
+		if len(statusDelegates) == 0 {
+			return in.Delegates
+		}
+		return statusDelegates
+	}
+	for _, delegate := range statusOnly {
+		logging.Verbosef("warning: network %q of the network status is not cached, tearing it down as well", delegate.Name)
+	}
+	return append(in.Delegates, statusOnly...)
+}
+
+// networkStatusDelegates returns the delegates of the additional networks listed in the
+// pod network status, or false if the pod has no network status
+func networkStatusDelegates(kubeClient *k8s.ClientInfo, pod *v1.Pod, in *types.NetConf) ([]*types.DelegateNetConf, bool) {
+	statuses, err := nadutils.GetNetworkStatus(pod)
+	if err != nil || len(statuses) == 0 {
+		return nil, false
+	}
+
+	var delegates []*types.DelegateNetConf
+	for _, status := range statuses {
+		// the default network comes from the multus configuration
+		if status.Default && !in.NoDefaultNetwork {
+			continue
+		}
+		delegate, err := k8s.GetNetworkStatusDelegate(kubeClient, pod, status, in)
+		if err != nil {
+			logging.Verbosef("warning: cannot tear down network %q of the network status: %v", status.Name, err)
+			continue
+		}
+		delegates = append(delegates, delegate)
+	}
+	return delegates, true
+}
+
+// verifyPodNode checks that the pod is scheduled to the node multus runs on,
+// as given by the NODE_NAME environment variable
+func verifyPodNode(pod *v1.Pod) error {
+	nodeName := os.Getenv("NODE_NAME")
+	if nodeName == "" {
+		return logging.Errorf("verifyPodNode: NODE_NAME is not set")
+	}
+	if pod.Spec.NodeName != nodeName {
+		return logging.Errorf("verifyPodNode: pod is scheduled to node %q, not to this node %q", pod.Spec.NodeName, nodeName)
+	}
+	return nil
+}
+
+// delegateResult is the result of the delegate of a network
+type delegateResult struct {
+	netName string
+	ifName  string
+	result  *cni100.Result
+}
+
+// targetsInterface returns whether ipc of res is assigned to the interface ifName
+func targetsInterface(res *cni100.Result, ipc *cni100.IPConfig, ifName string) bool {
+	if ipc.Interface == nil || *ipc.Interface < 0 || *ipc.Interface >= len(res.Interfaces) {
+		return false
+	}
+	return res.Interfaces[*ipc.Interface].Name == ifName
+}
+
+// copyResult returns a 1.0.0 copy of result whose fields can be replaced without modifying
+// the delegate result, which NewResultFromResult returns as is when it already is 1.0.0.
+// The copy shares the slices of result, which are to be replaced rather than modified.
+func copyResult(result cnitypes.Result) (*cni100.Result, error) {
+	res, err := cni100.NewResultFromResult(result)
+	if err != nil {
+		return nil, err
+	}
+	copied := *res
+	return &copied, nil
+}
+
+// mergeDualStackResult returns the result of network resultNetName with the IP addresses
+// the other delegates assigned to its interface argif, e.g. the IPv6 address of a network
+// adding it to the interface of an IPv4 network. The interface may have a single address
+// of each family.
+func mergeDualStackResult(result cnitypes.Result, resultNetName string, delegateResults []delegateResult, argif string) (cnitypes.Result, error) {
+	res, err := copyResult(result)
+	if err != nil {
+		return nil, err
+	}
+
+	// the interface in the sandbox, if the result has several ones of this name
+	var ifIndex *int
+	for idx, iface := range res.Interfaces {
+		if iface.Name == argif && (ifIndex == nil || iface.Sandbox != "") {
+			ifIndex = cni100.Int(idx)
+		}
+	}
+
+	ips := append([]*cni100.IPConfig{}, res.IPs...)
+	owners := map[string]string{}
+	for _, ipc := range res.IPs {
+		if ipc.Interface == nil || targetsInterface(res, ipc, argif) {
+			owners[ipFamily(ipc.Address.IP)] = resultNetName
+		}
+	}
+
+	for _, r := range delegateResults {
+		if r.netName == resultNetName {
+			continue
+		}
+		for _, ipc := range r.result.IPs {
+			if !targetsInterface(r.result, ipc, argif) && (ipc.Interface != nil || r.ifName != argif) {
+				continue
+			}
+			family := ipFamily(ipc.Address.IP)
+			if owner, ok := owners[family]; ok {
+				return nil, fmt.Errorf("network %q returns the %s address %s for interface %s, which already has a %s address from network %q", r.netName, family, ipc.Address.String(), argif, family, owner)
+			}
+			owners[family] = r.netName
+			mergedIP := *ipc
+			mergedIP.Interface = ifIndex
+			ips = append(ips, &mergedIP)
+		}
+	}
+	res.IPs = ips
+	return res.GetAsVersion(result.Version())
+}
+
+// checkSelfReferentialRoutes returns an error if a route of the delegate results
+// uses one of the IP addresses assigned to the pod as its gateway
+func checkSelfReferentialRoutes(results []delegateResult) error {
+	podIPs := map[string]string{}
+	for _, r := range results {
+		for _, ipc := range r.result.IPs {
+			podIPs[ipc.Address.IP.String()] = r.netName
+		}
+	}
+
+	for _, r := range results {
+		for _, route := range r.result.Routes {
+			if route.GW == nil {
+				continue
+			}
+			if owner, ok := podIPs[route.GW.String()]; ok {
+				return fmt.Errorf("route %s of network %q uses the pod IP address %s of network %q as gateway", route.Dst.String(), r.netName, route.GW, owner)
+			}
+		}
+	}
+	return nil
+}
+
+// maxDNSNameservers is the number of nameservers honored in resolv.conf
+const maxDNSNameservers = 3
+
+// mergeDNS appends the DNS settings of dns which are not in merged yet,
+// keeping the order of first occurrence
+func mergeDNS(merged *cnitypes.DNS, dns cnitypes.DNS) {
+	if merged.Domain == "" {
+		merged.Domain = dns.Domain
+	}
+	merged.Nameservers = appendUnique(merged.Nameservers, dns.Nameservers)
+	merged.Search = appendUnique(merged.Search, dns.Search)
+	merged.Options = appendUnique(merged.Options, dns.Options)
+}
+
+func appendUnique(list []string, items []string) []string {
+	for _, item := range items {
+		found := false
+		for _, existing := range list {
+			if existing == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// setResultDNS returns the result with its DNS replaced by dns
+func setResultDNS(result cnitypes.Result, dns cnitypes.DNS) (cnitypes.Result, error) {
+	res, err := copyResult(result)
+	if err != nil {
+		return nil, err
+	}
+	res.DNS = dns
+	return res.GetAsVersion(result.Version())
+}
+
+// stripLinkLocalFromResult returns the result without its IPv6 link-local
+// addresses, and without its IPv4 link-local addresses if stripIPv4 is set
+func stripLinkLocalFromResult(result cnitypes.Result, stripIPv4 bool) (cnitypes.Result, error) {
+	res, err := copyResult(result)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []*cni100.IPConfig
+	for _, ipc := range res.IPs {
+		ip := ipc.Address.IP
+		if ip.IsLinkLocalUnicast() && (ip.To4() == nil || stripIPv4) {
+			logging.Debugf("stripLinkLocalFromResult: removing %s from the result", ipc.Address.String())
+			continue
+		}
+		ips = append(ips, ipc)
+	}
+	if len(ips) == len(res.IPs) {
+		return result, nil
+	}
+
+	res.IPs = ips
+	return res.GetAsVersion(result.Version())
+}
+
+func containsString(list []string, item string) bool {
+	for _, s := range list {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
+
+// ipFamily returns the defaultRouteFamilies name of the family of ip
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return types.DefaultRouteFamilyV4
+	}
+	return types.DefaultRouteFamilyV6
+}
+
+// filterDefaultRouteFamilies returns the gateways of the families listed in families,
+// or all the gateways if families is empty
+func filterDefaultRouteFamilies(gateways []net.IP, families []string) []net.IP {
+	if len(families) == 0 {
+		return gateways
+	}
+	var filtered []net.IP
+	for _, gw := range gateways {
+		if containsString(families, ipFamily(gw)) {
+			filtered = append(filtered, gw)
+		}
+	}
+	return filtered
+}
+
+// stripDefaultRoutesFromResult returns the result without the default routes of
+// the families not listed in families
+func stripDefaultRoutesFromResult(result cnitypes.Result, families []string) (cnitypes.Result, error) {
+	res, err := copyResult(result)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []*cnitypes.Route
+	for _, route := range res.Routes {
+		ones, _ := route.Dst.Mask.Size()
+		if ones == 0 && route.Dst.IP.IsUnspecified() && !containsString(families, ipFamily(route.Dst.IP)) {
+			logging.Debugf("stripDefaultRoutesFromResult: removing default route %s from the result", route.String())
+			continue
+		}
+		routes = append(routes, route)
+	}
+	if len(routes) == len(res.Routes) {
+		return result, nil
+	}
+
+	res.Routes = routes
+	return res.GetAsVersion(result.Version())
+}
+
+// overrideDefaultRoutesInResult returns the result with the default routes of the families
+// of gateways replaced by default routes through gateways, as set by default-route
+func overrideDefaultRoutesInResult(result cnitypes.Result, gateways []net.IP) (cnitypes.Result, error) {
+	res, err := copyResult(result)
+	if err != nil {
+		return nil, err
+	}
+
+	var families []string
+	for _, gw := range gateways {
+		families = append(families, ipFamily(gw))
+	}
+	var routes []*cnitypes.Route
+	for _, route := range res.Routes {
+		ones, _ := route.Dst.Mask.Size()
+		if ones == 0 && route.Dst.IP.IsUnspecified() && containsString(families, ipFamily(route.Dst.IP)) {
+			logging.Debugf("overrideDefaultRoutesInResult: removing default route %s from the result", route.String())
+			continue
+		}
+		routes = append(routes, route)
+	}
+	for _, gw := range gateways {
+		dst := net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}
+		if gw.To4() == nil {
+			dst = net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+		}
+		routes = append(routes, &cnitypes.Route{Dst: dst, GW: gw})
+	}
+	res.Routes = routes
+	return res.GetAsVersion(result.Version())
+}
+
+// fillInterfaceSandbox sets the sandbox of the container interface ifName
+// in the result when the delegate left it empty
+func fillInterfaceSandbox(result cnitypes.Result, ifName, netns string) (cnitypes.Result, error) {
+	res, err := cni100.NewResultFromResult(result)
+	if err != nil {
+		return nil, err
+	}
+
+	filled := false
+	for _, intf := range res.Interfaces {
+		if intf.Name == ifName && intf.Sandbox == "" {
+			intf.Sandbox = netns
+			filled = true
+		}
+	}
+	if !filled {
+		return result, nil
+	}
+	return res.GetAsVersion(result.Version())
+}
+
+// checkResultInterfaceIndexes verifies that the IPs of the result reference existing
+// interfaces, and either rejects the result or removes the invalid references
+func checkResultInterfaceIndexes(result cnitypes.Result, action string) (cnitypes.Result, error) {
+	res, err := cni100.NewResultFromResult(result)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []*cni100.IPConfig
+	invalid := false
+	for _, ipc := range res.IPs {
+		if ipc.Interface == nil || (*ipc.Interface >= 0 && *ipc.Interface < len(res.Interfaces)) {
+			ips = append(ips, ipc)
+			continue
+		}
+		if action == types.InvalidInterfaceIndexReject {
+			return nil, fmt.Errorf("IP address %s references interface index %d but the result has %d interfaces", ipc.Address.String(), *ipc.Interface, len(res.Interfaces))
+		}
+		logging.Verbosef("warning: removing interface index %d of IP address %s, the result has %d interfaces", *ipc.Interface, ipc.Address.String(), len(res.Interfaces))
+		cleared := *ipc
+		cleared.Interface = nil
+		ips = append(ips, &cleared)
+		invalid = true
+	}
+	if !invalid {
+		return result, nil
+	}
+
+	checked := *res
+	checked.IPs = ips
+	return checked.GetAsVersion(result.Version())
+}
+
+// checkResultPrefixes returns an error if an IP of the result has a prefix length out of
+// range for its family, or a zero prefix length unless allowZero is set
+func checkResultPrefixes(result cnitypes.Result, allowZero bool) error {
+	res, err := cni100.NewResultFromResult(result)
+	if err != nil {
+		return err
+	}
+	for _, ipc := range res.IPs {
+		ones, bits := ipc.Address.Mask.Size()
+		if bits == 0 {
+			return fmt.Errorf("IP address %s has a non-canonical netmask %s", ipc.Address.IP, ipc.Address.Mask)
+		}
+		family, prefix := "IPv6", ones
+		if ipc.Address.IP.To4() != nil {
+			// an IPv4 address may come with a 16 byte mask
+			family, prefix = "IPv4", ones-(bits-32)
+		} else if bits != 128 {
+			prefix = -1
+		}
+		if prefix < 0 {
+			return fmt.Errorf("IP address %s has a %d bit netmask with prefix length %d, which is invalid for %s", ipc.Address.IP, bits, ones, family)
+		}
+		if prefix == 0 && !allowZero {
+			return fmt.Errorf("IP address %s has prefix length 0", ipc.Address.IP)
+		}
+	}
+	return nil
+}
+
+// checkRequestedMAC returns an error if the container interface ifName of the result
+// does not have the MAC address requested for the network
+func checkRequestedMAC(res *cni100.Result, ifName, macRequest string) error {
+	requested, err := net.ParseMAC(macRequest)
+	if err != nil {
+		return fmt.Errorf("invalid requested MAC address %q: %v", macRequest, err)
+	}
+	for _, intf := range res.Interfaces {
+		if intf.Name != ifName || intf.Mac == "" {
+			continue
+		}
+		mac, err := net.ParseMAC(intf.Mac)
+		if err != nil {
+			return fmt.Errorf("invalid MAC address %q of interface %s in the result: %v", intf.Mac, ifName, err)
+		}
+		if mac.String() != requested.String() {
+			return fmt.Errorf("interface %s has MAC address %s but %s was requested", ifName, mac, requested)
+		}
+		return nil
+	}
+	logging.Verbosef("warning: result has no MAC address for interface %s, cannot verify requested MAC address %s", ifName, requested)
+	return nil
+}
+
+// checkRequestedIPCount returns an error if the result does not have as many IP addresses
+// as the static IP addresses requested for the network
+func checkRequestedIPCount(res *cni100.Result, ipRequest []string) error {
+	if len(res.IPs) == len(ipRequest) {
+		return nil
+	}
+	ips := []string{}
+	for _, ipc := range res.IPs {
+		ips = append(ips, ipc.Address.String())
+	}
+	return fmt.Errorf("%d IP addresses %v were requested but the result has %d %v", len(ipRequest), ipRequest, len(res.IPs), ips)
+}
+
+// checkDuplicateResultIPs records the IP addresses of a delegate result in resultIPs and
+// returns an error if any of them was already returned by another network
+func checkDuplicateResultIPs(resultIPs map[string]string, res *cni100.Result, netName string) error {
+	for _, ipc := range res.IPs {
+		ip := ipc.Address.IP.String()
+		if owner, ok := resultIPs[ip]; ok {
+			return fmt.Errorf("duplicate IP address %s returned by networks %q and %q", ip, owner, netName)
+		}
+		resultIPs[ip] = netName
+	}
+	return nil
+}
+
+// setNetworkStatus writes the network status annotation of the pod, tagged with the attachment IDs
+// if enabled. Failing to write it does not fail ADD, unless the pod was deleted or ADD is retried on
+// API server errors
+func setNetworkStatus(kubeClient *k8s.ClientInfo, k8sArgs *types.K8sArgs, netStatus []nettypes.NetworkStatus, attachmentIDs []string, n *types.NetConf) error {
+	if !n.AttachmentIDs {
+		attachmentIDs = nil
+	}
+	err := k8s.SetNetworkStatusWithAttachmentIDs(kubeClient, k8sArgs, netStatus, attachmentIDs, n)
+	if err != nil {
+		if strings.Contains(err.Error(), "failed to query the pod") {
+			return cmdErr(k8sArgs, "error setting the networks status, pod was already deleted: %v", err)
+		}
+		if n.CmdAddRetries > 0 && containsString(n.CmdAddRetryOn, types.CmdAddRetryOnAPIServer) {
+			return &recoverableError{category: types.CmdAddRetryOnAPIServer, err: cmdErr(k8sArgs, "error setting the networks status: %v", err)}
+		}
+		logging.Verbosef("warning: [%s/%s/%s]: failed to set the networks status: %v", k8sArgs.K8S_POD_NAMESPACE, k8sArgs.K8S_POD_NAME, k8sArgs.K8S_POD_UID, err)
+	}
+	return nil
 }
 
 func getDelegateDeviceInfo(_ *types.DelegateNetConf, runtimeConf *libcni.RuntimeConf) (*nettypes.DeviceInfo, error) {
@@ -121,9 +940,23 @@ func getDelegateDeviceInfo(_ *types.DelegateNetConf, runtimeConf *libcni.Runtime
 	return nil, nil
 }
 
+// scratchCacheVersion is the version of the delegates cache format written by saveDelegates.
+// Version 1 is the bare list of delegates, without the scratchCache envelope.
+const scratchCacheVersion = 2
+
+// scratchCache is the envelope of the delegates cache
+type scratchCache struct {
+	Version int             `json:"version"`
+	Data    json.RawMessage `json:"data"`
+}
+
 func saveDelegates(containerID, dataDir string, delegates []*types.DelegateNetConf) error {
 	logging.Debugf("saveDelegates: %s, %s, %v", containerID, dataDir, delegates)
-	delegatesBytes, err := json.Marshal(delegates)
+	data, err := json.Marshal(delegates)
+	if err != nil {
+		return logging.Errorf("saveDelegates: error serializing delegate netconf: %v", err)
+	}
+	delegatesBytes, err := json.Marshal(&scratchCache{Version: scratchCacheVersion, Data: data})
 	if err != nil {
 		return logging.Errorf("saveDelegates: error serializing delegate netconf: %v", err)
 	}
@@ -135,10 +968,46 @@ func saveDelegates(containerID, dataDir string, delegates []*types.DelegateNetCo
 	return err
 }
 
+// loadDelegates parses the delegates cache of saveDelegates, of the current or
+// of an older version
+func loadDelegates(netconfBytes []byte) ([]*types.DelegateNetConf, error) {
+	cache := &scratchCache{Version: 1, Data: netconfBytes}
+	if trimmed := strings.TrimSpace(string(netconfBytes)); !strings.HasPrefix(trimmed, "[") {
+		cache = &scratchCache{}
+		if err := json.Unmarshal(netconfBytes, cache); err != nil {
+			return nil, fmt.Errorf("loadDelegates: corrupt delegates cache: %v", err)
+		}
+	}
+
+	switch cache.Version {
+	case 1, scratchCacheVersion:
+	default:
+		return nil, fmt.Errorf("loadDelegates: unsupported delegates cache version %d, expected at most %d", cache.Version, scratchCacheVersion)
+	}
+
+	delegates := []*types.DelegateNetConf{}
+	if err := json.Unmarshal(cache.Data, &delegates); err != nil {
+		return nil, fmt.Errorf("loadDelegates: corrupt delegates cache of version %d: %v", cache.Version, err)
+	}
+	if len(delegates) == 0 {
+		return nil, fmt.Errorf("loadDelegates: corrupt delegates cache of version %d: no delegate", cache.Version)
+	}
+
+	// check plugins field and enable ConfListPlugin if there is
+	for _, v := range delegates {
+		if len(v.ConfList.Plugins) != 0 {
+			v.ConfListPlugin = true
+		}
+	}
+	// First delegate is always the master plugin
+	delegates[0].MasterPlugin = true
+	return delegates, nil
+}
+
 func deleteDelegates(containerID, dataDir string) error {
 	logging.Debugf("deleteDelegates: %s, %s", containerID, dataDir)
 
-	path := filepath.Join(dataDir, containerID)
+	path := scratchNetConfPath(containerID, dataDir)
 	if err := os.Remove(path); err != nil {
 		return logging.Errorf("deleteDelegates: error in deleting the delegates : %v", err)
 	}
@@ -167,19 +1036,45 @@ func validateIfName(nsname string, ifname string) error {
 	return err
 }
 
-func confAdd(rt *libcni.RuntimeConf, rawNetconf []byte, multusNetconf *types.NetConf, exec invoke.Exec) (cnitypes.Result, error) {
-	logging.Debugf("confAdd: %v, %s", rt, string(rawNetconf))
+// checkExistingInterfaces verifies, before any delegate is invoked, that none of
+// the interfaces to create already exists in the pod network namespace
+func checkExistingInterfaces(netnsPath string, n *types.NetConf, argif string) error {
+	podNs, err := ns.GetNS(netnsPath)
+	if err != nil {
+		if _, ok := err.(ns.NSPathNotExistErr); ok {
+			return logging.Errorf("checkExistingInterfaces: pod network namespace %s does not exist", netnsPath)
+		}
+		return logging.Errorf("checkExistingInterfaces: failed to open pod network namespace %s: %v", netnsPath, err)
+	}
+	defer podNs.Close()
+
+	return podNs.Do(func(_ ns.NetNS) error {
+		for idx, delegate := range n.Delegates {
+			ifName := getIfname(delegate, argif, n.SecondaryInterfacePrefix, idx)
+			_, err := netlink.LinkByName(ifName)
+			if err == nil {
+				return logging.Errorf("checkExistingInterfaces: interface %q for network %q already exists in pod network namespace %s", ifName, delegate.Name, netnsPath)
+			}
+			if _, ok := err.(netlink.LinkNotFoundError); !ok {
+				return logging.Errorf("checkExistingInterfaces: failed to look up interface %q: %v", ifName, err)
+			}
+		}
+		return nil
+	})
+}
+
+func confAdd(ctx context.Context, rt *libcni.RuntimeConf, rawNetconf []byte, multusNetconf *types.NetConf, exec invoke.Exec, logger *logging.Logger) (cnitypes.Result, error) {
+	logger.Debugf("confAdd: %v, %s", rt, string(rawNetconf))
 	// In part, adapted from K8s pkg/kubelet/dockershim/network/cni/cni.go
-	binDirs := filepath.SplitList(os.Getenv("CNI_PATH"))
-	binDirs = append([]string{multusNetconf.BinDir}, binDirs...)
+	binDirs := pluginBinDirs(multusNetconf)
 	cniNet := libcni.NewCNIConfigWithCacheDir(binDirs, multusNetconf.CNIDir, exec)
 
 	conf, err := libcni.ConfFromBytes(rawNetconf)
 	if err != nil {
-		return nil, logging.Errorf("error in converting the raw bytes to conf: %v", err)
+		return nil, logger.Errorf("error in converting the raw bytes to conf: %v", err)
 	}
 
-	result, err := cniNet.AddNetwork(context.Background(), conf, rt)
+	result, err := cniNet.AddNetwork(ctx, conf, rt)
 	if err != nil {
 		return nil, err
 	}
@@ -187,59 +1082,56 @@ func confAdd(rt *libcni.RuntimeConf, rawNetconf []byte, multusNetconf *types.Net
 	return result, nil
 }
 
-func confCheck(rt *libcni.RuntimeConf, rawNetconf []byte, multusNetconf *types.NetConf, exec invoke.Exec) error {
-	logging.Debugf("confCheck: %v, %s", rt, string(rawNetconf))
+func confCheck(rt *libcni.RuntimeConf, rawNetconf []byte, multusNetconf *types.NetConf, exec invoke.Exec, logger *logging.Logger) error {
+	logger.Debugf("confCheck: %v, %s", rt, string(rawNetconf))
 
-	binDirs := filepath.SplitList(os.Getenv("CNI_PATH"))
-	binDirs = append([]string{multusNetconf.BinDir}, binDirs...)
+	binDirs := pluginBinDirs(multusNetconf)
 	cniNet := libcni.NewCNIConfigWithCacheDir(binDirs, multusNetconf.CNIDir, exec)
 
 	conf, err := libcni.ConfFromBytes(rawNetconf)
 	if err != nil {
-		return logging.Errorf("error in converting the raw bytes to conf: %v", err)
+		return logger.Errorf("error in converting the raw bytes to conf: %v", err)
 	}
 
 	err = cniNet.CheckNetwork(context.Background(), conf, rt)
 	if err != nil {
-		return logging.Errorf("error in getting result from CheckNetwork: %v", err)
+		return logger.Errorf("error in getting result from CheckNetwork: %v", err)
 	}
 
 	return err
 }
 
-func confDel(rt *libcni.RuntimeConf, rawNetconf []byte, multusNetconf *types.NetConf, exec invoke.Exec) error {
-	logging.Debugf("confDel: %v, %s", rt, string(rawNetconf))
+func confDel(rt *libcni.RuntimeConf, rawNetconf []byte, multusNetconf *types.NetConf, exec invoke.Exec, logger *logging.Logger) error {
+	logger.Debugf("confDel: %v, %s", rt, string(rawNetconf))
 	// In part, adapted from K8s pkg/kubelet/dockershim/network/cni/cni.go
-	binDirs := filepath.SplitList(os.Getenv("CNI_PATH"))
-	binDirs = append([]string{multusNetconf.BinDir}, binDirs...)
+	binDirs := pluginBinDirs(multusNetconf)
 	cniNet := libcni.NewCNIConfigWithCacheDir(binDirs, multusNetconf.CNIDir, exec)
 
 	conf, err := libcni.ConfFromBytes(rawNetconf)
 	if err != nil {
-		return logging.Errorf("error in converting the raw bytes to conf: %v", err)
+		return logger.Errorf("error in converting the raw bytes to conf: %v", err)
 	}
 
 	err = cniNet.DelNetwork(context.Background(), conf, rt)
 	if err != nil {
-		return logging.Errorf("error in getting result from DelNetwork: %v", err)
+		return logger.Errorf("error in getting result from DelNetwork: %v", err)
 	}
 
 	return err
 }
 
-func conflistAdd(rt *libcni.RuntimeConf, rawnetconflist []byte, multusNetconf *types.NetConf, exec invoke.Exec) (cnitypes.Result, error) {
-	logging.Debugf("conflistAdd: %v, %s", rt, string(rawnetconflist))
+func conflistAdd(ctx context.Context, rt *libcni.RuntimeConf, rawnetconflist []byte, multusNetconf *types.NetConf, exec invoke.Exec, logger *logging.Logger) (cnitypes.Result, error) {
+	logger.Debugf("conflistAdd: %v, %s", rt, string(rawnetconflist))
 	// In part, adapted from K8s pkg/kubelet/dockershim/network/cni/cni.go
-	binDirs := filepath.SplitList(os.Getenv("CNI_PATH"))
-	binDirs = append([]string{multusNetconf.BinDir}, binDirs...)
+	binDirs := pluginBinDirs(multusNetconf)
 	cniNet := libcni.NewCNIConfigWithCacheDir(binDirs, multusNetconf.CNIDir, exec)
 
 	confList, err := libcni.ConfListFromBytes(rawnetconflist)
 	if err != nil {
-		return nil, logging.Errorf("conflistAdd: error converting the raw bytes into a conflist: %v", err)
+		return nil, logger.Errorf("conflistAdd: error converting the raw bytes into a conflist: %v", err)
 	}
 
-	result, err := cniNet.AddNetworkList(context.Background(), confList, rt)
+	result, err := cniNet.AddNetworkList(ctx, confList, rt)
 	if err != nil {
 		return nil, err
 	}
@@ -247,52 +1139,239 @@ func conflistAdd(rt *libcni.RuntimeConf, rawnetconflist []byte, multusNetconf *t
 	return result, nil
 }
 
-func conflistCheck(rt *libcni.RuntimeConf, rawnetconflist []byte, multusNetconf *types.NetConf, exec invoke.Exec) error {
-	logging.Debugf("conflistCheck: %v, %s", rt, string(rawnetconflist))
+func conflistCheck(rt *libcni.RuntimeConf, rawnetconflist []byte, multusNetconf *types.NetConf, exec invoke.Exec, logger *logging.Logger) error {
+	logger.Debugf("conflistCheck: %v, %s", rt, string(rawnetconflist))
 
-	binDirs := filepath.SplitList(os.Getenv("CNI_PATH"))
-	binDirs = append([]string{multusNetconf.BinDir}, binDirs...)
+	binDirs := pluginBinDirs(multusNetconf)
 	cniNet := libcni.NewCNIConfigWithCacheDir(binDirs, multusNetconf.CNIDir, exec)
 
 	confList, err := libcni.ConfListFromBytes(rawnetconflist)
 	if err != nil {
-		return logging.Errorf("conflistCheck: error converting the raw bytes into a conflist: %v", err)
+		return logger.Errorf("conflistCheck: error converting the raw bytes into a conflist: %v", err)
 	}
 
 	err = cniNet.CheckNetworkList(context.Background(), confList, rt)
 	if err != nil {
-		return logging.Errorf("conflistCheck: error in getting result from CheckNetworkList: %v", err)
+		return logger.Errorf("conflistCheck: error in getting result from CheckNetworkList: %v", err)
 	}
 
 	return err
 }
 
-func conflistDel(rt *libcni.RuntimeConf, rawnetconflist []byte, multusNetconf *types.NetConf, exec invoke.Exec) error {
-	logging.Debugf("conflistDel: %v, %s", rt, string(rawnetconflist))
+func conflistDel(rt *libcni.RuntimeConf, rawnetconflist []byte, multusNetconf *types.NetConf, exec invoke.Exec, logger *logging.Logger) error {
+	logger.Debugf("conflistDel: %v, %s", rt, string(rawnetconflist))
 	// In part, adapted from K8s pkg/kubelet/dockershim/network/cni/cni.go
-	binDirs := filepath.SplitList(os.Getenv("CNI_PATH"))
-	binDirs = append([]string{multusNetconf.BinDir}, binDirs...)
+	binDirs := pluginBinDirs(multusNetconf)
 	cniNet := libcni.NewCNIConfigWithCacheDir(binDirs, multusNetconf.CNIDir, exec)
 
-	confList, err := libcni.ConfListFromBytes(rawnetconflist)
+	confList, err := delConfList(rawnetconflist)
+	if err != nil {
+		return logger.Errorf("conflistDel: error converting the raw bytes into a conflist: %v", err)
+	}
+
+	err = cniNet.DelNetworkList(context.Background(), confList, rt)
+	if err != nil {
+		return logger.Errorf("conflistDel: error in getting result from DelNetworkList: %v", err)
+	}
+
+	return err
+}
+
+// delConfList returns the conflist of the raw bytes, wrapping a single plugin conf,
+// which has no "plugins" key, into a conflist of that plugin so that it can be deleted
+func delConfList(rawnetconflist []byte) (*libcni.NetworkConfigList, error) {
+	rawList := map[string]interface{}{}
+	if err := json.Unmarshal(rawnetconflist, &rawList); err != nil {
+		return nil, err
+	}
+	if _, ok := rawList["plugins"]; ok {
+		return libcni.ConfListFromBytes(rawnetconflist)
+	}
+
+	conf, err := libcni.ConfFromBytes(rawnetconflist)
+	if err != nil {
+		return nil, err
+	}
+	logging.Debugf("delConfList: wrapping the single plugin conf %q into a conflist", conf.Network.Name)
+	return libcni.ConfListFromConf(conf)
+}
+
+// pluginBinDirs returns the directories searched, in order, for the delegate plugin
+// binaries: binDir, the binDirs entries, then the CNI_PATH entries
+func pluginBinDirs(multusNetconf *types.NetConf) []string {
+	binDirs := []string{}
+	if multusNetconf.BinDir != "" {
+		binDirs = append(binDirs, multusNetconf.BinDir)
+	}
+	binDirs = append(binDirs, multusNetconf.BinDirs...)
+	return append(binDirs, filepath.SplitList(os.Getenv("CNI_PATH"))...)
+}
+
+// checkDelegatePlugins verifies that the plugin binaries of a delegate exist
+func checkDelegatePlugins(exec invoke.Exec, delegate *types.DelegateNetConf, multusNetconf *types.NetConf) error {
+	binDirs := pluginBinDirs(multusNetconf)
+
+	pluginTypes := []string{delegate.Conf.Type}
+	if delegate.ConfListPlugin {
+		pluginTypes = nil
+		for _, plugin := range delegate.ConfList.Plugins {
+			pluginTypes = append(pluginTypes, plugin.Type)
+		}
+	}
+
+	for _, pluginType := range pluginTypes {
+		var err error
+		if exec != nil {
+			_, err = exec.FindInPath(pluginType, binDirs)
+		} else {
+			_, err = invoke.FindInPath(pluginType, binDirs)
+		}
+		if err != nil {
+			return fmt.Errorf("plugin binary %q not found in paths %v", pluginType, binDirs)
+		}
+	}
+	return nil
+}
+
+// delegateLogger returns the logger of the lines of the delegate, at the delegateLogLevels
+// level of its plugin type, if any, or else at the global logging level
+func delegateLogger(delegate *types.DelegateNetConf, multusNetconf *types.NetConf) *logging.Logger {
+	if multusNetconf == nil || len(multusNetconf.DelegateLogLevels) == 0 {
+		return logging.NewLogger("")
+	}
+	pluginTypes := []string{delegate.Conf.Type}
+	if delegate.ConfListPlugin {
+		pluginTypes = nil
+		for _, plugin := range delegate.ConfList.Plugins {
+			pluginTypes = append(pluginTypes, plugin.Type)
+		}
+	}
+	for _, pluginType := range pluginTypes {
+		if level, ok := multusNetconf.DelegateLogLevels[pluginType]; ok {
+			return logging.NewLogger(level)
+		}
+	}
+	return logging.NewLogger("")
+}
+
+// hybridResultExec normalizes the ADD results filling both the 0.2.0 ip4/ip6 fields
+// and the 0.3.0+ ips field, keeping only the fields of the result version
+type hybridResultExec struct {
+	invoke.Exec
+}
+
+// ExecPlugin executes the plugin and normalizes its ADD result
+func (e *hybridResultExec) ExecPlugin(ctx context.Context, pluginPath string, stdinData []byte, environ []string) ([]byte, error) {
+	stdout, err := e.Exec.ExecPlugin(ctx, pluginPath, stdinData, environ)
+	if err != nil || !containsString(environ, "CNI_COMMAND=ADD") {
+		return stdout, err
+	}
+	if !json.Valid(stdout) {
+		return nil, fmt.Errorf("plugin %s succeeded but its result is not valid JSON: %q", filepath.Base(pluginPath), invalidResultHead(stdout))
+	}
+	return normalizeHybridResult(pluginPath, stdinData, stdout), nil
+}
+
+// maxInvalidResultHead is the size of the beginning of an invalid result kept in its error
+const maxInvalidResultHead = 128
+
+// invalidResultHead returns the beginning of the invalid result of a plugin
+func invalidResultHead(stdout []byte) string {
+	if len(stdout) > maxInvalidResultHead {
+		return string(stdout[:maxInvalidResultHead]) + "..."
+	}
+	return string(stdout)
+}
+
+// normalizeHybridResult removes the fields of the other result versions from a result
+// filling both the ip4/ip6 and ips fields, warning if their addresses differ
+func normalizeHybridResult(pluginPath string, stdinData, stdout []byte) []byte {
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(stdout, &result); err != nil {
+		return stdout
+	}
+	_, hasIP4 := result["ip4"]
+	_, hasIP6 := result["ip6"]
+	if _, hasIPs := result["ips"]; !hasIPs || (!hasIP4 && !hasIP6) {
+		return stdout
+	}
+
+	// the result version defaults to the config version, as in libcni
+	resultVersion := struct {
+		CNIVersion string `json:"cniVersion"`
+	}{}
+	_ = json.Unmarshal(stdout, &resultVersion)
+	if resultVersion.CNIVersion == "" {
+		_ = json.Unmarshal(stdinData, &resultVersion)
+	}
+
+	type legacyIPConfig struct {
+		IP string `json:"ip"`
+	}
+	var addresses struct {
+		IP4 *legacyIPConfig `json:"ip4"`
+		IP6 *legacyIPConfig `json:"ip6"`
+		IPs []struct {
+			Address string `json:"address"`
+		} `json:"ips"`
+	}
+	if err := json.Unmarshal(stdout, &addresses); err != nil {
+		return stdout
+	}
+	var legacyIPs, currentIPs []string
+	if addresses.IP4 != nil {
+		legacyIPs = append(legacyIPs, addresses.IP4.IP)
+	}
+	if addresses.IP6 != nil {
+		legacyIPs = append(legacyIPs, addresses.IP6.IP)
+	}
+	for _, ipc := range addresses.IPs {
+		currentIPs = append(currentIPs, ipc.Address)
+	}
+
+	current, err := cniversion.GreaterThanOrEqualTo(resultVersion.CNIVersion, "0.3.0")
 	if err != nil {
-		return logging.Errorf("conflistDel: error converting the raw bytes into a conflist: %v", err)
+		return stdout
+	}
+	fields := "ip4/ip6"
+	if current {
+		fields = "ips"
+	}
+	if strings.Join(legacyIPs, ",") != strings.Join(currentIPs, ",") {
+		logging.Verbosef("warning: result of %s has different addresses in ip4/ip6 %v and ips %v, using the %s of version %s", pluginPath, legacyIPs, currentIPs, fields, resultVersion.CNIVersion)
 	}
 
-	err = cniNet.DelNetworkList(context.Background(), confList, rt)
+	if current {
+		delete(result, "ip4")
+		delete(result, "ip6")
+	} else {
+		delete(result, "ips")
+		delete(result, "interfaces")
+	}
+	normalized, err := json.Marshal(result)
 	if err != nil {
-		return logging.Errorf("conflistDel: error in getting result from DelNetworkList: %v", err)
+		return stdout
 	}
+	return normalized
+}
 
-	return err
+// delegateTimeoutError is returned when a delegate ADD exceeds delegateTimeoutSeconds
+type delegateTimeoutError struct {
+	seconds int
+	err     error
+}
+
+func (e *delegateTimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %d seconds: %v", e.seconds, e.err)
 }
 
 // DelegateAdd ...
 func DelegateAdd(exec invoke.Exec, kubeClient *k8s.ClientInfo, pod *v1.Pod, delegate *types.DelegateNetConf, rt *libcni.RuntimeConf, multusNetconf *types.NetConf) (cnitypes.Result, error) {
-	logging.Debugf("DelegateAdd: %v, %v, %v", exec, delegate, rt)
+	logger := delegateLogger(delegate, multusNetconf)
+	logger.Debugf("DelegateAdd: %v, %v, %v", exec, delegate, rt)
 
 	if err := validateIfName(rt.NetNS, rt.IfName); err != nil {
-		return nil, logging.Errorf("DelegateAdd: cannot set %q interface name to %q: %v", delegate.Conf.Type, rt.IfName, err)
+		return nil, logger.Errorf("DelegateAdd: cannot set %q interface name to %q: %v", delegate.Conf.Type, rt.IfName, err)
 	}
 
 	// Deprecated in ver 3.5.
@@ -301,10 +1380,10 @@ func DelegateAdd(exec invoke.Exec, kubeClient *k8s.ClientInfo, pod *v1.Pod, dele
 			// validate Mac address
 			_, err := net.ParseMAC(delegate.MacRequest)
 			if err != nil {
-				return nil, logging.Errorf("DelegateAdd: failed to parse mac address %q", delegate.MacRequest)
+				return nil, logger.Errorf("DelegateAdd: failed to parse mac address %q", delegate.MacRequest)
 			}
 
-			logging.Debugf("DelegateAdd: set MAC address %q to %q", delegate.MacRequest, rt.IfName)
+			logger.Debugf("DelegateAdd: set MAC address %q to %q", delegate.MacRequest, rt.IfName)
 			rt.Args = append(rt.Args, [2]string{"MAC", delegate.MacRequest})
 		}
 
@@ -314,34 +1393,46 @@ func DelegateAdd(exec invoke.Exec, kubeClient *k8s.ClientInfo, pod *v1.Pod, dele
 				if strings.Contains(ip, "/") {
 					_, _, err := net.ParseCIDR(ip)
 					if err != nil {
-						return nil, logging.Errorf("DelegateAdd: failed to parse IP address %q", ip)
+						return nil, logger.Errorf("DelegateAdd: failed to parse IP address %q", ip)
 					}
 				} else if net.ParseIP(ip) == nil {
-					return nil, logging.Errorf("DelegateAdd: failed to parse IP address %q", ip)
+					return nil, logger.Errorf("DelegateAdd: failed to parse IP address %q", ip)
 				}
 			}
 
 			ips := strings.Join(delegate.IPRequest, ",")
-			logging.Debugf("DelegateAdd: set IP address %q to %q", ips, rt.IfName)
+			logger.Debugf("DelegateAdd: set IP address %q to %q", ips, rt.IfName)
 			rt.Args = append(rt.Args, [2]string{"IP", ips})
 		}
 	}
 
+	ctx := context.Background()
+	if multusNetconf != nil && multusNetconf.DelegateTimeoutSeconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(multusNetconf.DelegateTimeoutSeconds)*time.Second)
+		defer cancel()
+	}
+
+	if exec == nil {
+		exec = newStderrExec()
+	}
+	exec = newMetricsExec(&hybridResultExec{Exec: exec}, delegate.Name)
+
 	var result cnitypes.Result
 	var err error
 	if delegate.ConfListPlugin {
-		result, err = conflistAdd(rt, delegate.Bytes, multusNetconf, exec)
-		if err != nil {
-			return nil, err
-		}
+		result, err = conflistAdd(ctx, rt, delegate.Bytes, multusNetconf, exec, logger)
 	} else {
-		result, err = confAdd(rt, delegate.Bytes, multusNetconf, exec)
-		if err != nil {
-			return nil, err
+		result, err = confAdd(ctx, rt, delegate.Bytes, multusNetconf, exec, logger)
+	}
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, &delegateTimeoutError{seconds: multusNetconf.DelegateTimeoutSeconds, err: err}
 		}
+		return nil, err
 	}
 
-	if logging.GetLoggingLevel() >= logging.VerboseLevel {
+	if logger.Level() >= logging.VerboseLevel {
 		data, _ := json.Marshal(result)
 		var cniConfName string
 		if delegate.ConfListPlugin {
@@ -354,14 +1445,18 @@ func DelegateAdd(exec invoke.Exec, kubeClient *k8s.ClientInfo, pod *v1.Pod, dele
 		if pod != nil {
 			podUID = string(pod.ObjectMeta.UID)
 		}
-		logging.Verbosef("Add: %s:%s:%s:%s(%s):%s %s", rt.Args[1][1], rt.Args[2][1], podUID, delegate.Name, cniConfName, rt.IfName, string(data))
+		fields := logging.Fields{"containerID": rt.ContainerID, "podNamespace": rt.Args[1][1], "podName": rt.Args[2][1], "podUID": podUID, "netName": delegate.Name}
+		if delegate.AttachmentID != "" {
+			fields["attachmentID"] = delegate.AttachmentID
+		}
+		logger.WithFields(fields).Verbosef("Add: %s:%s:%s:%s(%s):%s%s %s", rt.Args[1][1], rt.Args[2][1], podUID, delegate.Name, cniConfName, rt.IfName, attachmentLogSuffix(delegate), string(data))
 	}
 
 	// get IP addresses from result
 	ips := []string{}
 	res, err := cni100.NewResultFromResult(result)
 	if err != nil {
-		logging.Errorf("DelegateAdd: error converting result: %v", err)
+		logger.Errorf("DelegateAdd: error converting result: %v", err)
 		return result, nil
 	}
 	for _, ip := range res.IPs {
@@ -373,42 +1468,71 @@ func DelegateAdd(exec invoke.Exec, kubeClient *k8s.ClientInfo, pod *v1.Pod, dele
 		if res.Interfaces != nil || res.IPs != nil {
 			// send kubernetes events
 			if delegate.Name != "" {
-				kubeClient.Eventf(pod, v1.EventTypeNormal, "AddedInterface", "Add %s %v from %s", rt.IfName, ips, delegate.Name)
+				kubeClient.Eventf(pod, v1.EventTypeNormal, "AddedInterface", "Add %s %v from %s%s", rt.IfName, ips, delegate.Name, interfaceEventDetails(res, rt))
 			} else {
-				kubeClient.Eventf(pod, v1.EventTypeNormal, "AddedInterface", "Add %s %v", rt.IfName, ips)
+				kubeClient.Eventf(pod, v1.EventTypeNormal, "AddedInterface", "Add %s %v%s", rt.IfName, ips, interfaceEventDetails(res, rt))
 			}
 		}
 	} else {
 		// for further debug https://github.com/k8snetworkplumbingwg/multus-cni/issues/481
-		logging.Errorf("DelegateAdd: pod nil pointer: namespace: %s, name: %s, container id: %s, pod: %v", rt.Args[1][1], rt.Args[2][1], rt.Args[3][1], pod)
+		logger.Errorf("DelegateAdd: pod nil pointer: namespace: %s, name: %s, container id: %s, pod: %v", rt.Args[1][1], rt.Args[2][1], rt.Args[3][1], pod)
 	}
 	return result, nil
 }
 
+// interfaceEventDetails describes the MAC address and sandbox of the container interface of
+// a result, as " mac=<mac> sandbox=<path>", the sandbox defaulting to the container netns
+func interfaceEventDetails(res *cni100.Result, rt *libcni.RuntimeConf) string {
+	var containerIface *cni100.Interface
+	for _, iface := range res.Interfaces {
+		// prefer the interface in a sandbox to a host interface of the same name
+		if iface.Name == rt.IfName && (containerIface == nil || containerIface.Sandbox == "") {
+			containerIface = iface
+		}
+	}
+
+	var details string
+	sandbox := rt.NetNS
+	if containerIface != nil {
+		if containerIface.Mac != "" {
+			details += " mac=" + containerIface.Mac
+		}
+		if containerIface.Sandbox != "" {
+			sandbox = containerIface.Sandbox
+		}
+	}
+	if sandbox != "" {
+		details += " sandbox=" + sandbox
+	}
+	return details
+}
+
 // DelegateCheck ...
 func DelegateCheck(exec invoke.Exec, delegateConf *types.DelegateNetConf, rt *libcni.RuntimeConf, multusNetconf *types.NetConf) error {
-	logging.Debugf("DelegateCheck: %v, %v, %v", exec, delegateConf, rt)
+	logger := delegateLogger(delegateConf, multusNetconf)
+	logger.Debugf("DelegateCheck: %v, %v, %v", exec, delegateConf, rt)
+	exec = newMetricsExec(exec, delegateConf.Name)
 
-	if logging.GetLoggingLevel() >= logging.VerboseLevel {
+	if logger.Level() >= logging.VerboseLevel {
 		var cniConfName string
 		if delegateConf.ConfListPlugin {
 			cniConfName = delegateConf.ConfList.Name
 		} else {
 			cniConfName = delegateConf.Conf.Name
 		}
-		logging.Verbosef("Check: %s:%s:%s(%s):%s %s", rt.Args[1][1], rt.Args[2][1], delegateConf.Name, cniConfName, rt.IfName, string(delegateConf.Bytes))
+		logger.Verbosef("Check: %s:%s:%s(%s):%s%s %s", rt.Args[1][1], rt.Args[2][1], delegateConf.Name, cniConfName, rt.IfName, attachmentLogSuffix(delegateConf), string(delegateConf.Bytes))
 	}
 
 	var err error
 	if delegateConf.ConfListPlugin {
-		err = conflistCheck(rt, delegateConf.Bytes, multusNetconf, exec)
+		err = conflistCheck(rt, delegateConf.Bytes, multusNetconf, exec, logger)
 		if err != nil {
-			return logging.Errorf("DelegateCheck: error invoking ConflistCheck - %q: %v", delegateConf.ConfList.Name, err)
+			return logger.Errorf("DelegateCheck: error invoking ConflistCheck - %q: %v", delegateConf.ConfList.Name, err)
 		}
 	} else {
-		err = confCheck(rt, delegateConf.Bytes, multusNetconf, exec)
+		err = confCheck(rt, delegateConf.Bytes, multusNetconf, exec, logger)
 		if err != nil {
-			return logging.Errorf("DelegateCheck: error invoking DelegateCheck - %q: %v", delegateConf.Conf.Type, err)
+			return logger.Errorf("DelegateCheck: error invoking DelegateCheck - %q: %v", delegateConf.Conf.Type, err)
 		}
 	}
 
@@ -417,9 +1541,11 @@ func DelegateCheck(exec invoke.Exec, delegateConf *types.DelegateNetConf, rt *li
 
 // DelegateDel ...
 func DelegateDel(exec invoke.Exec, pod *v1.Pod, delegateConf *types.DelegateNetConf, rt *libcni.RuntimeConf, multusNetconf *types.NetConf) error {
-	logging.Debugf("DelegateDel: %v, %v, %v, %v", exec, pod, delegateConf, rt)
+	logger := delegateLogger(delegateConf, multusNetconf)
+	logger.Debugf("DelegateDel: %v, %v, %v, %v", exec, pod, delegateConf, rt)
+	exec = newMetricsExec(exec, delegateConf.Name)
 
-	if logging.GetLoggingLevel() >= logging.VerboseLevel {
+	if logger.Level() >= logging.VerboseLevel {
 		var confName string
 		if delegateConf.ConfListPlugin {
 			confName = delegateConf.ConfList.Name
@@ -430,38 +1556,340 @@ func DelegateDel(exec invoke.Exec, pod *v1.Pod, delegateConf *types.DelegateNetC
 		if pod != nil {
 			podUID = string(pod.ObjectMeta.UID)
 		}
-		logging.Verbosef("Del: %s:%s:%s:%s:%s %s", rt.Args[1][1], rt.Args[2][1], podUID, confName, rt.IfName, string(delegateConf.Bytes))
+		fields := logging.Fields{"containerID": rt.ContainerID, "podNamespace": rt.Args[1][1], "podName": rt.Args[2][1], "podUID": podUID, "netName": confName}
+		if delegateConf.AttachmentID != "" {
+			fields["attachmentID"] = delegateConf.AttachmentID
+		}
+		logger.WithFields(fields).Verbosef("Del: %s:%s:%s:%s:%s%s %s", rt.Args[1][1], rt.Args[2][1], podUID, confName, rt.IfName, attachmentLogSuffix(delegateConf), string(delegateConf.Bytes))
 	}
 
 	var err error
 	if delegateConf.ConfListPlugin {
-		err = conflistDel(rt, delegateConf.Bytes, multusNetconf, exec)
+		err = conflistDel(rt, delegateConf.Bytes, multusNetconf, exec, logger)
 		if err != nil {
-			return logging.Errorf("DelegateDel: error invoking ConflistDel - %q: %v", delegateConf.ConfList.Name, err)
+			return logger.Errorf("DelegateDel: error invoking ConflistDel - %q: %v", delegateConf.ConfList.Name, err)
 		}
 	} else {
-		err = confDel(rt, delegateConf.Bytes, multusNetconf, exec)
+		err = confDel(rt, delegateConf.Bytes, multusNetconf, exec, logger)
 		if err != nil {
-			return logging.Errorf("DelegateDel: error invoking DelegateDel - %q: %v", delegateConf.Conf.Type, err)
+			return logger.Errorf("DelegateDel: error invoking DelegateDel - %q: %v", delegateConf.Conf.Type, err)
 		}
 	}
 
 	return err
 }
 
+// delegatesSummary describes the name, plugin type and interface name of each delegate,
+// leaving out the rest of their configuration
+func delegatesSummary(delegates []*types.DelegateNetConf, argif, prefix string) string {
+	summaries := make([]string, 0, len(delegates))
+	for idx, delegate := range delegates {
+		pluginType := delegate.Conf.Type
+		if delegate.ConfListPlugin {
+			var pluginTypes []string
+			for _, plugin := range delegate.ConfList.Plugins {
+				pluginTypes = append(pluginTypes, plugin.Type)
+			}
+			pluginType = strings.Join(pluginTypes, ",")
+		}
+		summaries = append(summaries, fmt.Sprintf("%s(type: %s, ifname: %s)", delegate.Name, pluginType, getIfname(delegate, argif, prefix, idx)))
+	}
+	return strings.Join(summaries, ", ")
+}
+
+// resolvedNetworksSummary lists the default and additional networks of the pod
+func resolvedNetworksSummary(delegates []*types.DelegateNetConf) string {
+	var defaults, additional []string
+	for _, delegate := range delegates {
+		if delegate.MasterPlugin {
+			defaults = append(defaults, delegate.Name)
+		} else {
+			additional = append(additional, delegate.Name)
+		}
+	}
+	return fmt.Sprintf("default networks: [%s], additional networks: [%s]", strings.Join(defaults, ", "), strings.Join(additional, ", "))
+}
+
+// delegateOrder returns the indexes of the delegates in the order they are added,
+// which depends on defaultNetworkOrder
+func delegateOrder(n *types.NetConf) []int {
+	order := make([]int, 0, len(n.Delegates))
+	var masters []int
+	for idx, delegate := range n.Delegates {
+		if delegate.MasterPlugin && n.DefaultNetworkOrder == types.DefaultNetworkOrderLast {
+			masters = append(masters, idx)
+			continue
+		}
+		order = append(order, idx)
+	}
+	return append(order, masters...)
+}
+
+// delegateAddResult is the outcome of the ADD of a delegate
+type delegateAddResult struct {
+	rt     *libcni.RuntimeConf
+	result cnitypes.Result
+	// pluginErr is set when the plugin binaries of the delegate are missing,
+	// in which case the delegate was not invoked
+	pluginErr error
+	err       error
+}
+
+// addDelegate invokes the ADD of the delegate at position pos of order. defaultResult
+// is the result of the default network, if it was added already.
+func addDelegate(exec invoke.Exec, kubeClient *k8s.ClientInfo, pod *v1.Pod, args *skel.CmdArgs, k8sArgs *types.K8sArgs, n *types.NetConf, order []int, pos int, defaultResult cnitypes.Result) *delegateAddResult {
+	idx := order[pos]
+	delegate := n.Delegates[idx]
+	// interface names follow the position in the delegate list, not the order of addition
+	ifName := getIfname(delegate, args.IfName, n.SecondaryInterfacePrefix, idx)
+	rt, cniDeviceInfoPath := types.CreateCNIRuntimeConf(args, k8sArgs, ifName, n.RuntimeConfig, delegate)
+	if delegate.ReceivesDefaultResult {
+		if err := setDefaultResultCapability(rt, defaultResult); err != nil {
+			return &delegateAddResult{rt: rt, err: err}
+		}
+	}
+	if cniDeviceInfoPath != "" && delegate.ResourceName != "" && delegate.DeviceID != "" {
+		err := nadutils.CopyDeviceInfoForCNIFromDP(cniDeviceInfoPath, delegate.ResourceName, delegate.DeviceID)
+		// Even if the filename is set, file may not be present. Ignore error,
+		// but log and in the future may need to filter on specific errors.
+		if err != nil {
+			logging.Debugf("CmdAdd: CopyDeviceInfoForCNIFromDP returned an error - err=%v", err)
+		}
+	}
+
+	if err := checkDelegatePlugins(exec, delegate, n); err != nil {
+		return &delegateAddResult{rt: rt, pluginErr: err}
+	}
+	result, err := DelegateAdd(exec, kubeClient, pod, delegate, rt, n)
+	return &delegateAddResult{rt: rt, result: result, err: err}
+}
+
+// setDefaultResultCapability passes the IPs and routes of the default network result
+// in the defaultResult runtimeConfig of a delegate
+func setDefaultResultCapability(rt *libcni.RuntimeConf, defaultResult cnitypes.Result) error {
+	if defaultResult == nil {
+		return fmt.Errorf("the result of the default network is not available, the default network must be added first")
+	}
+	res, err := cni100.NewResultFromResult(defaultResult)
+	if err != nil {
+		return fmt.Errorf("failed to read the result of the default network: %v", err)
+	}
+	rc := &types.DefaultResult{Routes: res.Routes}
+	for _, ip := range res.IPs {
+		rc.IPs = append(rc.IPs, ip.Address.String())
+	}
+	if rt.CapabilityArgs == nil {
+		rt.CapabilityArgs = map[string]interface{}{}
+	}
+	rt.CapabilityArgs[types.DefaultResultCapability] = rc
+	return nil
+}
+
+// addDelegatesConcurrently invokes the ADD of the delegates in order, running consecutive
+// non-master delegates concurrently, at most maxConcurrentDelegates at a time. It stops
+// after the first batch with a failure, and returns the outcomes by position in order
+// along with the number of delegates it invoked.
+func addDelegatesConcurrently(exec invoke.Exec, kubeClient *k8s.ClientInfo, pod *v1.Pod, args *skel.CmdArgs, k8sArgs *types.K8sArgs, n *types.NetConf, order []int) ([]*delegateAddResult, int) {
+	added := make([]*delegateAddResult, len(order))
+	sem := make(chan struct{}, n.MaxConcurrentDelegates)
+	var defaultResult cnitypes.Result
+	start := 0
+	for start < len(order) {
+		// the master delegate runs on its own, the other ones in batches
+		end := start + 1
+		if !n.Delegates[order[start]].MasterPlugin {
+			for end < len(order) && !n.Delegates[order[end]].MasterPlugin {
+				end++
+			}
+		}
+
+		var wg sync.WaitGroup
+		for pos := start; pos < end; pos++ {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(pos int) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				added[pos] = addDelegate(exec, kubeClient, pod, args, k8sArgs, n, order, pos, defaultResult)
+			}(pos)
+		}
+		wg.Wait()
+
+		for pos := start; pos < end; pos++ {
+			if added[pos].err != nil || (added[pos].pluginErr != nil && !n.Delegates[order[pos]].Optional) {
+				return added, end
+			}
+		}
+		if n.Delegates[order[start]].MasterPlugin {
+			defaultResult = added[start].result
+		}
+		start = end
+	}
+	return added, len(order)
+}
+
 // delPlugins deletes plugins in reverse order from lastdIdx
 // Uses netRt as base RuntimeConf (coming from NetConf) but merges it
 // with each of the delegates' configuration
 func delPlugins(exec invoke.Exec, pod *v1.Pod, args *skel.CmdArgs, k8sArgs *types.K8sArgs, delegates []*types.DelegateNetConf, lastIdx int, netRt *types.RuntimeConfig, multusNetconf *types.NetConf) error {
-	logging.Debugf("delPlugins: %v, %v, %v, %v, %v, %d, %v", exec, pod, args, k8sArgs, delegates, lastIdx, netRt)
+	order := make([]int, 0, lastIdx+1)
+	for idx := 0; idx <= lastIdx; idx++ {
+		order = append(order, idx)
+	}
+	return delPluginsInOrder(exec, nil, pod, args, k8sArgs, delegates, order, netRt, multusNetconf)
+}
+
+// delWithoutDelegates is the last resort of DEL when neither the delegates cache nor the pod
+// are available: it deletes the interfaces left in the netns whose networks are found in the
+// result cache of libcni, then the default network, the only one known from the configuration,
+// and reports the other interfaces, whose networks cannot be known anymore
+func delWithoutDelegates(exec invoke.Exec, netns ns.NetNS, args *skel.CmdArgs, k8sArgs *types.K8sArgs, in *types.NetConf, kubeClient *k8s.ClientInfo) {
+	if in.ClusterNetwork != "" && kubeClient != nil {
+		delegate, err := k8s.GetClusterNetworkDelegate(kubeClient, in)
+		if err != nil {
+			logging.Errorf("Multus: %v, cannot delete the default network", err)
+		} else {
+			in.Delegates = []*types.DelegateNetConf{delegate}
+		}
+	}
+
+	if netns != nil {
+		delLeftoverInterfaces(exec, netns, args, k8sArgs, in)
+	}
+
+	if !in.NoDefaultNetwork && len(in.Delegates) > 0 {
+		if err := setMasterPlugin(in); err != nil {
+			logging.Errorf("Multus: %v, cannot delete the default network", err)
+		} else {
+			delegates := in.Delegates[:1]
+			_ = types.SetDelegateCNIVersion(delegates[0], in.CNIVersion)
+			_ = types.SetDelegateDefaultCapabilities(delegates[0], in.DefaultCapabilities)
+			logging.Verbosef("Multus: deleting the default network %s of container %s without cache nor pod", delegates[0].Name, args.ContainerID)
+			if err := delPluginsInOrder(exec, nil, nil, args, k8sArgs, delegates, []int{0}, in.RuntimeConfig, in); err != nil {
+				logging.Errorf("Multus: failed to delete the default network: %v", err)
+			}
+		}
+	}
+}
+
+// delLeftoverInterfaces deletes the networks of the interfaces left in netns, other than the
+// one of the default network, from their configuration in the result cache of libcni
+func delLeftoverInterfaces(exec invoke.Exec, netns ns.NetNS, args *skel.CmdArgs, k8sArgs *types.K8sArgs, in *types.NetConf) {
+	var leftover []string
+	err := netns.Do(func(_ ns.NetNS) error {
+		links, err := netlink.LinkList()
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			attrs := link.Attrs()
+			if attrs.Flags&net.FlagLoopback != 0 || attrs.Name == args.IfName {
+				continue
+			}
+			leftover = append(leftover, attrs.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		logging.Verbosef("warning: failed to list the interfaces of netns %s: %v", args.Netns, err)
+		return
+	}
+
+	var delegates []*types.DelegateNetConf
+	var unknown []string
+	for _, ifName := range leftover {
+		delegate, err := cachedInterfaceDelegate(in.CNIDir, args.ContainerID, ifName)
+		if err != nil {
+			logging.Verbosef("warning: %v", err)
+		}
+		if delegate == nil {
+			unknown = append(unknown, ifName)
+			continue
+		}
+		delegates = append(delegates, delegate)
+	}
+	if len(delegates) > 0 {
+		order := make([]int, 0, len(delegates))
+		for idx := range delegates {
+			order = append(order, idx)
+		}
+		logging.Verbosef("Multus: deleting the networks of interfaces %v of container %s from the result cache", delegateIfNames(delegates), args.ContainerID)
+		if err := delPluginsInOrder(exec, nil, nil, args, k8sArgs, delegates, order, in.RuntimeConfig, in); err != nil {
+			logging.Errorf("Multus: failed to delete the networks of the interfaces left in netns %s: %v", args.Netns, err)
+		}
+	}
+	if len(unknown) > 0 {
+		logging.Verbosef("warning: interfaces %v of container %s are left to the removal of netns %s, their networks are unknown", unknown, args.ContainerID, args.Netns)
+	}
+}
+
+// cachedInterfaceDelegate returns the delegate of the interface ifName of the container from
+// the result cache that libcni saves in cniDir on ADD, or nil if it has no cached result
+func cachedInterfaceDelegate(cniDir, containerID, ifName string) (*types.DelegateNetConf, error) {
+	// the cached results are named <network name>-<container ID>-<interface name>
+	paths, err := filepath.Glob(filepath.Join(cniDir, "results", "*-"+containerID+"-"+ifName))
+	if err != nil || len(paths) == 0 {
+		return nil, err
+	}
+	data, err := os.ReadFile(paths[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the cached result of interface %s: %v", ifName, err)
+	}
+	cached := struct {
+		Kind   string `json:"kind"`
+		Config []byte `json:"config"`
+		IfName string `json:"ifName"`
+	}{}
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, fmt.Errorf("failed to parse the cached result of interface %s: %v", ifName, err)
+	}
+	if cached.Kind != libcni.CNICacheV1 || cached.IfName != ifName || len(cached.Config) == 0 {
+		return nil, nil
+	}
+	delegate, err := types.LoadDelegateNetConf(cached.Config, nil, "", "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the cached config of interface %s: %v", ifName, err)
+	}
+	delegate.IfnameRequest = ifName
+	return delegate, nil
+}
+
+// delegateIfNames returns the requested interface names of delegates
+func delegateIfNames(delegates []*types.DelegateNetConf) []string {
+	ifNames := make([]string, 0, len(delegates))
+	for _, delegate := range delegates {
+		ifNames = append(ifNames, delegate.IfnameRequest)
+	}
+	return ifNames
+}
 
-	var errorstrings []string
-	for idx := lastIdx; idx >= 0; idx-- {
-		ifName := getIfname(delegates[idx], args.IfName, idx)
+// delPluginsInOrder deletes the plugins of the delegates at the given indexes,
+// in reverse order, and records a RemovedInterface event on pod for each one
+func delPluginsInOrder(exec invoke.Exec, kubeClient *k8s.ClientInfo, pod *v1.Pod, args *skel.CmdArgs, k8sArgs *types.K8sArgs, delegates []*types.DelegateNetConf, order []int, netRt *types.RuntimeConfig, multusNetconf *types.NetConf) error {
+	logging.Debugf("delPluginsInOrder: %v, %v, %v, %v, %v, %v, %v", exec, pod, args, k8sArgs, delegates, order, netRt)
+
+	var prefix string
+	if multusNetconf != nil {
+		prefix = multusNetconf.SecondaryInterfacePrefix
+	}
+	var errs delegateErrors
+	for pos := len(order) - 1; pos >= 0; pos-- {
+		idx := order[pos]
+		if delegates[idx].Optional && checkDelegatePlugins(exec, delegates[idx], multusNetconf) != nil {
+			// optional networks without plugin binary are never added
+			continue
+		}
+		ifName := getIfname(delegates[idx], args.IfName, prefix, idx)
 		rt, cniDeviceInfoPath := types.CreateCNIRuntimeConf(args, k8sArgs, ifName, netRt, delegates[idx])
 		// Attempt to delete all but do not error out, instead, collect all errors.
 		if err := DelegateDel(exec, pod, delegates[idx], rt, multusNetconf); err != nil {
-			errorstrings = append(errorstrings, err.Error())
+			errs = append(errs, &delegateError{idx: idx, netName: delegateNetName(delegates[idx]), err: err})
+		} else if pod != nil {
+			if delegates[idx].Name != "" {
+				kubeClient.Eventf(pod, v1.EventTypeNormal, "RemovedInterface", "Remove %s from %s", ifName, delegates[idx].Name)
+			} else {
+				kubeClient.Eventf(pod, v1.EventTypeNormal, "RemovedInterface", "Remove %s", ifName)
+			}
 		}
 		if cniDeviceInfoPath != "" {
 			err := nadutils.CleanDeviceInfoForCNI(cniDeviceInfoPath)
@@ -474,11 +1902,69 @@ func delPlugins(exec invoke.Exec, pod *v1.Pod, args *skel.CmdArgs, k8sArgs *type
 	}
 
 	// Check if we had any errors, and send them all back.
-	if len(errorstrings) > 0 {
-		return fmt.Errorf(strings.Join(errorstrings, " / "))
+	return errs.errorOrNil()
+}
+
+// delegateError is the error of the delegate at index idx of the delegates
+type delegateError struct {
+	idx     int
+	netName string
+	err     error
+}
+
+// delegateErrors are the errors of several delegates, reported together
+type delegateErrors []*delegateError
+
+func (e delegateErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].err.Error()
+	}
+	errorstrings := make([]string, 0, len(e))
+	for _, de := range e {
+		errorstrings = append(errorstrings, fmt.Sprintf("delegate %d (%q): %v", de.idx, de.netName, de.err))
 	}
+	return fmt.Sprintf("%d delegates failed: %s", len(e), strings.Join(errorstrings, " / "))
+}
 
-	return nil
+// Unwrap returns the error of the first delegate
+func (e delegateErrors) Unwrap() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e[0].err
+}
+
+// errorOrNil returns e, or nil if there is no error
+func (e delegateErrors) errorOrNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// delegateNetName returns the name of the network of delegate
+func delegateNetName(delegate *types.DelegateNetConf) string {
+	if delegate.Conf.Name != "" {
+		return delegate.Conf.Name
+	}
+	return delegate.ConfList.Name
+}
+
+// logFields returns the pod identity of k8sArgs, which the json log format reports as fields
+func logFields(k8sArgs *types.K8sArgs) logging.Fields {
+	if k8sArgs == nil {
+		return logging.Fields{}
+	}
+	fields := logging.Fields{
+		"podNamespace": string(k8sArgs.K8S_POD_NAMESPACE),
+		"podName":      string(k8sArgs.K8S_POD_NAME),
+		"podUID":       string(k8sArgs.K8S_POD_UID),
+	}
+	// the sandbox is the container of the CNI requests of kubelet
+	if k8sArgs.K8S_POD_INFRA_CONTAINER_ID != "" {
+		fields["containerID"] = string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID)
+	}
+	return fields
 }
 
 func cmdErr(k8sArgs *types.K8sArgs, format string, args ...interface{}) error {
@@ -486,7 +1972,7 @@ func cmdErr(k8sArgs *types.K8sArgs, format string, args ...interface{}) error {
 	if k8sArgs != nil {
 		prefix += fmt.Sprintf("[%s/%s/%s]: ", k8sArgs.K8S_POD_NAMESPACE, k8sArgs.K8S_POD_NAME, k8sArgs.K8S_POD_UID)
 	}
-	return logging.Errorf(prefix+format, args...)
+	return logFields(k8sArgs).Errorf(prefix+format, args...)
 }
 
 func cmdPluginErr(k8sArgs *types.K8sArgs, confName string, format string, args ...interface{}) error {
@@ -494,24 +1980,25 @@ func cmdPluginErr(k8sArgs *types.K8sArgs, confName string, format string, args .
 	if k8sArgs != nil {
 		msg += fmt.Sprintf("[%s/%s/%s:%s]: ", k8sArgs.K8S_POD_NAMESPACE, k8sArgs.K8S_POD_NAME, k8sArgs.K8S_POD_UID, confName)
 	}
-	return logging.Errorf(msg+format, args...)
+	fields := logFields(k8sArgs)
+	fields["netName"] = confName
+	return fields.Errorf(msg+format, args...)
 }
 
 func isCriticalRequestRetriable(err error) bool {
 	logging.Debugf("isCriticalRequestRetriable: %v", err)
-	errorTypesAllowingRetry := []func(error) bool{
-		errors.IsServiceUnavailable, errors.IsInternalError, k8snet.IsConnectionReset, k8snet.IsConnectionRefused}
-	for _, f := range errorTypesAllowingRetry {
-		if f(err) {
-			return true
-		}
-	}
-	return false
+	return k8s.IsTransientAPIError(err)
 }
 
 // GetPod retrieves Kubernetes Pod object from given namespace/name in k8sArgs (i.e. cni args)
 // GetPod also get pod UID, but it is not used to retrieve, but it is used for double check
 func GetPod(kubeClient *k8s.ClientInfo, k8sArgs *types.K8sArgs, warnOnly bool) (*v1.Pod, error) {
+	return getPod(kubeClient, k8sArgs, warnOnly, nil, nil)
+}
+
+// getPod is GetPod which spends its retries out of the given retry budget, and
+// retries as configured by apiRetry instead of the default polling if set
+func getPod(kubeClient *k8s.ClientInfo, k8sArgs *types.K8sArgs, warnOnly bool, budget *types.RetryBudget, apiRetry *types.APIRetry) (*v1.Pod, error) {
 	if kubeClient == nil {
 		return nil, nil
 	}
@@ -520,19 +2007,39 @@ func GetPod(kubeClient *k8s.ClientInfo, k8sArgs *types.K8sArgs, warnOnly bool) (
 	podName := string(k8sArgs.K8S_POD_NAME)
 	podUID := string(k8sArgs.K8S_POD_UID)
 
-	pod, err := kubeClient.GetPod(podNamespace, podName)
+	var pod *v1.Pod
+	err := k8s.RetryAPICall(apiRetry, func() error {
+		var getErr error
+		pod, getErr = kubeClient.GetPodFromCache(podNamespace, podName)
+		return getErr
+	})
+	if apiRetry != nil {
+		if err != nil && isCriticalRequestRetriable(err) {
+			return nil, &recoverableError{category: types.CmdAddRetryOnAPIServer, err: cmdErr(k8sArgs, "error getting pod after %d retries: %w", apiRetry.MaxRetries, err)}
+		}
+	}
 	if err != nil {
 		// in case of a retriable error, retry 10 times with 0.25 sec interval
 		if isCriticalRequestRetriable(err) {
-			waitErr := wait.PollImmediate(shortPollDuration, shortPollTimeout, func() (bool, error) {
+			timeout := budget.Timeout(shortPollTimeout)
+			if timeout <= 0 {
+				return nil, &recoverableError{category: types.CmdAddRetryOnAPIServer, err: cmdErr(k8sArgs, "error getting pod, retry budget exhausted: %v", err)}
+			}
+			start := time.Now()
+			waitErr := wait.PollImmediate(shortPollDuration, timeout, func() (bool, error) {
 				pod, err = kubeClient.GetPod(podNamespace, podName)
+				if err != nil && isCriticalRequestRetriable(err) {
+					// keep polling until the timeout
+					return false, nil
+				}
 				return pod != nil, err
 			})
+			budget.Consume(time.Since(start))
 			// retry failed, then return error with retry out
 			if waitErr != nil {
-				return nil, cmdErr(k8sArgs, "error waiting for pod: %v", err)
+				return nil, &recoverableError{category: types.CmdAddRetryOnAPIServer, err: cmdErr(k8sArgs, "error waiting for pod: %v", err)}
 			}
-		} else if warnOnly && errors.IsNotFound(err) {
+		} else if warnOnly && k8serrors.IsNotFound(err) {
 			// If not found, proceed to remove interface with cache
 			return nil, nil
 		} else {
@@ -541,23 +2048,220 @@ func GetPod(kubeClient *k8s.ClientInfo, k8sArgs *types.K8sArgs, warnOnly bool) (
 		}
 	}
 
-	// In case of static pod, UID through kube api is different because of mirror pod, hence it is expected.
-	if podUID != "" && string(pod.UID) != podUID && !k8s.IsStaticPod(pod) {
-		msg := fmt.Sprintf("expected pod UID %q but got %q from Kube API", podUID, pod.UID)
-		if warnOnly {
-			// On CNI DEL we just operate on the cache when these mismatch, we don't error out.
-			// For example: stateful sets namespace/name can remain the same while podUID changes.
-			logging.Verbosef("warning: %s", msg)
-			return nil, nil
+	// a cached pod may be outdated, e.g. by a stateful set pod recreated with the same name
+	if podUID != "" && string(pod.UID) != podUID && !k8s.IsStaticPod(pod) {
+		if livePod, err := kubeClient.GetPod(podNamespace, podName); err == nil {
+			pod = livePod
+		}
+	}
+
+	// In case of static pod, UID through kube api is different because of mirror pod, hence it is expected.
+	if podUID != "" && string(pod.UID) != podUID && !k8s.IsStaticPod(pod) {
+		msg := fmt.Sprintf("pod UID mismatch: expected pod UID %q but got %q from Kube API, the pod may have been recreated with the same name", podUID, pod.UID)
+		if warnOnly {
+			// On CNI DEL we just operate on the cache when these mismatch, we don't error out.
+			// For example: stateful sets namespace/name can remain the same while podUID changes.
+			logging.Verbosef("warning: %s", msg)
+			return nil, nil
+		}
+		return nil, cmdErr(k8sArgs, msg)
+	}
+
+	return pod, nil
+}
+
+// statReadinessIndicatorFile checks the readinessindicatorfile, replaced by the tests to
+// count the checks
+var statReadinessIndicatorFile = os.Stat
+
+// waitForReadinessIndicatorFile waits for the readinessindicatorfile of n, if any, to exist,
+// checking it every readinessPollIntervalMillis for readinessIndicatorWaitSeconds. The
+// interval is doubled after each check up to readinessPollMaxIntervalMillis, if set.
+func waitForReadinessIndicatorFile(n *types.NetConf) error {
+	if n.ReadinessIndicatorFile == "" {
+		return nil
+	}
+	interval := pollDuration
+	if n.ReadinessPollIntervalMillis > 0 {
+		interval = time.Duration(n.ReadinessPollIntervalMillis) * time.Millisecond
+	}
+	maxInterval := time.Duration(n.ReadinessPollMaxIntervalMillis) * time.Millisecond
+	if maxInterval < interval {
+		maxInterval = interval
+	}
+	timeout := pollTimeout
+	if n.ReadinessIndicatorWaitSeconds > 0 {
+		timeout = time.Duration(n.ReadinessIndicatorWaitSeconds) * time.Second
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		if _, err := statReadinessIndicatorFile(n.ReadinessIndicatorFile); err == nil {
+			return nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		// check one last time at the deadline
+		if interval > remaining {
+			interval = remaining
+		}
+		time.Sleep(interval)
+		if interval *= 2; interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+	return fmt.Errorf("default network file %s not found after %ds, check that the default network is ready", n.ReadinessIndicatorFile, int(timeout.Seconds()))
+}
+
+// recoverableError is an ADD error of a category cmdAddRetryOn can select for retries
+type recoverableError struct {
+	category string
+	err      error
+}
+
+func (e *recoverableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *recoverableError) Unwrap() error {
+	return e.err
+}
+
+// CmdAdd ...
+func CmdAdd(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) (_ cnitypes.Result, err error) {
+	defer func() { recordCommand("ADD", err) }()
+	n, err := types.LoadNetConf(args.StdinData)
+	if err != nil || n.CmdAddRetries == 0 {
+		return cmdAdd(args, exec, kubeClient)
+	}
+
+	for attempt := 1; ; attempt++ {
+		result, err := cmdAdd(args, exec, kubeClient)
+		var recoverable *recoverableError
+		if err == nil || !errors.As(err, &recoverable) || attempt > n.CmdAddRetries || !containsString(n.CmdAddRetryOn, recoverable.category) {
+			return result, err
+		}
+		logging.Verbosef("warning: retrying ADD after attempt %d failed with a recoverable %s error: %v", attempt, recoverable.category, err)
+		// tear down whatever the failed attempt left, so that the next one starts afresh
+		if err := cmdDel(args, exec, kubeClient); err != nil {
+			logging.Verbosef("warning: failed to clean up before retrying ADD: %v", err)
+		}
+	}
+}
+
+// skipDefaultNetwork switches n to noDefaultNetwork, dropping its default networks, if the
+// pod opts out of the default network and allowNoDefaultNetwork lets it. It returns whether
+// the pod skips the default network.
+func skipDefaultNetwork(n *types.NetConf, pod *v1.Pod) bool {
+	if !k8s.SkipsDefaultNetwork(pod) {
+		return false
+	}
+	if !n.AllowNoDefaultNetwork {
+		logging.Verbosef("warning: pod %s/%s opts out of the default network, which allowNoDefaultNetwork does not allow", pod.Namespace, pod.Name)
+		return false
+	}
+	n.NoDefaultNetwork = true
+	n.ClusterNetwork = ""
+	n.DefaultNetworks = nil
+	n.Delegates = nil
+	return true
+}
+
+// resolveDelegates sets the delegates of n to the networks of pod, default networks first,
+// and checks them as configured. It returns the client to write the network status with,
+// nil if the status is not to be written.
+func resolveDelegates(args *skel.CmdArgs, k8sArgs *types.K8sArgs, n *types.NetConf, pod *v1.Pod, kubeClient *k8s.ClientInfo) (*k8s.ClientInfo, error) {
+	var err error
+
+	podSkipsDefaultNetwork := skipDefaultNetwork(n, pod)
+
+	// resourceMap holds Pod device allocation information; only initizized if CRD contains 'resourceName' annotation.
+	// This will only be initialized once and all delegate objects can reference this to look up device info.
+	var resourceMap map[string]*types.ResourceInfo
+
+	if n.ClusterNetwork != "" || len(n.DefaultNetworks) > 0 {
+		resourceMap, err = k8s.GetDefaultNetworks(pod, n, kubeClient, resourceMap)
+		if err != nil {
+			return nil, cmdErr(k8sArgs, "failed to get clusterNetwork/defaultNetworks: %v", err)
+		}
+	}
+	if !n.NoDefaultNetwork {
+		if err := setMasterPlugin(n); err != nil {
+			return nil, cmdErr(k8sArgs, "%v", err)
+		}
+	}
+
+	if len(n.PriorityClassNetworks) > 0 && pod != nil {
+		resourceMap, err = k8s.GetPriorityClassNetworks(pod, n, kubeClient, resourceMap)
+		if err != nil {
+			return nil, cmdErr(k8sArgs, "%v", err)
+		}
+	}
+
+	_, kc, err := k8s.TryLoadPodDelegates(pod, n, kubeClient, resourceMap)
+	if err != nil {
+		return nil, cmdErr(k8sArgs, "error loading k8s delegates k8s args: %v", err)
+	}
+
+	if n.NoDefaultNetwork {
+		if len(n.Delegates) == 0 {
+			if podSkipsDefaultNetwork {
+				return nil, cmdErr(k8sArgs, "no network is selected for the pod, which skips the default network with the k8s.v1.cni.cncf.io/default-network: none annotation")
+			}
+			return nil, cmdErr(k8sArgs, "no network is selected for the pod (noDefaultNetwork is set)")
+		}
+		// First selected network owns the result
+		n.Delegates[0].MasterPlugin = true
+	}
+
+	if n.NormalizeInterfaceNames {
+		if err := normalizeInterfaceNames(n.Delegates); err != nil {
+			return nil, cmdErr(k8sArgs, "%v", err)
+		}
+	}
+
+	if err := checkReservedInterfaceNames(n, args.IfName); err != nil {
+		return nil, cmdErr(k8sArgs, "%v", err)
+	}
+	if err := checkInterfaceNameCollisions(n, args.IfName); err != nil {
+		return nil, cmdErr(k8sArgs, "%v", err)
+	}
+
+	if n.StaticIPPool != nil {
+		if err := setStaticIPs(n, pod, kubeClient); err != nil {
+			return nil, cmdErr(k8sArgs, "%v", err)
+		}
+	}
+
+	if n.MaxBandwidthBps > 0 {
+		if err := checkBandwidthLimits(n); err != nil {
+			return nil, cmdErr(k8sArgs, "%v", err)
+		}
+	}
+
+	if n.RejectHostPortCollisions {
+		if err := checkHostPortCollisions(n); err != nil {
+			return nil, cmdErr(k8sArgs, "%v", err)
+		}
+	}
+
+	// delegate results without cniVersion are interpreted at the multus CNIVersion,
+	// and delegates get the default capabilities
+	for _, delegate := range n.Delegates {
+		if err := types.SetDelegateCNIVersion(delegate, n.CNIVersion); err != nil {
+			return nil, cmdErr(k8sArgs, "%v", err)
+		}
+		if err := types.SetDelegateDefaultCapabilities(delegate, n.DefaultCapabilities); err != nil {
+			return nil, cmdErr(k8sArgs, "%v", err)
 		}
-		return nil, cmdErr(k8sArgs, msg)
 	}
 
-	return pod, nil
+	return kc, nil
 }
 
-// CmdAdd ...
-func CmdAdd(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) (cnitypes.Result, error) {
+func cmdAdd(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) (cnitypes.Result, error) {
 	n, err := types.LoadNetConf(args.StdinData)
 	logging.Debugf("CmdAdd: %v, %v, %v", args, exec, kubeClient)
 	if err != nil {
@@ -574,91 +2278,242 @@ func CmdAdd(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) (c
 		return nil, cmdErr(nil, "error getting k8s args: %v", err)
 	}
 
-	if n.ReadinessIndicatorFile != "" {
-		err := wait.PollImmediate(pollDuration, pollTimeout, func() (bool, error) {
-			_, err := os.Stat(n.ReadinessIndicatorFile)
-			return err == nil, nil
-		})
-		if err != nil {
-			return nil, cmdErr(k8sArgs, "have you checked that your default network is ready? still waiting for readinessindicatorfile @ %v. pollimmediate error: %v", n.ReadinessIndicatorFile, err)
+	if n.CheckBinDirs {
+		if err := checkBinDirs(n); err != nil {
+			return nil, cmdErr(k8sArgs, "%v", err)
 		}
 	}
 
-	pod, err := GetPod(kubeClient, k8sArgs, false)
+	// nothing is set up for the container yet, so there is nothing to clean up on timeout
+	if err := waitForReadinessIndicatorFile(n); err != nil {
+		return nil, cmdErr(k8sArgs, "%v", err)
+	}
+
+	pod, err := getPod(kubeClient, k8sArgs, false, n.RetryBudget, n.APIRetry)
 	if err != nil {
 		return nil, err
 	}
 
-	// resourceMap holds Pod device allocation information; only initizized if CRD contains 'resourceName' annotation.
-	// This will only be initialized once and all delegate objects can reference this to look up device info.
-	var resourceMap map[string]*types.ResourceInfo
+	if n.VerifyPodNode && pod != nil {
+		if err := verifyPodNode(pod); err != nil {
+			return nil, cmdErr(k8sArgs, "%v", err)
+		}
+	}
 
-	if n.ClusterNetwork != "" {
-		resourceMap, err = k8s.GetDefaultNetworks(pod, n, kubeClient, resourceMap)
-		if err != nil {
-			return nil, cmdErr(k8sArgs, "failed to get clusterNetwork/defaultNetworks: %v", err)
+	kc, err := resolveDelegates(args, k8sArgs, n, pod, kubeClient)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.CheckExistingInterface {
+		if err := checkExistingInterfaces(args.Netns, n, args.IfName); err != nil {
+			return nil, cmdErr(k8sArgs, "%v", err)
 		}
-		// First delegate is always the master plugin
-		n.Delegates[0].MasterPlugin = true
 	}
 
-	_, kc, err := k8s.TryLoadPodDelegates(pod, n, kubeClient, resourceMap)
+	if n.AttachmentIDs {
+		setAttachmentIDs(args.ContainerID, args.IfName, n.SecondaryInterfacePrefix, n.Delegates)
+	}
+
+	// negotiated before any delegate runs, so that delegates without a common version fail
+	// the ADD with nothing to tear down
+	resultVersion, err := negotiateResultVersion(exec, n)
 	if err != nil {
-		return nil, cmdErr(k8sArgs, "error loading k8s delegates k8s args: %v", err)
+		return nil, cmdErr(k8sArgs, "error negotiating the result version: %v", err)
 	}
 
+	// logged before any delegate runs, to keep a record of the networks if one fails
+	fields := logFields(k8sArgs)
+	fields["containerID"] = args.ContainerID
+	fields.Infof("Multus: [%s/%s/%s]: resolved networks: %s", k8sArgs.K8S_POD_NAMESPACE, k8sArgs.K8S_POD_NAME, k8sArgs.K8S_POD_UID, resolvedNetworksSummary(n.Delegates))
+
 	// cache the multus config
 	if err := saveDelegates(args.ContainerID, n.CNIDir, n.Delegates); err != nil {
 		return nil, cmdErr(k8sArgs, "error saving the delegates: %v", err)
 	}
 
+	if pod != nil && pod.Annotations[debugDelegatesAnnot] == "true" {
+		kubeClient.Eventf(pod, v1.EventTypeNormal, "DelegatesResolved", "%s", delegatesSummary(n.Delegates, args.IfName, n.SecondaryInterfacePrefix))
+	}
+
 	var result, tmpResult cnitypes.Result
 	var netStatus []nettypes.NetworkStatus
-	for idx, delegate := range n.Delegates {
-		ifName := getIfname(delegate, args.IfName, idx)
-		rt, cniDeviceInfoPath := types.CreateCNIRuntimeConf(args, k8sArgs, ifName, n.RuntimeConfig, delegate)
-		if cniDeviceInfoPath != "" && delegate.ResourceName != "" && delegate.DeviceID != "" {
-			err = nadutils.CopyDeviceInfoForCNIFromDP(cniDeviceInfoPath, delegate.ResourceName, delegate.DeviceID)
-			// Even if the filename is set, file may not be present. Ignore error,
-			// but log and in the future may need to filter on specific errors.
-			if err != nil {
-				logging.Debugf("CmdAdd: CopyDeviceInfoForCNIFromDP returned an error - err=%v", err)
+	// attachmentIDs are the attachment IDs of the netStatus entries
+	var attachmentIDs []string
+	// resultIPs maps the IP addresses returned so far to the network returning them
+	resultIPs := map[string]string{}
+	// defaultDNS is the DNS of the default network result, which takes precedence
+	// over mergedDNS, collecting the DNS settings of the other delegate results
+	var defaultDNS, mergedDNS cnitypes.DNS
+	// defaultGateways are the gateways of the default routes set from default-route
+	var defaultGateways []net.IP
+	var delegateResults []delegateResult
+	// resultNetName is the network of result
+	var resultNetName string
+	// promoted is the delegate of the resultNetwork, returning the result if set, on the
+	// interface resultIfName
+	promoted := resultDelegate(n, string(k8sArgs.K8S_POD_NAMESPACE))
+	resultIfName := args.IfName
+	order := delegateOrder(n)
+	var added []*delegateAddResult
+	var defaultResult cnitypes.Result
+	// started is the number of delegates invoked ahead of the loop below, all of which
+	// must be torn down on failure
+	started := 0
+	if n.MaxConcurrentDelegates > 1 {
+		added, started = addDelegatesConcurrently(exec, kubeClient, pod, args, k8sArgs, n, order)
+	}
+	// addFailed tears down the delegates at teardown and returns err, the error of the
+	// delegate at pos, along with the errors of the delegates added concurrently which
+	// failed too and the errors of the teardown
+	addFailed := func(pos int, teardown []int, err error) error {
+		delErr := delPluginsInOrder(exec, nil, nil, args, k8sArgs, n.Delegates, teardown, n.RuntimeConfig, n)
+		errs := delegateErrors{{idx: order[pos], netName: delegateNetName(n.Delegates[order[pos]]), err: err}}
+		for other := pos + 1; other < len(added); other++ {
+			if added[other] == nil {
+				continue
+			}
+			idx := order[other]
+			netName := delegateNetName(n.Delegates[idx])
+			if added[other].pluginErr != nil && !n.Delegates[idx].Optional {
+				errs = append(errs, &delegateError{idx: idx, netName: netName, err: cmdPluginErr(k8sArgs, netName, "error adding container to network %q: %v", netName, added[other].pluginErr)})
+			} else if added[other].err != nil {
+				errs = append(errs, &delegateError{idx: idx, netName: netName, err: cmdPluginErr(k8sArgs, netName, "error adding container to network %q: %v", netName, added[other].err)})
 			}
 		}
+		if teardownErrs, ok := delErr.(delegateErrors); ok {
+			failed := map[int]bool{}
+			for _, de := range errs {
+				failed[de.idx] = true
+			}
+			for _, de := range teardownErrs {
+				// the teardown of a delegate whose ADD failed is expected to fail too
+				if failed[de.idx] {
+					continue
+				}
+				errs = append(errs, &delegateError{idx: de.idx, netName: de.netName, err: fmt.Errorf("error tearing down the network %q: %v", de.netName, de.err)})
+			}
+		}
+		return errs.errorOrNil()
+	}
+	for pos, idx := range order {
+		delegate := n.Delegates[idx]
+		ifName := getIfname(delegate, args.IfName, n.SecondaryInterfacePrefix, idx)
+		// the delegates to tear down if this one fails
+		teardown := order[:pos+1]
+		if started > pos+1 {
+			teardown = order[:started]
+		}
 
 		// We collect the delegate netName for the cachefile name as well as following errors
-		netName := delegate.Conf.Name
-		if netName == "" {
-			netName = delegate.ConfList.Name
+		netName := delegateNetName(delegate)
+		var addResult *delegateAddResult
+		if pos < len(added) && added[pos] != nil {
+			addResult = added[pos]
+		} else {
+			addResult = addDelegate(exec, kubeClient, pod, args, k8sArgs, n, order, pos, defaultResult)
+		}
+		rt := addResult.rt
+		if addResult.pluginErr != nil {
+			if delegate.Optional && !delegate.MasterPlugin {
+				fields.Verbosef("warning: skipping optional network %q: %v", netName, addResult.pluginErr)
+				continue
+			}
+			if started <= pos+1 {
+				// the delegate itself was not invoked
+				teardown = order[:pos]
+			}
+			return nil, addFailed(pos, teardown, cmdPluginErr(k8sArgs, netName, "error adding container to network %q: %v", netName, addResult.pluginErr))
+		}
+		tmpResult, err = addResult.result, addResult.err
+		if _, ok := err.(*delegateTimeoutError); ok {
+			return nil, &recoverableError{category: types.CmdAddRetryOnTimeout, err: addFailed(pos, teardown, cmdPluginErr(k8sArgs, netName, "delegate %q (index %d) %v", netName, idx, err))}
 		}
-		tmpResult, err = DelegateAdd(exec, kubeClient, pod, delegate, rt, n)
 		if err != nil {
 			// If the add failed, tear down all networks we already added
-			// Ignore errors; DEL must be idempotent anyway
-			_ = delPlugins(exec, nil, args, k8sArgs, n.Delegates, idx, n.RuntimeConfig, n)
-			return nil, cmdPluginErr(k8sArgs, netName, "error adding container to network %q: %v", netName, err)
+			return nil, &recoverableError{category: types.CmdAddRetryOnDelegate, err: addFailed(pos, teardown, cmdPluginErr(k8sArgs, netName, "error adding container to network %q: %v", netName, err))}
+		}
+
+		if n.FillInterfaceSandbox {
+			if tmpResult, err = fillInterfaceSandbox(tmpResult, ifName, args.Netns); err != nil {
+				return nil, addFailed(pos, teardown, cmdPluginErr(k8sArgs, netName, "failed to fill in interface sandbox: %v", err))
+			}
+		}
+
+		if n.InvalidInterfaceIndexAction != types.InvalidInterfaceIndexIgnore {
+			if tmpResult, err = checkResultInterfaceIndexes(tmpResult, n.InvalidInterfaceIndexAction); err != nil {
+				return nil, addFailed(pos, teardown, cmdPluginErr(k8sArgs, netName, "invalid result of network %q: %v", netName, err))
+			}
 		}
 
-		// Master plugin result is always used if present
-		if delegate.MasterPlugin || result == nil {
+		if n.ValidateResultPrefixes {
+			if err := checkResultPrefixes(tmpResult, n.AllowZeroResultPrefix); err != nil {
+				return nil, addFailed(pos, teardown, cmdPluginErr(k8sArgs, netName, "invalid result of network %q: %v", netName, err))
+			}
+		}
+
+		// Master plugin result, or the resultNetwork one, is always used if present
+		if delegate == promoted || (promoted == nil && delegate.MasterPlugin) || result == nil {
 			result = tmpResult
+			resultNetName = netName
+		}
+		if delegate == promoted {
+			resultIfName = ifName
+		}
+		if delegate.MasterPlugin {
+			defaultResult = tmpResult
 		}
 
 		res, err := cni100.NewResultFromResult(tmpResult)
 		if err != nil {
-			logging.Errorf("CmdAdd: failed to read result: %v, but proceed", err)
+			fields.Errorf("CmdAdd: failed to read result: %v, but proceed", err)
+		}
+
+		if n.VerifyRequestedMAC && delegate.MacRequest != "" && res != nil {
+			if err := checkRequestedMAC(res, ifName, delegate.MacRequest); err != nil {
+				return nil, addFailed(pos, teardown, cmdPluginErr(k8sArgs, netName, "network %q: %v", netName, err))
+			}
+		}
+
+		if n.VerifyRequestedIPCount && len(delegate.IPRequest) > 0 && res != nil {
+			if err := checkRequestedIPCount(res, delegate.IPRequest); err != nil {
+				if n.RequestedIPCountFatal {
+					return nil, addFailed(pos, teardown, cmdPluginErr(k8sArgs, netName, "network %q: %v", netName, err))
+				}
+				fields.Verbosef("warning: network %q: %v", netName, err)
+			}
+		}
+
+		if n.MergeDNS && res != nil {
+			if delegate.MasterPlugin {
+				defaultDNS = res.DNS
+			} else {
+				mergeDNS(&mergedDNS, res.DNS)
+			}
+		}
+		if res != nil {
+			delegateResults = append(delegateResults, delegateResult{netName: netName, ifName: ifName, result: res})
+		}
+
+		if n.DetectDuplicateResultIPs && res != nil {
+			if err := checkDuplicateResultIPs(resultIPs, res, delegate.Name); err != nil {
+				if n.DuplicateResultIPsFatal {
+					return nil, addFailed(pos, teardown, cmdPluginErr(k8sArgs, netName, "%v", err))
+				}
+				fields.Verbosef("warning: %v", err)
+			}
 		}
 
 		// check Interfaces and IPs because some CNI plugin does not create any interface
 		// and just returns empty result
-		if res != nil &&  (res.Interfaces != nil || res.IPs != nil) {
+		if res != nil && (res.Interfaces != nil || res.IPs != nil) {
 			// Remove gateway from routing table if the gateway is not used
 			deleteV4gateway := false
 			deleteV6gateway := false
 			adddefaultgateway := false
 			if delegate.IsFilterV4Gateway {
 				deleteV4gateway = true
-				logging.Debugf("Marked interface %v for v4 gateway deletion", ifName)
+				fields.Debugf("Marked interface %v for v4 gateway deletion", ifName)
 			} else {
 				// Otherwise, determine if this interface now gets our default route.
 				// According to
@@ -668,13 +2523,13 @@ func CmdAdd(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) (c
 				if delegate.GatewayRequest != nil && len(*delegate.GatewayRequest) != 0 {
 					deleteV4gateway = true
 					adddefaultgateway = true
-					logging.Debugf("Detected gateway override on interface %v to %v", ifName, delegate.GatewayRequest)
+					fields.Debugf("Detected gateway override on interface %v to %v", ifName, delegate.GatewayRequest)
 				}
 			}
 
 			if delegate.IsFilterV6Gateway {
 				deleteV6gateway = true
-				logging.Debugf("Marked interface %v for v6 gateway deletion", ifName)
+				fields.Debugf("Marked interface %v for v6 gateway deletion", ifName)
 			} else {
 				// Otherwise, determine if this interface now gets our default route.
 				// According to
@@ -684,7 +2539,7 @@ func CmdAdd(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) (c
 				if delegate.GatewayRequest != nil && len(*delegate.GatewayRequest) != 0 {
 					deleteV6gateway = true
 					adddefaultgateway = true
-					logging.Debugf("Detected gateway override on interface %v to %v", ifName, delegate.GatewayRequest)
+					fields.Debugf("Detected gateway override on interface %v to %v", ifName, delegate.GatewayRequest)
 				}
 			}
 
@@ -701,15 +2556,21 @@ func CmdAdd(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) (c
 			}
 
 			// Here we'll set the default gateway which specified in `default-route` network selection
+			var gateways []net.IP
 			if adddefaultgateway {
-				err = netutils.SetDefaultGW(args.Netns, ifName, *delegate.GatewayRequest)
+				// only set the gateways of the families multus handles
+				gateways = filterDefaultRouteFamilies(*delegate.GatewayRequest, n.DefaultRouteFamilies)
+			}
+			if len(gateways) > 0 {
+				err = netutils.SetDefaultGW(args.Netns, ifName, gateways)
 				if err != nil {
 					return nil, cmdErr(k8sArgs, "error setting default gateway: %v", err)
 				}
-				err = netutils.AddDefaultGWCache(n.CNIDir, rt, netName, ifName, *delegate.GatewayRequest)
+				err = netutils.AddDefaultGWCache(n.CNIDir, rt, netName, ifName, gateways)
 				if err != nil {
 					return nil, cmdErr(k8sArgs, "error setting default gateway in cache: %v", err)
 				}
+				defaultGateways = append(defaultGateways, gateways...)
 			}
 		}
 
@@ -719,7 +2580,7 @@ func CmdAdd(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) (c
 		if err != nil {
 			// Even if the filename is set, file may not be present. Ignore error,
 			// but log and in the future may need to filter on specific errors.
-			logging.Debugf("CmdAdd: getDelegateDeviceInfo returned an error - err=%v", err)
+			fields.Debugf("CmdAdd: getDelegateDeviceInfo returned an error - err=%v", err)
 		}
 
 		// create the network status, only in case Multus as kubeconfig
@@ -730,23 +2591,100 @@ func CmdAdd(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) (c
 					return nil, cmdErr(k8sArgs, "error setting network status: %v", err)
 				}
 
-				netStatus = append(netStatus, *delegateNetStatus)
+				if delegate.MasterPlugin {
+					// the default network is listed first whatever the order of addition
+					netStatus = append([]nettypes.NetworkStatus{*delegateNetStatus}, netStatus...)
+					attachmentIDs = append([]string{delegate.AttachmentID}, attachmentIDs...)
+				} else {
+					netStatus = append(netStatus, *delegateNetStatus)
+					attachmentIDs = append(attachmentIDs, delegate.AttachmentID)
+				}
+
+				if n.StatusWriteMode == types.StatusWriteModeIncremental {
+					if err := setNetworkStatus(kubeClient, k8sArgs, netStatus, attachmentIDs, n); err != nil {
+						return nil, err
+					}
+				}
 			}
 		} else if devinfo != nil {
 			// Warn that devinfo exists but could not add it to downwards API
-			logging.Errorf("devinfo available, but no kubeConfig so NetworkStatus not modified.")
+			fields.Errorf("devinfo available, but no kubeConfig so NetworkStatus not modified.")
+		}
+	}
+
+	if n.RejectSelfReferentialRoutes {
+		if err := checkSelfReferentialRoutes(delegateResults); err != nil {
+			_ = delPluginsInOrder(exec, nil, nil, args, k8sArgs, n.Delegates, order, n.RuntimeConfig, n)
+			return nil, cmdErr(k8sArgs, "%v", err)
+		}
+	}
+
+	if n.MergeDualStackResults && result != nil {
+		result, err = mergeDualStackResult(result, resultNetName, delegateResults, resultIfName)
+		if err != nil {
+			_ = delPluginsInOrder(exec, nil, nil, args, k8sArgs, n.Delegates, order, n.RuntimeConfig, n)
+			return nil, cmdErr(k8sArgs, "error merging the dual-stack results: %v", err)
+		}
+	}
+
+	if n.MergeDNS && result != nil {
+		// the default network comes first whatever the order of addition
+		var dns cnitypes.DNS
+		mergeDNS(&dns, defaultDNS)
+		mergeDNS(&dns, mergedDNS)
+		if len(dns.Nameservers) > maxDNSNameservers {
+			fields.Verbosef("warning: merged DNS has %d nameservers, keeping only the first %d: %v", len(dns.Nameservers), maxDNSNameservers, dns.Nameservers)
+			dns.Nameservers = dns.Nameservers[:maxDNSNameservers]
+		}
+		result, err = setResultDNS(result, dns)
+		if err != nil {
+			return nil, cmdErr(k8sArgs, "error merging DNS into the result: %v", err)
 		}
 	}
 
 	// set the network status annotation in apiserver, only in case Multus as kubeconfig
-	if kubeClient != nil && kc != nil {
+	if kubeClient != nil && kc != nil && n.StatusWriteMode != types.StatusWriteModeIncremental {
 		if !types.CheckSystemNamespaces(string(k8sArgs.K8S_POD_NAME), n.SystemNamespaces) {
-			err = k8s.SetNetworkStatus(kubeClient, k8sArgs, netStatus, n)
+			if err := setNetworkStatus(kubeClient, k8sArgs, netStatus, attachmentIDs, n); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if n.RecordMultusVersion && kubeClient != nil && pod != nil {
+		if err := k8s.SetPodAnnotation(kubeClient, pod.Namespace, pod.Name, multusVersionAnnot, version); err != nil {
+			fields.Verbosef("warning: failed to record the multus version: %v", err)
+		}
+	}
+
+	// the result reflects the default routes set from default-route
+	if len(defaultGateways) > 0 && result != nil {
+		result, err = overrideDefaultRoutesInResult(result, defaultGateways)
+		if err != nil {
+			return nil, cmdErr(k8sArgs, "error overriding default routes in the result: %v", err)
+		}
+	}
+
+	if len(n.DefaultRouteFamilies) > 0 && result != nil {
+		result, err = stripDefaultRoutesFromResult(result, n.DefaultRouteFamilies)
+		if err != nil {
+			return nil, cmdErr(k8sArgs, "error stripping default routes from the result: %v", err)
+		}
+	}
+
+	if n.StripLinkLocalFromResult && result != nil {
+		result, err = stripLinkLocalFromResult(result, n.StripIPv4LinkLocalFromResult)
+		if err != nil {
+			return nil, cmdErr(k8sArgs, "error stripping link-local addresses from the result: %v", err)
+		}
+	}
+
+	// upgrade an older master result (e.g. 0.4.0) to the negotiated version (e.g. 1.0.0)
+	if result != nil && resultVersion != "" && result.Version() != resultVersion {
+		if newer, verErr := cniversion.GreaterThanOrEqualTo(resultVersion, result.Version()); verErr == nil && newer {
+			result, err = result.GetAsVersion(resultVersion)
 			if err != nil {
-				if strings.Contains(err.Error(), "failed to query the pod") {
-					return nil, cmdErr(k8sArgs, "error setting the networks status, pod was already deleted: %v", err)
-				}
-				return nil, cmdErr(k8sArgs, "error setting the networks status: %v", err)
+				return nil, cmdErr(k8sArgs, "error converting the result to version %s: %v", resultVersion, err)
 			}
 		}
 	}
@@ -754,21 +2692,286 @@ func CmdAdd(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) (c
 	return result, nil
 }
 
+// negotiateResultVersion returns the version of the multus result: the multus cniVersion,
+// or without it the highest version supported by all the plugins of the delegates, up to
+// the highest cniVersion of the delegates
+func negotiateResultVersion(exec invoke.Exec, n *types.NetConf) (string, error) {
+	if n.CNIVersion != "" {
+		return n.CNIVersion, nil
+	}
+	highest := ""
+	for _, delegate := range n.Delegates {
+		cniVersion := delegate.Conf.CNIVersion
+		if delegate.ConfListPlugin {
+			cniVersion = delegate.ConfList.CNIVersion
+		}
+		if cniVersion == "" {
+			continue
+		}
+		if highest == "" {
+			highest = cniVersion
+			continue
+		}
+		if higher, err := cniversion.GreaterThanOrEqualTo(cniVersion, highest); err == nil && higher {
+			highest = cniVersion
+		}
+	}
+	if highest == "" {
+		return "", nil
+	}
+
+	cniNet := libcni.NewCNIConfigWithCacheDir(pluginBinDirs(n), n.CNIDir, exec)
+
+	var common []string
+	first := true
+	for _, delegate := range n.Delegates {
+		pluginTypes := []string{delegate.Conf.Type}
+		if delegate.ConfListPlugin {
+			pluginTypes = nil
+			for _, plugin := range delegate.ConfList.Plugins {
+				pluginTypes = append(pluginTypes, plugin.Type)
+			}
+		}
+		for _, pluginType := range pluginTypes {
+			info, err := cniNet.GetVersionInfo(context.TODO(), pluginType)
+			if err != nil {
+				// left to the ADD of the delegate to fail, or to skip an optional network
+				logging.Verbosef("warning: failed to get the versions supported by plugin %q: %v", pluginType, err)
+				continue
+			}
+			if first {
+				common, first = info.SupportedVersions(), false
+				continue
+			}
+			common = intersectVersions(common, info.SupportedVersions())
+		}
+	}
+	if first {
+		// no plugin reported its versions
+		return highest, nil
+	}
+
+	negotiated := ""
+	for _, cniVersion := range common {
+		if lower, err := cniversion.GreaterThanOrEqualTo(highest, cniVersion); err != nil || !lower {
+			continue
+		}
+		if negotiated == "" {
+			negotiated = cniVersion
+			continue
+		}
+		if higher, err := cniversion.GreaterThanOrEqualTo(cniVersion, negotiated); err == nil && higher {
+			negotiated = cniVersion
+		}
+	}
+	if negotiated == "" {
+		return "", fmt.Errorf("the plugins of the delegates support no common CNI version up to %s", highest)
+	}
+	return negotiated, nil
+}
+
+// intersectVersions returns the versions of a which b supports too
+func intersectVersions(a, b []string) []string {
+	var versions []string
+	for _, va := range a {
+		for _, vb := range b {
+			if va == vb {
+				versions = append(versions, va)
+				break
+			}
+		}
+	}
+	return versions
+}
+
+// checkBinDirs verifies that at least one of the CNI plugin directories, binDir,
+// binDirs and the CNI_PATH entries, exists and is readable
+func checkBinDirs(conf *types.NetConf) error {
+	binDirs := pluginBinDirs(conf)
+	for _, dir := range binDirs {
+		if dir == "" {
+			continue
+		}
+		f, err := os.Open(dir)
+		if err != nil {
+			logging.Debugf("checkBinDirs: cannot open %s: %v", dir, err)
+			continue
+		}
+		_, err = f.Readdirnames(1)
+		f.Close()
+		if err == nil || err == io.EOF {
+			return nil
+		}
+		logging.Debugf("checkBinDirs: cannot read %s: %v", dir, err)
+	}
+	return logging.Errorf("checkBinDirs: none of the CNI plugin directories %v exists and is readable", binDirs)
+}
+
+// CheckStatus verifies that multus can serve ADD requests with the given configuration:
+// a CNI plugin directory exists, the readiness indicator file exists, the kubernetes
+// client is valid and the clusterNetwork can be resolved
+func CheckStatus(conf *types.NetConf, kubeClient *k8s.ClientInfo) error {
+	if err := checkBinDirs(conf); err != nil {
+		return logging.Errorf("CheckStatus: %v", err)
+	}
+
+	if conf.ReadinessIndicatorFile != "" {
+		if _, err := os.Stat(conf.ReadinessIndicatorFile); err != nil {
+			return logging.Errorf("CheckStatus: readinessindicatorfile %s is not ready: %v", conf.ReadinessIndicatorFile, err)
+		}
+	}
+
+	kubeClient, err := k8s.GetK8sClient(conf.Kubeconfig, kubeClient)
+	if err != nil {
+		return logging.Errorf("CheckStatus: error getting k8s client: %v", err)
+	}
+
+	if conf.ClusterNetwork != "" {
+		if kubeClient == nil {
+			return logging.Errorf("CheckStatus: no k8s client to resolve clusterNetwork %s", conf.ClusterNetwork)
+		}
+		if err := k8s.CheckClusterNetwork(kubeClient, conf); err != nil {
+			return logging.Errorf("CheckStatus: %v", err)
+		}
+	}
+	return nil
+}
+
+// CmdStatus reports whether multus can service ADD requests: the status checks must pass,
+// the Kubernetes API server must be reachable and the default network must be ready
+func CmdStatus(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) error {
+	n, err := types.LoadNetConf(args.StdinData)
+	logging.Debugf("CmdStatus: %v, %v, %v", args, exec, kubeClient)
+	if err != nil {
+		return err
+	}
+
+	if err := CheckStatus(n, kubeClient); err != nil {
+		return cnitypes.NewError(errPluginNotAvailable, "multus is not ready", err.Error())
+	}
+
+	kubeClient, err = k8s.GetK8sClient(n.Kubeconfig, kubeClient)
+	if err != nil {
+		return cnitypes.NewError(errPluginNotAvailable, "multus is not ready", err.Error())
+	}
+	if kubeClient != nil {
+		if _, err := kubeClient.Client.Discovery().ServerVersion(); err != nil {
+			return cnitypes.NewError(errPluginNotAvailable, "cannot reach the Kubernetes API server", err.Error())
+		}
+	}
+
+	var delegate *types.DelegateNetConf
+	if n.ClusterNetwork != "" && kubeClient != nil {
+		if delegate, err = k8s.GetClusterNetworkDelegate(kubeClient, n); err != nil {
+			return cnitypes.NewError(errPluginNotAvailable, "multus is not ready", err.Error())
+		}
+	} else if len(n.Delegates) > 0 {
+		delegate = n.Delegates[0]
+	}
+	if delegate == nil {
+		return nil
+	}
+	return delegateStatus(exec, delegate, n)
+}
+
+// delegateStatus sends STATUS to the plugins of the delegate, if they support it
+func delegateStatus(exec invoke.Exec, delegate *types.DelegateNetConf, multusNetconf *types.NetConf) error {
+	cniVersion := delegate.Conf.CNIVersion
+	if delegate.ConfListPlugin {
+		cniVersion = delegate.ConfList.CNIVersion
+	}
+	// STATUS was added in CNI 1.1.0
+	if supported, err := cniversion.GreaterThanOrEqualTo(cniVersion, "1.1.0"); err != nil || !supported {
+		logging.Debugf("delegateStatus: %s does not support STATUS with cniVersion %q", delegate.Name, cniVersion)
+		return nil
+	}
+
+	binDirs := pluginBinDirs(multusNetconf)
+	pluginConfs := [][]byte{delegate.Bytes}
+	if delegate.ConfListPlugin {
+		confList, err := libcni.ConfListFromBytes(delegate.Bytes)
+		if err != nil {
+			return logging.Errorf("delegateStatus: error converting the raw bytes into a conflist: %v", err)
+		}
+		pluginConfs = nil
+		for _, plugin := range confList.Plugins {
+			// like libcni, inject the name and version of the list into each plugin config
+			var conf map[string]interface{}
+			if err := json.Unmarshal(plugin.Bytes, &conf); err != nil {
+				return logging.Errorf("delegateStatus: error unmarshalling the plugin config: %v", err)
+			}
+			conf["name"] = confList.Name
+			conf["cniVersion"] = confList.CNIVersion
+			pluginConf, err := json.Marshal(conf)
+			if err != nil {
+				return logging.Errorf("delegateStatus: error marshalling the plugin config: %v", err)
+			}
+			pluginConfs = append(pluginConfs, pluginConf)
+		}
+	}
+
+	for _, pluginConf := range pluginConfs {
+		conf, err := libcni.ConfFromBytes(pluginConf)
+		if err != nil {
+			return logging.Errorf("delegateStatus: error converting the raw bytes to conf: %v", err)
+		}
+		var pluginPath string
+		if exec != nil {
+			pluginPath, err = exec.FindInPath(conf.Network.Type, binDirs)
+		} else {
+			pluginPath, err = invoke.FindInPath(conf.Network.Type, binDirs)
+		}
+		if err != nil {
+			return cnitypes.NewError(errPluginNotAvailable, fmt.Sprintf("plugin %s of the default network is not available", conf.Network.Type), err.Error())
+		}
+		statusArgs := &invoke.Args{Command: "STATUS", Path: strings.Join(binDirs, string(os.PathListSeparator))}
+		if err := invoke.ExecPluginWithoutResult(context.Background(), pluginPath, pluginConf, statusArgs, exec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SyncReadinessOutputFile runs CheckStatus, then creates the readinessOutputFile
+// if the checks pass or removes it if they fail
+func SyncReadinessOutputFile(conf *types.NetConf, kubeClient *k8s.ClientInfo) error {
+	if conf.ReadinessOutputFile == "" {
+		return nil
+	}
+
+	statusErr := CheckStatus(conf, kubeClient)
+	if statusErr != nil {
+		if err := os.Remove(conf.ReadinessOutputFile); err != nil && !os.IsNotExist(err) {
+			return logging.Errorf("SyncReadinessOutputFile: failed to remove %s: %v", conf.ReadinessOutputFile, err)
+		}
+		return statusErr
+	}
+
+	if err := os.WriteFile(conf.ReadinessOutputFile, []byte("ready\n"), 0644); err != nil {
+		return logging.Errorf("SyncReadinessOutputFile: failed to write %s: %v", conf.ReadinessOutputFile, err)
+	}
+	return nil
+}
+
 // CmdCheck ...
-func CmdCheck(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) error {
+func CmdCheck(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) (err error) {
+	defer func() { recordCommand("CHECK", err) }()
 	in, err := types.LoadNetConf(args.StdinData)
 	logging.Debugf("CmdCheck: %v, %v, %v", args, exec, kubeClient)
 	if err != nil {
 		return err
 	}
-
 	k8sArgs, err := k8s.GetK8sArgs(args)
 	if err != nil {
 		return cmdErr(nil, "error getting k8s args: %v", err)
 	}
 
+	if in.AttachmentIDs {
+		setAttachmentIDs(args.ContainerID, args.IfName, in.SecondaryInterfacePrefix, in.Delegates)
+	}
+
 	for idx, delegate := range in.Delegates {
-		ifName := getIfname(delegate, args.IfName, idx)
+		ifName := getIfname(delegate, args.IfName, in.SecondaryInterfacePrefix, idx)
 
 		rt, _ := types.CreateCNIRuntimeConf(args, k8sArgs, ifName, in.RuntimeConfig, delegate)
 		err = DelegateCheck(exec, delegate, rt, in)
@@ -781,13 +2984,17 @@ func CmdCheck(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo)
 }
 
 // CmdDel ...
-func CmdDel(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) error {
+func CmdDel(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) (err error) {
+	defer func() { recordCommand("DEL", err) }()
+	return cmdDel(args, exec, kubeClient)
+}
+
+func cmdDel(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) error {
 	in, err := types.LoadNetConf(args.StdinData)
 	logging.Debugf("CmdDel: %v, %v, %v", args, exec, kubeClient)
 	if err != nil {
 		return err
 	}
-
 	skipStatusUpdate := false
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
@@ -811,15 +3018,11 @@ func CmdDel(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) er
 	if err != nil {
 		return cmdErr(nil, "error getting k8s args: %v", err)
 	}
+	fields := logFields(k8sArgs)
+	fields["containerID"] = args.ContainerID
 
-	if in.ReadinessIndicatorFile != "" {
-		err := wait.PollImmediate(pollDuration, pollTimeout, func() (bool, error) {
-			_, err := os.Stat(in.ReadinessIndicatorFile)
-			return err == nil, nil
-		})
-		if err != nil {
-			return cmdErr(k8sArgs, "PollImmediate error waiting for ReadinessIndicatorFile (on del): %v", err)
-		}
+	if err := waitForReadinessIndicatorFile(in); err != nil {
+		return cmdErr(k8sArgs, "%v (on del)", err)
 	}
 
 	kubeClient, err = k8s.GetK8sClient(in.Kubeconfig, kubeClient)
@@ -827,10 +3030,10 @@ func CmdDel(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) er
 		return cmdErr(nil, "error getting k8s client: %v", err)
 	}
 
-	pod, err := GetPod(kubeClient, k8sArgs, true)
+	pod, err := getPod(kubeClient, k8sArgs, true, nil, in.APIRetry)
 	if err != nil {
 		// GetPod may be failed but just do print error in its log and continue to delete
-		logging.Errorf("Multus: GetPod failed: %v, but continue to delete", err)
+		fields.Errorf("Multus: GetPod failed: %v, but continue to delete", err)
 		// skip status update because k8s api seems to be stucked
 		skipStatusUpdate = true
 	}
@@ -838,101 +3041,144 @@ func CmdDel(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) er
 	// Read the cache to get delegates json for the pod
 	netconfBytes, path, err := consumeScratchNetConf(args.ContainerID, in.CNIDir)
 	useCacheConf := false
+	// the cache, even one which cannot be parsed, is removed once the delegates are deleted
+	cacheFound := err == nil
+	// a cache which cannot be parsed, e.g. of an unknown version, is handled as a missing one
+	cacheMissing := os.IsNotExist(err)
 	if err == nil {
-		in.Delegates = []*types.DelegateNetConf{}
-		if err := json.Unmarshal(netconfBytes, &in.Delegates); err != nil {
-			logging.Errorf("Multus: failed to load netconf: %v", err)
+		var delegates []*types.DelegateNetConf
+		if delegates, err = loadDelegates(netconfBytes); err != nil {
+			fields.Errorf("Multus: failed to load the cached delegates file %s: %v, fetching the delegates again", path, err)
+			cacheMissing = true
 		} else {
+			in.Delegates = delegates
 			useCacheConf = true
-			// check plugins field and enable ConfListPlugin if there is
-			for _, v := range in.Delegates {
-				if len(v.ConfList.Plugins) != 0 {
-					v.ConfListPlugin = true
-				}
+			if pod != nil {
+				in.Delegates = reconcileDelegatesWithStatus(kubeClient, pod, in)
 			}
-			// First delegate is always the master plugin
-			in.Delegates[0].MasterPlugin = true
 		}
 	}
 
 	if !useCacheConf {
 		// Fetch delegates again if cache is not exist and pod info can be read
-		if os.IsNotExist(err) && pod != nil {
-			if in.ClusterNetwork != "" {
+		if cacheMissing && pod != nil {
+			skipDefaultNetwork(in, pod)
+			if in.ClusterNetwork != "" || len(in.DefaultNetworks) > 0 {
 				_, err = k8s.GetDefaultNetworks(pod, in, kubeClient, nil)
 				if err != nil {
 					return cmdErr(k8sArgs, "failed to get clusterNetwork/defaultNetworks: %v", err)
 				}
-				// First delegate is always the master plugin
-				in.Delegates[0].MasterPlugin = true
+			}
+			if !in.NoDefaultNetwork {
+				if err := setMasterPlugin(in); err != nil {
+					return cmdErr(k8sArgs, "%v", err)
+				}
+			}
+			if len(in.PriorityClassNetworks) > 0 {
+				if _, err := k8s.GetPriorityClassNetworks(pod, in, kubeClient, nil); err != nil {
+					fields.Errorf("Multus: %v, but continue to delete", err)
+				}
 			}
 
-			// Get pod annotation and so on
-			_, _, err := k8s.TryLoadPodDelegates(pod, in, kubeClient, nil)
-			if err != nil {
+			// DEL tears down the networks whatever the age of their network-attachment-definitions
+			in.MinNADAgeSeconds = 0
+
+			// The networks of a terminating pod are those of its network status,
+			// its annotation and network-attachment-definitions may have changed since
+			var statusDelegates []*types.DelegateNetConf
+			fromStatus := false
+			if pod.DeletionTimestamp != nil {
+				statusDelegates, fromStatus = networkStatusDelegates(kubeClient, pod, in)
+			}
+			if fromStatus {
+				fields.Debugf("CmdDel: pod is terminating, using the networks of its network status")
+				in.Delegates = append(in.Delegates, statusDelegates...)
+			} else if _, _, err := k8s.TryLoadPodDelegates(pod, in, kubeClient, nil); err != nil {
 				if len(in.Delegates) == 0 {
 					// No delegate available so send error
 					return cmdErr(k8sArgs, "failed to get delegates: %v", err)
 				}
 				// Get clusterNetwork before, so continue to delete
-				logging.Errorf("Multus: failed to get delegates: %v, but continue to delete clusterNetwork", err)
+				fields.Errorf("Multus: failed to get delegates: %v, but continue to delete clusterNetwork", err)
+			}
+			if in.NoDefaultNetwork && len(in.Delegates) > 0 {
+				// First selected network owns the result
+				in.Delegates[0].MasterPlugin = true
 			}
 		} else {
 			// The options to continue with a delete have been exhausted (cachefile + API query didn't work)
 			// We cannot exit with an error as this may cause a sandbox to never get deleted.
-			logging.Errorf("Multus: failed to get the cached delegates file: %v, cannot properly delete", err)
+			fields.Errorf("Multus: failed to get the cached delegates file: %v, cannot properly delete", err)
+			delWithoutDelegates(exec, netns, args, k8sArgs, in, kubeClient)
 			return nil
 		}
 	}
 
+	if in.NormalizeInterfaceNames {
+		if err := normalizeInterfaceNames(in.Delegates); err != nil {
+			// error happen but continue to delete
+			fields.Errorf("Multus: %v, but continue to delete", err)
+		}
+	}
+
+	if in.AttachmentIDs {
+		setAttachmentIDs(args.ContainerID, args.IfName, in.SecondaryInterfacePrefix, in.Delegates)
+	}
+
 	// set CNIVersion in delegate CNI config if there is no CNIVersion and multus conf have CNIVersion.
 	for _, v := range in.Delegates {
-		if v.ConfListPlugin && v.ConfList.CNIVersion == "" && in.CNIVersion != "" {
-			v.ConfList.CNIVersion = in.CNIVersion
-			v.Bytes, err = json.Marshal(v.ConfList)
-			if err != nil {
-				// error happen but continue to delete
-				logging.Errorf("Multus: failed to marshal delegate %q config: %v", v.Name, err)
-			}
-		}
+		// error happen but continue to delete
+		_ = types.SetDelegateCNIVersion(v, in.CNIVersion)
+		_ = types.SetDelegateDefaultCapabilities(v, in.DefaultCapabilities)
 	}
 
 	// unset the network status annotation in apiserver, only in case Multus as kubeconfig
+	// and the pod (of the same UID) still exists
 	if kubeClient != nil {
 		if !skipStatusUpdate {
-			if !types.CheckSystemNamespaces(string(k8sArgs.K8S_POD_NAMESPACE), in.SystemNamespaces) {
-				err := k8s.SetNetworkStatus(kubeClient, k8sArgs, nil, in)
+			if pod != nil && !types.CheckSystemNamespaces(string(k8sArgs.K8S_POD_NAMESPACE), in.SystemNamespaces) {
+				err := k8s.ClearPodNetworkStatusAnnotation(kubeClient, pod.Namespace, pod.Name)
 				if err != nil {
 					// error happen but continue to delete
-					logging.Errorf("Multus: error unsetting the networks status: %v", err)
+					fields.Errorf("Multus: error unsetting the networks status: %v", err)
 				}
 			}
 		} else {
-			logging.Debugf("WARNING: Unset SetNetworkStatus skipped")
+			fields.Debugf("WARNING: Unset SetNetworkStatus skipped")
 		}
 	}
 
-	e := delPlugins(exec, pod, args, k8sArgs, in.Delegates, len(in.Delegates)-1, in.RuntimeConfig, in)
+	// all the delegates are deleted, whatever the errors of the others
+	e := delPluginsInOrder(exec, kubeClient, pod, args, k8sArgs, in.Delegates, delegateOrder(in), in.RuntimeConfig, in)
 
-	// Enable Option only delegate plugin delete success to delete cache file
-	// CNI Runtime maybe return an error to block sandbox cleanup a while initiative,
-	// like starting, prepare something, it will be OK when retry later
-	// put "delete cache file" off later ensure have enough info delegate DEL message when Pod has been fully
-	// deleted from ETCD before sandbox cleanup success..
-	if in.RetryDeleteOnError {
-		if useCacheConf {
-			// Kubelet though this error as has been cleanup success and never retry, clean cache also
-			// Block sandbox cleanup error message can not contain "no such file or directory", CNI Runtime maybe should adaptor it !
-			if e == nil || strings.Contains(e.Error(), "no such file or directory") {
-				_ = os.Remove(path) // lgtm[go/path-injection]
-			}
-		}
-	} else {
-		if useCacheConf {
-			// remove used cache file
-			_ = os.Remove(path) // lgtm[go/path-injection]
+	if cacheFound {
+		if keepDelegatesCache(e, len(in.Delegates), in.RetryDeleteOnError) {
+			fields.Verbosef("warning: keeping the cached delegates file %s for the DEL to be retried: %v", path, e)
+		} else {
+			// errors are logged only, the delegates were deleted
+			_ = deleteDelegates(args.ContainerID, in.CNIDir)
 		}
 	}
 
 	return e
 }
+
+// keepDelegatesCache returns whether the delegates cache is kept for a retried DEL after
+// the DEL of the delegates failed with delErr: only with retryDeleteOnError, when every
+// delegate failed and none with "no such file or directory", which the kubelet takes as
+// a successful cleanup and does not retry.
+func keepDelegatesCache(delErr error, delegates int, retryDeleteOnError bool) bool {
+	if delErr == nil || !retryDeleteOnError {
+		return false
+	}
+	errs, ok := delErr.(delegateErrors)
+	if !ok || len(errs) < delegates {
+		return false
+	}
+	for _, de := range errs {
+		if strings.Contains(de.err.Error(), "no such file or directory") {
+			return false
+		}
+	}
+	return true
+}
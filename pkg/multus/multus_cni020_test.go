@@ -16,11 +16,15 @@ package multus
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 
 	"github.com/containernetworking/cni/pkg/skel"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
 	types020 "github.com/containernetworking/cni/pkg/types/020"
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/containernetworking/plugins/pkg/testutils"
@@ -42,6 +46,135 @@ var _ = Describe("multus operations", func() {
 		Expect(err).To(HaveOccurred())
 	})
 
+	It("saves NetConf atomically", func() {
+		dataDir, err := os.MkdirTemp("", "multus_scratch")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dataDir)
+
+		Expect(saveScratchNetConf("123456789", dataDir, []byte(`{"old":"conf"}`))).To(Succeed())
+		info, err := os.Stat(filepath.Join(dataDir, "123456789"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+		// a write failing halfway leaves the previous file untouched
+		origWrite := writeScratchFile
+		defer func() { writeScratchFile = origWrite }()
+		writeScratchFile = func(f *os.File, data []byte) error {
+			_, err := f.Write(data[:len(data)/2])
+			Expect(err).NotTo(HaveOccurred())
+			// the reader does not see the partial write
+			b, _, err := consumeScratchNetConf("123456789", dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(b)).To(Equal(`{"old":"conf"}`))
+			return fmt.Errorf("no space left on device")
+		}
+		err = saveScratchNetConf("123456789", dataDir, []byte(`{"new":"conf"}`))
+		Expect(err).To(MatchError(ContainSubstring("no space left on device")))
+
+		b, _, err := consumeScratchNetConf("123456789", dataDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(b)).To(Equal(`{"old":"conf"}`))
+		// and no temporary file behind
+		entries, err := os.ReadDir(dataDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(len(entries)).To(Equal(1))
+
+		writeScratchFile = origWrite
+		Expect(saveScratchNetConf("123456789", dataDir, []byte(`{"new":"conf"}`))).To(Succeed())
+		b, _, err = consumeScratchNetConf("123456789", dataDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(b)).To(Equal(`{"new":"conf"}`))
+	})
+
+	It("saves and loads the delegates cache with its version", func() {
+		dataDir, err := os.MkdirTemp("", "multus_scratch")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dataDir)
+
+		delegate, err := types.LoadDelegateNetConf([]byte(`{"name": "net1", "cniVersion": "0.2.0", "type": "mynet"}`), nil, "", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(saveDelegates("123456789", dataDir, []*types.DelegateNetConf{delegate})).To(Succeed())
+
+		b, _, err := consumeScratchNetConf("123456789", dataDir)
+		Expect(err).NotTo(HaveOccurred())
+		cache := &scratchCache{}
+		Expect(json.Unmarshal(b, cache)).To(Succeed())
+		Expect(cache.Version).To(Equal(2))
+
+		delegates, err := loadDelegates(b)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(delegates).To(HaveLen(1))
+		Expect(delegates[0].Name).To(Equal("net1"))
+		Expect(delegates[0].Conf.Type).To(Equal("mynet"))
+	})
+
+	It("loads a version 1 delegates cache", func() {
+		delegates, err := loadDelegates([]byte(`[{"conf": {"name": "net1", "cniVersion": "0.2.0", "type": "mynet"}, "name": "net1", "ifnameRequest": "myeth"}]`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(delegates).To(HaveLen(1))
+		Expect(delegates[0].Conf.Type).To(Equal("mynet"))
+		Expect(delegates[0].IfnameRequest).To(Equal("myeth"))
+
+		delegates, err = loadDelegates([]byte(`{"version": 1, "data": [{"conf": {"name": "net1", "type": "mynet"}, "name": "net1"}]}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(delegates).To(HaveLen(1))
+	})
+
+	It("fails to load a corrupt delegates cache", func() {
+		_, err := loadDelegates([]byte(`{"version": 2, "data": [{"name"`))
+		Expect(err).To(MatchError(HavePrefix("loadDelegates: corrupt delegates cache: ")))
+
+		_, err = loadDelegates([]byte(`{"version": 2, "data": {"name": "net1"}}`))
+		Expect(err).To(MatchError(HavePrefix("loadDelegates: corrupt delegates cache of version 2: ")))
+
+		_, err = loadDelegates([]byte(`{"version": 2, "data": []}`))
+		Expect(err).To(MatchError("loadDelegates: corrupt delegates cache of version 2: no delegate"))
+
+		_, err = loadDelegates([]byte(`{"version": 3, "data": []}`))
+		Expect(err).To(MatchError("loadDelegates: unsupported delegates cache version 3, expected at most 2"))
+	})
+
+	It("finds the delegates cache of another form of the container ID", func() {
+		dataDir, err := os.MkdirTemp("", "multus_scratch")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dataDir)
+
+		fullID := "3f4e1b9c2a7d5e6f8091a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f7"
+		shortID := fullID[:12]
+
+		// written under the full ID on ADD, read under the short one on DEL
+		Expect(saveScratchNetConf(fullID, dataDir, []byte(`{"full":"conf"}`))).To(Succeed())
+		b, path, err := consumeScratchNetConf(shortID, dataDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(b)).To(Equal(`{"full":"conf"}`))
+		Expect(path).To(Equal(filepath.Join(dataDir, fullID)))
+		b, _, err = consumeScratchNetConf(strings.ToUpper(fullID), dataDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(b)).To(Equal(`{"full":"conf"}`))
+		Expect(deleteDelegates(shortID, dataDir)).To(Succeed())
+		Expect(filepath.Join(dataDir, fullID)).NotTo(BeAnExistingFile())
+
+		// and the other way around
+		Expect(saveScratchNetConf(shortID, dataDir, []byte(`{"short":"conf"}`))).To(Succeed())
+		b, _, err = consumeScratchNetConf(fullID, dataDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(b)).To(Equal(`{"short":"conf"}`))
+
+		// a cache of the exact ID is preferred
+		Expect(saveScratchNetConf(fullID, dataDir, []byte(`{"full":"conf"}`))).To(Succeed())
+		b, _, err = consumeScratchNetConf(shortID, dataDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(b)).To(Equal(`{"short":"conf"}`))
+
+		// an ID matching several caches, or too short, finds none
+		Expect(deleteDelegates(shortID, dataDir)).To(Succeed())
+		Expect(saveScratchNetConf(shortID+"aaaa", dataDir, []byte(`{"other":"conf"}`))).To(Succeed())
+		_, _, err = consumeScratchNetConf(shortID, dataDir)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		_, _, err = consumeScratchNetConf(fullID[:8], dataDir)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
 	It("fails to delete delegates with bad filepath", func() {
 		err := deleteDelegates("123456789", "bad!file!~?Path$^")
 		Expect(err).To(HaveOccurred())
@@ -501,9 +634,9 @@ var _ = Describe("multus operations cniVersion 0.2.0 config", func() {
 		recorder := clientInfo.EventRecorder.(*record.FakeRecorder)
 		events := collectEvents(recorder.Events)
 		Expect(len(events)).To(Equal(3))
-		Expect(events[0]).To(Equal("Normal AddedInterface Add eth0 [1.1.1.2/24] from weave1"))
-		Expect(events[1]).To(Equal("Normal AddedInterface Add net1 [1.1.1.3/24] from test/net1"))
-		Expect(events[2]).To(Equal("Normal AddedInterface Add net2 [1.1.1.4/24] from test/net2"))
+		Expect(events[0]).To(Equal("Normal AddedInterface Add eth0 [1.1.1.2/24] from weave1 sandbox=" + testNS.Path()))
+		Expect(events[1]).To(Equal("Normal AddedInterface Add net1 [1.1.1.3/24] from test/net1 sandbox=" + testNS.Path()))
+		Expect(events[2]).To(Equal("Normal AddedInterface Add net2 [1.1.1.4/24] from test/net2 sandbox=" + testNS.Path()))
 	})
 
 	It("executes kubernetes networks and delete it after pod removal", func() {
@@ -767,7 +900,7 @@ var _ = Describe("multus operations cniVersion 0.2.0 config", func() {
 		Expect(fExec.delIndex).To(Equal(len(fExec.plugins)))
 	})
 
-	It("fails to execute confListDel given no 'plugins' key", func() {
+	It("executes confListDel given a single plugin conf with no 'plugins' key", func() {
 		args := &skel.CmdArgs{
 			ContainerID: "123456789",
 			Netns:       testNS.Path(),
@@ -825,8 +958,68 @@ var _ = Describe("multus operations cniVersion 0.2.0 config", func() {
 		n, err := types.LoadNetConf(args.StdinData)
 		rt, _ := types.CreateCNIRuntimeConf(args, k8sargs, args.IfName, n.RuntimeConfig, nil)
 
-		err = conflistDel(rt, rawnetconflist, &fakeMultusNetConf, fExec)
-		Expect(err).To(HaveOccurred())
+		err = conflistDel(rt, rawnetconflist, &fakeMultusNetConf, fExec, logging.NewLogger(""))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.delOrder).To(Equal([]string{"eth0"}))
 	})
 
+	It("merges the DNS of all delegates with mergeDNS, the default network first", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "mergeDNS": true,
+	    "defaultNetworkOrder": "last",
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "0.2.0",
+	        "type": "weave-net"
+	    },{
+	        "name": "other1",
+	        "cniVersion": "0.2.0",
+	        "type": "other-plugin"
+	    }]
+	}`),
+		}
+
+		fExec := newFakeExec()
+		// the additional network is added before the default network
+		fExec.addPlugin020(nil, "net1", "", &types020.Result{
+			CNIVersion: "0.2.0",
+			IP4: &types020.IPConfig{
+				IP: *testhelpers.EnsureCIDR("1.1.1.5/24"),
+			},
+			DNS: cnitypes.DNS{
+				Nameservers: []string{"9.9.9.9", "10.0.0.10"},
+				Domain:      "example.com",
+				Search:      []string{"example.com"},
+			},
+		}, nil)
+		fExec.addPlugin020(nil, "eth0", "", &types020.Result{
+			CNIVersion: "0.2.0",
+			IP4: &types020.IPConfig{
+				IP: *testhelpers.EnsureCIDR("1.1.1.2/24"),
+			},
+			DNS: cnitypes.DNS{
+				Nameservers: []string{"10.0.0.10"},
+				Domain:      "cluster.local",
+				Search:      []string{"svc.cluster.local", "cluster.local"},
+			},
+		}, nil)
+
+		result, err := CmdAdd(args, fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addOrder).To(Equal([]string{"net1", "eth0"}))
+		r, err := types020.GetResult(result)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.IP4.IP.String()).To(Equal("1.1.1.2/24"))
+		Expect(r.DNS.Nameservers).To(Equal([]string{"10.0.0.10", "9.9.9.9"}))
+		Expect(r.DNS.Domain).To(Equal("cluster.local"))
+		Expect(r.DNS.Search).To(Equal([]string{"svc.cluster.local", "cluster.local", "example.com"}))
+	})
 })
@@ -1500,7 +1500,7 @@ var _ = Describe("multus operations cniVersion 0.4.0 config", func() {
 		Expect(fExec.delIndex).To(Equal(len(fExec.plugins)))
 	})
 
-	It("fails to execute confListDel given no 'plugins' key", func() {
+	It("executes confListDel given a single plugin conf with no 'plugins' key", func() {
 		args := &skel.CmdArgs{
 			ContainerID: "123456789",
 			Netns:       testNS.Path(),
@@ -1560,7 +1560,8 @@ var _ = Describe("multus operations cniVersion 0.4.0 config", func() {
 		n, err := types.LoadNetConf(args.StdinData)
 		rt, _ := types.CreateCNIRuntimeConf(args, k8sargs, args.IfName, n.RuntimeConfig, nil)
 
-		err = conflistDel(rt, rawnetconflist, &fakeMultusNetConf, fExec)
-		Expect(err).To(HaveOccurred())
+		err = conflistDel(rt, rawnetconflist, &fakeMultusNetConf, fExec, logging.NewLogger(""))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.delOrder).To(Equal([]string{"eth0"}))
 	})
 })
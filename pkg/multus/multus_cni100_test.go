@@ -15,18 +15,43 @@
 package multus
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/containernetworking/cni/pkg/invoke"
 	"github.com/containernetworking/cni/pkg/skel"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	cni040 "github.com/containernetworking/cni/pkg/types/040"
 	cni100 "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/containernetworking/plugins/pkg/testutils"
+	nettypes "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	netutils "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/utils"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/vishvananda/netlink"
 	"gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/k8sclient"
 	"gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/logging"
 	testhelpers "gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/testing"
 	"gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/types"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -68,6 +93,67 @@ var _ = Describe("multus operations cniVersion 1.0.0 config", func() {
 		Expect(err).NotTo(HaveOccurred())
 	})
 
+	// podCmdArgs returns the CmdArgs of fakePod with the multus config of the weave1 default
+	// network, along with the given top-level fields, e.g. `"verifyRequestedMAC": true`
+	podCmdArgs := func(fakePod *v1.Pod, fields ...string) *skel.CmdArgs {
+		var conf strings.Builder
+		for _, field := range fields {
+			conf.WriteString("\n\t    " + field + ",")
+		}
+		return &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			Args:        fmt.Sprintf("K8S_POD_NAME=%s;K8S_POD_NAMESPACE=%s", fakePod.ObjectMeta.Name, fakePod.ObjectMeta.Namespace),
+			StdinData: []byte(fmt.Sprintf(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",%s
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`, conf.String())),
+		}
+	}
+
+	// newPodClientInfo returns a fake client with fakePod and a network-attachment-definition
+	// in its namespace for each of netConfs, named after the network
+	newPodClientInfo := func(fakePod *v1.Pod, netConfs ...string) *k8sclient.ClientInfo {
+		clientInfo := NewFakeClientInfo()
+		_, err := clientInfo.AddPod(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		for _, netConf := range netConfs {
+			var conf cnitypes.NetConf
+			Expect(json.Unmarshal([]byte(netConf), &conf)).To(Succeed())
+			_, err = clientInfo.AddNetAttachDef(
+				testhelpers.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, conf.Name, netConf))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		return clientInfo
+	}
+
+	// captureLogs returns the log written while run runs, and restores the logging level
+	// and format, which the multus config of run may change
+	captureLogs := func(run func()) string {
+		logFile := filepath.Join(tmpDir, "multus.log")
+		prevLevel := logging.GetLoggingLevel()
+		logging.SetLogFile(logFile)
+		run()
+		Expect(logging.CloseLogFile()).To(Succeed())
+		logging.SetLogLevel(prevLevel.String())
+		logging.SetLogFormat(logging.PlainFormat)
+
+		logs, err := os.ReadFile(logFile)
+		if os.IsNotExist(err) {
+			return ""
+		}
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Remove(logFile)).To(Succeed())
+		return string(logs)
+	}
+
 	It("executes delegates with CNI Check", func() {
 		args := &skel.CmdArgs{
 			ContainerID: "123456789",
@@ -392,6 +478,110 @@ var _ = Describe("multus operations cniVersion 1.0.0 config", func() {
 		Expect(err).To(HaveOccurred())
 	})
 
+	It("fails when the readinessindicatorfile is not found in time", func() {
+		cniDir, err := os.MkdirTemp("", "multus-readiness")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(cniDir)
+		indicatorFile := filepath.Join(cniDir, "missing.conf")
+
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(fmt.Sprintf(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "cniDir": %q,
+	    "readinessindicatorfile": %q,
+	    "readinessIndicatorWaitSeconds": 1,
+	    "readinessPollIntervalMillis": 100,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`, cniDir, indicatorFile)),
+		}
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		start := time.Now()
+		_, err = CmdAdd(args, fExec, nil)
+		Expect(err).To(MatchError(fmt.Sprintf("Multus: [//]: default network file %s not found after 1s, check that the default network is ready", indicatorFile)))
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		Expect(fExec.addIndex).To(Equal(0))
+		// no delegates cache is left behind
+		entries, err := os.ReadDir(cniDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+
+	It("checks the readinessindicatorfile every readinessPollIntervalMillis", func() {
+		origStat := statReadinessIndicatorFile
+		defer func() { statReadinessIndicatorFile = origStat }()
+		attempts := 0
+		statReadinessIndicatorFile = func(name string) (os.FileInfo, error) {
+			attempts++
+			return nil, os.ErrNotExist
+		}
+
+		n := &types.NetConf{
+			ReadinessIndicatorFile:        "/tmp/missing.conf",
+			ReadinessIndicatorWaitSeconds: 1,
+			ReadinessPollIntervalMillis:   100,
+		}
+		err := waitForReadinessIndicatorFile(n)
+		Expect(err).To(MatchError("default network file /tmp/missing.conf not found after 1s, check that the default network is ready"))
+		// at 0, 100, ..., 1000ms
+		Expect(attempts).To(BeNumerically(">=", 8))
+		Expect(attempts).To(BeNumerically("<=", 11))
+	})
+
+	It("backs off the checks of the readinessindicatorfile up to readinessPollMaxIntervalMillis", func() {
+		origStat := statReadinessIndicatorFile
+		defer func() { statReadinessIndicatorFile = origStat }()
+		attempts := 0
+		statReadinessIndicatorFile = func(name string) (os.FileInfo, error) {
+			attempts++
+			return nil, os.ErrNotExist
+		}
+
+		n := &types.NetConf{
+			ReadinessIndicatorFile:         "/tmp/missing.conf",
+			ReadinessIndicatorWaitSeconds:  1,
+			ReadinessPollIntervalMillis:    100,
+			ReadinessPollMaxIntervalMillis: 400,
+		}
+		start := time.Now()
+		err := waitForReadinessIndicatorFile(n)
+		Expect(err).To(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically("<", 2*time.Second))
+		// at 0, 100, 300, 700 and 1000ms
+		Expect(attempts).To(BeNumerically(">=", 4))
+		Expect(attempts).To(BeNumerically("<=", 5))
+	})
+
+	It("stops checking the readinessindicatorfile once it exists", func() {
+		origStat := statReadinessIndicatorFile
+		defer func() { statReadinessIndicatorFile = origStat }()
+		attempts := 0
+		statReadinessIndicatorFile = func(name string) (os.FileInfo, error) {
+			attempts++
+			if attempts < 3 {
+				return nil, os.ErrNotExist
+			}
+			return nil, nil
+		}
+
+		n := &types.NetConf{
+			ReadinessIndicatorFile:      "/tmp/ready.conf",
+			ReadinessPollIntervalMillis: 10,
+		}
+		Expect(waitForReadinessIndicatorFile(n)).To(Succeed())
+		Expect(attempts).To(Equal(3))
+	})
+
 	It("executes delegates and cleans up on failure", func() {
 		expectedConf1 := `{
 	    "name": "weave1",
@@ -436,6 +626,89 @@ var _ = Describe("multus operations cniVersion 1.0.0 config", func() {
 		Expect(err).To(MatchError("[//:other1]: error adding container to network \"other1\": expected plugin failure"))
 	})
 
+	It("returns the teardown errors with the ADD error", func() {
+		expectedConf1 := `{
+	    "name": "weave1",
+	    "cniVersion": "1.0.0",
+	    "type": "weave-net"
+	}`
+		expectedConf2 := `{
+	    "name": "other1",
+	    "cniVersion": "1.0.0",
+	    "type": "other-plugin"
+	}`
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(fmt.Sprintf(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [%s,%s]
+	}`, expectedConf1, expectedConf2)),
+		}
+
+		fExec := newFakeExec()
+		expectedResult1 := &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("1.1.1.2/24"),
+			},
+			},
+		}
+		fExec.addPlugin100(nil, "eth0", expectedConf1, expectedResult1, nil)
+		fExec.plugins["eth0"].delErr = fmt.Errorf("weave1 is busy")
+
+		// This plugin invocation should fail
+		err := fmt.Errorf("expected plugin failure")
+		fExec.addPlugin100(nil, "net1", expectedConf2, nil, err)
+
+		_, err = CmdAdd(args, fExec, nil)
+		Expect(fExec.addIndex).To(Equal(2))
+		Expect(fExec.delIndex).To(Equal(2))
+		Expect(err).To(MatchError(ContainSubstring("2 delegates failed")))
+		Expect(err).To(MatchError(ContainSubstring("error adding container to network \"other1\": expected plugin failure")))
+		Expect(err).To(MatchError(ContainSubstring("error tearing down the network \"weave1\"")))
+		Expect(err).To(MatchError(ContainSubstring("weave1 is busy")))
+	})
+
+	It("cleans up when a delegate returns a result which is not JSON", func() {
+		expectedConf1 := `{
+	    "name": "weave1",
+	    "cniVersion": "1.0.0",
+	    "type": "weave-net"
+	}`
+		expectedConf2 := `{
+	    "name": "other1",
+	    "cniVersion": "1.0.0",
+	    "type": "other-plugin"
+	}`
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(fmt.Sprintf(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "delegates": [%s,%s]
+	}`, expectedConf1, expectedConf2)),
+		}
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", expectedConf1, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", expectedConf2, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.plugins["net1"].rawResult = "configured net1\n" + strings.Repeat("x", 200)
+
+		_, err := CmdAdd(args, fExec, nil)
+		Expect(err).To(MatchError(fmt.Sprintf("[//:other1]: error adding container to network \"other1\": plugin other-plugin succeeded but its result is not valid JSON: %q",
+			"configured net1\n"+strings.Repeat("x", 112)+"...")))
+		Expect(fExec.addIndex).To(Equal(2))
+		// both networks are torn down, the network of the invalid result first
+		Expect(fExec.delOrder).To(Equal([]string{"net1", "eth0"}))
+	})
+
 	It("executes delegates and cleans up on failure with missing name field", func() {
 		expectedConf1 := `{
 		    "name": "weave1",
@@ -596,98 +869,130 @@ var _ = Describe("multus operations cniVersion 1.0.0 config", func() {
 
 	})
 
-	It("executes delegates and kubernetes networks", func() {
-		fakePod := testhelpers.NewFakePod("testpod", "net1,net2", "")
+	It("executes delegates with the sysctls runtimeConfig", func() {
+		podNet := `[{"name":"net1",
+			"sysctls": {
+				"net.ipv4.conf.IFNAME.arp_ignore": "1",
+				"net.ipv6.conf.IFNAME.accept_ra": "0"
+			}
+		},
+		{"name":"net2",
+			"sysctls": {
+				"net.ipv4.conf.IFNAME.arp_ignore": "2"
+			}
+		}]`
+		fakePod := testhelpers.NewFakePod("testpod", podNet, "")
 		net1 := `{
 		"name": "net1",
 		"type": "mynet",
+		"capabilities": {"sysctls": true},
 		"cniVersion": "1.0.0"
 	}`
+		// net2 does not declare the capability
 		net2 := `{
 		"name": "net2",
 		"type": "mynet2",
 		"cniVersion": "1.0.0"
 	}`
-		net3 := `{
-		"name": "net3",
-		"type": "mynet3",
-		"cniVersion": "1.0.0"
-	}`
-		args := &skel.CmdArgs{
-			ContainerID: "123456789",
-			Netns:       testNS.Path(),
-			IfName:      "eth0",
-			Args:        fmt.Sprintf("K8S_POD_NAME=%s;K8S_POD_NAMESPACE=%s", fakePod.ObjectMeta.Name, fakePod.ObjectMeta.Namespace),
-			StdinData: []byte(`{
-	    "name": "node-cni-network",
-	    "type": "multus",
-	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
-	    "delegates": [{
-	        "name": "weave1",
-	        "cniVersion": "1.0.0",
-	        "type": "weave-net"
-	    }]
-	}`),
-		}
+		args := podCmdArgs(fakePod)
 
 		fExec := newFakeExec()
-		expectedResult1 := &cni100.Result{
-			CNIVersion: "1.0.0",
-			IPs: []*cni100.IPConfig{{
-				Address: *testhelpers.EnsureCIDR("1.1.1.2/24"),
-			},
-			},
-		}
-		expectedConf1 := `{
+		fExec.addPlugin100(nil, "eth0", `{
 	    "name": "weave1",
 	    "cniVersion": "1.0.0",
 	    "type": "weave-net"
-	}`
-		fExec.addPlugin100(nil, "eth0", expectedConf1, expectedResult1, nil)
-		fExec.addPlugin100(nil, "net1", net1, &cni100.Result{
-			CNIVersion: "1.0.0",
-			IPs: []*cni100.IPConfig{{
-				Address: *testhelpers.EnsureCIDR("1.1.1.3/24"),
-			},
-			},
-		}, nil)
-		fExec.addPlugin100(nil, "net2", net2, &cni100.Result{
-			CNIVersion: "1.0.0",
-			IPs: []*cni100.IPConfig{{
-				Address: *testhelpers.EnsureCIDR("1.1.1.4/24"),
-			},
-			},
-		}, nil)
-
-		clientInfo := NewFakeClientInfo()
-		_, err := clientInfo.AddPod(fakePod)
-		Expect(err).NotTo(HaveOccurred())
+	}`, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", `{
+		"name": "net1",
+		"type": "mynet",
+		"capabilities": {"sysctls": true},
+		"runtimeConfig": {
+			"sysctls": {
+				"net.ipv4.conf.IFNAME.arp_ignore": "1",
+				"net.ipv6.conf.IFNAME.accept_ra": "0"
+			}
+		},
+		"cniVersion": "1.0.0"
+	}`, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net2", net2, &cni100.Result{CNIVersion: "1.0.0"}, nil)
 
-		_, err = clientInfo.AddNetAttachDef(
-			testhelpers.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "net1", net1))
-		Expect(err).NotTo(HaveOccurred())
-		_, err = clientInfo.AddNetAttachDef(
-			testhelpers.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "net2", net2))
-		Expect(err).NotTo(HaveOccurred())
-		// net3 is not used; make sure it's not accessed
-		_, err = clientInfo.AddNetAttachDef(
-			testhelpers.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "net3", net3))
-		Expect(err).NotTo(HaveOccurred())
+		clientInfo := newPodClientInfo(fakePod, net1, net2)
 
-		result, err := CmdAdd(args, fExec, clientInfo)
+		_, err := CmdAdd(args, fExec, clientInfo)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(fExec.addIndex).To(Equal(len(fExec.plugins)))
-		// plugin 1 is the masterplugin
-		Expect(reflect.DeepEqual(result, expectedResult1)).To(BeTrue())
 	})
 
-	It("executes kubernetes networks and delete it after pod removal", func() {
-		fakePod := testhelpers.NewFakePod("testpod", "net1", "")
-		net1 := `{
+	It("passes ingress-only and egress-only bandwidth requests in the runtimeConfig", func() {
+		podNet := `[{"name":"net1",
+			"bandwidth": {"ingressRate": 2048, "ingressBurst": 1600}
+		},{"name":"net2",
+			"bandwidth": {"egressRate": 4096, "egressBurst": 1600}
+		}]`
+		fakePod := testhelpers.NewFakePod("testpod", podNet, "")
+		netConf := `{
+		"name": %q,
+		"type": "mynet",
+		"capabilities": {"bandwidth": true},
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod)
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", `{
 		"name": "net1",
 		"type": "mynet",
+		"capabilities": {"bandwidth": true},
+		"runtimeConfig": {"bandwidth": {"ingressRate": 2048, "ingressBurst": 1600}},
+		"cniVersion": "1.0.0"
+	}`, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net2", `{
+		"name": "net2",
+		"type": "mynet",
+		"capabilities": {"bandwidth": true},
+		"runtimeConfig": {"bandwidth": {"egressRate": 4096, "egressBurst": 1600}},
+		"cniVersion": "1.0.0"
+	}`, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		clientInfo := newPodClientInfo(fakePod, fmt.Sprintf(netConf, "net1"), fmt.Sprintf(netConf, "net2"))
+
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addIndex).To(Equal(3))
+	})
+
+	It("passes the requested cni-args to the delegate of their network only", func() {
+		podNet := `[{"name":"net1",
+			"cni-args": {"foo": "bar", "port": "8080"}
+		},{"name":"net2"}]`
+		fakePod := testhelpers.NewFakePod("testpod", podNet, "")
+		netConf := `{
+		"name": %q,
+		"type": "mynet",
 		"cniVersion": "1.0.0"
 	}`
+		args := podCmdArgs(fakePod)
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", `{
+		"name": "net1",
+		"type": "mynet",
+		"args": {"cni": {"foo": "bar", "port": "8080"}},
+		"cniVersion": "1.0.0"
+	}`, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net2", fmt.Sprintf(netConf, "net2"), &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		clientInfo := newPodClientInfo(fakePod, fmt.Sprintf(netConf, "net1"), fmt.Sprintf(netConf, "net2"))
+
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addIndex).To(Equal(3))
+	})
+
+	It("returns the result of the resultNetwork instead of the default network's", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "net1", "")
 		args := &skel.CmdArgs{
 			ContainerID: "123456789",
 			Netns:       testNS.Path(),
@@ -696,7 +1001,10 @@ var _ = Describe("multus operations cniVersion 1.0.0 config", func() {
 			StdinData: []byte(`{
 	    "name": "node-cni-network",
 	    "type": "multus",
+	    "cniVersion": "1.0.0",
 	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "resultNetwork": "net1",
+	    "mergeDNS": true,
 	    "delegates": [{
 	        "name": "weave1",
 	        "cniVersion": "1.0.0",
@@ -706,28 +1014,233 @@ var _ = Describe("multus operations cniVersion 1.0.0 config", func() {
 		}
 
 		fExec := newFakeExec()
-		expectedResult1 := &cni100.Result{
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{
 			CNIVersion: "1.0.0",
-			IPs: []*cni100.IPConfig{{
-				Address: *testhelpers.EnsureCIDR("1.1.1.2/24"),
-			},
-			},
-		}
-		expectedConf1 := `{
-	    "name": "weave1",
-	    "cniVersion": "1.0.0",
-	    "type": "weave-net"
-	}`
-		fExec.addPlugin100(nil, "eth0", expectedConf1, expectedResult1, nil)
-		fExec.addPlugin100(nil, "net1", net1, &cni100.Result{
+			IPs:        []*cni100.IPConfig{{Address: *testhelpers.EnsureCIDR("10.0.0.2/24")}},
+			DNS:        cnitypes.DNS{Nameservers: []string{"10.0.0.10"}},
+		}, nil)
+		fExec.addPlugin100(nil, "net1", "", &cni100.Result{
 			CNIVersion: "1.0.0",
-			IPs: []*cni100.IPConfig{{
-				Address: *testhelpers.EnsureCIDR("1.1.1.3/24"),
-			},
-			},
+			IPs:        []*cni100.IPConfig{{Address: *testhelpers.EnsureCIDR("1.1.1.5/24")}},
+			DNS:        cnitypes.DNS{Nameservers: []string{"1.1.1.10"}},
 		}, nil)
 
-		clientInfo := NewFakeClientInfo()
+		clientInfo := newPodClientInfo(fakePod, `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`)
+
+		result, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addIndex).To(Equal(2))
+		r := result.(*cni100.Result)
+		Expect(r.IPs).To(HaveLen(1))
+		Expect(r.IPs[0].Address.String()).To(Equal("1.1.1.5/24"))
+		// the DNS of the default network still comes first
+		Expect(r.DNS.Nameservers).To(Equal([]string{"10.0.0.10", "1.1.1.10"}))
+
+		// the default network returns the result of the pods not selecting the network
+		n := &types.NetConf{ResultNetwork: "net2", Delegates: []*types.DelegateNetConf{{Name: "test/net1"}}}
+		Expect(resultDelegate(n, "test")).To(BeNil())
+		n.ResultNetwork = "test/net1"
+		Expect(resultDelegate(n, "other")).To(Equal(n.Delegates[0]))
+	})
+
+	It("rejects bandwidth requests above maxBandwidthBps", func() {
+		podNet := `[{"name":"net1",
+			"bandwidth": {
+				"ingressRate": 2048,
+				"ingressBurst": 1600,
+				"egressRate": 1000000000000,
+				"egressBurst": 1600
+			}
+		}]`
+		fakePod := testhelpers.NewFakePod("testpod", podNet, "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"capabilities": {"bandwidth": true},
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod, `"maxBandwidthBps": 10000000000`)
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		clientInfo := newPodClientInfo(fakePod, net1)
+
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).To(MatchError(ContainSubstring(`egress rate 1000000000000 of network "test/net1" exceeds the limit of 10000000000 bps`)))
+		Expect(fExec.addIndex).To(Equal(0))
+	})
+
+	It("rejects networks requesting the same hostPort with rejectHostPortCollisions", func() {
+		podNet := `[{"name":"net1",
+			"portMappings": [{"hostPort": 8080, "containerPort": 80, "protocol": "tcp"}]
+		},{"name":"net2",
+			"portMappings": [{"hostPort": 8080, "containerPort": 8080}]
+		}]`
+		fakePod := testhelpers.NewFakePod("testpod", podNet, "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"capabilities": {"portMappings": true},
+		"cniVersion": "1.0.0"
+	}`
+		net2 := `{
+		"name": "net2",
+		"type": "mynet2",
+		"capabilities": {"portMappings": true},
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod, `"rejectHostPortCollisions": true`)
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net2", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		clientInfo := newPodClientInfo(fakePod, net1, net2)
+
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).To(MatchError(ContainSubstring(`hostPort 8080/tcp of network "test/net2" is already requested by network "test/net1"`)))
+		Expect(fExec.addIndex).To(Equal(0))
+	})
+
+	It("executes delegates and kubernetes networks", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "net1,net2", "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		net2 := `{
+		"name": "net2",
+		"type": "mynet2",
+		"cniVersion": "1.0.0"
+	}`
+		net3 := `{
+		"name": "net3",
+		"type": "mynet3",
+		"cniVersion": "1.0.0"
+	}`
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			Args:        fmt.Sprintf("K8S_POD_NAME=%s;K8S_POD_NAMESPACE=%s", fakePod.ObjectMeta.Name, fakePod.ObjectMeta.Namespace),
+			StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`),
+		}
+
+		fExec := newFakeExec()
+		expectedResult1 := &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("1.1.1.2/24"),
+			},
+			},
+		}
+		expectedConf1 := `{
+	    "name": "weave1",
+	    "cniVersion": "1.0.0",
+	    "type": "weave-net"
+	}`
+		fExec.addPlugin100(nil, "eth0", expectedConf1, expectedResult1, nil)
+		fExec.addPlugin100(nil, "net1", net1, &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("1.1.1.3/24"),
+			},
+			},
+		}, nil)
+		fExec.addPlugin100(nil, "net2", net2, &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("1.1.1.4/24"),
+			},
+			},
+		}, nil)
+
+		clientInfo := NewFakeClientInfo()
+		_, err := clientInfo.AddPod(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = clientInfo.AddNetAttachDef(
+			testhelpers.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "net1", net1))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = clientInfo.AddNetAttachDef(
+			testhelpers.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "net2", net2))
+		Expect(err).NotTo(HaveOccurred())
+		// net3 is not used; make sure it's not accessed
+		_, err = clientInfo.AddNetAttachDef(
+			testhelpers.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "net3", net3))
+		Expect(err).NotTo(HaveOccurred())
+
+		result, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addIndex).To(Equal(len(fExec.plugins)))
+		// plugin 1 is the masterplugin
+		Expect(reflect.DeepEqual(result, expectedResult1)).To(BeTrue())
+	})
+
+	It("executes kubernetes networks and delete it after pod removal", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "net1", "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			Args:        fmt.Sprintf("K8S_POD_NAME=%s;K8S_POD_NAMESPACE=%s", fakePod.ObjectMeta.Name, fakePod.ObjectMeta.Namespace),
+			StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`),
+		}
+
+		fExec := newFakeExec()
+		expectedResult1 := &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("1.1.1.2/24"),
+			},
+			},
+		}
+		expectedConf1 := `{
+	    "name": "weave1",
+	    "cniVersion": "1.0.0",
+	    "type": "weave-net"
+	}`
+		fExec.addPlugin100(nil, "eth0", expectedConf1, expectedResult1, nil)
+		fExec.addPlugin100(nil, "net1", net1, &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("1.1.1.3/24"),
+			},
+			},
+		}, nil)
+
+		clientInfo := NewFakeClientInfo()
 		_, err := clientInfo.AddPod(fakePod)
 		Expect(err).NotTo(HaveOccurred())
 
@@ -748,6 +1261,63 @@ var _ = Describe("multus operations cniVersion 1.0.0 config", func() {
 		Expect(fExec.delIndex).To(Equal(len(fExec.plugins)))
 	})
 
+	It("clears the network status annotation on DEL", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "net1", "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod)
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", net1, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		clientInfo := newPodClientInfo(fakePod, net1)
+
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		pod, err := clientInfo.GetPod(fakePod.ObjectMeta.Namespace, fakePod.ObjectMeta.Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.Annotations).To(HaveKey(nettypes.NetworkStatusAnnot))
+
+		err = CmdDel(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.delIndex).To(Equal(len(fExec.plugins)))
+		pod, err = clientInfo.GetPod(fakePod.ObjectMeta.Namespace, fakePod.ObjectMeta.Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.Annotations).NotTo(HaveKey(nettypes.NetworkStatusAnnot))
+		Expect(pod.Annotations).To(HaveKey("k8s.v1.cni.cncf.io/networks"))
+	})
+
+	It("does not check the age of the network-attachment-definitions on DEL", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "net1", "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod, fmt.Sprintf(`"cniDir": %q`, tmpDir+"/cniData"), `"minNADAgeSeconds": 30`, `"minNADAgeAction": "wait"`)
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", net1, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		clientInfo := newPodClientInfo(fakePod)
+		nad := testhelpers.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "net1", net1)
+		nad.CreationTimestamp = metav1.Now()
+		_, err := clientInfo.AddNetAttachDef(nad)
+		Expect(err).NotTo(HaveOccurred())
+
+		// without cache, the networks are those of the pod annotation
+		start := time.Now()
+		err = CmdDel(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.delOrder).To(Equal([]string{"net1", "eth0"}))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+
 	It("ensure delegates get portmap runtime config", func() {
 		args := &skel.CmdArgs{
 			ContainerID: "123456789",
@@ -791,6 +1361,47 @@ var _ = Describe("multus operations cniVersion 1.0.0 config", func() {
 		Expect(err).NotTo(HaveOccurred())
 	})
 
+	It("ensure delegates get portmap runtime config from defaultCapabilities", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "defaultCapabilities": {"portMappings": true, "bandwidth": false},
+	    "delegates": [{
+	        "cniVersion": "1.0.0",
+	        "name": "mynet",
+	        "type": "firstPlugin",
+	        "capabilities": {"bandwidth": true}
+	    }],
+		"runtimeConfig": {
+	        "portMappings": [
+	            {"hostPort": 8080, "containerPort": 80, "protocol": "tcp"}
+			]
+	    }
+	}`),
+		}
+
+		fExec := newFakeExec()
+		// delegate-declared bandwidth capability wins over the default one
+		expectedConf1 := `{
+	    "capabilities": {"bandwidth": true, "portMappings": true},
+		"name": "mynet",
+	    "cniVersion": "1.0.0",
+	    "type": "firstPlugin",
+	    "runtimeConfig": {
+		    "portMappings": [
+	            {"hostPort": 8080, "containerPort": 80, "protocol": "tcp"}
+			]
+	    }
+	}`
+		fExec.addPlugin100(nil, "eth0", expectedConf1, nil, nil)
+		_, err := CmdAdd(args, fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
 	It("executes clusterNetwork delegate", func() {
 		fakePod := testhelpers.NewFakePod("testpod", "", "kube-system/net1")
 		net1 := `{
@@ -987,7 +1598,7 @@ var _ = Describe("multus operations cniVersion 1.0.0 config", func() {
 		Expect(fExec.delIndex).To(Equal(len(fExec.plugins)))
 	})
 
-	It("fails to execute confListDel given no 'plugins' key", func() {
+	It("executes confListDel given a single plugin conf with no 'plugins' key", func() {
 		args := &skel.CmdArgs{
 			ContainerID: "123456789",
 			Netns:       testNS.Path(),
@@ -1047,7 +1658,3350 @@ var _ = Describe("multus operations cniVersion 1.0.0 config", func() {
 		n, err := types.LoadNetConf(args.StdinData)
 		rt, _ := types.CreateCNIRuntimeConf(args, k8sargs, args.IfName, n.RuntimeConfig, nil)
 
-		err = conflistDel(rt, rawnetconflist, &fakeMultusNetConf, fExec)
-		Expect(err).To(HaveOccurred())
+		err = conflistDel(rt, rawnetconflist, &fakeMultusNetConf, fExec, logging.NewLogger(""))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.delOrder).To(Equal([]string{"eth0"}))
+	})
+
+	It("fails with a pod UID mismatch when the pod was recreated with the same name", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "", "")
+		clientInfo := newPodClientInfo(fakePod)
+
+		stdin := []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`)
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			Args:        fmt.Sprintf("K8S_POD_NAME=%s;K8S_POD_NAMESPACE=%s;K8S_POD_UID=oldUID", fakePod.Name, fakePod.Namespace),
+			StdinData:   stdin,
+		}
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).To(MatchError(ContainSubstring(`pod UID mismatch: expected pod UID "oldUID" but got "testUID" from Kube API`)))
+		Expect(fExec.addIndex).To(Equal(0))
+
+		// the UID is not checked when the runtime does not pass it
+		args.Args = fmt.Sprintf("K8S_POD_NAME=%s;K8S_POD_NAMESPACE=%s", fakePod.Name, fakePod.Namespace)
+		_, err = CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addIndex).To(Equal(1))
+	})
+
+	It("stops retrying pod lookups once the k8s retry budget is exhausted", func() {
+		fakeClient := fake.NewSimpleClientset()
+		getCount := 0
+		fakeClient.PrependReactor("get", "pods", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+			getCount++
+			return true, nil, errors.NewServiceUnavailable("apiserver is unavailable")
+		})
+		clientInfo := &k8sclient.ClientInfo{Client: fakeClient}
+		k8sArgs := &types.K8sArgs{
+			K8S_POD_NAME:      "testpod",
+			K8S_POD_NAMESPACE: "test",
+		}
+
+		budget := types.NewRetryBudget(600)
+		start := time.Now()
+		_, err := getPod(clientInfo, k8sArgs, false, budget, nil)
+		Expect(err).To(HaveOccurred())
+		firstCount := getCount
+		Expect(firstCount).To(BeNumerically(">", 1))
+
+		// the budget is spent, hence the second lookup must not retry
+		_, err = getPod(clientInfo, k8sArgs, false, budget, nil)
+		Expect(err).To(MatchError(ContainSubstring("retry budget exhausted")))
+		Expect(getCount).To(Equal(firstCount + 1))
+
+		// without the budget two lookups would take 2 * shortPollTimeout
+		Expect(time.Since(start)).To(BeNumerically("<", shortPollTimeout))
+	})
+
+	It("retries pod lookups as configured by apiRetry", func() {
+		fakeClient := fake.NewSimpleClientset()
+		clientInfo := &k8sclient.ClientInfo{Client: fakeClient}
+		_, err := clientInfo.AddPod(testhelpers.NewFakePod("testpod", "", ""))
+		Expect(err).NotTo(HaveOccurred())
+		getCount := 0
+		failures := 2
+		fakeClient.PrependReactor("get", "pods", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+			getCount++
+			if getCount <= failures {
+				return true, nil, errors.NewServiceUnavailable("apiserver is unavailable")
+			}
+			return false, nil, nil
+		})
+		k8sArgs := &types.K8sArgs{
+			K8S_POD_NAME:      "testpod",
+			K8S_POD_NAMESPACE: "test",
+		}
+		apiRetry := &types.APIRetry{MaxRetries: 3, BackoffMillis: 1, BackoffStrategy: types.APIRetryBackoffLinear}
+
+		pod, err := getPod(clientInfo, k8sArgs, false, nil, apiRetry)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.Name).To(Equal("testpod"))
+		Expect(getCount).To(Equal(failures + 1))
+
+		// the retries are exhausted before the API server recovers
+		getCount = 0
+		failures = 10
+		_, err = getPod(clientInfo, k8sArgs, false, nil, apiRetry)
+		Expect(err).To(MatchError(ContainSubstring("error getting pod after 3 retries")))
+		Expect(errors.IsServiceUnavailable(err)).To(BeTrue())
+		Expect(getCount).To(Equal(4))
+	})
+
+	It("rejects a pod scheduled to another node with verifyPodNode", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "", "")
+		fakePod.Spec.NodeName = "node-b"
+		args := podCmdArgs(fakePod, `"verifyPodNode": true`)
+		Expect(os.Setenv("NODE_NAME", "node-a")).To(Succeed())
+		defer os.Unsetenv("NODE_NAME")
+
+		fExec := newFakeExec()
+		clientInfo := newPodClientInfo(fakePod)
+
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).To(MatchError(ContainSubstring(`pod is scheduled to node "node-b", not to this node "node-a"`)))
+		Expect(fExec.addIndex).To(Equal(0))
+	})
+
+	It("rejects an additional network requesting a reserved interface name", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "net1@docker0", "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod, `"reservedInterfaceNames": ["eth0", "docker0"]`)
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "docker0", net1, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		clientInfo := newPodClientInfo(fakePod, net1)
+
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).To(MatchError(ContainSubstring(`interface name "docker0" for network "test/net1" is reserved`)))
+		// nothing is executed, not even the master plugin whose "eth0" is exempt
+		Expect(fExec.addIndex).To(Equal(0))
+	})
+
+	Context("with secondaryInterfacePrefix", func() {
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		net2 := `{
+		"name": "net2",
+		"type": "mynet2",
+		"cniVersion": "1.0.0"
+	}`
+		addPod := func(networks string) (*k8sclient.ClientInfo, *skel.CmdArgs) {
+			fakePod := testhelpers.NewFakePod("testpod", networks, "")
+			clientInfo := newPodClientInfo(fakePod, net1, net2)
+			return clientInfo, &skel.CmdArgs{
+				ContainerID: "123456789",
+				Netns:       testNS.Path(),
+				IfName:      "eth0",
+				Args:        fmt.Sprintf("K8S_POD_NAME=%s;K8S_POD_NAMESPACE=%s", fakePod.ObjectMeta.Name, fakePod.ObjectMeta.Namespace),
+				StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "secondaryInterfacePrefix": "eth",
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`),
+			}
+		}
+
+		It("names the additional networks with the prefix", func() {
+			clientInfo, args := addPod("net1, net2")
+			fExec := newFakeExec()
+			fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+			fExec.addPlugin100(nil, "eth1", net1, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+			fExec.addPlugin100(nil, "eth2", net2, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+			_, err := CmdAdd(args, fExec, clientInfo)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fExec.addOrder).To(Equal([]string{"eth0", "eth1", "eth2"}))
+
+			Expect(CmdDel(args, fExec, clientInfo)).To(Succeed())
+			Expect(fExec.delOrder).To(Equal([]string{"eth2", "eth1", "eth0"}))
+		})
+
+		It("adds a requested interface name along with the prefixed ones", func() {
+			clientInfo, args := addPod("net1@eth1, net2")
+			fExec := newFakeExec()
+			fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+			fExec.addPlugin100(nil, "eth1", net1, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+			fExec.addPlugin100(nil, "eth2", net2, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+			_, err := CmdAdd(args, fExec, clientInfo)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fExec.addOrder).To(Equal([]string{"eth0", "eth1", "eth2"}))
+		})
+
+		It("rejects a requested interface name assigned to another network", func() {
+			clientInfo, args := addPod("net1, net2@eth1")
+			fExec := newFakeExec()
+			fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+			_, err := CmdAdd(args, fExec, clientInfo)
+			Expect(err).To(MatchError(ContainSubstring(`requested interface name "eth1" of network "test/net2" collides with the one assigned to network "test/net1"`)))
+			Expect(fExec.addIndex).To(Equal(0))
+		})
+	})
+
+	Context("with delegates returning the same IP address", func() {
+		var args *skel.CmdArgs
+		var fExec *fakeExec
+
+		BeforeEach(func() {
+			args = &skel.CmdArgs{
+				ContainerID: "123456789",
+				Netns:       testNS.Path(),
+				IfName:      "eth0",
+			}
+			fExec = newFakeExec()
+			fExec.addPlugin100(nil, "eth0", "", &cni100.Result{
+				CNIVersion: "1.0.0",
+				IPs:        []*cni100.IPConfig{{Address: *testhelpers.EnsureCIDR("1.1.1.2/24")}},
+			}, nil)
+			fExec.addPlugin100(nil, "net1", "", &cni100.Result{
+				CNIVersion: "1.0.0",
+				IPs:        []*cni100.IPConfig{{Address: *testhelpers.EnsureCIDR("1.1.1.2/24")}},
+			}, nil)
+		})
+
+		multusConf := func(fatal bool) []byte {
+			return []byte(fmt.Sprintf(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "detectDuplicateResultIPs": true,
+	    "duplicateResultIPsFatal": %t,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    },{
+	        "name": "other1",
+	        "cniVersion": "1.0.0",
+	        "type": "other-plugin"
+	    }]
+	}`, fatal))
+		}
+
+		It("fails and cleans up when duplicates are fatal", func() {
+			args.StdinData = multusConf(true)
+			_, err := CmdAdd(args, fExec, nil)
+			Expect(err).To(MatchError(ContainSubstring(`duplicate IP address 1.1.1.2 returned by networks "weave1" and "other1"`)))
+			Expect(fExec.addIndex).To(Equal(2))
+			Expect(fExec.delIndex).To(Equal(2))
+		})
+
+		It("only warns when duplicates are not fatal", func() {
+			args.StdinData = multusConf(false)
+			_, err := CmdAdd(args, fExec, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fExec.addIndex).To(Equal(2))
+			Expect(fExec.delIndex).To(Equal(0))
+		})
+	})
+
+	It("executes only annotation networks with noDefaultNetwork", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "net1,net2", "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		net2 := `{
+		"name": "net2",
+		"type": "mynet2",
+		"cniVersion": "1.0.0"
+	}`
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			Args:        fmt.Sprintf("K8S_POD_NAME=%s;K8S_POD_NAMESPACE=%s", fakePod.ObjectMeta.Name, fakePod.ObjectMeta.Namespace),
+			StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "noDefaultNetwork": true,
+	    "clusterNetwork": "weave1"
+	}`),
+		}
+
+		fExec := newFakeExec()
+		expectedResult1 := &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("1.1.1.3/24"),
+			},
+			},
+		}
+		fExec.addPlugin100(nil, "eth0", net1, expectedResult1, nil)
+		fExec.addPlugin100(nil, "net1", net2, &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("1.1.1.4/24"),
+			},
+			},
+		}, nil)
+
+		clientInfo := newPodClientInfo(fakePod, net1, net2)
+
+		result, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		// only net1 and net2 are executed; the clusterNetwork is never resolved
+		Expect(fExec.addIndex).To(Equal(2))
+		// net1 is the first selected network, hence its result is returned
+		Expect(reflect.DeepEqual(result, expectedResult1)).To(BeTrue())
+
+		err = CmdDel(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.delIndex).To(Equal(2))
+	})
+
+	It("interprets a delegate result without cniVersion at the multus cniVersion", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "cniVersion": "1.0.0",
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "weave1",
+	        "type": "weave-net"
+	    }]
+	}`),
+		}
+
+		fExec := newFakeExec()
+		// the delegate result is missing cniVersion
+		fExec.addPlugin100(nil, "eth0", `{
+	    "name": "weave1",
+	    "cniVersion": "1.0.0",
+	    "type": "weave-net"
+	}`, &cni100.Result{
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("1.1.1.2/24"),
+			},
+			},
+		}, nil)
+
+		result, err := CmdAdd(args, fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+		r, err := cni100.GetResult(result)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.CNIVersion).To(Equal("1.0.0"))
+		Expect(len(r.IPs)).To(Equal(1))
+		Expect(r.IPs[0].Address.String()).To(Equal("1.1.1.2/24"))
+
+		err = CmdDel(args, fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Context("with a missing plugin binary", func() {
+		var fakePod *v1.Pod
+		var clientInfo *k8sclient.ClientInfo
+		var fExec *fakeExec
+		var args *skel.CmdArgs
+
+		BeforeEach(func() {
+			fakePod = testhelpers.NewFakePod("testpod", "", "")
+			args = podCmdArgs(fakePod)
+
+			fExec = newFakeExec()
+			fExec.missingPlugins = map[string]bool{"missing-plugin": true}
+			fExec.addPlugin100(nil, "eth0", "", &cni100.Result{
+				CNIVersion: "1.0.0",
+				IPs: []*cni100.IPConfig{{
+					Address: *testhelpers.EnsureCIDR("1.1.1.2/24"),
+				},
+				},
+			}, nil)
+
+			clientInfo = NewFakeClientInfo()
+			_, err := clientInfo.AddNetAttachDef(
+				testhelpers.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "net1", `{
+		"name": "net1",
+		"type": "missing-plugin",
+		"cniVersion": "1.0.0"
+	}`))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("fails for a required network", func() {
+			fakePod.Annotations["k8s.v1.cni.cncf.io/networks"] = `[{"name": "net1"}]`
+			_, err := clientInfo.AddPod(fakePod)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = CmdAdd(args, fExec, clientInfo)
+			Expect(err).To(MatchError(ContainSubstring(`plugin binary "missing-plugin" not found in paths`)))
+			// net1 is never executed, the cluster network is cleaned up
+			Expect(fExec.addIndex).To(Equal(1))
+			Expect(fExec.delIndex).To(Equal(1))
+		})
+
+		It("skips an optional network", func() {
+			fakePod.Annotations["k8s.v1.cni.cncf.io/networks"] = `[{"name": "net1", "optional": true}]`
+			_, err := clientInfo.AddPod(fakePod)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = CmdAdd(args, fExec, clientInfo)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fExec.addIndex).To(Equal(1))
+
+			err = CmdDel(args, fExec, clientInfo)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fExec.delIndex).To(Equal(1))
+		})
+
+		It("logs the skipped optional network with the pod fields in the json format", func() {
+			fakePod.Annotations["k8s.v1.cni.cncf.io/networks"] = `[{"name": "net1", "optional": true}]`
+			_, err := clientInfo.AddPod(fakePod)
+			Expect(err).NotTo(HaveOccurred())
+			args = podCmdArgs(fakePod, `"logLevel": "verbose"`, `"logFormat": "json"`)
+
+			logs := captureLogs(func() {
+				_, err = CmdAdd(args, fExec, clientInfo)
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var skipped map[string]string
+			for _, line := range strings.Split(strings.TrimSpace(logs), "\n") {
+				var entry map[string]string
+				Expect(json.Unmarshal([]byte(line), &entry)).To(Succeed())
+				if strings.Contains(entry["msg"], `skipping optional network "net1"`) {
+					skipped = entry
+				}
+			}
+			Expect(skipped).NotTo(BeNil())
+			Expect(skipped).To(HaveKeyWithValue("containerID", "123456789"))
+			Expect(skipped).To(HaveKeyWithValue("podNamespace", fakePod.ObjectMeta.Namespace))
+			Expect(skipped).To(HaveKeyWithValue("podName", fakePod.ObjectMeta.Name))
+		})
+	})
+
+	It("fills in the interface sandbox left empty by a delegate with fillInterfaceSandbox", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "net1", "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod, `"fillInterfaceSandbox": true`)
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{
+			CNIVersion: "1.0.0",
+			Interfaces: []*cni100.Interface{{
+				Name:    "eth0",
+				Mac:     "0a:58:0a:f4:02:06",
+				Sandbox: testNS.Path(),
+			}},
+		}, nil)
+		// the delegate result leaves the sandbox of net1 empty
+		fExec.addPlugin100(nil, "net1", net1, &cni100.Result{
+			CNIVersion: "1.0.0",
+			Interfaces: []*cni100.Interface{{
+				Name: "net1-host",
+			}, {
+				Name: "net1",
+				Mac:  "0a:58:0a:f4:02:07",
+			}},
+			IPs: []*cni100.IPConfig{{
+				Address:   *testhelpers.EnsureCIDR("1.1.1.4/24"),
+				Interface: cni100.Int(1),
+			}},
+		}, nil)
+
+		clientInfo := newPodClientInfo(fakePod, net1)
+
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+
+		pod, err := clientInfo.GetPod(fakePod.ObjectMeta.Namespace, fakePod.ObjectMeta.Name)
+		Expect(err).NotTo(HaveOccurred())
+		netStatus, err := netutils.GetNetworkStatus(pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(len(netStatus)).To(Equal(2))
+		// the network status reports the container interface of net1
+		Expect(netStatus[1].Name).To(Equal("test/net1"))
+		Expect(netStatus[1].Interface).To(Equal("net1"))
+		Expect(netStatus[1].Mac).To(Equal("0a:58:0a:f4:02:07"))
+		Expect(netStatus[1].IPs).To(Equal([]string{"1.1.1.4"}))
+
+		err = CmdDel(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("strips link-local addresses from the returned result with stripLinkLocalFromResult", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "stripLinkLocalFromResult": true,
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`),
+		}
+
+		fExec := newFakeExec()
+		expectedResult1 := &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("1.1.1.2/24"),
+			}, {
+				Address: *testhelpers.EnsureCIDR("fe80::1/64"),
+			}, {
+				Address: *testhelpers.EnsureCIDR("169.254.1.1/16"),
+			}},
+		}
+		fExec.addPlugin100(nil, "eth0", "", expectedResult1, nil)
+
+		result, err := CmdAdd(args, fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+		r, err := cni100.GetResult(result)
+		Expect(err).NotTo(HaveOccurred())
+		// IPv4 link-local addresses are kept unless stripIPv4LinkLocalFromResult is set
+		Expect(len(r.IPs)).To(Equal(2))
+		Expect(r.IPs[0].Address.String()).To(Equal("1.1.1.2/24"))
+		Expect(r.IPs[1].Address.String()).To(Equal("169.254.1.1/16"))
+	})
+
+	It("merges and deduplicates DNS of all delegates with mergeDNS", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "mergeDNS": true,
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    },{
+	        "name": "other1",
+	        "cniVersion": "1.0.0",
+	        "type": "other-plugin"
+	    }]
+	}`),
+		}
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("1.1.1.2/24"),
+			}},
+			DNS: cnitypes.DNS{
+				Nameservers: []string{"10.0.0.10", "8.8.8.8"},
+				Domain:      "cluster.local",
+				Search:      []string{"a.local", "b.local"},
+				Options:     []string{"ndots:5"},
+			},
+		}, nil)
+		fExec.addPlugin100(nil, "net1", "", &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("1.1.1.5/24"),
+			}},
+			DNS: cnitypes.DNS{
+				Nameservers: []string{"8.8.8.8", "9.9.9.9", "4.4.4.4"},
+				Domain:      "example.com",
+				Search:      []string{"b.local", "c.local"},
+				Options:     []string{"ndots:5", "rotate"},
+			},
+		}, nil)
+
+		result, err := CmdAdd(args, fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+		r, err := cni100.GetResult(result)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.IPs[0].Address.String()).To(Equal("1.1.1.2/24"))
+		// nameservers are capped to 3 after deduplication
+		Expect(r.DNS.Nameservers).To(Equal([]string{"10.0.0.10", "8.8.8.8", "9.9.9.9"}))
+		Expect(r.DNS.Domain).To(Equal("cluster.local"))
+		Expect(r.DNS.Search).To(Equal([]string{"a.local", "b.local", "c.local"}))
+		Expect(r.DNS.Options).To(Equal([]string{"ndots:5", "rotate"}))
+	})
+
+	It("tears down networks of both the cache and the network status on DEL", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "net1", "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		net2 := `{
+		"name": "net2",
+		"type": "mynet2",
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod, fmt.Sprintf(`"cniDir": %q`, tmpDir))
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("1.1.1.2/24"),
+			}},
+		}, nil)
+		fExec.addPlugin100(nil, "net1", net1, &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("1.1.1.3/24"),
+			}},
+		}, nil)
+
+		clientInfo := newPodClientInfo(fakePod, net1, net2)
+
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addIndex).To(Equal(2))
+
+		// net2 is listed only in the network status, e.g. by an earlier partial ADD
+		pod, err := clientInfo.GetPod(fakePod.ObjectMeta.Namespace, fakePod.ObjectMeta.Name)
+		Expect(err).NotTo(HaveOccurred())
+		netStatus, err := netutils.GetNetworkStatus(pod)
+		Expect(err).NotTo(HaveOccurred())
+		netStatus = append(netStatus, nettypes.NetworkStatus{Name: "test/net2", Interface: "net2"})
+		Expect(netutils.SetNetworkStatus(clientInfo.Client, pod, netStatus)).To(Succeed())
+		fExec.addPlugin100(nil, "net2", net2, nil, nil)
+
+		err = CmdDel(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		// the default union strategy tears down all of them
+		Expect(fExec.delIndex).To(Equal(3))
+	})
+
+	Context("DEL of a terminating pod", func() {
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		net2 := `{
+		"name": "net2",
+		"type": "mynet2",
+		"cniVersion": "1.0.0"
+	}`
+		var fakePod *v1.Pod
+		var clientInfo *k8sclient.ClientInfo
+		var fExec *fakeExec
+		var args *skel.CmdArgs
+
+		BeforeEach(func() {
+			fakePod = testhelpers.NewFakePod("testpod", "net1", "")
+			args = &skel.CmdArgs{
+				ContainerID: "123456789",
+				Netns:       testNS.Path(),
+				IfName:      "eth0",
+				Args:        fmt.Sprintf("K8S_POD_NAME=%s;K8S_POD_NAMESPACE=%s", fakePod.ObjectMeta.Name, fakePod.ObjectMeta.Namespace),
+				StdinData: []byte(fmt.Sprintf(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "cniDir": %q,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`, tmpDir)),
+			}
+
+			fExec = newFakeExec()
+			fExec.addPlugin100(nil, "eth0", "", &cni100.Result{
+				CNIVersion: "1.0.0",
+				IPs: []*cni100.IPConfig{{
+					Address: *testhelpers.EnsureCIDR("1.1.1.2/24"),
+				}},
+			}, nil)
+			fExec.addPlugin100(nil, "net1", net1, &cni100.Result{
+				CNIVersion: "1.0.0",
+				IPs: []*cni100.IPConfig{{
+					Address: *testhelpers.EnsureCIDR("1.1.1.3/24"),
+				}},
+			}, nil)
+
+			clientInfo = newPodClientInfo(fakePod, net1, net2)
+
+			_, err := CmdAdd(args, fExec, clientInfo)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fExec.addIndex).To(Equal(2))
+
+			// the pod is terminating and now selects net2 instead of net1
+			pod, err := clientInfo.GetPod(fakePod.ObjectMeta.Namespace, fakePod.ObjectMeta.Name)
+			Expect(err).NotTo(HaveOccurred())
+			now := metav1.Now()
+			pod.DeletionTimestamp = &now
+			pod.Annotations["k8s.v1.cni.cncf.io/networks"] = "net2"
+			_, err = clientInfo.Client.CoreV1().Pods(pod.Namespace).Update(context.TODO(), pod, metav1.UpdateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("uses the cached networks", func() {
+			err := CmdDel(args, fExec, clientInfo)
+			Expect(err).NotTo(HaveOccurred())
+			// net1 is torn down with its cached config
+			Expect(fExec.delIndex).To(Equal(2))
+			Expect(fExec.delOrder).To(Equal([]string{"net1", "eth0"}))
+		})
+
+		It("uses the networks of the network status without cache", func() {
+			Expect(os.Remove(filepath.Join(tmpDir, args.ContainerID))).To(Succeed())
+
+			err := CmdDel(args, fExec, clientInfo)
+			Expect(err).NotTo(HaveOccurred())
+			// net1 of the network status is torn down rather than net2 of the annotation
+			Expect(fExec.delIndex).To(Equal(2))
+			Expect(fExec.delOrder).To(Equal([]string{"net1", "eth0"}))
+		})
+	})
+
+	It("syncs the readinessOutputFile with the status checks", func() {
+		indicatorFile := filepath.Join(tmpDir, "indicator")
+		outputFile := filepath.Join(tmpDir, "ready")
+		conf := types.GetDefaultNetConf()
+		conf.BinDir = tmpDir
+		conf.ReadinessIndicatorFile = indicatorFile
+		conf.ReadinessOutputFile = outputFile
+		conf.ClusterNetwork = "net1"
+
+		clientInfo := NewFakeClientInfo()
+		_, err := clientInfo.AddNetAttachDef(
+			testhelpers.NewFakeNetAttachDef("kube-system", "net1", `{"name": "net1", "type": "mynet", "cniVersion": "1.0.0"}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		// the readiness indicator file is missing
+		Expect(SyncReadinessOutputFile(conf, clientInfo)).NotTo(Succeed())
+		Expect(outputFile).NotTo(BeAnExistingFile())
+
+		Expect(os.WriteFile(indicatorFile, nil, 0644)).To(Succeed())
+		Expect(SyncReadinessOutputFile(conf, clientInfo)).To(Succeed())
+		Expect(outputFile).To(BeAnExistingFile())
+
+		// the clusterNetwork cannot be resolved anymore
+		conf.ClusterNetwork = "net2"
+		Expect(SyncReadinessOutputFile(conf, clientInfo)).To(MatchError(ContainSubstring("failed to get clusterNetwork net2")))
+		Expect(outputFile).NotTo(BeAnExistingFile())
+	})
+
+	It("looks up the delegate plugins in the binDirs in order", func() {
+		cniPath, cniPathSet := os.LookupEnv("CNI_PATH")
+		os.Unsetenv("CNI_PATH")
+		defer func() {
+			if cniPathSet {
+				os.Setenv("CNI_PATH", cniPath)
+			}
+		}()
+		binDir1 := filepath.Join(tmpDir, "bin1")
+		binDir2 := filepath.Join(tmpDir, "bin2")
+		Expect(os.Mkdir(binDir1, 0755)).To(Succeed())
+		Expect(os.Mkdir(binDir2, 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(binDir2, "second-plugin"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+
+		conf, err := types.LoadNetConf([]byte(fmt.Sprintf(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "binDirs": [%q, %q],
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`, binDir1, binDir2)))
+		Expect(err).NotTo(HaveOccurred())
+		conf.BinDir = ""
+		Expect(pluginBinDirs(conf)).To(Equal([]string{binDir1, binDir2}))
+
+		delegate, err := types.LoadDelegateNetConf([]byte(`{"name": "net1", "cniVersion": "1.0.0", "type": "second-plugin"}`), nil, "", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(checkDelegatePlugins(nil, delegate, conf)).To(Succeed())
+		path, err := invoke.FindInPath(delegate.Conf.Type, pluginBinDirs(conf))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(path).To(Equal(filepath.Join(binDir2, "second-plugin")))
+
+		delegate, err = types.LoadDelegateNetConf([]byte(`{"name": "net2", "cniVersion": "1.0.0", "type": "missing-plugin"}`), nil, "", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(checkDelegatePlugins(nil, delegate, conf)).To(MatchError(
+			fmt.Sprintf(`plugin binary "missing-plugin" not found in paths [%s %s]`, binDir1, binDir2)))
+	})
+
+	It("returns the stderr of a failed delegate with its error", func() {
+		binDir := filepath.Join(tmpDir, "bin")
+		Expect(os.Mkdir(binDir, 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(binDir, "failing-plugin"), []byte(`#!/bin/sh
+echo "failed to find the PF ens1f0" >&2
+echo '{"cniVersion": "1.0.0", "code": 7, "msg": "failed to load netconf"}'
+exit 1
+`), 0755)).To(Succeed())
+
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(fmt.Sprintf(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "binDir": %q,
+	    "cniDir": %q,
+	    "delegates": [{
+	        "name": "sriov1",
+	        "cniVersion": "1.0.0",
+	        "type": "failing-plugin"
+	    }]
+	}`, binDir, filepath.Join(tmpDir, "cniData"))),
+		}
+
+		_, err := CmdAdd(args, nil, nil)
+		Expect(err).To(MatchError(ContainSubstring(`failed to load netconf; plugin stderr: "failed to find the PF ens1f0"`)))
+
+		err = CmdDel(args, nil, nil)
+		Expect(err).To(MatchError(ContainSubstring(`plugin stderr: "failed to find the PF ens1f0"`)))
+	})
+
+	It("keeps the end of a long stderr of a failed delegate", func() {
+		pluginErr := &cnitypes.Error{Code: 7, Msg: "failed"}
+		Expect(NewStderrError(pluginErr, []byte(" \n"))).To(BeIdenticalTo(pluginErr))
+
+		stderr := strings.Repeat("x", 2000) + "the last line\n"
+		err := NewStderrError(pluginErr, []byte(stderr))
+		stderrErr, ok := err.(*StderrError)
+		Expect(ok).To(BeTrue())
+		Expect(stderrErr.Stderr).To(HaveLen(maxStderrTail + 3))
+		Expect(stderrErr.Stderr).To(HavePrefix("..."))
+		Expect(stderrErr.Stderr).To(HaveSuffix("the last line"))
+		Expect(stderrErr.Unwrap()).To(BeIdenticalTo(pluginErr))
+	})
+
+	It("fails if no CNI plugin directory exists with checkBinDirs", func() {
+		cniPath, cniPathSet := os.LookupEnv("CNI_PATH")
+		os.Setenv("CNI_PATH", filepath.Join(tmpDir, "missing2"))
+		defer func() {
+			if cniPathSet {
+				os.Setenv("CNI_PATH", cniPath)
+			} else {
+				os.Unsetenv("CNI_PATH")
+			}
+		}()
+		binDir := filepath.Join(tmpDir, "missing1")
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(fmt.Sprintf(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "binDir": %q,
+	    "checkBinDirs": true,
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`, binDir)),
+		}
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		expectedErr := fmt.Sprintf("none of the CNI plugin directories [%s %s] exists and is readable", binDir, filepath.Join(tmpDir, "missing2"))
+		_, err := CmdAdd(args, fExec, nil)
+		Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+		Expect(fExec.addIndex).To(Equal(0))
+
+		conf := types.GetDefaultNetConf()
+		conf.BinDir = binDir
+		Expect(CheckStatus(conf, nil)).To(MatchError(ContainSubstring(expectedErr)))
+
+		Expect(os.Mkdir(binDir, 0755)).To(Succeed())
+		Expect(CheckStatus(conf, nil)).To(Succeed())
+	})
+
+	It("rejects a route using a pod IP address as gateway with rejectSelfReferentialRoutes", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "rejectSelfReferentialRoutes": true,
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    },{
+	        "name": "other1",
+	        "cniVersion": "1.0.0",
+	        "type": "other-plugin"
+	    }]
+	}`),
+		}
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("1.1.1.2/24"),
+			}},
+		}, nil)
+		// the route of other1 goes through the pod IP address of weave1
+		fExec.addPlugin100(nil, "net1", "", &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("2.2.2.2/24"),
+			}},
+			Routes: []*cnitypes.Route{{
+				Dst: *testhelpers.EnsureCIDR("10.0.0.0/8"),
+				GW:  net.ParseIP("1.1.1.2"),
+			}},
+		}, nil)
+
+		_, err := CmdAdd(args, fExec, nil)
+		Expect(err).To(MatchError(ContainSubstring(`route 10.0.0.0/8 of network "other1" uses the pod IP address 1.1.1.2 of network "weave1" as gateway`)))
+		// both delegates are cleaned up
+		Expect(fExec.addIndex).To(Equal(2))
+		Expect(fExec.delIndex).To(Equal(2))
+	})
+
+	It("fails before invoking delegates if an interface already exists with checkExistingInterface", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "checkExistingInterface": true,
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    },{
+	        "name": "other1",
+	        "cniVersion": "1.0.0",
+	        "type": "other-plugin"
+	    }]
+	}`),
+		}
+
+		// net1 is left over in the pod network namespace
+		err := testNS.Do(func(_ ns.NetNS) error {
+			return netlink.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "net1"}})
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		_, err = CmdAdd(args, fExec, nil)
+		Expect(err).To(MatchError(ContainSubstring(`interface "net1" for network "other1" already exists`)))
+		Expect(fExec.addIndex).To(Equal(0))
+	})
+
+	It("fails with a clear error if the pod network namespace is gone with checkExistingInterface", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       "/var/run/netns/does-not-exist",
+			IfName:      "eth0",
+			StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "checkExistingInterface": true,
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`),
+		}
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		_, err := CmdAdd(args, fExec, nil)
+		Expect(err).To(MatchError(ContainSubstring("pod network namespace /var/run/netns/does-not-exist does not exist")))
+		Expect(fExec.addIndex).To(Equal(0))
+	})
+
+	It("adds the default network after the other networks with defaultNetworkOrder last", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "defaultNetworkOrder": "last",
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    },{
+	        "name": "mgmt1",
+	        "cniVersion": "1.0.0",
+	        "type": "mgmt-plugin"
+	    },{
+	        "name": "other1",
+	        "cniVersion": "1.0.0",
+	        "type": "other-plugin"
+	    }]
+	}`),
+		}
+
+		fExec := newFakeExec()
+		expectedResult := &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("1.1.1.2/24"),
+			}},
+		}
+		fExec.addPlugin100(nil, "eth0", "", expectedResult, nil)
+		fExec.addPlugin100(nil, "net1", "", &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("2.2.2.2/24"),
+			}},
+		}, nil)
+		fExec.addPlugin100(nil, "net2", "", &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("3.3.3.3/24"),
+			}},
+		}, nil)
+
+		result, err := CmdAdd(args, fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addOrder).To(Equal([]string{"net1", "net2", "eth0"}))
+		// the default network still owns the result
+		Expect(reflect.DeepEqual(result, expectedResult)).To(BeTrue())
+
+		err = CmdDel(args, fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.delOrder).To(Equal([]string{"eth0", "net2", "net1"}))
+	})
+
+	Context("default network precedence", func() {
+		net1 := `{
+		"name": "net1",
+		"type": "mynet1",
+		"cniVersion": "1.0.0"
+	}`
+		net2 := `{
+		"name": "net2",
+		"type": "mynet2",
+		"cniVersion": "1.0.0"
+	}`
+		weave1 := `{
+		"name": "weave1",
+		"type": "weave-net",
+		"cniVersion": "1.0.0"
+	}`
+		var fakePod *v1.Pod
+		var fKubeClient *k8sclient.ClientInfo
+
+		BeforeEach(func() {
+			fakePod = testhelpers.NewFakePod("testpod", "", "")
+			fKubeClient = NewFakeClientInfo()
+			_, err := fKubeClient.AddPod(fakePod)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = fKubeClient.AddNetAttachDef(testhelpers.NewFakeNetAttachDef("kube-system", "net1", net1))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = fKubeClient.AddNetAttachDef(testhelpers.NewFakeNetAttachDef("kube-system", "net2", net2))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		cmdArgs := func(conf string) *skel.CmdArgs {
+			return &skel.CmdArgs{
+				ContainerID: "123456789",
+				Netns:       testNS.Path(),
+				IfName:      "eth0",
+				Args:        fmt.Sprintf("K8S_POD_NAME=%s;K8S_POD_NAMESPACE=%s", fakePod.ObjectMeta.Name, fakePod.ObjectMeta.Namespace),
+				StdinData:   []byte(conf),
+			}
+		}
+
+		It("uses clusterNetwork as master when set", func() {
+			args := cmdArgs(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "clusterNetwork": "net1",
+	    "defaultNetworks": ["net2"]
+	}`)
+			fExec := newFakeExec()
+			fExec.addPlugin100(nil, "eth0", net1, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+			fExec.addPlugin100(nil, "net1", net2, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+			_, err := CmdAdd(args, fExec, fKubeClient)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fExec.addIndex).To(Equal(2))
+		})
+
+		It("adds clusterNetwork, then defaultNetworks in order, then the networks of the pod", func() {
+			net3 := `{
+		"name": "net3",
+		"type": "mynet3",
+		"cniVersion": "1.0.0"
+	}`
+			podNet := `{
+		"name": "podnet",
+		"type": "mypodnet",
+		"cniVersion": "1.0.0"
+	}`
+			_, err := fKubeClient.AddNetAttachDef(testhelpers.NewFakeNetAttachDef("kube-system", "net3", net3))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = fKubeClient.AddNetAttachDef(testhelpers.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "podnet", podNet))
+			Expect(err).NotTo(HaveOccurred())
+			fakePod.ObjectMeta.Annotations = map[string]string{"k8s.v1.cni.cncf.io/networks": "podnet"}
+			_, err = fKubeClient.Client.CoreV1().Pods(fakePod.ObjectMeta.Namespace).Update(context.TODO(), fakePod, metav1.UpdateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			args := cmdArgs(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "clusterNetwork": "net1",
+	    "defaultNetworks": ["net3", "net2"]
+	}`)
+			fExec := newFakeExec()
+			fExec.addPlugin100(nil, "eth0", net1, &cni100.Result{
+				CNIVersion: "1.0.0",
+				IPs:        []*cni100.IPConfig{{Address: *testhelpers.EnsureCIDR("10.0.0.2/24")}},
+			}, nil)
+			fExec.addPlugin100(nil, "net1", net3, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+			fExec.addPlugin100(nil, "net2", net2, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+			fExec.addPlugin100(nil, "net3", podNet, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+			result, err := CmdAdd(args, fExec, fKubeClient)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fExec.addOrder).To(Equal([]string{"eth0", "net1", "net2", "net3"}))
+			// clusterNetwork owns the result
+			Expect(result.(*cni100.Result).IPs[0].Address.String()).To(Equal("10.0.0.2/24"))
+		})
+
+		It("uses the first defaultNetworks entry as master without clusterNetwork", func() {
+			args := cmdArgs(fmt.Sprintf(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "defaultNetworks": ["net2"],
+	    "delegates": [%s]
+	}`, weave1))
+			fExec := newFakeExec()
+			fExec.addPlugin100(nil, "eth0", net2, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+			fExec.addPlugin100(nil, "net1", weave1, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+			_, err := CmdAdd(args, fExec, fKubeClient)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fExec.addIndex).To(Equal(2))
+		})
+
+		It("uses the first delegate as master without clusterNetwork and defaultNetworks", func() {
+			args := cmdArgs(fmt.Sprintf(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "delegates": [%s, %s]
+	}`, weave1, net1))
+			fExec := newFakeExec()
+			fExec.addPlugin100(nil, "eth0", weave1, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+			fExec.addPlugin100(nil, "net1", net1, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+			_, err := CmdAdd(args, fExec, fKubeClient)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fExec.addIndex).To(Equal(2))
+		})
+
+		It("uses the default network named by masterPlugin as master", func() {
+			args := cmdArgs(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "clusterNetwork": "net1",
+	    "defaultNetworks": ["net2"],
+	    "masterPlugin": "net2"
+	}`)
+			fExec := newFakeExec()
+			fExec.addPlugin100(nil, "eth0", net2, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+			fExec.addPlugin100(nil, "net1", net1, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+			_, err := CmdAdd(args, fExec, fKubeClient)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fExec.addIndex).To(Equal(2))
+		})
+
+		It("fails if masterPlugin is not one of the default networks", func() {
+			args := cmdArgs(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "clusterNetwork": "net1",
+	    "defaultNetworks": ["net2"],
+	    "masterPlugin": "net3"
+	}`)
+			fExec := newFakeExec()
+
+			_, err := CmdAdd(args, fExec, fKubeClient)
+			Expect(err).To(MatchError(ContainSubstring(`masterPlugin "net3" is not one of the default networks`)))
+			Expect(fExec.addIndex).To(Equal(0))
+		})
+
+		It("attaches the networks of the pod priority class with priorityClassNetworks", func() {
+			conf := `{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "clusterNetwork": "net1",
+	    "priorityClassNetworks": {"high-priority": ["net2"]}
+	}`
+			fakePod.Spec.PriorityClassName = "high-priority"
+			_, err := fKubeClient.Client.CoreV1().Pods(fakePod.Namespace).Update(context.TODO(), fakePod, metav1.UpdateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			fExec := newFakeExec()
+			fExec.addPlugin100(nil, "eth0", net1, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+			fExec.addPlugin100(nil, "net1", net2, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+			_, err = CmdAdd(cmdArgs(conf), fExec, fKubeClient)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fExec.addOrder).To(Equal([]string{"eth0", "net1"}))
+
+			// a pod of another priority class only gets the cluster network
+			fakePod.Spec.PriorityClassName = "low-priority"
+			_, err = fKubeClient.Client.CoreV1().Pods(fakePod.Namespace).Update(context.TODO(), fakePod, metav1.UpdateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			fExec = newFakeExec()
+			fExec.addPlugin100(nil, "eth0", net1, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+			_, err = CmdAdd(cmdArgs(conf), fExec, fKubeClient)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fExec.addOrder).To(Equal([]string{"eth0"}))
+		})
+	})
+
+	It("logs delegates of a type at the level of delegateLogLevels", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "logLevel": "error",
+	    "delegateLogLevels": {"other-plugin": "debug"},
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    },{
+	        "name": "other1",
+	        "cniVersion": "1.0.0",
+	        "type": "other-plugin"
+	    }]
+	}`),
+		}
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		var err error
+		logs := captureLogs(func() {
+			_, err = CmdAdd(args, fExec, nil)
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(logs).To(MatchRegexp(`\[debug\] DelegateAdd: .*other-plugin`))
+		Expect(logs).NotTo(MatchRegexp(`\[debug\] DelegateAdd: .*weave-net`))
+		// the global level applies outside of the delegates
+		Expect(logs).NotTo(ContainSubstring("[debug] getIfname"))
+	})
+
+	It("rejects or clears result IPs with an out of range interface index with invalidInterfaceIndexAction", func() {
+		conf := `{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "invalidInterfaceIndexAction": "%s",
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`
+		newResult := func() *cni100.Result {
+			return &cni100.Result{
+				CNIVersion: "1.0.0",
+				Interfaces: []*cni100.Interface{{
+					Name:    "eth0",
+					Sandbox: testNS.Path(),
+				}},
+				IPs: []*cni100.IPConfig{{
+					Address:   *testhelpers.EnsureCIDR("1.1.1.2/24"),
+					Interface: cni100.Int(0),
+				}, {
+					Address:   *testhelpers.EnsureCIDR("1.1.1.3/24"),
+					Interface: cni100.Int(2),
+				}},
+			}
+		}
+
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData:   []byte(fmt.Sprintf(conf, "reject")),
+		}
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", newResult(), nil)
+
+		_, err := CmdAdd(args, fExec, nil)
+		Expect(err).To(MatchError(ContainSubstring(`invalid result of network "weave1": IP address 1.1.1.3/24 references interface index 2 but the result has 1 interfaces`)))
+		Expect(fExec.delIndex).To(Equal(1))
+
+		args.StdinData = []byte(fmt.Sprintf(conf, "clear"))
+		fExec = newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", newResult(), nil)
+
+		result, err := CmdAdd(args, fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+		r := result.(*cni100.Result)
+		Expect(len(r.IPs)).To(Equal(2))
+		Expect(*r.IPs[0].Interface).To(Equal(0))
+		Expect(r.IPs[1].Interface).To(BeNil())
+	})
+
+	It("strips default routes of the families not in defaultRouteFamilies", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "defaultRouteFamilies": ["v4"],
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`),
+		}
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("1.1.1.2/24"),
+			}, {
+				Address: *testhelpers.EnsureCIDR("2001::2/64"),
+			}},
+			Routes: []*cnitypes.Route{{
+				Dst: *testhelpers.EnsureCIDR("0.0.0.0/0"),
+				GW:  net.ParseIP("1.1.1.1"),
+			}, {
+				Dst: *testhelpers.EnsureCIDR("::/0"),
+				GW:  net.ParseIP("2001::1"),
+			}, {
+				Dst: *testhelpers.EnsureCIDR("2002::/64"),
+				GW:  net.ParseIP("2001::1"),
+			}},
+		}, nil)
+
+		result, err := CmdAdd(args, fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+		r := result.(*cni100.Result)
+		Expect(len(r.IPs)).To(Equal(2))
+		Expect(len(r.Routes)).To(Equal(2))
+		Expect(r.Routes[0].Dst.String()).To(Equal("0.0.0.0/0"))
+		Expect(r.Routes[1].Dst.String()).To(Equal("2002::/64"))
+	})
+
+	It("fails and cleans up if the result MAC differs from the requested one with verifyRequestedMAC", func() {
+		fakePod := testhelpers.NewFakePod("testpod", `[{"name":"net1","mac":"c2:11:22:33:44:66"}]`, "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"capabilities": {"mac": true},
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod, `"verifyRequestedMAC": true`)
+
+		clientInfo := newPodClientInfo(fakePod, net1)
+
+		addPlugins := func(mac string) *fakeExec {
+			fExec := newFakeExec()
+			fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+			fExec.addPlugin100(nil, "net1", "", &cni100.Result{
+				CNIVersion: "1.0.0",
+				Interfaces: []*cni100.Interface{{
+					Name:    "net1",
+					Mac:     mac,
+					Sandbox: testNS.Path(),
+				}},
+			}, nil)
+			return fExec
+		}
+
+		fExec := addPlugins("C2:11:22:33:44:66")
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+
+		fExec = addPlugins("c2:11:22:33:44:77")
+		_, err = CmdAdd(args, fExec, clientInfo)
+		Expect(err).To(MatchError(ContainSubstring(`network "net1": interface net1 has MAC address c2:11:22:33:44:77 but c2:11:22:33:44:66 was requested`)))
+		Expect(fExec.addIndex).To(Equal(2))
+		Expect(fExec.delIndex).To(Equal(2))
+	})
+
+	It("passes the normalized requested MAC in the runtimeConfig and rejects a multicast one", func() {
+		fakePod := testhelpers.NewFakePod("testpod", `[{"name":"net1","mac":"C2-11-22-33-44-66"}]`, "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"capabilities": {"mac": true},
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod)
+
+		clientInfo := newPodClientInfo(fakePod, net1)
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", `{
+		"name": "net1",
+		"type": "mynet",
+		"capabilities": {"mac": true},
+		"runtimeConfig": {"mac": "c2:11:22:33:44:66"},
+		"cniVersion": "1.0.0"
+	}`, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addIndex).To(Equal(2))
+
+		fakePod.Annotations["k8s.v1.cni.cncf.io/networks"] = `[{"name":"net1","mac":"01:00:5e:00:00:01"}]`
+		_, err = clientInfo.Client.CoreV1().Pods(fakePod.Namespace).Update(context.TODO(), fakePod, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		fExec = newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		_, err = CmdAdd(args, fExec, clientInfo)
+		Expect(err).To(MatchError(ContainSubstring(`invalid mac "01:00:5e:00:00:01" of network test/net1: must not be a multicast or broadcast address`)))
+		Expect(fExec.addIndex).To(Equal(0))
+	})
+
+	It("passes the static IP addresses of the pod in the staticIPPool configmap", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "net1,net2", "")
+		netConf := `{
+		"name": %q,
+		"type": "mynet",
+		"capabilities": {"ips": true},
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod, `"staticIPPool": {"configMap": "static-ips", "namespace": "kube-system", "network": "net2"}`)
+
+		clientInfo := newPodClientInfo(fakePod, fmt.Sprintf(netConf, "net1"), fmt.Sprintf(netConf, "net2"))
+		_, err := clientInfo.Client.CoreV1().ConfigMaps("kube-system").Create(context.TODO(), &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "static-ips", Namespace: "kube-system"},
+			Data: map[string]string{
+				"testpod":   "10.1.1.5/24, 2001:db8::5/64",
+				"appliance": "10.1.1.6/24",
+				"invalid":   "10.1.1.300",
+			},
+		}, metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		addPlugins := func(ips string) *fakeExec {
+			fExec := newFakeExec()
+			fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+			fExec.addPlugin100(nil, "net1", fmt.Sprintf(netConf, "net1"), &cni100.Result{CNIVersion: "1.0.0"}, nil)
+			fExec.addPlugin100(nil, "net2", fmt.Sprintf(`{
+		"name": "net2",
+		"type": "mynet",
+		"capabilities": {"ips": true},
+		"runtimeConfig": {"ips": %s},
+		"cniVersion": "1.0.0"
+	}`, ips), &cni100.Result{CNIVersion: "1.0.0"}, nil)
+			return fExec
+		}
+
+		// by pod name
+		fExec := addPlugins(`["10.1.1.5/24", "2001:db8::5/64"]`)
+		_, err = CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addIndex).To(Equal(3))
+
+		// by ip-claim annotation
+		fakePod.Annotations["k8s.v1.cni.cncf.io/ip-claim"] = "appliance"
+		_, err = clientInfo.Client.CoreV1().Pods(fakePod.Namespace).Update(context.TODO(), fakePod, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		fExec = addPlugins(`["10.1.1.6/24"]`)
+		_, err = CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addIndex).To(Equal(3))
+
+		// missing or invalid entries fail before any network is added
+		for claim, expectedErr := range map[string]string{
+			"missing": `GetStaticIPs: no IP address for pod test/testpod with key "missing" in the configmap kube-system/static-ips`,
+			"invalid": `GetStaticIPs: invalid IP address "10.1.1.300" of key "invalid" in the configmap kube-system/static-ips, must be an IP address or a CIDR`,
+		} {
+			fakePod.Annotations["k8s.v1.cni.cncf.io/ip-claim"] = claim
+			_, err = clientInfo.Client.CoreV1().Pods(fakePod.Namespace).Update(context.TODO(), fakePod, metav1.UpdateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			fExec = addPlugins(`[]`)
+			_, err = CmdAdd(args, fExec, clientInfo)
+			Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+			Expect(fExec.addIndex).To(Equal(0))
+		}
+	})
+
+	It("passes the requested CIDR but not the default-route in the runtimeConfig", func() {
+		fakePod := testhelpers.NewFakePod("testpod", `[{"name":"net1","ips":["10.1.1.5/24"],"default-route":["10.1.1.1"]}]`, "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"capabilities": {"ips": true, "gateway": true},
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod)
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", `{
+		"name": "net1",
+		"type": "mynet",
+		"capabilities": {"ips": true, "gateway": true},
+		"runtimeConfig": {
+			"ips": ["10.1.1.5/24"]
+		},
+		"cniVersion": "1.0.0"
+	}`, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		clientInfo := newPodClientInfo(fakePod, net1)
+
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addIndex).To(Equal(len(fExec.plugins)))
+	})
+
+	It("passes the requested gateway in the runtimeConfig", func() {
+		fakePod := testhelpers.NewFakePod("testpod", `[{"name":"net1","gateway":["10.1.1.1","fd00::1"]},{"name":"net2","gateway":["10.2.2.1"]}]`, "")
+		netConf := `{
+		"name": %q,
+		"type": "mynet",
+		"capabilities": {"gateway": %t},
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod)
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", `{
+		"name": "net1",
+		"type": "mynet",
+		"capabilities": {"gateway": true},
+		"runtimeConfig": {"gateway": ["10.1.1.1", "fd00::1"]},
+		"cniVersion": "1.0.0"
+	}`, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		// not passed to the plugins without the capability
+		fExec.addPlugin100(nil, "net2", fmt.Sprintf(netConf, "net2", false), &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		clientInfo := newPodClientInfo(fakePod)
+		_, err := clientInfo.AddNetAttachDef(
+			testhelpers.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "net1", fmt.Sprintf(netConf, "net1", true)))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = clientInfo.AddNetAttachDef(
+			testhelpers.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, "net2", fmt.Sprintf(netConf, "net2", false)))
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addIndex).To(Equal(len(fExec.plugins)))
+	})
+
+	It("fails before adding any network when the requested gateway is invalid", func() {
+		fakePod := testhelpers.NewFakePod("testpod", `[{"name":"net1","gateway":["10.1.1.1/24"]}]`, "")
+		args := podCmdArgs(fakePod)
+
+		fExec := newFakeExec()
+		clientInfo := newPodClientInfo(fakePod)
+
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).To(MatchError(ContainSubstring(`invalid gateway "10.1.1.1/24" of network test/net1, must be an IP address`)))
+		Expect(fExec.addIndex).To(Equal(0))
+	})
+
+	It("detects a result with another number of IPs than requested with verifyRequestedIPCount", func() {
+		fakePod := testhelpers.NewFakePod("testpod", `[{"name":"net1","ips":["10.1.1.5/24","fd00::5/64"]}]`, "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"capabilities": {"ips": true},
+		"cniVersion": "1.0.0"
+	}`
+		conf := `{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "verifyRequestedIPCount": true,
+	    "requestedIPCountFatal": %t,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			Args:        fmt.Sprintf("K8S_POD_NAME=%s;K8S_POD_NAMESPACE=%s", fakePod.ObjectMeta.Name, fakePod.ObjectMeta.Namespace),
+			StdinData:   []byte(fmt.Sprintf(conf, true)),
+		}
+
+		clientInfo := newPodClientInfo(fakePod, net1)
+
+		addPlugins := func(addresses ...string) *fakeExec {
+			fExec := newFakeExec()
+			fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+			result := &cni100.Result{CNIVersion: "1.0.0"}
+			for _, address := range addresses {
+				result.IPs = append(result.IPs, &cni100.IPConfig{Address: *testhelpers.EnsureCIDR(address)})
+			}
+			fExec.addPlugin100(nil, "net1", "", result, nil)
+			return fExec
+		}
+
+		fExec := addPlugins("10.1.1.5/24", "fd00::5/64")
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+
+		fExec = addPlugins("10.1.1.5/24")
+		_, err = CmdAdd(args, fExec, clientInfo)
+		Expect(err).To(MatchError(ContainSubstring(`network "net1": 2 IP addresses [10.1.1.5/24 fd00::5/64] were requested but the result has 1 [10.1.1.5/24]`)))
+		Expect(fExec.addIndex).To(Equal(2))
+		Expect(fExec.delIndex).To(Equal(2))
+
+		// only a warning without requestedIPCountFatal
+		args.StdinData = []byte(fmt.Sprintf(conf, false))
+		fExec = addPlugins("10.1.1.5/24")
+		_, err = CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.delIndex).To(Equal(0))
+	})
+
+	It("writes the network status once per ADD with statusWriteMode single", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "net1,net2", "")
+		conf := `{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "statusWriteMode": "%s",
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`
+
+		statusWrites := func(mode string) int {
+			clientInfo := newPodClientInfo(fakePod)
+			for _, name := range []string{"net1", "net2"} {
+				_, err := clientInfo.AddNetAttachDef(testhelpers.NewFakeNetAttachDef(fakePod.ObjectMeta.Namespace, name,
+					fmt.Sprintf(`{"name": "%s", "type": "mynet", "cniVersion": "1.0.0"}`, name)))
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			args := &skel.CmdArgs{
+				ContainerID: "123456789",
+				Netns:       testNS.Path(),
+				IfName:      "eth0",
+				Args:        fmt.Sprintf("K8S_POD_NAME=%s;K8S_POD_NAMESPACE=%s", fakePod.ObjectMeta.Name, fakePod.ObjectMeta.Namespace),
+				StdinData:   []byte(fmt.Sprintf(conf, mode)),
+			}
+			fExec := newFakeExec()
+			fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+			fExec.addPlugin100(nil, "net1", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+			fExec.addPlugin100(nil, "net2", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+			_, err := CmdAdd(args, fExec, clientInfo)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fExec.addIndex).To(Equal(3))
+
+			writes := 0
+			for _, action := range clientInfo.Client.(*fake.Clientset).Actions() {
+				if action.Matches("update", "pods") && action.GetSubresource() == "status" {
+					writes++
+				}
+			}
+			return writes
+		}
+
+		Expect(statusWrites("single")).To(Equal(1))
+		Expect(statusWrites("incremental")).To(Equal(3))
+	})
+
+	Context("with maxConcurrentDelegates", func() {
+		conf := `{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "maxConcurrentDelegates": 3,
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    },{
+	        "name": "other1",
+	        "cniVersion": "1.0.0",
+	        "type": "other-plugin"
+	    },{
+	        "name": "other2",
+	        "cniVersion": "1.0.0",
+	        "type": "other-plugin"
+	    },{
+	        "name": "other3",
+	        "cniVersion": "1.0.0",
+	        "type": "other-plugin"
+	    }]
+	}`
+		var args *skel.CmdArgs
+		BeforeEach(func() {
+			args = &skel.CmdArgs{
+				ContainerID: "123456789",
+				Netns:       testNS.Path(),
+				IfName:      "eth0",
+				StdinData:   []byte(conf),
+			}
+		})
+		masterResult := &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("1.1.1.2/24"),
+			}},
+		}
+
+		addPlugins := func(failingIfNames ...string) *fakeExec {
+			fExec := newFakeExec()
+			fExec.addPlugin100(nil, "eth0", "", masterResult, nil)
+			barrier := &sync.WaitGroup{}
+			barrier.Add(3)
+			for i, ifName := range []string{"net1", "net2", "net3"} {
+				var err error
+				for _, failingIfName := range failingIfNames {
+					if ifName == failingIfName {
+						err = fmt.Errorf("expected plugin failure of %s", ifName)
+					}
+				}
+				fExec.addPlugin100(nil, ifName, "", &cni100.Result{
+					CNIVersion: "1.0.0",
+					IPs: []*cni100.IPConfig{{
+						Address: *testhelpers.EnsureCIDR(fmt.Sprintf("2.2.2.%d/24", i+2)),
+					}},
+				}, err)
+				fExec.plugins[ifName].barrier = barrier
+			}
+			return fExec
+		}
+
+		It("adds the master first and returns its result", func() {
+			fExec := addPlugins()
+			result, err := CmdAdd(args, fExec, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fExec.addIndex).To(Equal(4))
+			Expect(fExec.addOrder[0]).To(Equal("eth0"))
+			Expect(fExec.addOrder[1:]).To(ConsistOf("net1", "net2", "net3"))
+			Expect(reflect.DeepEqual(result, masterResult)).To(BeTrue())
+		})
+
+		It("tears down all the networks in reverse order on a failure", func() {
+			fExec := addPlugins("net2")
+			_, err := CmdAdd(args, fExec, nil)
+			Expect(err).To(MatchError(ContainSubstring("expected plugin failure")))
+			Expect(fExec.addIndex).To(Equal(4))
+			Expect(fExec.delOrder).To(Equal([]string{"net3", "net2", "net1", "eth0"}))
+		})
+
+		It("logs the concurrent delegates at their own delegateLogLevels level", func() {
+			args.StdinData = []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "logLevel": "verbose",
+	    "maxConcurrentDelegates": 2,
+	    "delegateLogLevels": {"other-plugin": "debug", "quiet-plugin": "error"},
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    },{
+	        "name": "other1",
+	        "cniVersion": "1.0.0",
+	        "type": "other-plugin"
+	    },{
+	        "name": "quiet1",
+	        "cniVersion": "1.0.0",
+	        "type": "quiet-plugin"
+	    }]
+	}`)
+			fExec := newFakeExec()
+			fExec.addPlugin100(nil, "eth0", "", masterResult, nil)
+			// both delegates run at the same time
+			barrier := &sync.WaitGroup{}
+			barrier.Add(2)
+			for _, ifName := range []string{"net1", "net2"} {
+				fExec.addPlugin100(nil, ifName, "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+				fExec.plugins[ifName].barrier = barrier
+			}
+
+			var err error
+			logs := captureLogs(func() {
+				_, err = CmdAdd(args, fExec, nil)
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(logs).To(MatchRegexp(`\[debug\] DelegateAdd: .*other-plugin`))
+			Expect(logs).To(MatchRegexp(`\[verbose\] Add: .*:other1\(other1\):net1`))
+			Expect(logs).NotTo(MatchRegexp(`\[debug\] DelegateAdd: .*quiet-plugin`))
+			Expect(logs).NotTo(MatchRegexp(`\[verbose\] Add: .*:quiet1\(quiet1\):net2`))
+			// the global level applies to the delegates without their own level
+			Expect(logs).To(MatchRegexp(`\[verbose\] Add: .*:weave1\(weave1\):eth0`))
+			Expect(logs).NotTo(MatchRegexp(`\[debug\] DelegateAdd: .*weave-net`))
+		})
+
+		It("returns the errors of all the failing networks", func() {
+			fExec := addPlugins("net1", "net3")
+			_, err := CmdAdd(args, fExec, nil)
+			Expect(err).To(MatchError(ContainSubstring("2 delegates failed: ")))
+			Expect(err).To(MatchError(ContainSubstring(`delegate 1 ("other1"): [//:other1]: error adding container to network "other1": expected plugin failure of net1`)))
+			Expect(err).To(MatchError(ContainSubstring(`delegate 3 ("other3"): [//:other3]: error adding container to network "other3": expected plugin failure of net3`)))
+			Expect(fExec.delOrder).To(Equal([]string{"net3", "net2", "net1", "eth0"}))
+		})
+	})
+
+	It("fails and cleans up when a delegate exceeds delegateTimeoutSeconds", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "delegateTimeoutSeconds": 1,
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    },{
+	        "name": "other1",
+	        "cniVersion": "1.0.0",
+	        "type": "other-plugin"
+	    }]
+	}`),
+		}
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.plugins["net1"].hang = true
+
+		_, err := CmdAdd(args, fExec, nil)
+		Expect(err).To(MatchError(ContainSubstring(`delegate "other1" (index 1) timed out after 1 seconds`)))
+		Expect(fExec.addIndex).To(Equal(2))
+		Expect(fExec.delOrder).To(Equal([]string{"net1", "eth0"}))
+	})
+
+	It("reports the resolved delegates in an event with the debug-delegates annotation", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "net1", "")
+		fakePod.Annotations["k8s.v1.cni.cncf.io/debug-delegates"] = "true"
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod)
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", net1, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		clientInfo := newPodClientInfo(fakePod, net1)
+
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+
+		recorder := clientInfo.EventRecorder.(*record.FakeRecorder)
+		events := collectEvents(recorder.Events)
+		Expect(events).To(ContainElement(
+			"Normal DelegatesResolved weave1(type: weave-net, ifname: eth0), test/net1(type: mynet, ifname: net1)"))
+	})
+
+	It("normalizes a result with both ip4 and ips to the fields of its version", func() {
+		conf := `{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "cniVersion": "%[1]s",
+	    "logLevel": "verbose",
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "%[1]s",
+	        "type": "weave-net"
+	    }]
+	}`
+		hybridResult := `{
+	    "ip4": {"ip": "1.1.1.2/24"},
+	    "ips": [{"address": "9.9.9.9/24"}]
+	}`
+
+		addHybrid := func(cniVersion string) (*cni100.Result, string) {
+			args := &skel.CmdArgs{
+				ContainerID: "123456789",
+				Netns:       testNS.Path(),
+				IfName:      "eth0",
+				StdinData:   []byte(fmt.Sprintf(conf, cniVersion)),
+			}
+			fExec := newFakeExec()
+			fExec.addPlugin100(nil, "eth0", "", nil, nil)
+			fExec.plugins["eth0"].rawResult = hybridResult
+
+			var result cnitypes.Result
+			var err error
+			logs := captureLogs(func() {
+				result, err = CmdAdd(args, fExec, nil)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Version()).To(Equal(cniVersion))
+
+			res, err := cni100.NewResultFromResult(result)
+			Expect(err).NotTo(HaveOccurred())
+			return res, logs
+		}
+
+		res, logs := addHybrid("0.2.0")
+		Expect(res.IPs).To(HaveLen(1))
+		Expect(res.IPs[0].Address.String()).To(Equal("1.1.1.2/24"))
+		Expect(logs).To(ContainSubstring("warning: result of /opt/cni/bin/weave-net has different addresses in ip4/ip6 [1.1.1.2/24] and ips [9.9.9.9/24], using the ip4/ip6 of version 0.2.0"))
+
+		res, logs = addHybrid("1.0.0")
+		Expect(res.IPs).To(HaveLen(1))
+		Expect(res.IPs[0].Address.String()).To(Equal("9.9.9.9/24"))
+		Expect(logs).To(ContainSubstring("using the ips of version 1.0.0"))
+	})
+
+	It("reports STATUS not ready until the readiness indicator file exists and the default network is ready", func() {
+		readinessFile := filepath.Join(tmpDir, "ready")
+		args := &skel.CmdArgs{
+			StdinData: []byte(fmt.Sprintf(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "cniVersion": "1.0.0",
+	    "binDir": "%s",
+	    "readinessindicatorfile": "%s",
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.1.0",
+	        "type": "weave-net"
+	    }]
+	}`, tmpDir, readinessFile)),
+		}
+		fExec := newFakeExec()
+		fKubeClient := NewFakeClientInfo()
+
+		err := CmdStatus(args, fExec, fKubeClient)
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*cnitypes.Error).Code).To(Equal(uint(50)))
+		Expect(fExec.statusIndex).To(Equal(0))
+
+		Expect(os.WriteFile(readinessFile, []byte(""), 0600)).To(Succeed())
+		Expect(CmdStatus(args, fExec, fKubeClient)).To(Succeed())
+		Expect(fExec.statusIndex).To(Equal(1))
+
+		// the default network plugin is not ready
+		fExec.statusErr = cnitypes.NewError(50, "weave is not ready", "")
+		err = CmdStatus(args, fExec, fKubeClient)
+		Expect(err).To(MatchError("weave is not ready"))
+		Expect(err.(*cnitypes.Error).Code).To(Equal(uint(50)))
+
+		fExec.statusErr = nil
+		Expect(os.Remove(readinessFile)).To(Succeed())
+		err = CmdStatus(args, fExec, fKubeClient)
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*cnitypes.Error).Code).To(Equal(uint(50)))
+	})
+
+	It("runs ADD again after cleaning up on a recoverable error with cmdAddRetries", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "", "")
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			Args:        fmt.Sprintf("K8S_POD_NAME=%s;K8S_POD_NAMESPACE=%s", fakePod.ObjectMeta.Name, fakePod.ObjectMeta.Namespace),
+			StdinData: []byte(fmt.Sprintf(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "cniDir": "%s",
+	    "cmdAddRetries": 1,
+	    "cmdAddRetryOn": ["apiserver"],
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    },{
+	        "name": "other1",
+	        "cniVersion": "1.0.0",
+	        "type": "other-plugin"
+	    }]
+	}`, tmpDir)),
+		}
+
+		fExec := newFakeExec()
+		fExec.readd = true
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		clientInfo := newPodClientInfo(fakePod)
+		// the first network status write hits an API server blip
+		statusWrites := 0
+		clientInfo.Client.(*fake.Clientset).PrependReactor("update", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() != "status" {
+				return false, nil, nil
+			}
+			statusWrites++
+			if statusWrites == 1 {
+				return true, nil, errors.NewServiceUnavailable("apiserver is unavailable")
+			}
+			return false, nil, nil
+		})
+
+		deletes := func() float64 {
+			var total float64
+			for _, result := range []string{"success", "failure"} {
+				metric := &dto.Metric{}
+				Expect(commandsTotal.WithLabelValues("DEL", result).Write(metric)).To(Succeed())
+				total += metric.GetCounter().GetValue()
+			}
+			return total
+		}
+		deletesBefore := deletes()
+
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addOrder).To(Equal([]string{"eth0", "net1", "eth0", "net1"}))
+		// the networks of the failed attempt were torn down before the retry
+		Expect(fExec.delOrder).To(Equal([]string{"net1", "eth0"}))
+		// which is not counted as a DEL command of the runtime
+		Expect(deletes()).To(Equal(deletesBefore))
+		Expect(statusWrites).To(BeNumerically(">=", 2))
+		Expect(filepath.Join(tmpDir, "123456789")).To(BeAnExistingFile())
+	})
+
+	It("converts a 0.4.0 master result to the 1.0.0 multus cniVersion", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "cniVersion": "1.0.0",
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "0.4.0",
+	        "type": "weave-net"
+	    }]
+	}`),
+		}
+
+		fExec := newFakeExec()
+		expectedConf1 := `{
+	    "name": "weave1",
+	    "cniVersion": "0.4.0",
+	    "type": "weave-net"
+	}`
+		fExec.addPlugin040(nil, "eth0", expectedConf1, &cni040.Result{
+			CNIVersion: "0.4.0",
+			IPs: []*cni040.IPConfig{{
+				Version: "4",
+				Address: *testhelpers.EnsureCIDR("1.1.1.2/24"),
+			}},
+		}, nil)
+
+		result, err := CmdAdd(args, fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addIndex).To(Equal(len(fExec.plugins)))
+		Expect(result.Version()).To(Equal("1.0.0"))
+		r := result.(*cni100.Result)
+		Expect(r.IPs).To(HaveLen(1))
+		Expect(r.IPs[0].Address.String()).To(Equal("1.1.1.2/24"))
+	})
+
+	It("returns the result at the highest delegate cniVersion without a multus cniVersion", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "0.4.0",
+	        "type": "weave-net"
+	    },{
+	        "name": "other1",
+	        "cniVersion": "1.0.0",
+	        "type": "other-plugin"
+	    }]
+	}`),
+		}
+
+		fExec := newFakeExec()
+		expectedConf1 := `{
+	    "name": "weave1",
+	    "cniVersion": "0.4.0",
+	    "type": "weave-net"
+	}`
+		fExec.addPlugin040(nil, "eth0", expectedConf1, &cni040.Result{
+			CNIVersion: "0.4.0",
+			IPs: []*cni040.IPConfig{{
+				Version: "4",
+				Address: *testhelpers.EnsureCIDR("1.1.1.2/24"),
+			}},
+		}, nil)
+		expectedConf2 := `{
+	    "name": "other1",
+	    "cniVersion": "1.0.0",
+	    "type": "other-plugin"
+	}`
+		fExec.addPlugin100(nil, "net1", expectedConf2, &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("1.1.1.5/24"),
+			}},
+		}, nil)
+
+		result, err := CmdAdd(args, fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addIndex).To(Equal(len(fExec.plugins)))
+		Expect(result.Version()).To(Equal("1.0.0"))
+		r := result.(*cni100.Result)
+		Expect(r.IPs).To(HaveLen(1))
+		Expect(r.IPs[0].Address.String()).To(Equal("1.1.1.2/24"))
+	})
+
+	It("returns the result at the highest cniVersion supported by all the delegates", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "0.3.1",
+	        "type": "weave-net"
+	    },{
+	        "name": "other1",
+	        "cniVersion": "1.0.0",
+	        "type": "other-plugin"
+	    }]
+	}`),
+		}
+
+		fExec := newFakeExec()
+		fExec.supportedVersions = map[string][]string{
+			"weave-net": {"0.3.0", "0.3.1", "0.4.0"},
+		}
+		expectedConf1 := `{
+	    "name": "weave1",
+	    "cniVersion": "0.3.1",
+	    "type": "weave-net"
+	}`
+		fExec.addPlugin040(nil, "eth0", expectedConf1, &cni040.Result{
+			CNIVersion: "0.3.1",
+			IPs: []*cni040.IPConfig{{
+				Version: "4",
+				Address: *testhelpers.EnsureCIDR("1.1.1.2/24"),
+			}},
+		}, nil)
+		expectedConf2 := `{
+	    "name": "other1",
+	    "cniVersion": "1.0.0",
+	    "type": "other-plugin"
+	}`
+		fExec.addPlugin100(nil, "net1", expectedConf2, &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("1.1.1.5/24"),
+			}},
+		}, nil)
+
+		result, err := CmdAdd(args, fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addIndex).To(Equal(len(fExec.plugins)))
+		// weave-net supports no version above 0.4.0
+		Expect(result.Version()).To(Equal("0.4.0"))
+	})
+
+	It("fails when the delegates support no common cniVersion", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "0.4.0",
+	        "type": "weave-net"
+	    },{
+	        "name": "other1",
+	        "cniVersion": "1.0.0",
+	        "type": "other-plugin"
+	    }]
+	}`),
+		}
+
+		fExec := newFakeExec()
+		fExec.supportedVersions = map[string][]string{
+			"weave-net":    {"0.3.1", "0.4.0"},
+			"other-plugin": {"1.0.0"},
+		}
+		fExec.addPlugin040(nil, "eth0", "", &cni040.Result{CNIVersion: "0.4.0"}, nil)
+		fExec.addPlugin100(nil, "net1", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		_, err := CmdAdd(args, fExec, nil)
+		Expect(err).To(MatchError(ContainSubstring("support no common CNI version")))
+		// no delegate was added
+		Expect(fExec.addIndex).To(Equal(0))
+	})
+
+	It("annotates the pod with the multus version with recordMultusVersion", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "", "")
+		args := podCmdArgs(fakePod, `"recordMultusVersion": true`)
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		clientInfo := newPodClientInfo(fakePod)
+		// the ClusterRole grants get and update on pods/status, not patch
+		clientInfo.Client.(*fake.Clientset).PrependReactor("patch", "pods", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.NewForbidden(v1.Resource("pods"), fakePod.Name, fmt.Errorf("patch is not allowed"))
+		})
+
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+
+		pod, err := clientInfo.GetPod(fakePod.ObjectMeta.Namespace, fakePod.ObjectMeta.Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.Annotations).To(HaveKeyWithValue("k8s.v1.cni.cncf.io/multus-version", version))
+	})
+
+	It("writes the network status of the delegate results and tolerates a failed write", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "net1", "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod)
+
+		newExec := func() *fakeExec {
+			fExec := newFakeExec()
+			fExec.addPlugin100(nil, "eth0", "", &cni100.Result{
+				CNIVersion: "1.0.0",
+				Interfaces: []*cni100.Interface{{
+					Name:    "eth0",
+					Mac:     "0a:58:0a:f4:02:06",
+					Sandbox: testNS.Path(),
+				}},
+				IPs: []*cni100.IPConfig{{
+					Address:   *testhelpers.EnsureCIDR("10.244.2.6/24"),
+					Interface: cni100.Int(0),
+				}},
+			}, nil)
+			fExec.addPlugin100(nil, "net1", net1, &cni100.Result{
+				CNIVersion: "1.0.0",
+				Interfaces: []*cni100.Interface{{
+					Name:    "net1",
+					Mac:     "0a:58:0a:f4:02:07",
+					Sandbox: testNS.Path(),
+				}},
+				IPs: []*cni100.IPConfig{{
+					Address:   *testhelpers.EnsureCIDR("1.1.1.4/24"),
+					Interface: cni100.Int(0),
+				}},
+			}, nil)
+			return fExec
+		}
+
+		clientInfo := newPodClientInfo(fakePod, net1)
+
+		_, err := CmdAdd(args, newExec(), clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+
+		pod, err := clientInfo.GetPod(fakePod.ObjectMeta.Namespace, fakePod.ObjectMeta.Name)
+		Expect(err).NotTo(HaveOccurred())
+		netStatus, err := netutils.GetNetworkStatus(pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(netStatus).To(HaveLen(2))
+		// the default network is the gateway interface
+		Expect(netStatus[0].Name).To(Equal("weave1"))
+		Expect(netStatus[0].Interface).To(Equal("eth0"))
+		Expect(netStatus[0].Mac).To(Equal("0a:58:0a:f4:02:06"))
+		Expect(netStatus[0].IPs).To(Equal([]string{"10.244.2.6"}))
+		Expect(netStatus[0].Default).To(BeTrue())
+		Expect(netStatus[1].Name).To(Equal("test/net1"))
+		Expect(netStatus[1].Interface).To(Equal("net1"))
+		Expect(netStatus[1].Mac).To(Equal("0a:58:0a:f4:02:07"))
+		Expect(netStatus[1].IPs).To(Equal([]string{"1.1.1.4"}))
+		Expect(netStatus[1].Default).To(BeFalse())
+
+		// a failing write of the network status does not fail ADD
+		clientInfo.Client.(*fake.Clientset).PrependReactor("update", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() != "status" {
+				return false, nil, nil
+			}
+			return true, nil, errors.NewServiceUnavailable("apiserver is unavailable")
+		})
+		_, err = CmdAdd(args, newExec(), clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("passes the default network result to a network with receivesDefaultResult", func() {
+		fakePod := testhelpers.NewFakePod("testpod", `[{"name": "net1", "receivesDefaultResult": true}]`, "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		conf := `{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "defaultNetworkOrder": "%s",
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			Args:        fmt.Sprintf("K8S_POD_NAME=%s;K8S_POD_NAMESPACE=%s", fakePod.ObjectMeta.Name, fakePod.ObjectMeta.Namespace),
+			StdinData:   []byte(fmt.Sprintf(conf, "first")),
+		}
+
+		masterResult := &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("10.244.2.6/24"),
+			}},
+			Routes: []*cnitypes.Route{{
+				Dst: *testhelpers.EnsureCIDR("0.0.0.0/0"),
+				GW:  net.ParseIP("10.244.2.1"),
+			}},
+		}
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", masterResult, nil)
+		fExec.addPlugin100(nil, "net1", `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0",
+		"capabilities": {"defaultResult": true},
+		"runtimeConfig": {
+			"defaultResult": {
+				"ips": ["10.244.2.6/24"],
+				"routes": [{"dst": "0.0.0.0/0", "gw": "10.244.2.1"}]
+			}
+		}
+	}`, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		clientInfo := newPodClientInfo(fakePod, net1)
+
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addOrder).To(Equal([]string{"eth0", "net1"}))
+
+		// the default network result is not available when it is added last
+		args.StdinData = []byte(fmt.Sprintf(conf, "last"))
+		fExec = newFakeExec()
+		fExec.addPlugin100(nil, "net1", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "eth0", "", masterResult, nil)
+		_, err = CmdAdd(args, fExec, clientInfo)
+		Expect(err).To(MatchError(ContainSubstring("the default network must be added first")))
+		Expect(fExec.addIndex).To(Equal(0))
+	})
+
+	It("logs the resolved networks before adding them", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "net1,net2", "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		net2 := `{
+		"name": "net2",
+		"type": "mynet2",
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod)
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", "", nil, fmt.Errorf("missing network"))
+
+		clientInfo := newPodClientInfo(fakePod, net1, net2)
+
+		var err error
+		logs := captureLogs(func() {
+			logging.SetLogLevel("info")
+			_, err = CmdAdd(args, fExec, clientInfo)
+		})
+		Expect(err).To(HaveOccurred())
+
+		Expect(logs).To(ContainSubstring("[info] Multus: [test/testpod/]: resolved networks: default networks: [weave1], additional networks: [test/net1, test/net2]"))
+		// the summary is logged before the first delegate is added
+		Expect(logs).To(MatchRegexp(`(?s)resolved networks: .*\[verbose\] Add: test:testpod`))
+		Expect(logs).NotTo(MatchRegexp(`(?s)\[verbose\] Add: .*resolved networks: `))
+	})
+
+	It("rejects result IPs with an invalid prefix length with validateResultPrefixes", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "net1", "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		conf := `{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "validateResultPrefixes": true,
+	    "allowZeroResultPrefix": %t,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			Args:        fmt.Sprintf("K8S_POD_NAME=%s;K8S_POD_NAMESPACE=%s", fakePod.ObjectMeta.Name, fakePod.ObjectMeta.Namespace),
+			StdinData:   []byte(fmt.Sprintf(conf, false)),
+		}
+		newExec := func(net1Address net.IPNet) *fakeExec {
+			fExec := newFakeExec()
+			fExec.addPlugin100(nil, "eth0", "", &cni100.Result{
+				CNIVersion: "1.0.0",
+				IPs:        []*cni100.IPConfig{{Address: *testhelpers.EnsureCIDR("10.244.2.6/24")}},
+			}, nil)
+			fExec.addPlugin100(nil, "net1", "", &cni100.Result{
+				CNIVersion: "1.0.0",
+				IPs:        []*cni100.IPConfig{{Address: net1Address}},
+			}, nil)
+			return fExec
+		}
+
+		clientInfo := newPodClientInfo(fakePod, net1)
+
+		// an IPv4 address with a /40 prefix
+		err := checkResultPrefixes(&cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs:        []*cni100.IPConfig{{Address: net.IPNet{IP: net.ParseIP("1.1.1.2"), Mask: net.CIDRMask(40, 128)}}},
+		}, false)
+		Expect(err).To(MatchError("IP address 1.1.1.2 has a 128 bit netmask with prefix length 40, which is invalid for IPv4"))
+
+		fExec := newExec(net.IPNet{IP: net.ParseIP("1.1.1.2").To4(), Mask: net.CIDRMask(0, 32)})
+		_, err = CmdAdd(args, fExec, clientInfo)
+		Expect(err).To(MatchError(ContainSubstring(`invalid result of network "net1": IP address 1.1.1.2 has prefix length 0`)))
+		// the networks added so far are torn down
+		Expect(fExec.delIndex).To(Equal(2))
+
+		args.StdinData = []byte(fmt.Sprintf(conf, true))
+		fExec = newExec(net.IPNet{IP: net.ParseIP("1.1.1.2").To4(), Mask: net.CIDRMask(0, 32)})
+		_, err = CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("overrides the default routes of the result with the default-route gateways", func() {
+		result := &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("10.244.2.6/24"),
+			}},
+			Routes: []*cnitypes.Route{{
+				Dst: *testhelpers.EnsureCIDR("0.0.0.0/0"),
+				GW:  net.ParseIP("10.244.2.1"),
+			}, {
+				Dst: *testhelpers.EnsureCIDR("10.96.0.0/12"),
+				GW:  net.ParseIP("10.244.2.1"),
+			}, {
+				Dst: *testhelpers.EnsureCIDR("::/0"),
+				GW:  net.ParseIP("2001::1"),
+			}},
+		}
+
+		overridden, err := overrideDefaultRoutesInResult(result, []net.IP{net.ParseIP("192.168.1.1")})
+		Expect(err).NotTo(HaveOccurred())
+		r := overridden.(*cni100.Result)
+		Expect(r.Routes).To(HaveLen(3))
+		Expect(r.Routes[0].Dst.String()).To(Equal("10.96.0.0/12"))
+		// the default route of the other family is kept
+		Expect(r.Routes[1].Dst.String()).To(Equal("::/0"))
+		Expect(r.Routes[2].Dst.String()).To(Equal("0.0.0.0/0"))
+		Expect(r.Routes[2].GW.String()).To(Equal("192.168.1.1"))
+		// the delegate result is not modified
+		Expect(result.Routes).To(HaveLen(3))
+		Expect(result.Routes[0].GW.String()).To(Equal("10.244.2.1"))
+	})
+
+	It("returns the result with its default routes overridden by the default-route gateways", func() {
+		fakePod := testhelpers.NewFakePod("testpod", `[{"name":"net1","default-route":["10.1.1.1"]}]`, "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod, fmt.Sprintf(`"cniDir": %q`, tmpDir+"/cniData"))
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("10.244.2.6/24"),
+			}},
+			Routes: []*cnitypes.Route{{
+				Dst: *testhelpers.EnsureCIDR("0.0.0.0/0"),
+				GW:  net.ParseIP("10.244.2.1"),
+			}, {
+				Dst: *testhelpers.EnsureCIDR("10.96.0.0/12"),
+				GW:  net.ParseIP("10.244.2.1"),
+			}},
+		}, nil)
+		fExec.addPlugin100(nil, "net1", "", &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs: []*cni100.IPConfig{{
+				Address: *testhelpers.EnsureCIDR("10.1.1.5/24"),
+			}},
+		}, nil)
+		// the interface of net1, on which the default route is set
+		fExec.plugins["net1"].onAdd = func() error {
+			return testNS.Do(func(_ ns.NetNS) error {
+				if err := netlink.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "net1"}}); err != nil {
+					return err
+				}
+				link, err := netlink.LinkByName("net1")
+				if err != nil {
+					return err
+				}
+				if err := netlink.AddrAdd(link, &netlink.Addr{IPNet: testhelpers.EnsureCIDR("10.1.1.5/24")}); err != nil {
+					return err
+				}
+				return netlink.LinkSetUp(link)
+			})
+		}
+		defer testNS.Do(func(_ ns.NetNS) error {
+			link, err := netlink.LinkByName("net1")
+			if err != nil {
+				return err
+			}
+			return netlink.LinkDel(link)
+		})
+
+		clientInfo := newPodClientInfo(fakePod, net1)
+
+		result, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addIndex).To(Equal(len(fExec.plugins)))
+		r := result.(*cni100.Result)
+		Expect(r.Routes).To(HaveLen(2))
+		Expect(r.Routes[0].Dst.String()).To(Equal("10.96.0.0/12"))
+		Expect(r.Routes[1].Dst.String()).To(Equal("0.0.0.0/0"))
+		Expect(r.Routes[1].GW.String()).To(Equal("10.1.1.1"))
+	})
+
+	It("lowercases the requested interface names with normalizeInterfaceNames", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "net1@MyEth", "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod, fmt.Sprintf(`"cniDir": %q`, tmpDir+"/cniData"), `"normalizeInterfaceNames": true`)
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "myeth", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		clientInfo := newPodClientInfo(fakePod, net1)
+
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addOrder).To(Equal([]string{"eth0", "myeth"}))
+
+		// the delegates cache has the normalized name
+		cached, err := os.ReadFile(filepath.Join(tmpDir, "cniData", args.ContainerID))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(cached)).To(ContainSubstring(`"ifnameRequest":"myeth"`))
+
+		// DEL rebuilding the delegates from the pod uses the same name
+		Expect(os.Remove(filepath.Join(tmpDir, "cniData", args.ContainerID))).To(Succeed())
+		Expect(CmdDel(args, fExec, clientInfo)).To(Succeed())
+		Expect(fExec.delOrder).To(Equal([]string{"myeth", "eth0"}))
+
+		fakePod = testhelpers.NewFakePod("testpod2", "net1@MyEth,net1@myeth", "")
+		_, err = clientInfo.AddPod(fakePod)
+		Expect(err).NotTo(HaveOccurred())
+		args.Args = fmt.Sprintf("K8S_POD_NAME=%s;K8S_POD_NAMESPACE=%s", fakePod.ObjectMeta.Name, fakePod.ObjectMeta.Namespace)
+		_, err = CmdAdd(args, newFakeExec(), clientInfo)
+		Expect(err).To(MatchError(ContainSubstring(`interface name "myeth" of network "test/net1" collides with the one of network "test/net1"`)))
+	})
+
+	It("serves the ADD counters on the metrics listener", func() {
+		// pick a free port
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		address := listener.Addr().String()
+		Expect(listener.Close()).To(Succeed())
+
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(fmt.Sprintf(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "metricsListenAddress": "%s",
+	    "defaultnetworkfile": "/tmp/foo.multus.conf",
+	    "defaultnetworkwaitseconds": 3,
+	    "delegates": [{
+	        "name": "metrics1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`, address)),
+		}
+		scrape := func() string {
+			resp, err := http.Get("http://" + address + "/metrics")
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			return string(body)
+		}
+		counter := func(metrics, name string) int {
+			match := regexp.MustCompile(regexp.QuoteMeta(name) + ` (\d+)`).FindStringSubmatch(metrics)
+			if match == nil {
+				return 0
+			}
+			value, err := strconv.Atoi(match[1])
+			Expect(err).NotTo(HaveOccurred())
+			return value
+		}
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		_, err = CmdAdd(args, fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+		// the commands of the thin plugin do not listen
+		_, err = http.Get("http://" + address + "/metrics")
+		Expect(err).To(HaveOccurred())
+
+		StartMetricsListener(address)
+		fExec = newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		_, err = CmdAdd(args, fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		metrics := scrape()
+		commands := counter(metrics, `multus_cni_commands_total{command="ADD",result="success"}`)
+		Expect(commands).To(BeNumerically(">=", 1))
+		Expect(counter(metrics, `multus_cni_delegate_operations_total{command="ADD",network="metrics1",result="success"}`)).To(Equal(2))
+		Expect(counter(metrics, `multus_cni_delegate_exec_duration_seconds_count{command="ADD",network="metrics1"}`)).To(Equal(2))
+
+		fExec = newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", nil, fmt.Errorf("failed"))
+		_, err = CmdAdd(args, fExec, nil)
+		Expect(err).To(HaveOccurred())
+
+		metrics = scrape()
+		Expect(counter(metrics, `multus_cni_commands_total{command="ADD",result="success"}`)).To(Equal(commands))
+		Expect(counter(metrics, `multus_cni_commands_total{command="ADD",result="failure"}`)).To(BeNumerically(">=", 1))
+		Expect(counter(metrics, `multus_cni_delegate_operations_total{command="ADD",network="metrics1",result="failure"}`)).To(Equal(1))
+		Expect(counter(metrics, `multus_cni_delegate_exec_duration_seconds_count{command="ADD",network="metrics1"}`)).To(Equal(3))
+	})
+
+	It("tags the log, status and cache of each network with its attachment ID with attachmentIDs", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "net1", "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod, fmt.Sprintf(`"cniDir": %q`, tmpDir+"/cniData"), `"attachmentIDs": true`)
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", "", &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs:        []*cni100.IPConfig{{Address: *testhelpers.EnsureCIDR("1.1.1.5/24")}},
+		}, nil)
+
+		clientInfo := newPodClientInfo(fakePod, net1)
+		// the ClusterRole grants get and update on pods/status, not patch
+		clientInfo.Client.(*fake.Clientset).PrependReactor("patch", "pods", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.NewForbidden(v1.Resource("pods"), fakePod.Name, fmt.Errorf("patch is not allowed"))
+		})
+
+		var err error
+		logs := captureLogs(func() {
+			logging.SetLogLevel("verbose")
+			_, err = CmdAdd(args, fExec, clientInfo)
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		id := attachmentID("123456789", "net1", "test/net1")
+		Expect(id).To(MatchRegexp("^[0-9a-f]{16}$"))
+		Expect(id).NotTo(Equal(attachmentID("123456789", "eth0", "weave1")))
+
+		Expect(logs).To(ContainSubstring("Add: test:testpod:testUID:test/net1(net1):net1 attachmentID=" + id + " "))
+
+		pod, err := clientInfo.GetPod(fakePod.ObjectMeta.Namespace, fakePod.ObjectMeta.Name)
+		Expect(err).NotTo(HaveOccurred())
+		status := []map[string]interface{}{}
+		Expect(json.Unmarshal([]byte(pod.Annotations[nettypes.NetworkStatusAnnot]), &status)).To(Succeed())
+		Expect(status).To(HaveLen(2))
+		Expect(status[0]).To(HaveKeyWithValue("attachment-id", attachmentID("123456789", "eth0", "weave1")))
+		Expect(status[1]).To(HaveKeyWithValue("name", "test/net1"))
+		Expect(status[1]).To(HaveKeyWithValue("attachment-id", id))
+
+		cached, err := os.ReadFile(filepath.Join(tmpDir, "cniData", args.ContainerID))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(cached)).To(ContainSubstring(`"attachmentID":"` + id + `"`))
+	})
+
+	It("deletes the default network without cache nor pod", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "net1", "")
+		args := podCmdArgs(fakePod, fmt.Sprintf(`"cniDir": %q`, tmpDir+"/cniData"))
+
+		// net1 of the unknown network is left over in the pod network namespace
+		err := testNS.Do(func(_ ns.NetNS) error {
+			return netlink.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "net1"}})
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer testNS.Do(func(_ ns.NetNS) error {
+			link, err := netlink.LinkByName("net1")
+			if err != nil {
+				return err
+			}
+			return netlink.LinkDel(link)
+		})
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		logs := captureLogs(func() {
+			logging.SetLogLevel("verbose")
+			// neither the delegates cache nor the pod exist
+			err = CmdDel(args, fExec, NewFakeClientInfo())
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.delOrder).To(Equal([]string{"eth0"}))
+
+		Expect(logs).To(ContainSubstring("deleting the default network weave1 of container 123456789 without cache nor pod"))
+		Expect(logs).To(ContainSubstring("warning: interfaces [net1] of container 123456789 are left to the removal of netns"))
+	})
+
+	It("deletes the networks of the interfaces found in the result cache without cache nor pod", func() {
+		cniDir := tmpDir + "/cniData"
+		args := &skel.CmdArgs{
+			ContainerID: "123456789",
+			Netns:       testNS.Path(),
+			IfName:      "eth0",
+			Args:        "K8S_POD_NAME=testpod;K8S_POD_NAMESPACE=test",
+			StdinData: []byte(fmt.Sprintf(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "cniDir": "%s",
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`, cniDir)),
+		}
+		net2 := `{
+		"name": "net2",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+
+		// net1 of an unknown network and net2 are left over in the pod network namespace
+		for _, ifName := range []string{"net1", "net2"} {
+			ifName := ifName
+			err := testNS.Do(func(_ ns.NetNS) error {
+				return netlink.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: ifName}})
+			})
+			Expect(err).NotTo(HaveOccurred())
+			defer testNS.Do(func(_ ns.NetNS) error {
+				link, err := netlink.LinkByName(ifName)
+				if err != nil {
+					return err
+				}
+				return netlink.LinkDel(link)
+			})
+		}
+		// only the result of net2 was cached by libcni on ADD
+		cached, err := json.Marshal(map[string]interface{}{
+			"kind":        "cniCacheV1",
+			"containerId": "123456789",
+			"config":      []byte(net2),
+			"ifName":      "net2",
+			"networkName": "net2",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.MkdirAll(filepath.Join(cniDir, "results"), 0700)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(cniDir, "results", "net2-123456789-net2"), cached, 0600)).To(Succeed())
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net2", net2, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		logs := captureLogs(func() {
+			logging.SetLogLevel("verbose")
+			// neither the delegates cache nor the pod exist
+			err = CmdDel(args, fExec, NewFakeClientInfo())
+		})
+		Expect(err).NotTo(HaveOccurred())
+		// the secondary interfaces are deleted before the default network
+		Expect(fExec.delOrder).To(Equal([]string{"net2", "eth0"}))
+		Expect(logs).To(ContainSubstring("deleting the networks of interfaces [net2] of container 123456789 from the result cache"))
+		Expect(logs).To(ContainSubstring("warning: interfaces [net1] of container 123456789 are left to the removal of netns"))
+	})
+
+	It("deletes the networks of a version 1 or of a corrupt delegates cache", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "net1", "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod, fmt.Sprintf(`"cniDir": %q`, tmpDir+"/cniData"))
+		cniDir := filepath.Join(tmpDir, "cniData")
+		clientInfo := newPodClientInfo(fakePod, net1)
+
+		// the cache of a previous multus version is the bare list of delegates
+		weave1, err := types.LoadDelegateNetConf([]byte(`{"name": "weave1", "cniVersion": "1.0.0", "type": "weave-net"}`), nil, "", "")
+		Expect(err).NotTo(HaveOccurred())
+		cached, err := types.LoadDelegateNetConf([]byte(`{"name": "net1", "cniVersion": "1.0.0", "type": "mynet"}`), nil, "", "")
+		Expect(err).NotTo(HaveOccurred())
+		v1Cache, err := json.Marshal([]*types.DelegateNetConf{weave1, cached})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(saveScratchNetConf(args.ContainerID, cniDir, v1Cache)).To(Succeed())
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		Expect(CmdDel(args, fExec, clientInfo)).To(Succeed())
+		Expect(fExec.delOrder).To(Equal([]string{"net1", "eth0"}))
+		Expect(filepath.Join(cniDir, args.ContainerID)).NotTo(BeAnExistingFile())
+
+		// the delegates of a corrupt cache are fetched again
+		Expect(saveScratchNetConf(args.ContainerID, cniDir, []byte(`{"version": 2, "data": [{"name"`))).To(Succeed())
+		fExec = newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		Expect(CmdDel(args, fExec, clientInfo)).To(Succeed())
+		Expect(fExec.delOrder).To(Equal([]string{"net1", "eth0"}))
+		Expect(filepath.Join(cniDir, args.ContainerID)).NotTo(BeAnExistingFile())
+	})
+
+	It("removes the delegates cache when the DEL of a delegate fails", func() {
+		cniDir := filepath.Join(tmpDir, "cniData")
+		cmdArgs := func(retryDeleteOnError bool) *skel.CmdArgs {
+			return &skel.CmdArgs{
+				ContainerID: "123456789",
+				Netns:       testNS.Path(),
+				IfName:      "eth0",
+				StdinData: []byte(fmt.Sprintf(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "cniDir": %q,
+	    "retryDeleteOnError": %t,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    },{
+	        "name": "other1",
+	        "cniVersion": "1.0.0",
+	        "type": "other-plugin"
+	    }]
+	}`, cniDir, retryDeleteOnError)),
+			}
+		}
+		cachePath := filepath.Join(cniDir, "123456789")
+
+		fExec := newFakeExec()
+		fExec.readd = true
+		fExec.redel = true
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		// the other delegates are deleted and the cache is removed
+		_, err := CmdAdd(cmdArgs(false), fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cachePath).To(BeAnExistingFile())
+		fExec.plugins["net1"].delErr = fmt.Errorf("other1 is busy")
+		err = CmdDel(cmdArgs(false), fExec, nil)
+		Expect(err).To(MatchError(ContainSubstring("other1 is busy")))
+		Expect(fExec.delOrder).To(Equal([]string{"net1", "eth0"}))
+		Expect(cachePath).NotTo(BeAnExistingFile())
+
+		// with retryDeleteOnError, the cache is removed as well once a delegate is deleted
+		_, err = CmdAdd(cmdArgs(true), fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+		err = CmdDel(cmdArgs(true), fExec, nil)
+		Expect(err).To(MatchError(ContainSubstring("other1 is busy")))
+		Expect(cachePath).NotTo(BeAnExistingFile())
+
+		// but kept for the retried DEL if every delegate fails
+		_, err = CmdAdd(cmdArgs(true), fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+		fExec.plugins["eth0"].delErr = fmt.Errorf("weave1 is busy")
+		err = CmdDel(cmdArgs(true), fExec, nil)
+		Expect(err).To(MatchError(ContainSubstring("2 delegates failed")))
+		Expect(cachePath).To(BeAnExistingFile())
+
+		// unless a delegate fails with "no such file or directory"
+		fExec.plugins["eth0"].delErr = fmt.Errorf("open /run/weave1: no such file or directory")
+		err = CmdDel(cmdArgs(true), fExec, nil)
+		Expect(err).To(HaveOccurred())
+		Expect(cachePath).NotTo(BeAnExistingFile())
+	})
+
+	It("removes the delegates cache of the stale containers on GC", func() {
+		cniDir := filepath.Join(tmpDir, "cniData")
+		conf := fmt.Sprintf(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "cniVersion": "1.1.0",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "cniDir": "%s",
+	    %%s
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`, cniDir)
+
+		weave1, err := types.LoadDelegateNetConf([]byte(`{"name": "weave1", "cniVersion": "1.0.0", "type": "weave-net"}`), nil, "", "")
+		Expect(err).NotTo(HaveOccurred())
+		net1, err := types.LoadDelegateNetConf([]byte(`{"name": "net1", "cniVersion": "1.0.0", "type": "mynet"}`), nil, "", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(saveDelegates("stale1", cniDir, []*types.DelegateNetConf{weave1, net1})).To(Succeed())
+		Expect(saveDelegates("stale2", cniDir, []*types.DelegateNetConf{weave1})).To(Succeed())
+		Expect(saveScratchNetConf("stale3", cniDir, []byte(`{"version": 2, "data": [{"name"`))).To(Succeed())
+		Expect(saveDelegates("valid1", cniDir, []*types.DelegateNetConf{weave1, net1})).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(cniDir, "results"), 0700)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(cniDir, ".valid2.tmp-1"), nil, 0600)).To(Succeed())
+
+		fExec := newFakeExec()
+		fExec.redel = true
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		// the valid attachments are required
+		_, err = CmdGC(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(conf, ""))}, fExec, nil)
+		Expect(err).To(MatchError("CmdGC: the request has no cni.dev/valid-attachments"))
+		Expect(fExec.delIndex).To(Equal(0))
+
+		args := &skel.CmdArgs{StdinData: []byte(fmt.Sprintf(conf, `"cni.dev/valid-attachments": [{"containerID": "valid1", "ifname": "eth0"}, {"containerID": "valid2", "ifname": "eth0"}],`))}
+		removed, err := CmdGC(args, fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(removed).To(Equal([]string{"stale1", "stale2", "stale3"}))
+		// the networks of the stale containers are deleted, but of the corrupt cache
+		Expect(fExec.delOrder).To(Equal([]string{"net1", "eth0", "eth0"}))
+
+		entries, err := os.ReadDir(cniDir)
+		Expect(err).NotTo(HaveOccurred())
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		Expect(names).To(ConsistOf(".valid2.tmp-1", "results", "valid1"))
+
+		// nothing left to clean up
+		removed, err = CmdGC(args, fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(removed).To(BeEmpty())
+		Expect(fExec.delIndex).To(Equal(3))
+	})
+
+	It("keeps on GC the delegates cache saved with the short ID of a valid container", func() {
+		cniDir := filepath.Join(tmpDir, "cniData")
+		const fullID = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+		args := &skel.CmdArgs{StdinData: []byte(fmt.Sprintf(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "cniVersion": "1.1.0",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "cniDir": "%s",
+	    "cni.dev/valid-attachments": [{"containerID": "%s", "ifname": "eth0"}],
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`, cniDir, fullID))}
+
+		weave1, err := types.LoadDelegateNetConf([]byte(`{"name": "weave1", "cniVersion": "1.0.0", "type": "weave-net"}`), nil, "", "")
+		Expect(err).NotTo(HaveOccurred())
+		// saved by a runtime passing the short container ID
+		Expect(saveDelegates(fullID[:12], cniDir, []*types.DelegateNetConf{weave1})).To(Succeed())
+		// too short to be taken for the short ID of the container
+		Expect(saveDelegates(fullID[:8], cniDir, []*types.DelegateNetConf{weave1})).To(Succeed())
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		removed, err := CmdGC(args, fExec, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(removed).To(Equal([]string{fullID[:8]}))
+		Expect(filepath.Join(cniDir, fullID[:12])).To(BeAnExistingFile())
+	})
+
+	It("records the MAC and sandbox of the interfaces on ADD and their removal on DEL", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "net1", "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod, fmt.Sprintf(`"cniDir": %q`, tmpDir+"/cniData"))
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs:        []*cni100.IPConfig{{Address: *testhelpers.EnsureCIDR("1.1.1.2/24")}},
+		}, nil)
+		fExec.addPlugin100(nil, "net1", net1, &cni100.Result{
+			CNIVersion: "1.0.0",
+			Interfaces: []*cni100.Interface{
+				{Name: "net1", Mac: "0a:58:0a:00:00:01"},
+				{Name: "net1", Mac: "0a:58:0a:00:00:03", Sandbox: "/var/run/netns/sandbox"},
+			},
+			IPs: []*cni100.IPConfig{{Address: *testhelpers.EnsureCIDR("1.1.1.3/24"), Interface: cni100.Int(1)}},
+		}, nil)
+
+		clientInfo := newPodClientInfo(fakePod, net1)
+
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		recorder := clientInfo.EventRecorder.(*record.FakeRecorder)
+		Expect(collectEvents(recorder.Events)).To(Equal([]string{
+			"Normal AddedInterface Add eth0 [1.1.1.2/24] from weave1 sandbox=" + testNS.Path(),
+			"Normal AddedInterface Add net1 [1.1.1.3/24] from test/net1 mac=0a:58:0a:00:00:03 sandbox=/var/run/netns/sandbox",
+		}))
+
+		Expect(CmdDel(args, fExec, clientInfo)).To(Succeed())
+		Expect(collectEvents(recorder.Events)).To(Equal([]string{
+			"Normal RemovedInterface Remove net1 from test/net1",
+			"Normal RemovedInterface Remove eth0 from weave1",
+		}))
+	})
+
+	It("merges the IPv4 and IPv6 addresses of an interface with mergeDualStackResults", func() {
+		fakePod := testhelpers.NewFakePod("testpod", "v6net@eth0", "")
+		v6net := `{
+		"name": "v6net",
+		"type": "ipv6-addr",
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod, fmt.Sprintf(`"cniDir": %q`, tmpDir+"/cniData"), `"mergeDualStackResults": true`)
+		clientInfo := newPodClientInfo(fakePod, v6net)
+
+		v4Result := &cni100.Result{
+			CNIVersion: "1.0.0",
+			Interfaces: []*cni100.Interface{
+				{Name: "veth1234"},
+				{Name: "eth0", Sandbox: testNS.Path()},
+			},
+			IPs: []*cni100.IPConfig{{Address: *testhelpers.EnsureCIDR("10.0.0.5/24"), Interface: cni100.Int(1)}},
+		}
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", v4Result, nil)
+		fExec.addNetworkPlugin100("v6net", "eth0", &cni100.Result{
+			CNIVersion: "1.0.0",
+			Interfaces: []*cni100.Interface{{Name: "eth0", Sandbox: testNS.Path()}},
+			IPs:        []*cni100.IPConfig{{Address: *testhelpers.EnsureCIDR("2001:db8::5/64"), Interface: cni100.Int(0)}},
+		}, nil)
+
+		result, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		r := result.(*cni100.Result)
+		Expect(r.Interfaces).To(Equal(v4Result.Interfaces))
+		Expect(len(r.IPs)).To(Equal(2))
+		Expect(r.IPs[0].Address.String()).To(Equal("10.0.0.5/24"))
+		Expect(r.IPs[1].Address.String()).To(Equal("2001:db8::5/64"))
+		Expect(*r.IPs[1].Interface).To(Equal(1))
+		// the delegate result is left untouched
+		Expect(len(v4Result.IPs)).To(Equal(1))
+
+		// the network may not add an address of a family the interface already has
+		fExec = newFakeExec()
+		fExec.addPlugin100(nil, "eth0", "", v4Result, nil)
+		fExec.addNetworkPlugin100("v6net", "eth0", &cni100.Result{
+			CNIVersion: "1.0.0",
+			IPs:        []*cni100.IPConfig{{Address: *testhelpers.EnsureCIDR("10.0.0.6/24")}},
+		}, nil)
+		_, err = CmdAdd(args, fExec, clientInfo)
+		Expect(err).To(MatchError(ContainSubstring(`error merging the dual-stack results: network "v6net" returns the v4 address 10.0.0.6/24 for interface eth0, which already has a v4 address from network "weave1"`)))
+		Expect(fExec.delIndex).To(Equal(2))
+	})
+
+	It("sets the mtu requested in the networks annotation in the delegate config", func() {
+		fakePod := testhelpers.NewFakePod("testpod", `[{"name": "net1", "mtu": 1400}, {"name": "net2"}]`, "")
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		net2 := `{
+		"name": "net2",
+		"type": "mynet2",
+		"mtu": 9000,
+		"cniVersion": "1.0.0"
+	}`
+		args := podCmdArgs(fakePod, fmt.Sprintf(`"cniDir": %q`, tmpDir+"/cniData"))
+
+		fExec := newFakeExec()
+		fExec.addPlugin100(nil, "eth0", `{
+	    "name": "weave1",
+	    "cniVersion": "1.0.0",
+	    "type": "weave-net"
+	}`, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net1", `{
+		"name": "net1",
+		"type": "mynet",
+		"mtu": 1400,
+		"cniVersion": "1.0.0"
+	}`, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+		fExec.addPlugin100(nil, "net2", net2, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+		clientInfo := newPodClientInfo(fakePod, net1, net2)
+
+		_, err := CmdAdd(args, fExec, clientInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fExec.addIndex).To(Equal(len(fExec.plugins)))
+	})
+
+	Context("with VALIDATE", func() {
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		validateArgs := func(networks string) (*k8sclient.ClientInfo, *skel.CmdArgs) {
+			fakePod := testhelpers.NewFakePod("testpod", networks, "")
+			clientInfo := newPodClientInfo(fakePod, net1)
+			return clientInfo, &skel.CmdArgs{
+				ContainerID: "123456789",
+				IfName:      "eth0",
+				Args:        fmt.Sprintf("K8S_POD_NAME=%s;K8S_POD_NAMESPACE=%s", fakePod.ObjectMeta.Name, fakePod.ObjectMeta.Namespace),
+				StdinData: []byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`),
+			}
+		}
+
+		It("reports the delegates ADD would run without executing them", func() {
+			clientInfo, args := validateArgs("net1")
+			fExec := newFakeExec()
+
+			report, err := CmdValidate(args, fExec, clientInfo)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Delegates).To(Equal([]ValidatedDelegate{{
+				Name:       "weave1",
+				Types:      []string{"weave-net"},
+				IfName:     "eth0",
+				CNIVersion: "1.0.0",
+				Default:    true,
+			}, {
+				Name:       "test/net1",
+				Types:      []string{"mynet"},
+				IfName:     "net1",
+				CNIVersion: "1.0.0",
+			}}))
+			Expect(fExec.addIndex).To(Equal(0))
+			Expect(fExec.delIndex).To(Equal(0))
+		})
+
+		It("fails on a missing network-attachment-definition", func() {
+			clientInfo, args := validateArgs("net1, missing")
+			fExec := newFakeExec()
+
+			_, err := CmdValidate(args, fExec, clientInfo)
+			Expect(err).To(MatchError(ContainSubstring("error loading k8s delegates k8s args")))
+			Expect(err).To(MatchError(ContainSubstring(`"missing"`)))
+			Expect(fExec.addIndex).To(Equal(0))
+		})
+	})
+
+	Context("with the default-network: none annotation", func() {
+		net1 := `{
+		"name": "net1",
+		"type": "mynet",
+		"cniVersion": "1.0.0"
+	}`
+		conf := `{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "kubeconfig": "/etc/kubernetes/node-kubeconfig.yaml",
+	    "allowNoDefaultNetwork": %t,
+	    "delegates": [{
+	        "name": "weave1",
+	        "cniVersion": "1.0.0",
+	        "type": "weave-net"
+	    }]
+	}`
+		podArgs := func(networks string, allow bool) (*k8sclient.ClientInfo, *skel.CmdArgs) {
+			fakePod := testhelpers.NewFakePod("testpod", networks, "")
+			fakePod.Annotations["k8s.v1.cni.cncf.io/default-network"] = "none"
+			clientInfo := newPodClientInfo(fakePod, net1)
+			return clientInfo, &skel.CmdArgs{
+				ContainerID: "123456789",
+				Netns:       testNS.Path(),
+				IfName:      "eth0",
+				Args:        fmt.Sprintf("K8S_POD_NAME=%s;K8S_POD_NAMESPACE=%s", fakePod.ObjectMeta.Name, fakePod.ObjectMeta.Namespace),
+				StdinData:   []byte(fmt.Sprintf(conf, allow)),
+			}
+		}
+
+		It("skips the default network and returns the result of the first network", func() {
+			clientInfo, args := podArgs("net1", true)
+			net1Result := &cni100.Result{
+				CNIVersion: "1.0.0",
+				IPs:        []*cni100.IPConfig{{Address: *testhelpers.EnsureCIDR("10.1.1.5/24")}},
+			}
+			fExec := newFakeExec()
+			// the first network gets the interface name of the runtime
+			fExec.addPlugin100(nil, "eth0", net1, net1Result, nil)
+
+			result, err := CmdAdd(args, fExec, clientInfo)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fExec.addIndex).To(Equal(1))
+			Expect(reflect.DeepEqual(result, net1Result)).To(BeTrue())
+
+			Expect(CmdDel(args, fExec, clientInfo)).To(Succeed())
+			Expect(fExec.delOrder).To(Equal([]string{"eth0"}))
+		})
+
+		It("fails when no network is selected", func() {
+			clientInfo, args := podArgs("", true)
+			fExec := newFakeExec()
+
+			_, err := CmdAdd(args, fExec, clientInfo)
+			Expect(err).To(MatchError(ContainSubstring("no network is selected for the pod, which skips the default network with the k8s.v1.cni.cncf.io/default-network: none annotation")))
+			Expect(fExec.addIndex).To(Equal(0))
+		})
+
+		It("keeps the default network without allowNoDefaultNetwork", func() {
+			clientInfo, args := podArgs("net1", false)
+			fExec := newFakeExec()
+			fExec.addPlugin100(nil, "eth0", "", &cni100.Result{CNIVersion: "1.0.0"}, nil)
+			fExec.addPlugin100(nil, "net1", net1, &cni100.Result{CNIVersion: "1.0.0"}, nil)
+
+			_, err := CmdAdd(args, fExec, clientInfo)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fExec.addOrder).To(Equal([]string{"eth0", "net1"}))
+		})
 	})
 })
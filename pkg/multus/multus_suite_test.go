@@ -21,7 +21,9 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	cnitypes "github.com/containernetworking/cni/pkg/types"
 	cni020 "github.com/containernetworking/cni/pkg/types/020"
@@ -48,16 +50,43 @@ type fakePlugin struct {
 	expectedIfname string
 	result         cnitypes.Result
 	err            error
+	// barrier, if set, makes ADD wait for the ADD of the other plugins sharing it
+	barrier *sync.WaitGroup
+	// hang makes ADD block until its context is done
+	hang bool
+	// rawResult, if set, is returned by ADD instead of result
+	rawResult string
+	// delErr, if set, is returned by DEL
+	delErr error
+	// onAdd, if set, is run by ADD, e.g. to create the interface of the plugin
+	onAdd func() error
 }
 
 type fakeExec struct {
 	cniversion.PluginDecoder
 
+	// mu protects the counters and orders from concurrent delegates
+	mu sync.Mutex
+
 	addIndex        int
 	delIndex        int
 	chkIndex        int
 	expectedDelSkip int
 	plugins         map[string]*fakePlugin
+	// missingPlugins are plugin binaries FindInPath cannot find
+	missingPlugins map[string]bool
+	// addOrder and delOrder record the interface names of ADD and DEL calls
+	addOrder []string
+	delOrder []string
+	// readd lets ADD be called again for the plugins, e.g. when ADD is retried
+	readd bool
+	// redel lets DEL be called again for the plugins, e.g. for several containers
+	redel bool
+	// statusErr is returned by STATUS calls
+	statusIndex int
+	statusErr   error
+	// supportedVersions are the versions VERSION returns by plugin binary, all of them if unset
+	supportedVersions map[string][]string
 }
 
 func newFakeExec() *fakeExec {
@@ -79,6 +108,15 @@ func (f *fakeExec) addPlugin100(expectedEnv []string, expectedIfname, expectedCo
 	}
 }
 
+// addNetworkPlugin100 adds the plugin of network for an interface shared with other networks
+func (f *fakeExec) addNetworkPlugin100(network, expectedIfname string, result *cni100.Result, err error) {
+	f.plugins[expectedIfname+"@"+network] = &fakePlugin{
+		expectedIfname: expectedIfname,
+		result:         result,
+		err:            err,
+	}
+}
+
 func (f *fakeExec) addPlugin040(expectedEnv []string, expectedIfname, expectedConf string, result *cni040.Result, err error) {
 	f.plugins[expectedIfname] = &fakePlugin{
 		expectedEnv:    expectedEnv,
@@ -146,31 +184,82 @@ func ParseEnvironment(environ []string) map[string]string {
 	return m
 }
 
-func (f *fakeExec) ExecPlugin(_ context.Context, pluginPath string, stdinData []byte, environ []string) ([]byte, error) {
+func (f *fakeExec) ExecPlugin(ctx context.Context, pluginPath string, stdinData []byte, environ []string) ([]byte, error) {
 	envMap := ParseEnvironment(environ)
 	cmd := envMap["CNI_COMMAND"]
 	var index int
 	var err error
 	var resultJSON []byte
 
+	f.mu.Lock()
 	switch cmd {
 	case "ADD":
-		Expect(len(f.plugins)).To(BeNumerically(">", f.addIndex))
+		if !f.readd {
+			Expect(len(f.plugins)).To(BeNumerically(">", f.addIndex))
+		}
 		index = f.addIndex
 		f.addIndex++
+		f.addOrder = append(f.addOrder, envMap["CNI_IFNAME"])
 	case "CHECK":
 		Expect(len(f.plugins)).To(BeNumerically("==", f.addIndex))
 		index = f.chkIndex
 		f.chkIndex++
 	case "DEL":
-		Expect(len(f.plugins)).To(BeNumerically(">", f.delIndex))
+		if !f.redel {
+			Expect(len(f.plugins)).To(BeNumerically(">", f.delIndex))
+		}
 		index = len(f.plugins) - f.expectedDelSkip - f.delIndex - 1
 		f.delIndex++
+		f.delOrder = append(f.delOrder, envMap["CNI_IFNAME"])
+	case "STATUS":
+		f.statusIndex++
+		f.mu.Unlock()
+		return nil, f.statusErr
+	case "VERSION":
+		f.mu.Unlock()
+		if versions, ok := f.supportedVersions[filepath.Base(pluginPath)]; ok {
+			return json.Marshal(cniversion.PluginSupports(versions...))
+		}
+		return json.Marshal(cniversion.All)
 	default:
 		// Should never be reached
 		Expect(false).To(BeTrue())
 	}
+	f.mu.Unlock()
 	plugin := f.plugins[envMap["CNI_IFNAME"]]
+	var netConf struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(stdinData, &netConf); err == nil {
+		if networkPlugin, ok := f.plugins[envMap["CNI_IFNAME"]+"@"+netConf.Name]; ok {
+			plugin = networkPlugin
+		}
+	}
+
+	if cmd == "ADD" && plugin.onAdd != nil {
+		if err := plugin.onAdd(); err != nil {
+			return nil, err
+		}
+	}
+
+	if cmd == "ADD" && plugin.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	if cmd == "ADD" && plugin.barrier != nil {
+		plugin.barrier.Done()
+		done := make(chan struct{})
+		go func() {
+			plugin.barrier.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			return nil, fmt.Errorf("plugin %s was not invoked concurrently", envMap["CNI_IFNAME"])
+		}
+	}
 
 	//GinkgoT().Logf("[%s %d] exec plugin %q found %+v\n", cmd, index, pluginPath, plugin)
 	fmt.Printf("[%s %d] exec plugin %q found %+v\n", cmd, index, pluginPath, plugin)
@@ -210,6 +299,14 @@ func (f *fakeExec) ExecPlugin(_ context.Context, pluginPath string, stdinData []
 		return nil, plugin.err
 	}
 
+	if cmd == "DEL" && plugin.delErr != nil {
+		return nil, plugin.delErr
+	}
+
+	if cmd == "ADD" && plugin.rawResult != "" {
+		return []byte(plugin.rawResult), nil
+	}
+
 	resultJSON, err = json.Marshal(plugin.result)
 	Expect(err).NotTo(HaveOccurred())
 	return resultJSON, nil
@@ -217,6 +314,9 @@ func (f *fakeExec) ExecPlugin(_ context.Context, pluginPath string, stdinData []
 
 func (f *fakeExec) FindInPath(plugin string, paths []string) (string, error) {
 	Expect(len(paths)).To(BeNumerically(">", 0))
+	if f.missingPlugins[plugin] {
+		return "", fmt.Errorf("failed to find plugin %q in path %s", plugin, paths)
+	}
 	return filepath.Join(paths[0], plugin), nil
 }
 
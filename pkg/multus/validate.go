@@ -0,0 +1,117 @@
+// Copyright (c) 2022 Multus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multus
+
+import (
+	"fmt"
+
+	"github.com/containernetworking/cni/libcni"
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/skel"
+	cniversion "github.com/containernetworking/cni/pkg/version"
+
+	k8s "gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/k8sclient"
+	"gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/logging"
+	"gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/types"
+)
+
+// ValidationReport lists the delegates an ADD would run, in order
+type ValidationReport struct {
+	Delegates []ValidatedDelegate `json:"delegates"`
+}
+
+// ValidatedDelegate is a delegate an ADD would run
+type ValidatedDelegate struct {
+	Name       string   `json:"name"`
+	Types      []string `json:"types"`
+	IfName     string   `json:"ifName"`
+	CNIVersion string   `json:"cniVersion"`
+	Default    bool     `json:"default,omitempty"`
+	Optional   bool     `json:"optional,omitempty"`
+}
+
+// CmdValidate resolves the delegates of the pod of args as ADD does, and validates their
+// configuration, without touching the pod network namespace nor executing any plugin.
+// It returns the delegates ADD would run, in order.
+func CmdValidate(args *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) (*ValidationReport, error) {
+	n, err := types.LoadNetConf(args.StdinData)
+	logging.Debugf("CmdValidate: %v, %v, %v", args, exec, kubeClient)
+	if err != nil {
+		return nil, cmdErr(nil, "error loading netconf: %v", err)
+	}
+
+	kubeClient, err = k8s.GetK8sClient(n.Kubeconfig, kubeClient)
+	if err != nil {
+		return nil, cmdErr(nil, "error getting k8s client: %v", err)
+	}
+
+	k8sArgs, err := k8s.GetK8sArgs(args)
+	if err != nil {
+		return nil, cmdErr(nil, "error getting k8s args: %v", err)
+	}
+
+	pod, err := getPod(kubeClient, k8sArgs, false, n.RetryBudget, n.APIRetry)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := resolveDelegates(args, k8sArgs, n, pod, kubeClient); err != nil {
+		return nil, err
+	}
+
+	report := &ValidationReport{Delegates: []ValidatedDelegate{}}
+	for _, idx := range delegateOrder(n) {
+		delegate := n.Delegates[idx]
+		validated, err := validateDelegate(delegate)
+		if err != nil {
+			return nil, cmdPluginErr(k8sArgs, delegateNetName(delegate), "invalid configuration of network %q: %v", delegate.Name, err)
+		}
+		validated.IfName = getIfname(delegate, args.IfName, n.SecondaryInterfacePrefix, idx)
+		validated.Default = delegate.MasterPlugin
+		validated.Optional = delegate.Optional
+		report.Delegates = append(report.Delegates, *validated)
+	}
+	return report, nil
+}
+
+// validateDelegate checks that libcni accepts the configuration of delegate, with a
+// version it can execute
+func validateDelegate(delegate *types.DelegateNetConf) (*ValidatedDelegate, error) {
+	validated := &ValidatedDelegate{Name: delegate.Name}
+	if delegate.ConfListPlugin {
+		confList, err := libcni.ConfListFromBytes(delegate.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		validated.CNIVersion = confList.CNIVersion
+		for _, plugin := range confList.Plugins {
+			validated.Types = append(validated.Types, plugin.Network.Type)
+		}
+	} else {
+		conf, err := libcni.ConfFromBytes(delegate.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		validated.CNIVersion = conf.Network.CNIVersion
+		validated.Types = []string{conf.Network.Type}
+	}
+
+	if validated.CNIVersion != "" {
+		if _, _, _, err := cniversion.ParseVersion(validated.CNIVersion); err != nil {
+			return nil, fmt.Errorf("invalid cniVersion %q: %v", validated.CNIVersion, err)
+		}
+	}
+	return validated, nil
+}
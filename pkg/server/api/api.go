@@ -17,11 +17,14 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"strings"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
 )
 
 const (
@@ -53,6 +56,11 @@ func DoCNI(url string, req interface{}, socketPath string) ([]byte, error) {
 
 	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
 	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			// e.g. multus-daemon is restarting; let the runtime retry the request
+			return nil, cnitypes.NewError(cnitypes.ErrTryAgainLater, "multus-daemon is unreachable, try again later", fmt.Sprintf("socket %s: %v", socketPath, err))
+		}
 		return nil, fmt.Errorf("failed to send CNI request: %v", err)
 	}
 	defer resp.Body.Close()
@@ -62,6 +70,9 @@ func DoCNI(url string, req interface{}, socketPath string) ([]byte, error) {
 		return nil, fmt.Errorf("failed to read CNI result: %v", err)
 	}
 
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		return nil, cnitypes.NewError(cnitypes.ErrTryAgainLater, "CNI request failed, try again later", string(body))
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("CNI request failed with status %v: '%s'", resp.StatusCode, string(body))
 	}
@@ -44,6 +44,11 @@ type ShimNetConf struct {
 func CmdAdd(args *skel.CmdArgs) error {
 	response, cniVersion, err := postRequest(args)
 	if err != nil {
+		if cniErr, ok := err.(*cnitypes.Error); ok {
+			// pass CNI errors (e.g. try again later) through to the runtime as is
+			_ = logging.Errorf("CmdAdd (shim): %v", err)
+			return cniErr
+		}
 		return logging.Errorf("CmdAdd (shim): %v", err)
 	}
 
@@ -30,6 +30,8 @@ import (
 	"github.com/containernetworking/cni/pkg/invoke"
 	"github.com/containernetworking/cni/pkg/types"
 	"github.com/containernetworking/cni/pkg/version"
+
+	"gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/multus"
 )
 
 // ChrootExec implements invoke.Exec to execute CNI with chroot
@@ -161,10 +163,12 @@ func (e *ChrootExec) pluginErr(err error, stdout, stderr []byte) error {
 		} else {
 			emsg.Msg = fmt.Sprintf("netplugin failed: %q", string(stderr))
 		}
-	} else if perr := json.Unmarshal(stdout, &emsg); perr != nil {
+		return &emsg
+	}
+	if perr := json.Unmarshal(stdout, &emsg); perr != nil {
 		emsg.Msg = fmt.Sprintf("netplugin failed but error parsing its diagnostic message %q: %v", string(stdout), perr)
 	}
-	return &emsg
+	return multus.NewStderrError(&emsg, stderr)
 }
 
 // FindInPath try to find CNI plugin based on given path
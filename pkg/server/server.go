@@ -17,12 +17,14 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/containernetworking/cni/pkg/invoke"
 	"github.com/containernetworking/cni/pkg/skel"
@@ -57,6 +59,137 @@ func FilesystemPreRequirements(rundir string) error {
 	return nil
 }
 
+// errAddQueueFull is returned when an ADD request does not get a slot in time
+var errAddQueueFull = errors.New("too many concurrent ADD requests, try again later")
+
+// setMaxConcurrentCmdAdd limits the number of ADD requests processed concurrently.
+// Excess requests wait up to queueTimeout for a free slot.
+func (s *Server) setMaxConcurrentCmdAdd(max int, queueTimeout time.Duration) {
+	if max <= 0 {
+		s.addSlots = nil
+		return
+	}
+	s.addSlots = make(chan struct{}, max)
+	s.addQueueTimeout = queueTimeout
+}
+
+// acquireAddSlot waits for a free ADD slot, failing with errAddQueueFull
+// once the queue timeout expires
+func (s *Server) acquireAddSlot() error {
+	if s.addSlots == nil {
+		return nil
+	}
+	select {
+	case s.addSlots <- struct{}{}:
+		return nil
+	default:
+	}
+	if s.addQueueTimeout <= 0 {
+		return errAddQueueFull
+	}
+
+	timer := time.NewTimer(s.addQueueTimeout)
+	defer timer.Stop()
+	select {
+	case s.addSlots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return errAddQueueFull
+	}
+}
+
+// releaseAddSlot frees a slot taken by acquireAddSlot
+func (s *Server) releaseAddSlot() {
+	if s.addSlots != nil {
+		<-s.addSlots
+	}
+}
+
+// errContainerLocked is returned when a request times out waiting for another request
+// of the same container
+var errContainerLocked = errors.New("another request for the container is in progress, try again later")
+
+// setContainerLockTimeout sets how long a request waits for the other requests of
+// the same container, DefaultContainerLockTimeout if timeout is not positive
+func (s *Server) setContainerLockTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultContainerLockTimeout
+	}
+	s.containerLockTimeout = timeout
+}
+
+// lockContainer waits until no other request of the container is processed, failing
+// with errContainerLocked once the lock timeout expires
+func (s *Server) lockContainer(containerID string) error {
+	s.containerLocksMu.Lock()
+	if s.containerLocks == nil {
+		s.containerLocks = map[string]*containerLock{}
+	}
+	lock, ok := s.containerLocks[containerID]
+	if !ok {
+		lock = &containerLock{held: make(chan struct{}, 1)}
+		s.containerLocks[containerID] = lock
+	}
+	lock.refs++
+	s.containerLocksMu.Unlock()
+
+	timeout := s.containerLockTimeout
+	if timeout <= 0 {
+		timeout = DefaultContainerLockTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case lock.held <- struct{}{}:
+		return nil
+	case <-timer.C:
+		s.releaseContainerLock(containerID, lock)
+		return errContainerLocked
+	}
+}
+
+// unlockContainer releases the lock taken by lockContainer
+func (s *Server) unlockContainer(containerID string) {
+	s.containerLocksMu.Lock()
+	lock := s.containerLocks[containerID]
+	s.containerLocksMu.Unlock()
+	<-lock.held
+	s.releaseContainerLock(containerID, lock)
+}
+
+// releaseContainerLock drops a reference to the lock, forgetting it once unused
+func (s *Server) releaseContainerLock(containerID string, lock *containerLock) {
+	s.containerLocksMu.Lock()
+	defer s.containerLocksMu.Unlock()
+	lock.refs--
+	if lock.refs == 0 {
+		delete(s.containerLocks, containerID)
+	}
+}
+
+// lockedCmdAdd runs the ADD holding the lock of the container and an ADD slot, released
+// even if the ADD panics
+func (s *Server) lockedCmdAdd(cniCmdArgs *skel.CmdArgs, k8sArgs *types.K8sArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) ([]byte, error) {
+	if err := s.lockContainer(cniCmdArgs.ContainerID); err != nil {
+		return nil, err
+	}
+	defer s.unlockContainer(cniCmdArgs.ContainerID)
+	if err := s.acquireAddSlot(); err != nil {
+		return nil, err
+	}
+	defer s.releaseAddSlot()
+	return cmdAdd(cniCmdArgs, k8sArgs, exec, kubeClient)
+}
+
+// lockedCmdDel runs the DEL holding the lock of the container, released even if the DEL panics
+func (s *Server) lockedCmdDel(cniCmdArgs *skel.CmdArgs, k8sArgs *types.K8sArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) error {
+	if err := s.lockContainer(cniCmdArgs.ContainerID); err != nil {
+		return err
+	}
+	defer s.unlockContainer(cniCmdArgs.ContainerID)
+	return cmdDel(cniCmdArgs, k8sArgs, exec, kubeClient)
+}
+
 // HandleCNIRequest is the CNI server handler function; it is invoked whenever
 // a CNI request is processed.
 func (s *Server) HandleCNIRequest(cmd string, k8sArgs *types.K8sArgs, cniCmdArgs *skel.CmdArgs, exec invoke.Exec, kubeClient *k8s.ClientInfo) ([]byte, error) {
@@ -66,9 +199,9 @@ func (s *Server) HandleCNIRequest(cmd string, k8sArgs *types.K8sArgs, cniCmdArgs
 	logging.Verbosef("%s starting CNI request %+v", cmd, cniCmdArgs)
 	switch cmd {
 	case "ADD":
-		result, err = cmdAdd(cniCmdArgs, k8sArgs, exec, kubeClient)
+		result, err = s.lockedCmdAdd(cniCmdArgs, k8sArgs, exec, kubeClient)
 	case "DEL":
-		err = cmdDel(cniCmdArgs, k8sArgs, exec, kubeClient)
+		err = s.lockedCmdDel(cniCmdArgs, k8sArgs, exec, kubeClient)
 	case "CHECK":
 		err = cmdCheck(cniCmdArgs, k8sArgs, exec, kubeClient)
 	default:
@@ -77,7 +210,7 @@ func (s *Server) HandleCNIRequest(cmd string, k8sArgs *types.K8sArgs, cniCmdArgs
 	logging.Verbosef("%s finished CNI request %+v, result: %q, err: %v", cmd, *cniCmdArgs, string(result), err)
 	if err != nil {
 		// Prefix errors with request info for easier failure debugging
-		return nil, fmt.Errorf("%+v ERRORED: %v", *cniCmdArgs, err)
+		return nil, fmt.Errorf("%+v ERRORED: %w", *cniCmdArgs, err)
 	}
 	return result, nil
 }
@@ -127,6 +260,46 @@ func GetListener(socketPath string) (net.Listener, error) {
 	return l, nil
 }
 
+// StartAPICache serves the pod and net-attach-def lookups of the server from caches watching
+// the apiserver until stopCh is closed, caching only the pods of nodeName if set. It waits
+// up to APICacheSyncTimeout for the caches to be listed.
+func (s *Server) StartAPICache(nodeName string, stopCh <-chan struct{}) {
+	if nodeName == "" {
+		logging.Verbosef("warning: NODE_NAME is not set, caching the pods of all the nodes")
+	}
+	s.kubeclient.StartCache(nodeName, stopCh)
+	if !s.kubeclient.WaitForCacheSync(APICacheSyncTimeout) {
+		logging.Verbosef("warning: the API caches are not synced after %v, the lookups read the apiserver until they are", APICacheSyncTimeout)
+	}
+}
+
+// SyncReadinessOutputFile runs the multus status checks against the daemon configuration,
+// then creates or removes its readinessOutputFile accordingly
+func (s *Server) SyncReadinessOutputFile() error {
+	if len(s.serverConfig) == 0 {
+		return nil
+	}
+	conf := types.GetDefaultNetConf()
+	if err := json.Unmarshal(s.serverConfig, conf); err != nil {
+		return fmt.Errorf("failed to parse the daemon configuration: %w", err)
+	}
+	return multus.SyncReadinessOutputFile(conf, s.kubeclient)
+}
+
+// StartMetricsListener serves the multus metrics on the metricsListenAddress of the daemon
+// configuration, if set
+func (s *Server) StartMetricsListener() error {
+	if len(s.serverConfig) == 0 {
+		return nil
+	}
+	conf := types.GetDefaultNetConf()
+	if err := json.Unmarshal(s.serverConfig, conf); err != nil {
+		return fmt.Errorf("failed to parse the daemon configuration: %w", err)
+	}
+	multus.StartMetricsListener(conf.MetricsListenAddress)
+	return nil
+}
+
 // NewCNIServer creates and returns a new Server object which will listen on a socket in the given path
 func NewCNIServer(daemonConfig *ControllerNetConf, serverConfig []byte) (*Server, error) {
 	kubeClient, err := k8s.InClusterK8sClient()
@@ -145,7 +318,13 @@ func NewCNIServer(daemonConfig *ControllerNetConf, serverConfig []byte) (*Server
 		logging.Verbosef("server configured with chroot: %s", daemonConfig.ChrootDir)
 	}
 
-	return newCNIServer(daemonConfig.SocketDir, kubeClient, exec, serverConfig)
+	s, err := newCNIServer(daemonConfig.SocketDir, kubeClient, exec, serverConfig)
+	if err != nil {
+		return nil, err
+	}
+	s.setMaxConcurrentCmdAdd(daemonConfig.MaxConcurrentCmdAdd, time.Duration(daemonConfig.CmdAddQueueTimeoutMillis)*time.Millisecond)
+	s.setContainerLockTimeout(time.Duration(daemonConfig.ContainerLockTimeoutMillis) * time.Millisecond)
+	return s, nil
 }
 
 func newCNIServer(rundir string, kubeClient *k8s.ClientInfo, exec invoke.Exec, servConfig []byte) (*Server, error) {
@@ -188,7 +367,12 @@ func newCNIServer(rundir string, kubeClient *k8s.ClientInfo, exec invoke.Exec, s
 
 			result, err := s.handleCNIRequest(r)
 			if err != nil {
-				http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+				status := http.StatusBadRequest
+				if errors.Is(err, errAddQueueFull) || errors.Is(err, errContainerLocked) {
+					// let the shim report a retriable error to the runtime
+					status = http.StatusServiceUnavailable
+				}
+				http.Error(w, fmt.Sprintf("%v", err), status)
 				return
 			}
 
@@ -266,7 +450,7 @@ func (s *Server) handleCNIRequest(r *http.Request) ([]byte, error) {
 	result, err := s.HandleCNIRequest(cmdType, k8sArgs, cniCmdArgs, s.exec, s.kubeclient)
 	if err != nil {
 		// Prefix error with request information for easier debugging
-		return nil, fmt.Errorf("%+v %v", cniCmdArgs, err)
+		return nil, fmt.Errorf("%+v %w", cniCmdArgs, err)
 	}
 	return result, nil
 }
@@ -399,6 +583,7 @@ func podUID(kubeclient *k8s.ClientInfo, cniArgs map[string]string, podNamespace,
 	// containerd 1.5: https://github.com/containerd/containerd/pull/5643
 	uid, found := cniArgs["K8S_POD_UID"]
 	if !found {
+		// not from the cache: a stale pod of the same name would defeat the UID checks
 		pod, err := kubeclient.GetPod(podNamespace, podName)
 		if err != nil {
 			return "", fmt.Errorf("missing pod UID; attempted to recover it from the K8s API, but failed: %w", err)
@@ -549,6 +734,9 @@ func LoadDaemonNetConf(config []byte) (*ControllerNetConf, error) {
 	if daemonNetConf.LogLevel != "" {
 		logging.SetLogLevel(daemonNetConf.LogLevel)
 	}
+	if daemonNetConf.LogFormat != "" {
+		logging.SetLogFormat(daemonNetConf.LogFormat)
+	}
 	daemonNetConf.ConfigFileContents = config
 
 	return daemonNetConf, nil
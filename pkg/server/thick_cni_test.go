@@ -16,29 +16,39 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
 	"github.com/containernetworking/cni/pkg/skel"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
 	"github.com/containernetworking/cni/pkg/version"
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/containernetworking/plugins/pkg/testutils"
 
 	"github.com/prometheus/client_golang/prometheus"
 
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	utilwait "k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/record"
 
 	netfake "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned/fake"
 	k8s "gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/k8sclient"
 	"gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/server/api"
 	testhelpers "gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/testing"
+	"gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/types"
 )
 
 const suiteName = "Thick CNI architecture"
@@ -60,6 +70,16 @@ func (fe *fakeExec) Decode(_ []byte) (version.PluginInfo, error) {
 	return nil, nil
 }
 
+// panicExec panics when executing a plugin
+type panicExec struct {
+	fakeExec
+}
+
+// ExecPlugin panics
+func (pe *panicExec) ExecPlugin(_ context.Context, _ string, _ []byte, _ []string) ([]byte, error) {
+	panic("plugin panic")
+}
+
 var _ = Describe(suiteName, func() {
 	const thickCNISocketDirPath = "multus-cni-thick-arch-socket-path"
 
@@ -138,6 +158,52 @@ var _ = Describe(suiteName, func() {
 			Expect(os.Setenv("CNI_COMMAND", "DEL")).NotTo(HaveOccurred())
 			Expect(api.CmdDel(cniCmdArgs(containerID, netns.Path(), ifaceName, referenceConfig(thickPluginRunDir)))).To(Succeed())
 		})
+
+		It("marshals the request and the response over the socket", func() {
+			request := &api.Request{
+				Env: map[string]string{
+					"CNI_COMMAND":     "ADD",
+					"CNI_CONTAINERID": containerID,
+					"CNI_NETNS":       netns.Path(),
+					"CNI_IFNAME":      ifaceName,
+					"CNI_ARGS":        fmt.Sprintf("K8S_POD_NAMESPACE=test;K8S_POD_NAME=%s;K8S_POD_UID=testUID", podName),
+				},
+				Config: []byte(referenceConfig(thickPluginRunDir)),
+			}
+			body, err := api.DoCNI(api.GetAPIEndpoint(api.MultusCNIAPIEndpoint), request, api.SocketPath(thickPluginRunDir))
+			Expect(err).NotTo(HaveOccurred())
+			response := &api.Response{}
+			Expect(json.Unmarshal(body, response)).To(Succeed())
+			Expect(response.Result).NotTo(BeNil())
+			Expect(response.Result.CNIVersion).To(Equal("1.0.0"))
+
+			request.Env["CNI_COMMAND"] = "DEL"
+			body, err = api.DoCNI(api.GetAPIEndpoint(api.MultusCNIAPIEndpoint), request, api.SocketPath(thickPluginRunDir))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(body).To(BeEmpty())
+
+			request.Env["CNI_COMMAND"] = "FOO"
+			_, err = api.DoCNI(api.GetAPIEndpoint(api.MultusCNIAPIEndpoint), request, api.SocketPath(thickPluginRunDir))
+			Expect(err).To(MatchError(ContainSubstring("CNI request failed with status 400")))
+		})
+	})
+
+	Context("CNI operations started from the shim without a daemon", func() {
+		It("fails with a retriable error, except for DEL", func() {
+			netns, err := testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			defer netns.Close()
+
+			// no daemon listens on the socket of the run directory
+			err = api.CmdAdd(cniCmdArgs("123456789", netns.Path(), "eth0", referenceConfig(thickPluginRunDir)))
+			var cniErr *cnitypes.Error
+			Expect(errors.As(err, &cniErr)).To(BeTrue())
+			Expect(cniErr.Code).To(Equal(uint(cnitypes.ErrTryAgainLater)))
+			Expect(cniErr.Msg).To(Equal("multus-daemon is unreachable, try again later"))
+			Expect(cniErr.Details).To(ContainSubstring(api.SocketPath(thickPluginRunDir)))
+
+			Expect(api.CmdDel(cniCmdArgs("123456789", netns.Path(), "eth0", referenceConfig(thickPluginRunDir)))).To(Succeed())
+		})
 	})
 
 	Context("CNI operations started from the shim with CNI config override with server config", func() {
@@ -194,6 +260,192 @@ var _ = Describe(suiteName, func() {
 
 		})
 	})
+
+	Context("serializing the requests of a container", func() {
+		const (
+			containerID = "123456789"
+			podName     = "my-little-pod"
+		)
+
+		var (
+			s         *Server
+			K8sClient *k8s.ClientInfo
+			netns     ns.NetNS
+			cacheDir  string
+		)
+
+		BeforeEach(func() {
+			var err error
+			s = &Server{}
+			s.setContainerLockTimeout(5 * time.Second)
+			K8sClient = fakeK8sClient()
+			Expect(createFakePod(K8sClient, podName)).To(Succeed())
+
+			netns, err = testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			cacheDir = filepath.Join(thickPluginRunDir, "cache")
+		})
+
+		AfterEach(func() {
+			Expect(netns.Close()).To(Succeed())
+		})
+
+		requestArgs := func() (*skel.CmdArgs, *types.K8sArgs) {
+			cmdArgs := cniCmdArgs(containerID, netns.Path(), "eth0", fmt.Sprintf(`{
+	"cniVersion": "0.4.0",
+	"name": "node-cni-network",
+	"type": "multus",
+	"cniDir": "%s",
+	"delegates": [{
+		"name": "weave1",
+		"cniVersion": "0.4.0",
+		"type": "weave-net"
+	}]}`, cacheDir))
+			cmdArgs.Args = fmt.Sprintf("K8S_POD_NAMESPACE=test;K8S_POD_NAME=%s", podName)
+			k8sArgs := &types.K8sArgs{
+				K8S_POD_NAMESPACE: "test",
+				K8S_POD_NAME:      podName,
+			}
+			return cmdArgs, k8sArgs
+		}
+
+		request := func(cmd string) <-chan error {
+			done := make(chan error, 1)
+			cmdArgs, k8sArgs := requestArgs()
+			go func() {
+				_, err := s.HandleCNIRequest(cmd, k8sArgs, cmdArgs, &fakeExec{}, K8sClient)
+				done <- err
+			}()
+			return done
+		}
+
+		It("processes the ADD and DEL of a container one at a time", func() {
+			cacheFile := filepath.Join(cacheDir, containerID)
+
+			Expect(s.lockContainer(containerID)).To(Succeed())
+			addDone := request("ADD")
+			Consistently(addDone, 200*time.Millisecond).ShouldNot(Receive())
+			Expect(cacheFile).NotTo(BeAnExistingFile())
+			s.unlockContainer(containerID)
+			Eventually(addDone, 5*time.Second).Should(Receive(BeNil()))
+			Expect(cacheFile).To(BeAnExistingFile())
+
+			Expect(s.lockContainer(containerID)).To(Succeed())
+			delDone := request("DEL")
+			Consistently(delDone, 200*time.Millisecond).ShouldNot(Receive())
+			Expect(cacheFile).To(BeAnExistingFile())
+			s.unlockContainer(containerID)
+			Eventually(delDone, 5*time.Second).Should(Receive(BeNil()))
+			Expect(cacheFile).NotTo(BeAnExistingFile())
+
+			// the locks of finished requests are forgotten
+			Expect(s.containerLocks).To(BeEmpty())
+		})
+
+		It("releases the lock and the ADD slot of a request which panics", func() {
+			s.setMaxConcurrentCmdAdd(1, time.Second)
+			for _, cmd := range []string{"ADD", "DEL"} {
+				cmdArgs, k8sArgs := requestArgs()
+				Expect(func() {
+					_, _ = s.HandleCNIRequest(cmd, k8sArgs, cmdArgs, &panicExec{}, K8sClient)
+				}).To(PanicWith("plugin panic"))
+				Expect(s.containerLocks).To(BeEmpty())
+			}
+			Expect(s.addSlots).To(BeEmpty())
+
+			Eventually(request("ADD"), 5*time.Second).Should(Receive(BeNil()))
+		})
+
+		It("fails with a retriable error once the lock timeout expires", func() {
+			s.setContainerLockTimeout(100 * time.Millisecond)
+			Expect(s.lockContainer(containerID)).To(Succeed())
+
+			var err error
+			Eventually(request("DEL"), 5*time.Second).Should(Receive(&err))
+			Expect(errors.Is(err, errContainerLocked)).To(BeTrue())
+
+			s.unlockContainer(containerID)
+			Expect(s.containerLocks).To(BeEmpty())
+		})
+	})
+
+	Context("limiting concurrent ADD requests", func() {
+		var s *Server
+
+		BeforeEach(func() {
+			s = &Server{}
+		})
+
+		It("does not limit ADD requests by default", func() {
+			s.setMaxConcurrentCmdAdd(0, 0)
+			for i := 0; i < 3; i++ {
+				Expect(s.acquireAddSlot()).To(Succeed())
+			}
+		})
+
+		It("rejects excess ADD requests immediately without a queue timeout", func() {
+			s.setMaxConcurrentCmdAdd(2, 0)
+			Expect(s.acquireAddSlot()).To(Succeed())
+			Expect(s.acquireAddSlot()).To(Succeed())
+			Expect(s.acquireAddSlot()).To(MatchError(errAddQueueFull))
+
+			_, err := s.HandleCNIRequest("ADD", nil, cniCmdArgs("123456789", "", "eth0", ""), &fakeExec{}, fakeK8sClient())
+			Expect(errors.Is(err, errAddQueueFull)).To(BeTrue())
+
+			s.releaseAddSlot()
+			Expect(s.acquireAddSlot()).To(Succeed())
+		})
+
+		It("queues excess ADD requests until a slot is released", func() {
+			s.setMaxConcurrentCmdAdd(1, 5*time.Second)
+			Expect(s.acquireAddSlot()).To(Succeed())
+
+			go func() {
+				defer GinkgoRecover()
+				time.Sleep(100 * time.Millisecond)
+				s.releaseAddSlot()
+			}()
+			Expect(s.acquireAddSlot()).To(Succeed())
+		})
+
+		It("fails queued ADD requests once the queue timeout expires", func() {
+			s.setMaxConcurrentCmdAdd(1, 100*time.Millisecond)
+			Expect(s.acquireAddSlot()).To(Succeed())
+			Expect(s.acquireAddSlot()).To(MatchError(errAddQueueFull))
+		})
+	})
+
+	Context("recovering a missing pod UID", func() {
+		It("reads the pod from the apiserver, not from a stale cache", func() {
+			stalePod := testhelpers.NewFakePod("statefulpod-0", "", "")
+			stalePod.UID = "old-uid"
+			livePod := stalePod.DeepCopy()
+			livePod.UID = "new-uid"
+
+			clientset := fake.NewSimpleClientset()
+			clientset.PrependReactor("list", "pods", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+				return true, &v1.PodList{Items: []v1.Pod{*stalePod}}, nil
+			})
+			clientset.PrependWatchReactor("pods", k8stesting.DefaultWatchReactor(watch.NewFake(), nil))
+			clientset.PrependReactor("get", "pods", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+				return true, livePod, nil
+			})
+			kubeClient := &k8s.ClientInfo{
+				Client:    clientset,
+				NetClient: netfake.NewSimpleClientset().K8sCniCncfIoV1(),
+			}
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+			kubeClient.StartCache("", stopCh)
+			Expect(kubeClient.WaitForCacheSync(5 * time.Second)).To(BeTrue())
+
+			k8sArgs, err := kubernetesRuntimeArgs(map[string]string{
+				"CNI_ARGS": fmt.Sprintf("K8S_POD_NAMESPACE=%s;K8S_POD_NAME=%s", stalePod.Namespace, stalePod.Name),
+			}, kubeClient)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(k8sArgs.K8S_POD_UID)).To(Equal("new-uid"))
+		})
+	})
 })
 
 func fakeK8sClient() *k8s.ClientInfo {
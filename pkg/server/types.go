@@ -16,6 +16,8 @@ package server
 
 import (
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/containernetworking/cni/pkg/invoke"
 
@@ -31,6 +33,12 @@ const (
 	DefaultMultusDaemonConfigFile = "/etc/cni/net.d/multus.d/daemon-config.json"
 	// DefaultMultusRunDir specifies default RunDir for multus
 	DefaultMultusRunDir = "/run/multus/"
+	// ReadinessCheckPeriod is the period of the readinessOutputFile sync
+	ReadinessCheckPeriod = 10 * time.Second
+	// DefaultContainerLockTimeout is how long a request waits for the other requests of the same container
+	DefaultContainerLockTimeout = 60 * time.Second
+	// APICacheSyncTimeout is how long the daemon waits for its API caches to be listed
+	APICacheSyncTimeout = 30 * time.Second
 )
 
 // Metrics represents server's metrics.
@@ -47,6 +55,20 @@ type Server struct {
 	exec         invoke.Exec
 	serverConfig []byte
 	metrics      *Metrics
+	// addSlots limits the number of concurrent ADD requests (nil means unlimited)
+	addSlots        chan struct{}
+	addQueueTimeout time.Duration
+	// containerLocks serialize the ADD and DEL requests of each container
+	containerLocksMu     sync.Mutex
+	containerLocks       map[string]*containerLock
+	containerLockTimeout time.Duration
+}
+
+// containerLock is held by the request being processed for a container
+type containerLock struct {
+	held chan struct{}
+	// refs counts the requests holding or waiting for the lock
+	refs int
 }
 
 // ControllerNetConf for the controller cni configuration
@@ -54,10 +76,20 @@ type ControllerNetConf struct {
 	ChrootDir   string `json:"chrootDir,omitempty"`
 	LogFile     string `json:"logFile"`
 	LogLevel    string `json:"logLevel"`
+	LogFormat   string `json:"logFormat,omitempty"`
 	LogToStderr bool   `json:"logToStderr,omitempty"`
 
 	MetricsPort *int `json:"metricsPort,omitempty"`
 
+	// Maximum number of ADD requests processed concurrently (0 means unlimited)
+	MaxConcurrentCmdAdd int `json:"maxConcurrentCmdAdd,omitempty"`
+	// Time (in milliseconds) an excess ADD request waits for a free slot before being rejected
+	CmdAddQueueTimeoutMillis int `json:"cmdAddQueueTimeoutMillis,omitempty"`
+	// Time (in milliseconds) an ADD or DEL request waits for the other requests of the same container
+	ContainerLockTimeoutMillis int `json:"containerLockTimeoutMillis,omitempty"`
+	// Serve the pod and net-attach-def lookups from caches watching the apiserver
+	CacheAPIObjects bool `json:"cacheAPIObjects,omitempty"`
+
 	// Option to point to the path of the unix domain socket through which the
 	// multus client / server communicate.
 	SocketDir string `json:"socketDir"`
@@ -20,8 +20,10 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/containernetworking/cni/libcni"
 	"github.com/containernetworking/cni/pkg/skel"
@@ -40,6 +42,102 @@ const (
 	defaultNonIsolatedNamespace   = "default"
 )
 
+// delReconcileStrategy values
+const (
+	// DelReconcileUnion tears down the networks of both the cache and the network status
+	DelReconcileUnion = "union"
+	// DelReconcileCachePreferred tears down only the cached networks
+	DelReconcileCachePreferred = "cachePreferred"
+	// DelReconcileStatusPreferred tears down only the networks of the network status
+	DelReconcileStatusPreferred = "statusPreferred"
+)
+
+// defaultNetworkOrder values
+const (
+	// DefaultNetworkOrderFirst adds the default network before the other networks
+	DefaultNetworkOrderFirst = "first"
+	// DefaultNetworkOrderLast adds the default network after the other networks
+	DefaultNetworkOrderLast = "last"
+)
+
+// minNADAgeAction values
+const (
+	// MinNADAgeActionReject fails the ADD for a network-attachment-definition modified too recently
+	MinNADAgeActionReject = "reject"
+	// MinNADAgeActionWait waits until the network-attachment-definition is old enough
+	MinNADAgeActionWait = "wait"
+)
+
+// invalidInterfaceIndexAction values
+const (
+	// InvalidInterfaceIndexIgnore returns delegate results as they are
+	InvalidInterfaceIndexIgnore = "ignore"
+	// InvalidInterfaceIndexReject fails the ADD when a result IP references a missing interface
+	InvalidInterfaceIndexReject = "reject"
+	// InvalidInterfaceIndexClear removes the reference to a missing interface from the result IP
+	InvalidInterfaceIndexClear = "clear"
+)
+
+// defaultRouteFamilies values
+const (
+	// DefaultRouteFamilyV4 is the IPv4 family
+	DefaultRouteFamilyV4 = "v4"
+	// DefaultRouteFamilyV6 is the IPv6 family
+	DefaultRouteFamilyV6 = "v6"
+)
+
+// cmdAddRetryOn values
+const (
+	// CmdAddRetryOnAPIServer retries ADD when the Kubernetes API server is unavailable
+	CmdAddRetryOnAPIServer = "apiserver"
+	// CmdAddRetryOnDelegate retries ADD when a delegate fails
+	CmdAddRetryOnDelegate = "delegate"
+	// CmdAddRetryOnTimeout retries ADD when a delegate exceeds delegateTimeoutSeconds
+	CmdAddRetryOnTimeout = "timeout"
+)
+
+// DefaultResultCapability is the capability, and runtimeConfig key, of the default network result
+const DefaultResultCapability = "defaultResult"
+
+// DefaultSecondaryInterfacePrefix is the prefix of the interface names of the additional
+// networks without a requested interface name, followed by the index of the network
+const DefaultSecondaryInterfacePrefix = "net"
+
+// maxSecondaryInterfacePrefixLen leaves room for a 3 digit index in the 15 characters of
+// an interface name
+const maxSecondaryInterfacePrefixLen = 12
+
+// apiRetry backoffStrategy values
+const (
+	// APIRetryBackoffLinear waits backoffMillis times the number of the retry
+	APIRetryBackoffLinear = "linear"
+	// APIRetryBackoffExponential doubles the wait, starting at backoffMillis, on each retry
+	APIRetryBackoffExponential = "exponential"
+	// DefaultAPIRetryBackoffMillis is the first wait when backoffMillis is not set
+	DefaultAPIRetryBackoffMillis = 250
+	// DefaultAPIRetryMaxBackoffMillis caps the waits when maxBackoffMillis is not set
+	DefaultAPIRetryMaxBackoffMillis = 5000
+)
+
+// statusWriteMode values
+const (
+	// StatusWriteModeSingle writes the network status once at the end of ADD
+	StatusWriteModeSingle = "single"
+	// StatusWriteModeIncremental writes the network status after each network is added
+	StatusWriteModeIncremental = "incremental"
+)
+
+// pluginTypeRegexp matches the delegate plugin types that are safe to look up in the CNI bin directories
+var pluginTypeRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// checkPluginType returns an error if the plugin type could resolve outside of the CNI bin directories
+func checkPluginType(pluginType string) error {
+	if !pluginTypeRegexp.MatchString(pluginType) {
+		return fmt.Errorf("invalid plugin type %q: only alphanumeric characters, '-' and '_' are allowed", pluginType)
+	}
+	return nil
+}
+
 // ChrootMutex provides lock to access host filesystem
 var ChrootMutex *sync.Mutex
 
@@ -58,6 +156,11 @@ func LoadDelegateNetConfList(bytes []byte, delegateConf *DelegateNetConf) error
 	if delegateConf.ConfList.Plugins[0].Type == "" {
 		return logging.Errorf("LoadDelegateNetConfList: a plugin delegate must have the 'type' field")
 	}
+	for _, plugin := range delegateConf.ConfList.Plugins {
+		if err := checkPluginType(plugin.Type); err != nil {
+			return logging.Errorf("LoadDelegateNetConfList: %v", err)
+		}
+	}
 	delegateConf.ConfListPlugin = true
 	delegateConf.Name = delegateConf.ConfList.Name
 	return nil
@@ -93,7 +196,16 @@ func LoadDelegateNetConf(bytes []byte, netElement *NetworkSelectionElement, devi
 				return nil, logging.Errorf("LoadDelegateNetConf(): failed to add cni-args in NetConfList bytes: %v", err)
 			}
 		}
+		if netElement != nil && netElement.MTURequest != nil {
+			bytes, err = addMTUInConfList(bytes, *netElement.MTURequest)
+			if err != nil {
+				return nil, logging.Errorf("LoadDelegateNetConf: failed to add mtu in NetConfList bytes: %v", err)
+			}
+		}
 	} else {
+		if err := checkPluginType(delegateConf.Conf.Type); err != nil {
+			return nil, logging.Errorf("LoadDelegateNetConf: %v", err)
+		}
 		if deviceID != "" {
 			bytes, err = delegateAddDeviceID(bytes, deviceID)
 			if err != nil {
@@ -109,7 +221,22 @@ func LoadDelegateNetConf(bytes []byte, netElement *NetworkSelectionElement, devi
 				return nil, logging.Errorf("LoadDelegateNetConf(): failed to add cni-args in NetConfList bytes: %v", err)
 			}
 		}
+		if netElement != nil && netElement.MTURequest != nil {
+			bytes, err = addMTUInConfig(bytes, *netElement.MTURequest)
+			if err != nil {
+				return nil, logging.Errorf("LoadDelegateNetConf: failed to add mtu in NetConf bytes: %v", err)
+			}
+		}
+	}
+
+	// the network attachment definition may request the default route of the pod
+	gatewayConf := &struct {
+		GatewayRequest *[]net.IP `json:"default-route,omitempty"`
+	}{}
+	if err := json.Unmarshal(bytes, gatewayConf); err != nil {
+		return nil, logging.Errorf("LoadDelegateNetConf: error unmarshalling default-route: %v", err)
 	}
+	delegateConf.GatewayRequest = gatewayConf.GatewayRequest
 
 	if netElement != nil {
 		if netElement.Name != "" {
@@ -125,21 +252,28 @@ func LoadDelegateNetConf(bytes []byte, netElement *NetworkSelectionElement, devi
 		if netElement.IPRequest != nil {
 			delegateConf.IPRequest = netElement.IPRequest
 		}
+		if netElement.MTURequest != nil {
+			delegateConf.MTURequest = *netElement.MTURequest
+		}
 		if netElement.BandwidthRequest != nil {
 			delegateConf.BandwidthRequest = netElement.BandwidthRequest
 		}
+		if netElement.SysctlsRequest != nil {
+			delegateConf.SysctlsRequest = netElement.SysctlsRequest
+		}
 		if netElement.PortMappingsRequest != nil {
 			delegateConf.PortMappingsRequest = netElement.PortMappingsRequest
 		}
+		// the network selection default-route overrides the one of the network attachment definition
 		if netElement.GatewayRequest != nil {
-			var list []net.IP
-			if delegateConf.GatewayRequest != nil {
-				list = append(*delegateConf.GatewayRequest, *netElement.GatewayRequest...)
-			} else {
-				list = *netElement.GatewayRequest
-			}
+			list := *netElement.GatewayRequest
 			delegateConf.GatewayRequest = &list
 		}
+		for _, gateway := range netElement.GatewayCapabilityRequest {
+			if ip := net.ParseIP(gateway); ip != nil {
+				delegateConf.GatewayCapabilityRequest = append(delegateConf.GatewayCapabilityRequest, ip)
+			}
+		}
 		if netElement.InfinibandGUIDRequest != "" {
 			delegateConf.InfinibandGUIDRequest = netElement.InfinibandGUIDRequest
 		}
@@ -150,10 +284,19 @@ func LoadDelegateNetConf(bytes []byte, netElement *NetworkSelectionElement, devi
 				delegateConf.DeviceID = netElement.DeviceID
 			}
 		}
+		delegateConf.Optional = netElement.Optional
+		delegateConf.ReceivesDefaultResult = netElement.ReceivesDefaultResult
 	}
 
 	delegateConf.Bytes = bytes
 
+	if delegateConf.ReceivesDefaultResult {
+		// the plugins must declare the capability to get the runtimeConfig
+		if err := SetDelegateDefaultCapabilities(delegateConf, map[string]bool{DefaultResultCapability: true}); err != nil {
+			return nil, err
+		}
+	}
+
 	return delegateConf, nil
 }
 
@@ -177,9 +320,15 @@ func mergeCNIRuntimeConfig(runtimeConfig *RuntimeConfig, delegate *DelegateNetCo
 		if delegate.BandwidthRequest != nil {
 			mergedRuntimeConfig.Bandwidth = delegate.BandwidthRequest
 		}
+		if delegate.SysctlsRequest != nil {
+			mergedRuntimeConfig.Sysctls = delegate.SysctlsRequest
+		}
 		if delegate.IPRequest != nil {
 			mergedRuntimeConfig.IPs = delegate.IPRequest
 		}
+		if len(delegate.GatewayCapabilityRequest) > 0 {
+			mergedRuntimeConfig.Gateway = delegate.GatewayCapabilityRequest
+		}
 		if delegate.MacRequest != "" {
 			mergedRuntimeConfig.Mac = delegate.MacRequest
 		}
@@ -189,6 +338,9 @@ func mergeCNIRuntimeConfig(runtimeConfig *RuntimeConfig, delegate *DelegateNetCo
 		if delegate.DeviceID != "" {
 			mergedRuntimeConfig.DeviceID = delegate.DeviceID
 		}
+		if delegate.MTURequest != 0 {
+			mergedRuntimeConfig.MTU = delegate.MTURequest
+		}
 		logging.Debugf("mergeCNIRuntimeConfig: add runtimeConfig for net-attach-def: %v", mergedRuntimeConfig)
 	}
 	return &mergedRuntimeConfig
@@ -254,9 +406,15 @@ func newCNIRuntimeConf(containerID, sandboxID, podName, podNamespace, podUID, ne
 		if delegateRc.Bandwidth != nil {
 			capabilityArgs["bandwidth"] = delegateRc.Bandwidth
 		}
+		if len(delegateRc.Sysctls) != 0 {
+			capabilityArgs["sysctls"] = delegateRc.Sysctls
+		}
 		if len(delegateRc.IPs) != 0 {
 			capabilityArgs["ips"] = delegateRc.IPs
 		}
+		if len(delegateRc.Gateway) != 0 {
+			capabilityArgs["gateway"] = delegateRc.Gateway
+		}
 		if len(delegateRc.Mac) != 0 {
 			capabilityArgs["mac"] = delegateRc.Mac
 		}
@@ -269,6 +427,9 @@ func newCNIRuntimeConf(containerID, sandboxID, podName, podNamespace, podUID, ne
 		if delegateRc.CNIDeviceInfoFile != "" {
 			capabilityArgs["CNIDeviceInfoFile"] = delegateRc.CNIDeviceInfoFile
 		}
+		if delegateRc.MTU != 0 {
+			capabilityArgs["mtu"] = delegateRc.MTU
+		}
 		rt.CapabilityArgs = capabilityArgs
 	}
 	return rt, cniDeviceInfoFile
@@ -335,6 +496,9 @@ func GetDefaultNetConf() *NetConf {
 		NonIsolatedNamespaces:  []string{defaultNonIsolatedNamespace},
 		ReadinessIndicatorFile: defaultReadinessIndicatorFile,
 		SystemNamespaces:       []string{"kube-system"},
+		DelReconcileStrategy:   DelReconcileUnion,
+		DefaultNetworkOrder:    DefaultNetworkOrderFirst,
+		MinNADAgeAction:        MinNADAgeActionReject,
 	}
 
 }
@@ -351,12 +515,162 @@ func LoadNetConf(bytes []byte) (*NetConf, error) {
 	// Logging
 	logging.SetLogStderr(netconf.LogToStderr)
 	logging.SetLogOptions(netconf.LogOptions)
+	if netconf.LogFileMaxSizeMB != nil && *netconf.LogFileMaxSizeMB <= 0 {
+		return nil, logging.Errorf("LoadNetConf: logFileMaxSizeMB must be positive, got %d", *netconf.LogFileMaxSizeMB)
+	}
+	if netconf.LogFileMaxBackups != nil && *netconf.LogFileMaxBackups < 0 {
+		return nil, logging.Errorf("LoadNetConf: logFileMaxBackups must not be negative, got %d", *netconf.LogFileMaxBackups)
+	}
+	logging.SetLogRotation(netconf.LogFileMaxSizeMB, netconf.LogFileMaxBackups)
 	if netconf.LogFile != "" {
 		logging.SetLogFile(netconf.LogFile)
 	}
 	if netconf.LogLevel != "" {
 		logging.SetLogLevel(netconf.LogLevel)
 	}
+	if netconf.LogFormat != "" {
+		if !logging.IsValidFormat(netconf.LogFormat) {
+			return nil, logging.Errorf("LoadNetConf: invalid logFormat %q", netconf.LogFormat)
+		}
+		logging.SetLogFormat(netconf.LogFormat)
+	}
+
+	netconf.RetryBudget = NewRetryBudget(netconf.K8sTotalRetryBudgetMs)
+
+	if netconf.MaxBandwidthBps < 0 {
+		return nil, logging.Errorf("LoadNetConf: invalid maxBandwidthBps %d", netconf.MaxBandwidthBps)
+	}
+
+	switch netconf.DelReconcileStrategy {
+	case DelReconcileUnion, DelReconcileCachePreferred, DelReconcileStatusPreferred:
+	default:
+		return nil, logging.Errorf("LoadNetConf: invalid delReconcileStrategy %q", netconf.DelReconcileStrategy)
+	}
+
+	switch netconf.DefaultNetworkOrder {
+	case DefaultNetworkOrderFirst, DefaultNetworkOrderLast:
+	default:
+		return nil, logging.Errorf("LoadNetConf: invalid defaultNetworkOrder %q", netconf.DefaultNetworkOrder)
+	}
+
+	if netconf.NetworkStatusMaxSize < 0 {
+		return nil, logging.Errorf("LoadNetConf: invalid networkStatusMaxSize %d", netconf.NetworkStatusMaxSize)
+	}
+	if netconf.MinNADAgeSeconds < 0 {
+		return nil, logging.Errorf("LoadNetConf: invalid minNADAgeSeconds %d", netconf.MinNADAgeSeconds)
+	}
+	for _, family := range netconf.DefaultRouteFamilies {
+		if family != DefaultRouteFamilyV4 && family != DefaultRouteFamilyV6 {
+			return nil, logging.Errorf("LoadNetConf: invalid defaultRouteFamilies entry %q", family)
+		}
+	}
+
+	if netconf.SecondaryInterfacePrefix == "" {
+		netconf.SecondaryInterfacePrefix = DefaultSecondaryInterfacePrefix
+	}
+	if len(netconf.SecondaryInterfacePrefix) > maxSecondaryInterfacePrefixLen || strings.ContainsAny(netconf.SecondaryInterfacePrefix, "/: \t\n") {
+		return nil, logging.Errorf("LoadNetConf: invalid secondaryInterfacePrefix %q", netconf.SecondaryInterfacePrefix)
+	}
+
+	if netconf.InvalidInterfaceIndexAction == "" {
+		netconf.InvalidInterfaceIndexAction = InvalidInterfaceIndexIgnore
+	}
+	switch netconf.InvalidInterfaceIndexAction {
+	case InvalidInterfaceIndexIgnore, InvalidInterfaceIndexReject, InvalidInterfaceIndexClear:
+	default:
+		return nil, logging.Errorf("LoadNetConf: invalid invalidInterfaceIndexAction %q", netconf.InvalidInterfaceIndexAction)
+	}
+
+	if netconf.CmdAddRetries < 0 {
+		return nil, logging.Errorf("LoadNetConf: invalid cmdAddRetries %d", netconf.CmdAddRetries)
+	}
+	if netconf.CmdAddRetries > 0 && len(netconf.CmdAddRetryOn) == 0 {
+		netconf.CmdAddRetryOn = []string{CmdAddRetryOnAPIServer}
+	}
+	for _, category := range netconf.CmdAddRetryOn {
+		if category != CmdAddRetryOnAPIServer && category != CmdAddRetryOnDelegate && category != CmdAddRetryOnTimeout {
+			return nil, logging.Errorf("LoadNetConf: invalid cmdAddRetryOn entry %q", category)
+		}
+	}
+
+	if netconf.StaticIPPool != nil && netconf.StaticIPPool.ConfigMap == "" {
+		return nil, logging.Errorf("LoadNetConf: staticIPPool requires a configMap")
+	}
+
+	if netconf.APIRetry != nil {
+		if netconf.APIRetry.MaxRetries < 0 {
+			return nil, logging.Errorf("LoadNetConf: invalid apiRetry maxRetries %d", netconf.APIRetry.MaxRetries)
+		}
+		if netconf.APIRetry.BackoffMillis < 0 {
+			return nil, logging.Errorf("LoadNetConf: invalid apiRetry backoffMillis %d", netconf.APIRetry.BackoffMillis)
+		}
+		if netconf.APIRetry.BackoffMillis == 0 {
+			netconf.APIRetry.BackoffMillis = DefaultAPIRetryBackoffMillis
+		}
+		if netconf.APIRetry.BackoffStrategy == "" {
+			netconf.APIRetry.BackoffStrategy = APIRetryBackoffLinear
+		}
+		if netconf.APIRetry.MaxBackoffMillis < 0 {
+			return nil, logging.Errorf("LoadNetConf: invalid apiRetry maxBackoffMillis %d", netconf.APIRetry.MaxBackoffMillis)
+		}
+		if netconf.APIRetry.MaxBackoffMillis == 0 {
+			netconf.APIRetry.MaxBackoffMillis = DefaultAPIRetryMaxBackoffMillis
+		}
+		if netconf.APIRetry.BackoffStrategy != APIRetryBackoffLinear && netconf.APIRetry.BackoffStrategy != APIRetryBackoffExponential {
+			return nil, logging.Errorf("LoadNetConf: invalid apiRetry backoffStrategy %q", netconf.APIRetry.BackoffStrategy)
+		}
+		// the retries of every API call of the request share the budget
+		netconf.APIRetry.Budget = netconf.RetryBudget
+	}
+
+	// the default networks are added once each, clusterNetwork first, then the
+	// defaultNetworks entries in order, which gives their interface names
+	defaultNetworks := map[string]bool{netconf.ClusterNetwork: netconf.ClusterNetwork != ""}
+	for _, netname := range netconf.DefaultNetworks {
+		if defaultNetworks[netname] {
+			return nil, logging.Errorf("LoadNetConf: default network %q is listed more than once in clusterNetwork/defaultNetworks", netname)
+		}
+		defaultNetworks[netname] = true
+	}
+
+	if netconf.DelegateTimeoutSeconds < 0 {
+		return nil, logging.Errorf("LoadNetConf: invalid delegateTimeoutSeconds %d", netconf.DelegateTimeoutSeconds)
+	}
+	if netconf.ReadinessIndicatorWaitSeconds < 0 {
+		return nil, logging.Errorf("LoadNetConf: invalid readinessIndicatorWaitSeconds %d", netconf.ReadinessIndicatorWaitSeconds)
+	}
+	if netconf.ReadinessPollIntervalMillis < 0 {
+		return nil, logging.Errorf("LoadNetConf: invalid readinessPollIntervalMillis %d", netconf.ReadinessPollIntervalMillis)
+	}
+	if netconf.ReadinessPollMaxIntervalMillis < 0 {
+		return nil, logging.Errorf("LoadNetConf: invalid readinessPollMaxIntervalMillis %d", netconf.ReadinessPollMaxIntervalMillis)
+	}
+
+	if netconf.MaxConcurrentDelegates == 0 {
+		netconf.MaxConcurrentDelegates = 1
+	}
+	if netconf.MaxConcurrentDelegates < 0 {
+		return nil, logging.Errorf("LoadNetConf: invalid maxConcurrentDelegates %d", netconf.MaxConcurrentDelegates)
+	}
+
+	if netconf.StatusWriteMode == "" {
+		netconf.StatusWriteMode = StatusWriteModeSingle
+	}
+	if netconf.StatusWriteMode != StatusWriteModeSingle && netconf.StatusWriteMode != StatusWriteModeIncremental {
+		return nil, logging.Errorf("LoadNetConf: invalid statusWriteMode %q", netconf.StatusWriteMode)
+	}
+
+	for pluginType, level := range netconf.DelegateLogLevels {
+		if !logging.IsValidLevel(level) {
+			return nil, logging.Errorf("LoadNetConf: invalid delegateLogLevels level %q for type %q", level, pluginType)
+		}
+	}
+
+	switch netconf.MinNADAgeAction {
+	case MinNADAgeActionReject, MinNADAgeActionWait:
+	default:
+		return nil, logging.Errorf("LoadNetConf: invalid minNADAgeAction %q", netconf.MinNADAgeAction)
+	}
 
 	// Parse previous result
 	if netconf.RawPrevResult != nil {
@@ -381,8 +695,13 @@ func LoadNetConf(bytes []byte) (*NetConf, error) {
 	// the master plugin. Kubernetes CRD delegates are then appended to
 	// the existing delegate list and all delegates executed in-order.
 
-	if len(netconf.RawDelegates) == 0 && netconf.ClusterNetwork == "" {
-		return nil, logging.Errorf("LoadNetConf: at least one delegate/clusterNetwork must be specified")
+	// In noDefaultNetwork mode only the pod annotation networks are attached and
+	// the first of them owns the result, hence delegates/clusterNetwork are not used.
+	if netconf.NoDefaultNetwork {
+		netconf.RawDelegates = nil
+		netconf.ClusterNetwork = ""
+	} else if len(netconf.RawDelegates) == 0 && netconf.ClusterNetwork == "" && len(netconf.DefaultNetworks) == 0 {
+		return nil, logging.Errorf("LoadNetConf: at least one delegate/clusterNetwork/defaultNetworks must be specified")
 	}
 
 	// setup namespace isolation
@@ -397,7 +716,7 @@ func LoadNetConf(bytes []byte) (*NetConf, error) {
 	}
 
 	// get RawDelegates and put delegates field
-	if netconf.ClusterNetwork == "" {
+	if netconf.ClusterNetwork == "" && !netconf.NoDefaultNetwork && (len(netconf.RawDelegates) > 0 || len(netconf.DefaultNetworks) == 0) {
 		// for Delegates
 		if len(netconf.RawDelegates) == 0 {
 			return nil, logging.Errorf("LoadNetConf: at least one delegate must be specified")
@@ -429,6 +748,151 @@ func (n *NetConf) AddDelegates(newDelegates []*DelegateNetConf) error {
 	return nil
 }
 
+// NewRetryBudget returns a RetryBudget of given milliseconds, or nil (unlimited) if ms is not positive
+func NewRetryBudget(ms int) *RetryBudget {
+	if ms <= 0 {
+		return nil
+	}
+	return &RetryBudget{remaining: time.Duration(ms) * time.Millisecond}
+}
+
+// Timeout returns how long the next retry loop may run: the given timeout,
+// capped by the remaining budget
+func (b *RetryBudget) Timeout(timeout time.Duration) time.Duration {
+	if b == nil {
+		return timeout
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.remaining < timeout {
+		return b.remaining
+	}
+	return timeout
+}
+
+// Consume subtracts the time spent on retries from the budget
+func (b *RetryBudget) Consume(spent time.Duration) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remaining -= spent
+	if b.remaining < 0 {
+		b.remaining = 0
+	}
+}
+
+// Backoff returns how long to wait before the given retry (starting at 1), at most
+// maxBackoffMillis
+func (r *APIRetry) Backoff(retry int) time.Duration {
+	backoff := time.Duration(r.BackoffMillis) * time.Millisecond
+	maxBackoff := time.Duration(r.MaxBackoffMillis) * time.Millisecond
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultAPIRetryMaxBackoffMillis * time.Millisecond
+	}
+	for i := 1; i < retry && backoff < maxBackoff; i++ {
+		if r.BackoffStrategy == APIRetryBackoffExponential {
+			backoff *= 2
+		} else {
+			backoff += time.Duration(r.BackoffMillis) * time.Millisecond
+		}
+	}
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
+
+// SetDelegateCNIVersion sets cniVersion in a delegate config without one, so
+// that a delegate result lacking cniVersion is interpreted at that version
+// instead of the CNI spec default (0.1.0)
+func SetDelegateCNIVersion(delegate *DelegateNetConf, cniVersion string) error {
+	if cniVersion == "" {
+		return nil
+	}
+	if delegate.ConfListPlugin {
+		if delegate.ConfList.CNIVersion != "" {
+			return nil
+		}
+		delegate.ConfList.CNIVersion = cniVersion
+	} else {
+		if delegate.Conf.CNIVersion != "" {
+			return nil
+		}
+		delegate.Conf.CNIVersion = cniVersion
+	}
+
+	var rawConfig map[string]interface{}
+	if err := json.Unmarshal(delegate.Bytes, &rawConfig); err != nil {
+		return logging.Errorf("SetDelegateCNIVersion: failed to unmarshal delegate %q config: %v", delegate.Name, err)
+	}
+	rawConfig["cniVersion"] = cniVersion
+	configBytes, err := json.Marshal(rawConfig)
+	if err != nil {
+		return logging.Errorf("SetDelegateCNIVersion: failed to re-marshal delegate %q config: %v", delegate.Name, err)
+	}
+	delegate.Bytes = configBytes
+	return nil
+}
+
+// SetDelegateDefaultCapabilities merges capabilities into the capabilities
+// declared by the delegate plugins; declared capabilities take precedence
+func SetDelegateDefaultCapabilities(delegate *DelegateNetConf, capabilities map[string]bool) error {
+	if len(capabilities) == 0 {
+		return nil
+	}
+
+	var rawConfig map[string]interface{}
+	if err := json.Unmarshal(delegate.Bytes, &rawConfig); err != nil {
+		return logging.Errorf("SetDelegateDefaultCapabilities: failed to unmarshal delegate %q config: %v", delegate.Name, err)
+	}
+
+	if delegate.ConfListPlugin {
+		plugins, ok := rawConfig["plugins"].([]interface{})
+		if !ok {
+			return logging.Errorf("SetDelegateDefaultCapabilities: unable to get plugin list of delegate %q", delegate.Name)
+		}
+		for idx, plugin := range plugins {
+			pluginConfig, ok := plugin.(map[string]interface{})
+			if !ok {
+				return logging.Errorf("SetDelegateDefaultCapabilities: unable to typecast plugin #%d of delegate %q", idx, delegate.Name)
+			}
+			mergeCapabilities(pluginConfig, capabilities)
+		}
+	} else {
+		mergeCapabilities(rawConfig, capabilities)
+	}
+
+	configBytes, err := json.Marshal(rawConfig)
+	if err != nil {
+		return logging.Errorf("SetDelegateDefaultCapabilities: failed to re-marshal delegate %q config: %v", delegate.Name, err)
+	}
+	delegate.Bytes = configBytes
+	if delegate.ConfListPlugin {
+		err = json.Unmarshal(configBytes, &delegate.ConfList)
+	} else {
+		err = json.Unmarshal(configBytes, &delegate.Conf)
+	}
+	if err != nil {
+		return logging.Errorf("SetDelegateDefaultCapabilities: failed to unmarshal updated delegate %q config: %v", delegate.Name, err)
+	}
+	return nil
+}
+
+func mergeCapabilities(pluginConfig map[string]interface{}, capabilities map[string]bool) {
+	declared, ok := pluginConfig["capabilities"].(map[string]interface{})
+	if !ok {
+		declared = map[string]interface{}{}
+	}
+	for capability, enabled := range capabilities {
+		if _, ok := declared[capability]; !ok {
+			declared[capability] = enabled
+		}
+	}
+	pluginConfig["capabilities"] = declared
+}
+
 // delegateAddDeviceID injects deviceID information in delegate bytes
 func delegateAddDeviceID(inBytes []byte, deviceID string) ([]byte, error) {
 	var rawConfig map[string]interface{}
@@ -487,6 +951,47 @@ func addDeviceIDInConfList(inBytes []byte, deviceID string) ([]byte, error) {
 	return configBytes, nil
 }
 
+// addMTUInConfig sets the mtu of the CNI config in inBytes
+func addMTUInConfig(inBytes []byte, mtu int) ([]byte, error) {
+	var rawConfig map[string]interface{}
+	if err := json.Unmarshal(inBytes, &rawConfig); err != nil {
+		return nil, logging.Errorf("addMTUInConfig: failed to unmarshal inBytes: %v", err)
+	}
+
+	rawConfig["mtu"] = mtu
+
+	configBytes, err := json.Marshal(rawConfig)
+	if err != nil {
+		return nil, logging.Errorf("addMTUInConfig: failed to re-marshal: %v", err)
+	}
+	return configBytes, nil
+}
+
+// addMTUInConfList sets the mtu of the first plugin, creating the interface, of the CNI
+// conflist in inBytes
+func addMTUInConfList(inBytes []byte, mtu int) ([]byte, error) {
+	var rawConfig map[string]interface{}
+	if err := json.Unmarshal(inBytes, &rawConfig); err != nil {
+		return nil, logging.Errorf("addMTUInConfList: failed to unmarshal inBytes: %v", err)
+	}
+
+	pList, ok := rawConfig["plugins"].([]interface{})
+	if !ok || len(pList) == 0 {
+		return nil, logging.Errorf("addMTUInConfList: unable to get plugin list")
+	}
+	firstPlugin, ok := pList[0].(map[string]interface{})
+	if !ok {
+		return nil, logging.Errorf("addMTUInConfList: unable to typecast plugin #0")
+	}
+	firstPlugin["mtu"] = mtu
+
+	configBytes, err := json.Marshal(rawConfig)
+	if err != nil {
+		return nil, logging.Errorf("addMTUInConfList: failed to re-marshal: %v", err)
+	}
+	return configBytes, nil
+}
+
 // injectCNIArgs injects given args to cniConfig
 func injectCNIArgs(cniConfig *map[string]interface{}, args *map[string]interface{}) error {
 	if argsval, ok := (*cniConfig)["args"]; ok {
@@ -563,26 +1068,26 @@ func addCNIArgsInConfList(inBytes []byte, cniArgs *map[string]interface{}) ([]by
 // gw filtering is required
 func CheckGatewayConfig(delegates []*DelegateNetConf) error {
 
-	v4Gateways := 0
-	v6Gateways := 0
-
-	// Check the gateway
+	// Check the gateway, at most one per family is allowed
+	claimedBy := map[string]string{}
 	for _, delegate := range delegates {
 		if delegate.GatewayRequest != nil {
 			for _, gw := range *delegate.GatewayRequest {
+				family := "IPv6"
 				if gw.To4() != nil {
-					v4Gateways++
-				} else {
-					v6Gateways++
+					family = "IPv4"
 				}
+				if other, ok := claimedBy[family]; ok {
+					if other == delegate.Name {
+						return fmt.Errorf("multus does not support ECMP for default-route: network %q requests more than one %s default route", other, family)
+					}
+					return fmt.Errorf("multus does not support ECMP for default-route: networks %q and %q both request the %s default route", other, delegate.Name, family)
+				}
+				claimedBy[family] = delegate.Name
 			}
 		}
 	}
 
-	if v4Gateways > 1 || v6Gateways > 1 {
-		return fmt.Errorf("multus does not support ECMP for default-route")
-	}
-
 	// set filter flag for each delegate
 	for i, delegate := range delegates {
 		delegates[i].IsFilterV4Gateway = true
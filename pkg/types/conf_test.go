@@ -18,8 +18,10 @@ package types
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/containernetworking/cni/pkg/skel"
 	types020 "github.com/containernetworking/cni/pkg/types/020"
@@ -247,6 +249,24 @@ var _ = Describe("config operations", func() {
 		Expect(netConf.Delegates[1].MasterPlugin).To(BeFalse())
 	})
 
+	It("rejects a delegate type that is not a safe identifier", func() {
+		conf := `{
+	  "name": "evil-network",
+	  "type": "../../evil",
+	  "cniVersion": "1.0.0"
+	}`
+		_, err := LoadDelegateNetConf([]byte(conf), nil, "", "")
+		Expect(err).To(MatchError(ContainSubstring(`invalid plugin type "../../evil"`)))
+
+		conflist := `{
+	  "name": "evil-network",
+	  "cniVersion": "1.0.0",
+	  "plugins": [{"type": "bridge"}, {"type": "/opt/evil"}]
+	}`
+		_, err = LoadDelegateNetConf([]byte(conflist), nil, "", "")
+		Expect(err).To(MatchError(ContainSubstring(`invalid plugin type "/opt/evil"`)))
+	})
+
 	It("fails if no kubeconfig or delegates are set", func() {
 		conf := `{
     "name": "node-cni-network",
@@ -591,6 +611,72 @@ var _ = Describe("config operations", func() {
 		Expect(bridgeConflist.Plugins[0].Args.CNI["args1"]).To(Equal("val1"))
 	})
 
+	It("sets the requested mtu in config and conflist", func() {
+		mtu := 1400
+		net := &NetworkSelectionElement{
+			Name:       "test-elem",
+			MTURequest: &mtu,
+		}
+		delegateNetConf, err := LoadDelegateNetConf([]byte(`{
+    "name": "second-network",
+    "type": "bridge",
+    "mtu": 9000
+}`), net, "", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(delegateNetConf.MTURequest).To(Equal(1400))
+		bridgeConf := &struct {
+			MTU int `json:"mtu"`
+		}{}
+		Expect(json.Unmarshal(delegateNetConf.Bytes, bridgeConf)).To(Succeed())
+		Expect(bridgeConf.MTU).To(Equal(1400))
+
+		delegateNetConf, err = LoadDelegateNetConf([]byte(`{
+    "name": "second-network",
+    "plugins": [
+      {
+        "type": "bridge"
+      },
+      {
+        "type": "tuning"
+      }
+    ]
+}`), net, "", "")
+		Expect(err).NotTo(HaveOccurred())
+		bridgeConflist := &struct {
+			Plugins []map[string]interface{} `json:"plugins"`
+		}{}
+		Expect(json.Unmarshal(delegateNetConf.Bytes, bridgeConflist)).To(Succeed())
+		Expect(bridgeConflist.Plugins[0]["mtu"]).To(BeNumerically("==", 1400))
+		Expect(bridgeConflist.Plugins[1]).NotTo(HaveKey("mtu"))
+
+		// the mtu capability gets it in the runtimeConfig
+		rt, _ := CreateCNIRuntimeConf(&skel.CmdArgs{ContainerID: "123456789"}, &K8sArgs{}, "net1", nil, delegateNetConf)
+		Expect(rt.CapabilityArgs["mtu"]).To(Equal(1400))
+	})
+
+	It("passes the requested sysctls in the runtimeConfig", func() {
+		networkSelection := &NetworkSelectionElement{
+			Name:           "testname",
+			SysctlsRequest: map[string]string{"net.ipv4.conf.IFNAME.arp_ignore": "1"},
+		}
+		delegate, err := LoadDelegateNetConf([]byte(`{
+			"name": "weave1",
+			"cniVersion": "0.2.0",
+			"type": "weave-net"
+		}`), networkSelection, "", "")
+		Expect(err).NotTo(HaveOccurred())
+		runtimeConf := mergeCNIRuntimeConfig(&RuntimeConfig{}, delegate)
+		Expect(runtimeConf.Sysctls).To(Equal(networkSelection.SysctlsRequest))
+
+		rt, _ := CreateCNIRuntimeConf(&skel.CmdArgs{ContainerID: "123456789"}, &K8sArgs{}, "net1", nil, delegate)
+		Expect(rt.CapabilityArgs["sysctls"]).To(Equal(networkSelection.SysctlsRequest))
+
+		// not for the default network
+		delegate.MasterPlugin = true
+		runtimeConf = mergeCNIRuntimeConfig(&RuntimeConfig{}, delegate)
+		Expect(runtimeConf.Sysctls).To(BeNil())
+	})
+
 	It("creates a valid CNI runtime config", func() {
 		args := &skel.CmdArgs{
 			ContainerID: "123456789",
@@ -1019,4 +1105,171 @@ var _ = Describe("config operations", func() {
 		Expect(netconf.IsFilterV6Gateway).To(BeFalse())
 	})
 
+	It("defaults and validates apiRetry", func() {
+		conf := `{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "delegates": [{"name": "weave1", "cniVersion": "0.3.1", "type": "weave-net"}],
+	    "apiRetry": {"maxRetries": 3}
+	}`
+		netConf, err := LoadNetConf([]byte(conf))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(netConf.APIRetry.BackoffMillis).To(Equal(DefaultAPIRetryBackoffMillis))
+		Expect(netConf.APIRetry.BackoffStrategy).To(Equal(APIRetryBackoffLinear))
+		Expect(netConf.APIRetry.Backoff(3)).To(Equal(750 * time.Millisecond))
+		Expect(netConf.APIRetry.Budget).To(BeNil())
+
+		Expect(netConf.APIRetry.MaxBackoffMillis).To(Equal(DefaultAPIRetryMaxBackoffMillis))
+		Expect(netConf.APIRetry.Backoff(100)).To(Equal(5 * time.Second))
+
+		netConf.APIRetry.BackoffStrategy = APIRetryBackoffExponential
+		Expect(netConf.APIRetry.Backoff(1)).To(Equal(250 * time.Millisecond))
+		Expect(netConf.APIRetry.Backoff(3)).To(Equal(time.Second))
+		// the waits stop doubling at maxBackoffMillis, instead of overflowing
+		Expect(netConf.APIRetry.Backoff(70)).To(Equal(5 * time.Second))
+		netConf.APIRetry.MaxBackoffMillis = 600
+		Expect(netConf.APIRetry.Backoff(3)).To(Equal(600 * time.Millisecond))
+
+		conf = `{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "delegates": [{"name": "weave1", "cniVersion": "0.3.1", "type": "weave-net"}],
+	    "k8sTotalRetryBudgetMs": 1000,
+	    "apiRetry": {"maxRetries": 3}
+	}`
+		netConf, err = LoadNetConf([]byte(conf))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(netConf.APIRetry.Budget).NotTo(BeNil())
+		Expect(netConf.APIRetry.Budget).To(BeIdenticalTo(netConf.RetryBudget))
+
+		conf = `{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "delegates": [{"name": "weave1", "cniVersion": "0.3.1", "type": "weave-net"}],
+	    "apiRetry": {"maxRetries": 3, "backoffStrategy": "random"}
+	}`
+		_, err = LoadNetConf([]byte(conf))
+		Expect(err).To(MatchError(`LoadNetConf: invalid apiRetry backoffStrategy "random"`))
+	})
+
+	It("defaults and validates secondaryInterfacePrefix", func() {
+		conf := `{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "delegates": [{"name": "weave1", "cniVersion": "0.3.1", "type": "weave-net"}]
+	}`
+		netConf, err := LoadNetConf([]byte(conf))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(netConf.SecondaryInterfacePrefix).To(Equal(DefaultSecondaryInterfacePrefix))
+
+		for _, prefix := range []string{"sriov/", "a very long prefix"} {
+			conf = fmt.Sprintf(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "delegates": [{"name": "weave1", "cniVersion": "0.3.1", "type": "weave-net"}],
+	    "secondaryInterfacePrefix": %q
+	}`, prefix)
+			_, err = LoadNetConf([]byte(conf))
+			Expect(err).To(MatchError(fmt.Sprintf("LoadNetConf: invalid secondaryInterfacePrefix %q", prefix)))
+		}
+	})
+
+	It("rejects default networks listed more than once", func() {
+		for _, networks := range []string{
+			`"clusterNetwork": "net1", "defaultNetworks": ["net2", "net2"]`,
+			`"clusterNetwork": "net1", "defaultNetworks": ["net2", "net1"]`,
+			`"defaultNetworks": ["net1", "net1"]`,
+		} {
+			conf := fmt.Sprintf(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    %s
+	}`, networks)
+			_, err := LoadNetConf([]byte(conf))
+			Expect(err).To(MatchError(ContainSubstring("is listed more than once in clusterNetwork/defaultNetworks")))
+		}
+
+		netConf, err := LoadNetConf([]byte(`{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "clusterNetwork": "net1",
+	    "defaultNetworks": ["net2", "net3"]
+	}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(netConf.DefaultNetworks).To(Equal([]string{"net2", "net3"}))
+	})
+
+	It("requires the configMap of staticIPPool", func() {
+		conf := `{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "delegates": [{"name": "weave1", "cniVersion": "0.3.1", "type": "weave-net"}],
+	    "staticIPPool": {"configMap": "static-ips", "network": "macvlan-conf"}
+	}`
+		netConf, err := LoadNetConf([]byte(conf))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(netConf.StaticIPPool).To(Equal(&StaticIPPool{ConfigMap: "static-ips", Network: "macvlan-conf"}))
+
+		conf = `{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "delegates": [{"name": "weave1", "cniVersion": "0.3.1", "type": "weave-net"}],
+	    "staticIPPool": {"network": "macvlan-conf"}
+	}`
+		_, err = LoadNetConf([]byte(conf))
+		Expect(err).To(MatchError("LoadNetConf: staticIPPool requires a configMap"))
+	})
+
+	It("validates logFileMaxSizeMB and logFileMaxBackups", func() {
+		conf := `{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "delegates": [{"name": "weave1", "cniVersion": "0.3.1", "type": "weave-net"}],
+	    "logFileMaxSizeMB": 10,
+	    "logFileMaxBackups": 3
+	}`
+		netConf, err := LoadNetConf([]byte(conf))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*netConf.LogFileMaxSizeMB).To(Equal(10))
+		Expect(*netConf.LogFileMaxBackups).To(Equal(3))
+
+		conf = `{
+	    "name": "node-cni-network",
+	    "type": "multus",
+	    "delegates": [{"name": "weave1", "cniVersion": "0.3.1", "type": "weave-net"}],
+	    "logFileMaxSizeMB": 0
+	}`
+		_, err = LoadNetConf([]byte(conf))
+		Expect(err).To(MatchError("LoadNetConf: logFileMaxSizeMB must be positive, got 0"))
+	})
+
+	It("loads the default-route of the network attachment definition", func() {
+		conf := `{
+			"name": "net1",
+			"type": "mynet",
+			"cniVersion": "1.0.0",
+			"default-route": ["10.1.1.1"]
+		}`
+		netconf, err := LoadDelegateNetConf([]byte(conf), nil, "", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*netconf.GatewayRequest).To(Equal([]net.IP{net.ParseIP("10.1.1.1")}))
+
+		// the network selection default-route takes precedence
+		ns := &NetworkSelectionElement{}
+		Expect(json.Unmarshal([]byte(`{"name": "net1", "default-route": ["10.1.1.2"]}`), ns)).To(Succeed())
+		netconf, err = LoadDelegateNetConf([]byte(conf), ns, "", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*netconf.GatewayRequest).To(Equal([]net.IP{net.ParseIP("10.1.1.2")}))
+	})
+
+	It("names the networks requesting the same default-route family", func() {
+		net1 := &DelegateNetConf{Name: "test/net1", GatewayRequest: &[]net.IP{net.ParseIP("10.1.1.1"), net.ParseIP("fc00::1")}}
+		net2 := &DelegateNetConf{Name: "test/net2", GatewayRequest: &[]net.IP{net.ParseIP("10.2.2.1")}}
+		Expect(CheckGatewayConfig([]*DelegateNetConf{net1, net2})).To(MatchError(
+			`multus does not support ECMP for default-route: networks "test/net1" and "test/net2" both request the IPv4 default route`))
+
+		net1.GatewayRequest = &[]net.IP{net.ParseIP("10.1.1.1"), net.ParseIP("10.1.1.2")}
+		Expect(CheckGatewayConfig([]*DelegateNetConf{net1})).To(MatchError(
+			`multus does not support ECMP for default-route: network "test/net1" requests more than one IPv4 default route`))
+	})
 })
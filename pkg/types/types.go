@@ -17,6 +17,8 @@ package types
 
 import (
 	"net"
+	"sync"
+	"time"
 
 	"gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/logging"
 
@@ -37,6 +39,8 @@ type NetConf struct {
 	ConfDir string `json:"confDir"`
 	CNIDir  string `json:"cniDir"`
 	BinDir  string `json:"binDir"`
+	// BinDirs are searched, in order, after BinDir for the delegate plugin binaries
+	BinDirs []string `json:"binDirs,omitempty"`
 	// RawDelegates is private to the NetConf class; use Delegates instead
 	RawDelegates []map[string]interface{} `json:"delegates"`
 	// These parameters are exclusive in one config file:
@@ -48,11 +52,22 @@ type NetConf struct {
 	Kubeconfig      string              `json:"kubeconfig"`
 	LogFile         string              `json:"logFile"`
 	LogLevel        string              `json:"logLevel"`
+	LogFormat       string              `json:"logFormat,omitempty"`
 	LogToStderr     bool                `json:"logToStderr,omitempty"`
 	LogOptions      *logging.LogOptions `json:"logOptions,omitempty"`
-	RuntimeConfig   *RuntimeConfig      `json:"runtimeConfig,omitempty"`
+	// LogFileMaxSizeMB and LogFileMaxBackups override the maxSize and maxBackups logOptions
+	LogFileMaxSizeMB  *int           `json:"logFileMaxSizeMB,omitempty"`
+	LogFileMaxBackups *int           `json:"logFileMaxBackups,omitempty"`
+	RuntimeConfig     *RuntimeConfig `json:"runtimeConfig,omitempty"`
 	// Default network readiness options
 	ReadinessIndicatorFile string `json:"readinessindicatorfile"`
+	// Time (in seconds) to wait for the readinessindicatorfile, 45 if not set
+	ReadinessIndicatorWaitSeconds int `json:"readinessIndicatorWaitSeconds,omitempty"`
+	// Interval (in milliseconds) between the checks of the readinessindicatorfile, 1000 if not set
+	ReadinessPollIntervalMillis int `json:"readinessPollIntervalMillis,omitempty"`
+	// Cap (in milliseconds) of the interval doubled after each check of the readinessindicatorfile,
+	// the interval is not doubled if not set
+	ReadinessPollMaxIntervalMillis int `json:"readinessPollMaxIntervalMillis,omitempty"`
 	// Option to isolate the usage of CR's to the namespace in which a pod resides.
 	NamespaceIsolation       bool     `json:"namespaceIsolation"`
 	RawNonIsolatedNamespaces string   `json:"globalNamespaces"`
@@ -65,17 +80,207 @@ type NetConf struct {
 
 	// Retry delegate DEL message to next when some error
 	RetryDeleteOnError bool `json:"retryDeleteOnError"`
+
+	// Total time (in milliseconds) that may be spent retrying Kubernetes API calls in one CNI request
+	K8sTotalRetryBudgetMs int `json:"k8sTotalRetryBudgetMs,omitempty"`
+	// RetryBudget is only used internal housekeeping
+	RetryBudget *RetryBudget `json:"-"`
+
+	// Interface names which additional networks may not use
+	ReservedInterfaceNames []string `json:"reservedInterfaceNames,omitempty"`
+	// Prefix of the interface names of the additional networks without a requested one
+	SecondaryInterfacePrefix string `json:"secondaryInterfacePrefix,omitempty"`
+
+	// Detect identical IP addresses returned by different delegates
+	DetectDuplicateResultIPs bool `json:"detectDuplicateResultIPs,omitempty"`
+	// Fail ADD (instead of warning) if duplicate IP addresses are detected
+	DuplicateResultIPsFatal bool `json:"duplicateResultIPsFatal,omitempty"`
+
+	// Attach only pod annotation networks, without any clusterNetwork/delegates
+	NoDefaultNetwork bool `json:"noDefaultNetwork,omitempty"`
+	// Let pods opt out of the default network with the default-network: none annotation
+	AllowNoDefaultNetwork bool `json:"allowNoDefaultNetwork,omitempty"`
+
+	// Pod annotation keys read in addition to the standard network annotation
+	AdditionalNetworkAnnotationKeys []string `json:"additionalNetworkAnnotationKeys,omitempty"`
+
+	// Seconds to cache the resolved clusterNetwork config in-process (0 means always re-resolve)
+	DefaultNetworkCacheTTLSeconds int `json:"defaultNetworkCacheTTLSeconds,omitempty"`
+
+	// Fill in the sandbox of result interfaces left empty by the delegate
+	FillInterfaceSandbox bool `json:"fillInterfaceSandbox,omitempty"`
+
+	// Capabilities merged into the capabilities declared by each delegate
+	DefaultCapabilities map[string]bool `json:"defaultCapabilities,omitempty"`
+
+	// Reject pods which are not scheduled to this node (NODE_NAME)
+	VerifyPodNode bool `json:"verifyPodNode,omitempty"`
+
+	// Remove IPv6 (and optionally IPv4) link-local addresses from the returned result
+	StripLinkLocalFromResult     bool `json:"stripLinkLocalFromResult,omitempty"`
+	StripIPv4LinkLocalFromResult bool `json:"stripIPv4LinkLocalFromResult,omitempty"`
+
+	// Return the DNS settings of all delegate results in the returned result
+	MergeDNS bool `json:"mergeDNS,omitempty"`
+
+	// Add to the returned result the IP addresses of the other delegates for its interface
+	MergeDualStackResults bool `json:"mergeDualStackResults,omitempty"`
+
+	// Network (name or namespace/name) whose result is returned instead of the default network's
+	ResultNetwork string `json:"resultNetwork,omitempty"`
+
+	// How the cached delegates and the network status are combined on DEL
+	// (union, cachePreferred or statusPreferred)
+	DelReconcileStrategy string `json:"delReconcileStrategy,omitempty"`
+
+	// File created while the status checks pass (i.e. multus can serve ADDs)
+	ReadinessOutputFile string `json:"readinessOutputFile,omitempty"`
+
+	// Fail when a result route uses one of the pod IP addresses as gateway
+	RejectSelfReferentialRoutes bool `json:"rejectSelfReferentialRoutes,omitempty"`
+
+	// Check that no interface to create exists in the pod netns before invoking delegates
+	CheckExistingInterface bool `json:"checkExistingInterface,omitempty"`
+
+	// Ignore additional networks of pods whose network annotation cannot be parsed instead of failing
+	TolerateMalformedAnnotation bool `json:"tolerateMalformedAnnotation,omitempty"`
+
+	// Maximum ingress/egress rate in bits per second a network may request, 0 for no limit
+	MaxBandwidthBps int `json:"maxBandwidthBps,omitempty"`
+
+	// Whether the default network is added before ("first") or after ("last") the other networks
+	DefaultNetworkOrder string `json:"defaultNetworkOrder,omitempty"`
+
+	// Minimum time since the last modification of a network-attachment-definition selected by a pod
+	MinNADAgeSeconds int `json:"minNADAgeSeconds,omitempty"`
+	// Whether to "reject" (default) or "wait" for network-attachment-definitions younger than MinNADAgeSeconds
+	MinNADAgeAction string `json:"minNADAgeAction,omitempty"`
+
+	// Name of the default network owning the result, instead of the first one
+	MasterPlugin string `json:"masterPlugin,omitempty"`
+
+	// Logging level used while executing delegates of the given plugin types
+	DelegateLogLevels map[string]string `json:"delegateLogLevels,omitempty"`
+
+	// What to do with result IPs referencing an interface index out of the result interfaces
+	InvalidInterfaceIndexAction string `json:"invalidInterfaceIndexAction,omitempty"`
+
+	// Fail ADD if a result IP has a prefix length out of range for its family, or a zero
+	// prefix length unless AllowZeroResultPrefix is set
+	ValidateResultPrefixes bool `json:"validateResultPrefixes,omitempty"`
+	AllowZeroResultPrefix  bool `json:"allowZeroResultPrefix,omitempty"`
+
+	// IP families ("v4", "v6") whose default routes are kept in the result and set from default-route
+	DefaultRouteFamilies []string `json:"defaultRouteFamilies,omitempty"`
+
+	// Fail ADD early if none of the CNI plugin directories exists
+	CheckBinDirs bool `json:"checkBinDirs,omitempty"`
+
+	// Additional networks attached to the pods of the given priority classes
+	PriorityClassNetworks map[string][]string `json:"priorityClassNetworks,omitempty"`
+
+	// Fail ADD if a delegate result does not have the requested MAC address
+	VerifyRequestedMAC bool `json:"verifyRequestedMAC,omitempty"`
+
+	// Check that a delegate result has as many IP addresses as requested for the network
+	VerifyRequestedIPCount bool `json:"verifyRequestedIPCount,omitempty"`
+	// Fail ADD (instead of warning) if the number of IP addresses differs
+	RequestedIPCountFatal bool `json:"requestedIPCountFatal,omitempty"`
+
+	// When to write the network status annotation: once at the end of ADD or after each network
+	StatusWriteMode string `json:"statusWriteMode,omitempty"`
+
+	// Maximum number of non-master delegates added concurrently
+	MaxConcurrentDelegates int `json:"maxConcurrentDelegates,omitempty"`
+
+	// Time limit of the ADD of each delegate (0 means no limit)
+	DelegateTimeoutSeconds int `json:"delegateTimeoutSeconds,omitempty"`
+
+	// Number of times ADD is run again after failing with an error of the cmdAddRetryOn categories
+	CmdAddRetries int      `json:"cmdAddRetries,omitempty"`
+	CmdAddRetryOn []string `json:"cmdAddRetryOn,omitempty"`
+
+	// Retries of the pod and network-attachment-definition lookups failing with a transient error
+	APIRetry *APIRetry `json:"apiRetry,omitempty"`
+
+	// Check that the namespace of a cross-namespace network exists before getting the network
+	CheckNetworkNamespace bool `json:"checkNetworkNamespace,omitempty"`
+
+	// Namespaces of the pods allowed to use the networks of a namespace, by namespace,
+	// the networks of the namespaces not listed can be used from any namespace
+	AllowedNamespaceSources map[string][]string `json:"allowedNamespaceSources,omitempty"`
+
+	// Use the networks annotation of the controller owner of a pod without one
+	InheritNetworkAnnotationFromOwner bool `json:"inheritNetworkAnnotationFromOwner,omitempty"`
+
+	// Lowercase the requested interface names on ADD and DEL
+	NormalizeInterfaceNames bool `json:"normalizeInterfaceNames,omitempty"`
+
+	// Address (host:port) serving the Prometheus metrics of the CNI commands, none if empty
+	MetricsListenAddress string `json:"metricsListenAddress,omitempty"`
+
+	// Maximum size in bytes of the network status annotation, truncated beyond it, no limit if 0
+	NetworkStatusMaxSize int `json:"networkStatusMaxSize,omitempty"`
+
+	// Tag the log lines, status entry and cache of each network with an attachment ID
+	AttachmentIDs bool `json:"attachmentIDs,omitempty"`
+
+	// Annotate the pod with the multus version on a successful ADD
+	RecordMultusVersion bool `json:"recordMultusVersion,omitempty"`
+
+	// Fail ADD before adding any network if networks request the same hostPort
+	RejectHostPortCollisions bool `json:"rejectHostPortCollisions,omitempty"`
+
+	// Static IP addresses of the pods read from a ConfigMap, none if nil
+	StaticIPPool *StaticIPPool `json:"staticIPPool,omitempty"`
+}
+
+// StaticIPPool configures the ConfigMap holding the static IP addresses of the pods
+type StaticIPPool struct {
+	// ConfigMap holding the IP addresses of each pod, by pod name or ip-claim annotation
+	ConfigMap string `json:"configMap"`
+	// Namespace of the ConfigMap, the namespace of the pod if empty
+	Namespace string `json:"namespace,omitempty"`
+	// Network getting the IP addresses, the first additional network of the pod if empty
+	Network string `json:"network,omitempty"`
+}
+
+// APIRetry configures the retries of Kubernetes API calls failing with a transient error
+type APIRetry struct {
+	MaxRetries       int    `json:"maxRetries"`
+	BackoffMillis    int    `json:"backoffMillis,omitempty"`
+	BackoffStrategy  string `json:"backoffStrategy,omitempty"`
+	MaxBackoffMillis int    `json:"maxBackoffMillis,omitempty"`
+	// Budget is the k8sTotalRetryBudgetMs budget the retries spend, set by LoadNetConf
+	Budget *RetryBudget `json:"-"`
+}
+
+// RetryBudget tracks the time left for retrying Kubernetes API calls
+// while handling a single CNI request. A nil RetryBudget is unlimited.
+type RetryBudget struct {
+	mu        sync.Mutex
+	remaining time.Duration
 }
 
 // RuntimeConfig specifies CNI RuntimeConfig
 type RuntimeConfig struct {
-	PortMaps          []*PortMapEntry `json:"portMappings,omitempty"`
-	Bandwidth         *BandwidthEntry `json:"bandwidth,omitempty"`
-	IPs               []string        `json:"ips,omitempty"`
-	Mac               string          `json:"mac,omitempty"`
-	InfinibandGUID    string          `json:"infinibandGUID,omitempty"`
-	DeviceID          string          `json:"deviceID,omitempty"`
-	CNIDeviceInfoFile string          `json:"CNIDeviceInfoFile,omitempty"`
+	PortMaps          []*PortMapEntry   `json:"portMappings,omitempty"`
+	Bandwidth         *BandwidthEntry   `json:"bandwidth,omitempty"`
+	IPs               []string          `json:"ips,omitempty"`
+	Mac               string            `json:"mac,omitempty"`
+	InfinibandGUID    string            `json:"infinibandGUID,omitempty"`
+	DeviceID          string            `json:"deviceID,omitempty"`
+	CNIDeviceInfoFile string            `json:"CNIDeviceInfoFile,omitempty"`
+	MTU               int               `json:"mtu,omitempty"`
+	Sysctls           map[string]string `json:"sysctls,omitempty"`
+	Gateway           []net.IP          `json:"gateway,omitempty"`
+}
+
+// DefaultResult is the result of the default network, given to the networks
+// selected with receivesDefaultResult
+type DefaultResult struct {
+	IPs    []string       `json:"ips,omitempty"`
+	Routes []*types.Route `json:"routes,omitempty"`
 }
 
 // PortMapEntry for CNI PortMapEntry
@@ -87,12 +292,13 @@ type PortMapEntry struct {
 }
 
 // BandwidthEntry for CNI BandwidthEntry
+// The limits of an unset direction are left out of the runtimeConfig
 type BandwidthEntry struct {
-	IngressRate  int `json:"ingressRate"`
-	IngressBurst int `json:"ingressBurst"`
+	IngressRate  int `json:"ingressRate,omitempty"`
+	IngressBurst int `json:"ingressBurst,omitempty"`
 
-	EgressRate  int `json:"egressRate"`
-	EgressBurst int `json:"egressBurst"`
+	EgressRate  int `json:"egressRate,omitempty"`
+	EgressBurst int `json:"egressBurst,omitempty"`
 }
 
 // DelegateNetConf for net-attach-def for pod
@@ -100,13 +306,15 @@ type DelegateNetConf struct {
 	Conf                  types.NetConf
 	ConfList              types.NetConfList
 	Name                  string
-	IfnameRequest         string          `json:"ifnameRequest,omitempty"`
-	MacRequest            string          `json:"macRequest,omitempty"`
-	InfinibandGUIDRequest string          `json:"infinibandGUIDRequest,omitempty"`
-	IPRequest             []string        `json:"ipRequest,omitempty"`
-	PortMappingsRequest   []*PortMapEntry `json:"-"`
-	BandwidthRequest      *BandwidthEntry `json:"-"`
-	GatewayRequest        *[]net.IP       `json:"default-route,omitempty"`
+	IfnameRequest         string            `json:"ifnameRequest,omitempty"`
+	MacRequest            string            `json:"macRequest,omitempty"`
+	InfinibandGUIDRequest string            `json:"infinibandGUIDRequest,omitempty"`
+	IPRequest             []string          `json:"ipRequest,omitempty"`
+	MTURequest            int               `json:"mtuRequest,omitempty"`
+	PortMappingsRequest   []*PortMapEntry   `json:"-"`
+	BandwidthRequest      *BandwidthEntry   `json:"-"`
+	SysctlsRequest        map[string]string `json:"-"`
+	GatewayRequest        *[]net.IP         `json:"default-route,omitempty"`
 	IsFilterV4Gateway     bool
 	IsFilterV6Gateway     bool
 	// MasterPlugin is only used internal housekeeping
@@ -117,6 +325,14 @@ type DelegateNetConf struct {
 	DeviceID string `json:"deviceID,omitempty"`
 	// ResourceName is only used internal housekeeping
 	ResourceName string `json:"resourceName,omitempty"`
+	// Optional networks are skipped when their plugin binary is missing
+	Optional bool `json:"optional,omitempty"`
+	// ReceivesDefaultResult networks get the default network result in their runtimeConfig
+	ReceivesDefaultResult bool `json:"receivesDefaultResult,omitempty"`
+	// GatewayCapabilityRequest is the gateway runtimeConfig requested by the pod
+	GatewayCapabilityRequest []net.IP `json:"-"`
+	// AttachmentID identifies the attachment of the network to the container interface
+	AttachmentID string `json:"attachmentID,omitempty"`
 
 	// Raw JSON
 	Bytes []byte
@@ -152,12 +368,28 @@ type NetworkSelectionElement struct {
 	// BandwidthRequest contains an optional requested bandwidth for
 	// the network
 	BandwidthRequest *BandwidthEntry `json:"bandwidth,omitempty"`
+	// SysctlsRequest contains optional requested sysctls for the network
+	// interface
+	SysctlsRequest map[string]string `json:"sysctls,omitempty"`
 	// DeviceID contains an optional requested deviceID the network
 	DeviceID string `json:"deviceID,omitempty"`
 	// CNIArgs contains additional CNI arguments for the network interface
 	CNIArgs *map[string]interface{} `json:"cni-args"`
 	// GatewayRequest contains default route IP address for the pod
 	GatewayRequest *[]net.IP `json:"default-route,omitempty"`
+	// GatewayCapabilityRequest contains the gateway IP addresses passed in
+	// the gateway runtimeConfig of the network
+	GatewayCapabilityRequest []string `json:"gateway,omitempty"`
+	// Optional marks a network which is skipped, instead of failing the
+	// pod, when its plugin binary is missing
+	Optional bool `json:"optional,omitempty"`
+	// ReceivesDefaultResult requests the IPs and routes of the default network
+	// in the defaultResult runtimeConfig of this network, which is then added
+	// after the default network
+	ReceivesDefaultResult bool `json:"receivesDefaultResult,omitempty"`
+	// MTURequest contains an optional requested MTU of the interface of
+	// this network attachment
+	MTURequest *int `json:"mtu,omitempty"`
 }
 
 // K8sArgs is the valid CNI_ARGS used for Kubernetes